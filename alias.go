@@ -0,0 +1,145 @@
+package pbsql
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	aliasesMu sync.RWMutex
+	// aliases maps message types to the columns of their fields by go field name
+	aliases = make(map[reflect.Type]map[string]string)
+)
+
+// RegisterColumnAliases stores the fields of messages of the type of `msg` in the columns of `columns` instead of
+// those of their `db` tags, so messages can be mapped onto a legacy schema without changing their definition:
+//
+//	err := pbsql.RegisterColumnAliases(&pb.Task{}, map[string]string{"created_at": "tsk_dt_crtd"})
+//
+// Fields are named by any name a field mask entry may use: the go name, the protobuf name, or the column they are
+// otherwise stored in. Fields without a column, such as those of protobuf messages without `db` tags, are stored in
+// the aliased column as well. An error lists the names which don't match any field, and nothing is registered then.
+// Registering the same type again replaces its aliases, an empty map removes them.
+func RegisterColumnAliases(msg interface{}, columns map[string]string) error {
+	t := reflect.TypeOf(msg)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("pbsql: cannot alias the columns of %s, it isn't a struct", t)
+	}
+	byName := make(map[string]string, len(columns))
+	var unknown []string
+	for name, column := range columns {
+		field, ok := namedField(t, name)
+		if !ok || column == "" {
+			unknown = append(unknown, name)
+			continue
+		}
+		byName[field] = column
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("%w: %s has no field %s", ErrUnknownField, t, strings.Join(unknown, ", "))
+	}
+	aliasesMu.Lock()
+	defer aliasesMu.Unlock()
+	if len(byName) == 0 {
+		delete(aliases, t)
+		return nil
+	}
+	aliases[t] = byName
+	return nil
+}
+
+// LoadColumnAliases registers the column aliases of a JSON mapping read from `r`, which maps the names of message
+// types to the aliases of their fields, see RegisterColumnAliases:
+//
+//	{"tasks.v1.Task": {"created_at": "tsk_dt_crtd", "title": "tsk_ttl"}}
+//
+// Types are named by their protobuf full name or their go type name, and must be among `msgs`. Mappings kept in YAML
+// can be decoded into a map[string]map[string]string and registered the same way with RegisterColumnAliases.
+func LoadColumnAliases(r io.Reader, msgs ...interface{}) error {
+	var mapping map[string]map[string]string
+	if err := json.NewDecoder(r).Decode(&mapping); err != nil {
+		return fmt.Errorf("pbsql: cannot decode column aliases: %w", err)
+	}
+	byType := messagesByName(msgs)
+	names := make([]string, 0, len(mapping))
+	for name := range mapping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		msg, ok := byType[name]
+		if !ok {
+			return fmt.Errorf("pbsql: column aliases of %s don't match any of the given messages", name)
+		}
+		if err := RegisterColumnAliases(msg, mapping[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// messagesByName maps the protobuf full names and go type names of `msgs` to the messages
+func messagesByName(msgs []interface{}) map[string]interface{} {
+	byName := make(map[string]interface{}, 2*len(msgs))
+	for _, msg := range msgs {
+		if m, ok := msg.(proto.Message); ok {
+			byName[string(m.ProtoReflect().Descriptor().FullName())] = msg
+		}
+		byName[reflect.Indirect(reflect.ValueOf(msg)).Type().Name()] = msg
+	}
+	return byName
+}
+
+// namedField returns the go name of the field of `t` named `name` by any of its maskNames
+func namedField(t reflect.Type, name string) (string, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		self := t.Field(i)
+		if self.PkgPath != "" {
+			continue
+		}
+		for _, n := range maskNames(self) {
+			if n == name {
+				return self.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// columnAliases returns the aliased columns of the fields of `t` by go field name, registered or declared with the
+// `(pbsql.column)` option, nil if it has none
+func columnAliases(t reflect.Type) map[string]string {
+	aliasesMu.RLock()
+	columns, ok := aliases[t]
+	aliasesMu.RUnlock()
+	if ok {
+		return columns
+	}
+	if registered := metadataOf(t); registered != nil {
+		return registered.columns
+	}
+	return nil
+}
+
+// columnOf returns the column storing the field `self` of `t`: its alias if one is registered, its column otherwise,
+// and none for the bounds of a range pair
+func columnOf(t reflect.Type, self reflect.StructField) string {
+	if column, ok := columnAliases(t)[self.Name]; ok {
+		return column
+	}
+	if isRangeBound(t, self) {
+		return ""
+	}
+	return columnName(self)
+}
@@ -0,0 +1,171 @@
+package pbsql
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Numbers of the extensions declared by proto/pbsql/options.proto
+const (
+	tableOption      protowire.Number = 50710
+	columnOption     protowire.Number = 50710
+	primaryKeyOption protowire.Number = 50711
+	nullableOption   protowire.Number = 50712
+	readonlyOption   protowire.Number = 50713
+	searchableOption protowire.Number = 50714
+	tagsOption       protowire.Number = 50715
+)
+
+var (
+	annotationsMu sync.RWMutex
+	// annotations caches the metadata read from the options of protobuf message types, nil for types without any
+	annotations = make(map[reflect.Type]*registeredType)
+)
+
+// annotatedMetadata returns the metadata of the protobuf message type `t` declared with the options of
+// proto/pbsql/options.proto, nil if it isn't a message or has none. The options are an alternative to struct tags for
+// generated messages:
+//
+//	int32 id = 1 [(pbsql.primary_key) = true];
+//	double lat = 2 [(pbsql.column) = "geolocation_lat"];
+//
+// They are read from the wire encoding of the descriptor options, so the Go package of options.proto doesn't need to
+// be linked. Metadata given to RegisterTableMetadata takes precedence over them.
+func annotatedMetadata(t reflect.Type) *registeredType {
+	annotationsMu.RLock()
+	registered, ok := annotations[t]
+	annotationsMu.RUnlock()
+	if ok {
+		return registered
+	}
+	registered = readAnnotations(t)
+	annotationsMu.Lock()
+	defer annotationsMu.Unlock()
+	annotations[t] = registered
+	return registered
+}
+
+// readAnnotations reads the metadata declared by the options of the message type `t`
+func readAnnotations(t reflect.Type) *registeredType {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	msg, ok := reflect.New(t).Interface().(proto.Message)
+	if !ok {
+		return nil
+	}
+	return annotationsOf(t, msg.ProtoReflect().Descriptor())
+}
+
+// annotationsOf reads the metadata declared by the options of `desc`, the descriptor of the message type `t`
+func annotationsOf(t reflect.Type, desc protoreflect.MessageDescriptor) *registeredType {
+	registered := &registeredType{tags: make(map[string]string), columns: make(map[string]string)}
+	found := false
+	for number, value := range optionValues(desc.Options()) {
+		if number == tableOption {
+			registered.table, found = value, true
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		self := t.Field(i)
+		field := desc.Fields().ByName(protoreflect.Name(protoFieldName(self)))
+		if self.PkgPath != "" || field == nil {
+			continue
+		}
+		var tags []string
+		for number, value := range optionValues(field.Options()) {
+			switch number {
+			case columnOption:
+				registered.columns[self.Name] = value
+			case primaryKeyOption, nullableOption, readonlyOption, searchableOption:
+				if value == "true" {
+					tags = append(tags, optionTags[number]+`:"y"`)
+				}
+			case tagsOption:
+				tags = append(tags, value)
+			default:
+				continue
+			}
+			found = true
+		}
+		if len(tags) > 0 {
+			registered.tags[self.Name] = strings.Join(tags, " ")
+		}
+	}
+	if !found {
+		return nil
+	}
+	if len(registered.columns) == 0 {
+		registered.columns = nil
+	}
+	return registered
+}
+
+// optionTags maps the boolean options to the tags they stand for
+var optionTags = map[protowire.Number]string{
+	primaryKeyOption: "primary_key",
+	nullableOption:   "nullable",
+	readonlyOption:   "readonly",
+	searchableOption: "searchable",
+}
+
+// optionValues returns the pbsql options set in `opts`, strings as is and bools as "true" or "false". The options are
+// read from the wire encoding, where they are either unknown fields or extensions registered by the Go package of
+// options.proto.
+func optionValues(opts proto.Message) map[protowire.Number]string {
+	if opts == nil || !opts.ProtoReflect().IsValid() {
+		return nil
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(opts)
+	if err != nil {
+		return nil
+	}
+	values := make(map[protowire.Number]string)
+	for len(b) > 0 {
+		number, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return values
+		}
+		b = b[n:]
+		isOption := number >= tableOption && number <= tagsOption
+		switch {
+		case isOption && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return values
+			}
+			values[number] = string(v)
+			b = b[n:]
+		case isOption && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return values
+			}
+			values[number] = strconv.FormatBool(v != 0)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(number, typ, b)
+			if n < 0 {
+				return values
+			}
+			b = b[n:]
+		}
+	}
+	return values
+}
+
+// protoFieldName returns the name of the protobuf field a struct field was generated for, empty if there is none
+func protoFieldName(self reflect.StructField) string {
+	for _, option := range strings.Split(self.Tag.Get("protobuf"), ",") {
+		if name := strings.TrimPrefix(option, "name="); name != option {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,77 @@
+package pbsql
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// modes of repeated fields tagged `array:"<mode>"`
+const (
+	// arrayColumn stores the field in a native array column, reads match rows whose array overlaps the field
+	arrayColumn = "column"
+	// arrayIn filters a scalar column by the values of the field, the field is never selected or written
+	arrayIn = "in"
+)
+
+// arrayMode returns the `array` tag of a repeated field, empty if the field isn't tagged or isn't a slice
+func arrayMode(self reflect.StructField) string {
+	mode := self.Tag.Get("array")
+	if mode == "" || self.Type.Kind() != reflect.Slice || self.Type.Elem().Kind() == reflect.Uint8 {
+		return ""
+	}
+	return mode
+}
+
+// arrayPredicate returns the predicate of a non empty array field, written after the column:
+// `&& :param` for array columns and `= ANY(:param)` for IN filters, negated if `not` is set
+func (f *field) arrayPredicate(not bool) string {
+	param := f.param()
+	switch {
+	case f.array == arrayIn && not:
+		return fmt.Sprintf(" <> ALL(:%s)", param)
+	case f.array == arrayIn:
+		return fmt.Sprintf(" = ANY(:%s)", param)
+	case not:
+		return fmt.Sprintf(" && :%s)", param)
+	default:
+		return fmt.Sprintf(" && :%s", param)
+	}
+}
+
+// writeArrayPredicate writes the predicate of an array field, if it holds any values. Arrays are a Postgres feature,
+// the predicates are written as is for the other dialects.
+func (qb *queryBuilder) writeArrayPredicate(f *field, predicateStr string, not bool) {
+	if f.value.Len() == 0 {
+		return
+	}
+	qb.countListItems(f)
+	predicate := f.predicateTarget(predicateStr)
+	if not && f.array != arrayIn {
+		column := f.table + "." + f.name
+		predicate = strings.TrimSuffix(predicate, column) + "NOT (" + column
+	}
+	qb.writeGroupedCondition(f, predicate+f.arrayPredicate(not), predicateStr)
+}
+
+// arrayArg wraps the value of an array field so the driver binds it as a Postgres array
+func arrayArg(v reflect.Value) interface{} {
+	if v.IsNil() {
+		return nil
+	}
+	return pq.Array(v.Interface())
+}
+
+// arrayType returns the column type of fields tagged `array:"column"`
+func (d Dialect) arrayType(t reflect.Type) (string, bool) {
+	if d != Postgres {
+		return "", false
+	}
+	elemType, ok := d.columnType(t.Elem(), false)
+	if !ok {
+		return "", false
+	}
+	return elemType + "[]", true
+}
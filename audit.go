@@ -0,0 +1,141 @@
+package pbsql
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Columns written to history tables in addition to the columns of the audited table
+const (
+	HistoryOperationColumn = "history_operation"
+	HistoryChangedAtColumn = "history_changed_at"
+	HistoryActorColumn     = "history_actor"
+)
+
+// HistoryTable returns the name of the table audit records of `target` are written to
+func HistoryTable(target string) string {
+	return target + "_history"
+}
+
+type actorKey struct{}
+
+// ContextWithActor returns a copy of ctx carrying the id of the user responsible for writes made with it, which is
+// recorded in history tables by an Executor configured WithAuditTrail
+func ContextWithActor(ctx context.Context, actor interface{}) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor stored by ContextWithActor, or nil
+func ActorFromContext(ctx context.Context) interface{} {
+	return ctx.Value(actorKey{})
+}
+
+// BuildHistoryQuery accepts a target table name and a protobuf message and builds an insert statement copying the
+// row matching the message's primary key into the target's history table (see HistoryTable), along with the
+// operation about to be applied to it, the current time, and `actor`. Run it before the update or delete it records
+// so the prior values are captured, ideally within the same transaction.
+//
+// The history table must have every column of the target table plus HistoryOperationColumn,
+// HistoryChangedAtColumn, and HistoryActorColumn.
+func BuildHistoryQuery(target string, source interface{}, op Operation, actor interface{}, opts ...Option) (string, []interface{}, error) {
+	o := newOptions(opts)
+	target = o.table(target, source)
+	qry, arg, err := historyQuery(target, source, op, actor, o)
+	if err != nil {
+		return "", nil, err
+	}
+	return o.bind(qry, arg)
+}
+
+// historyQuery returns the named history insert along with the arg it must be bound against
+func historyQuery(target string, source interface{}, op Operation, actor interface{}, o *options) (string, map[string]interface{}, error) {
+	reflectedValue := reflect.ValueOf(source).Elem()
+	keys := primaryKeys(reflectedValue, target)
+	if len(keys) == 0 {
+		return "", nil, fmt.Errorf("%w: cannot audit %s", ErrMissingPrimaryKey, target)
+	}
+
+	insert := historyInsert(target, reflectedValue, op, o)
+	arg := map[string]interface{}{HistoryActorColumn: actor}
+	for name, value := range o.params {
+		arg[name] = value
+	}
+	for _, key := range keys {
+		arg[key.name] = key.value.Interface()
+	}
+	return insert + keyPredicate(keys), arg, nil
+}
+
+// historyWhereQuery returns the named history insert recording every row matched by BuildDeleteWhereQuery, along
+// with the value it must be bound against
+func historyWhereQuery(target string, source interface{}, op Operation, actor interface{}, o *options) (string, interface{}, error) {
+	reflectedValue := reflect.ValueOf(source).Elem()
+	predicate, err := deleteWherePredicate(target, reflectedValue, o)
+	if err != nil {
+		return "", nil, err
+	}
+	insert := historyInsert(target, reflectedValue, op, o)
+	params := map[string]interface{}{HistoryActorColumn: actor}
+	for name, value := range o.params {
+		params[name] = value
+	}
+	return insert + predicate, withParams(source, params), nil
+}
+
+// historyInsert returns a history insert selecting from `target` up to its WHERE clause
+func historyInsert(target string, v reflect.Value, op Operation, o *options) string {
+	var columns, values strings.Builder
+	for i := 0; i < v.NumField(); i++ {
+		field := parseReflection(v, i, target)
+		if field.name == "" || field.shouldIgnore || field.selectFunc.ok || field.isReadonly || !field.value.CanInterface() {
+			continue
+		}
+		fmt.Fprintf(&columns, "%s, ", field.name)
+		fmt.Fprintf(&values, "%s.%s, ", target, field.name)
+	}
+
+	return fmt.Sprintf(
+		"INSERT INTO %s (%s%s, %s, %s) SELECT %s'%s', %s, :%s FROM %s ",
+		HistoryTable(target),
+		columns.String(),
+		HistoryOperationColumn,
+		HistoryChangedAtColumn,
+		HistoryActorColumn,
+		values.String(),
+		op,
+		o.nowExpr(),
+		HistoryActorColumn,
+		target,
+	)
+}
+
+// WithAuditTrail makes the Executor record the prior state of every row it updates or deletes in the table's
+// history table, see BuildHistoryQuery. The actor is read from the context with ActorFromContext.
+func WithAuditTrail() ExecutorOption {
+	return func(e *Executor) {
+		e.audit = true
+	}
+}
+
+// audited runs `fn`, which writes `source` with `op`, after recording the prior state of the row in the history table
+// if the Executor was configured WithAuditTrail. Both statements run in a single transaction.
+func (e *Executor) audited(ctx context.Context, target string, source interface{}, op Operation, o *options, fn func(*Executor) error) error {
+	return e.auditedWith(ctx, target, func() (string, interface{}, error) {
+		return historyQuery(target, source, op, ActorFromContext(ctx), o)
+	}, fn)
+}
+
+// auditedWith behaves like audited but records the rows selected by the history insert `history`
+func (e *Executor) auditedWith(ctx context.Context, target string, history buildFunc, fn func(*Executor) error) error {
+	if !e.audit {
+		return fn(e)
+	}
+	return e.inTx(ctx, func(tx *Executor) error {
+		if _, err := tx.silent().execBuilt(ctx, HistoryTable(target), OpCreate, history); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
@@ -0,0 +1,50 @@
+package pbsql
+
+import "testing"
+
+var benchStruct = TestStruct{ID: 1, Name: "name", GeoLat: 40.5, IsActive: 1, OrderBy: "name", OrderDir: "asc"}
+
+func BenchmarkBuildCreateQuery(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := BuildCreateQuery("test_table", &benchStruct); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBuildReadQuery(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := BuildReadQuery("test_table", &benchStruct); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBuildCountQuery(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := BuildCountQuery("test_table", &benchStruct); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBuildSearchQuery(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := BuildSearchQuery("test_table", &benchStruct, "phrase"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBuildUpdateQuery(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := BuildUpdateQuery("test_table", &benchStruct, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
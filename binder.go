@@ -0,0 +1,269 @@
+package pbsql
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Binder turns a named query generated by pbsql, which refers to the fields of the source message as `:column`
+// params, into a statement and args a particular database driver accepts.
+type Binder interface {
+	Bind(named string, source interface{}, d Dialect) (string, []interface{}, error)
+}
+
+// BinderFunc adapts an ordinary function to the Binder interface
+type BinderFunc func(named string, source interface{}, d Dialect) (string, []interface{}, error)
+
+// Bind calls f(named, source, d)
+func (f BinderFunc) Bind(named string, source interface{}, d Dialect) (string, []interface{}, error) {
+	return f(named, source, d)
+}
+
+// SQLBinder binds queries for database/sql without any third party dependency, using `?` placeholders or `$1, $2`
+// on Postgres. This is the default binder.
+var SQLBinder Binder = BinderFunc(func(named string, source interface{}, d Dialect) (string, []interface{}, error) {
+	qry, names := compileNamed(named, d)
+	args, err := ArgsOf(names, source)
+	return qry, args, err
+})
+
+// SQLXBinder binds queries with sqlx.Named and sqlx.Rebind, which additionally understands sqlx's mapping rules for
+// embedded structs
+var SQLXBinder Binder = BinderFunc(func(named string, source interface{}, d Dialect) (string, []interface{}, error) {
+	if _, ok := source.(paramSource); !ok && hasCustomColumns(reflect.TypeOf(source)) {
+		source = paramSource{source: source}
+	}
+	if p, ok := source.(paramSource); ok {
+		merged, err := p.asMap()
+		if err != nil {
+			return "", nil, err
+		}
+		source = merged
+	}
+	bound, args, err := sqlx.Named(named, source)
+	if err != nil {
+		return bound, args, err
+	}
+	return sqlx.Rebind(d.bindType(), bound), args, nil
+})
+
+// SetBinder changes the binder used by builders that aren't given one with WithBinder or WithConfig
+func SetBinder(b Binder) {
+	updateConfig(func(cfg *Config) { cfg.Binder = b })
+}
+
+// WithBinder binds the generated query with `b` instead of the package default
+func WithBinder(b Binder) Option {
+	return func(o *options) {
+		o.binder = b
+	}
+}
+
+// bind binds a named query against `source` and any params given with WithWhere using the configured binder
+func (o *options) bind(named string, source interface{}) (string, []interface{}, error) {
+	return o.binder.Bind(named, o.bindSource(source), o.dialect)
+}
+
+// bindSource returns the value named params of a statement built with these options are bound against
+func (o *options) bindSource(source interface{}) interface{} {
+	if p, ok := withParams(source, o.params).(paramSource); ok {
+		p.sensitive = o.sensitiveParams
+		return p
+	}
+	return source
+}
+
+// paramSource binds named params against `params` before falling back to the fields of `source`. `sensitive` lists
+// the params whose values are redacted from logs like those of sensitive fields.
+type paramSource struct {
+	source    interface{}
+	params    map[string]interface{}
+	sensitive map[string]bool
+}
+
+// withParams returns `source` extended with `params`, or `source` itself if there are none
+func withParams(source interface{}, params map[string]interface{}) interface{} {
+	if len(params) == 0 {
+		return source
+	}
+	return paramSource{source: source, params: params}
+}
+
+// asMap flattens the source and its params into a single map
+func (p paramSource) asMap() (map[string]interface{}, error) {
+	merged := make(map[string]interface{}, len(p.params))
+	v := reflect.Indirect(reflect.ValueOf(p.source))
+	switch v.Kind() {
+	case reflect.Struct:
+		for name, i := range fieldIndex(v.Type()) {
+			arg, err := fieldArg(structField(v.Type(), i), v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			merged[name] = arg
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() == reflect.String {
+			for _, key := range v.MapKeys() {
+				merged[key.String()] = v.MapIndex(key).Interface()
+			}
+		}
+	}
+	for name, value := range p.params {
+		merged[name] = value
+	}
+	return merged, nil
+}
+
+// fieldArg returns the value bound for a struct field, encoding converted, JSON, array, and optional fields and
+// unwrapping nullable wrappers such as sql.NullString
+func fieldArg(self reflect.StructField, v reflect.Value) (interface{}, error) {
+	c, err := converterOf(self)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		return c.Encode(v.Interface())
+	}
+	if isJSONColumn(self) {
+		return encodeJSON(v)
+	}
+	if arrayMode(self) != "" {
+		return arrayArg(v), nil
+	}
+	if isOptionalBool(self.Type) {
+		return optionalBoolArg(v), nil
+	}
+	if isOptionalValue(self.Type) {
+		return optionalArg(v), nil
+	}
+	if isNullType(self.Type) {
+		return nullValue(v)
+	}
+	return v.Interface(), nil
+}
+
+// ParamNames returns the name of every `:param` in a named query in order of appearance. Like sqlx, `::` is an
+// escaped colon and names may contain letters, digits, underscores, and dots.
+func ParamNames(named string) []string {
+	_, names := compileNamed(named, MySQL)
+	return names
+}
+
+// ArgsOf returns the value of each of `names` read from `source`, which must be a struct, a pointer to a struct, or
+// a map keyed by string. Struct fields are matched by their column name or `name` tag, falling back to the lower
+// cased field name. Fields tagged `convert`, `json_column`, or `array` are bound through their Converter, as
+// marshaled JSON, or as Postgres arrays. Use it with ParamNames to bind a named query generated by pbsql for any driver.
+func ArgsOf(names []string, source interface{}) ([]interface{}, error) {
+	if p, ok := source.(paramSource); ok {
+		merged, err := p.asMap()
+		if err != nil {
+			return nil, err
+		}
+		return ArgsOf(names, merged)
+	}
+	v := reflect.Indirect(reflect.ValueOf(source))
+	args := make([]interface{}, len(names))
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("pbsql: cannot bind args from a map keyed by %s", v.Type().Key())
+		}
+		for i, name := range names {
+			arg := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+			if !arg.IsValid() {
+				return nil, fmt.Errorf("pbsql: could not find name %s in %v", name, source)
+			}
+			args[i] = arg.Interface()
+		}
+	case reflect.Struct:
+		index := fieldIndex(v.Type())
+		for i, name := range names {
+			j, ok := index[name]
+			if !ok {
+				return nil, fmt.Errorf("pbsql: could not find name %s in %s", name, v.Type())
+			}
+			arg, err := fieldArg(structField(v.Type(), j), v.Field(j))
+			if err != nil {
+				return nil, err
+			}
+			args[i] = arg
+		}
+	default:
+		if len(names) > 0 {
+			return nil, fmt.Errorf("pbsql: cannot bind args from %T", source)
+		}
+	}
+	return args, nil
+}
+
+// fieldIndex maps each bindable name of a struct type to the index of its field
+func fieldIndex(t reflect.Type) map[string]int {
+	index := make(map[string]int, t.NumField())
+	for i := t.NumField() - 1; i >= 0; i-- {
+		f := structField(t, i)
+		if f.PkgPath != "" {
+			continue
+		}
+		index[strings.ToLower(f.Name)] = i
+	}
+	for i := t.NumField() - 1; i >= 0; i-- {
+		f := structField(t, i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if _, negated := negatedColumn(t, f); negated {
+			continue
+		}
+		if name := f.Tag.Get("name"); name != "" {
+			index[name] = i
+		}
+		if name := columnOf(t, f); name != "" && arrayMode(f) != arrayIn {
+			index[name] = i
+		}
+	}
+	return index
+}
+
+// compileNamed replaces every `:param` in a named query with a placeholder for the dialect and returns the names of
+// the params in order
+func compileNamed(named string, d Dialect) (string, []string) {
+	var builder strings.Builder
+	var names []string
+	for i := 0; i < len(named); i++ {
+		c := named[i]
+		if c != ':' {
+			builder.WriteByte(c)
+			continue
+		}
+		if i+1 < len(named) && named[i+1] == ':' {
+			builder.WriteByte(':')
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(named) && isBindRune(named[j]) {
+			j++
+		}
+		if j == i+1 {
+			builder.WriteByte(c)
+			continue
+		}
+		names = append(names, named[i+1:j])
+		if d.bindType() == sqlx.DOLLAR {
+			builder.WriteString("$" + strconv.Itoa(len(names)))
+		} else {
+			builder.WriteByte('?')
+		}
+		i = j - 1
+	}
+	return builder.String(), names
+}
+
+func isBindRune(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.'
+}
@@ -0,0 +1,72 @@
+package pbsql
+
+import (
+	"database/sql"
+	"reflect"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var (
+	boolPtrType   = reflect.TypeOf((*bool)(nil))
+	boolValueType = reflect.TypeOf((*wrapperspb.BoolValue)(nil))
+)
+
+// isOptionalBool reports whether `t` is a *bool or a *wrapperspb.BoolValue. Unlike a bool, whose false can't be told
+// apart from unset, such fields are set whenever they aren't nil, so they can filter rows by an explicit false.
+func isOptionalBool(t reflect.Type) bool {
+	return t == boolPtrType || t == boolValueType
+}
+
+// optionalBoolArg returns the value bound for an optional bool field, nil if it isn't set
+func optionalBoolArg(v reflect.Value) interface{} {
+	if v.IsNil() {
+		return nil
+	}
+	if v.Type() == boolValueType {
+		return v.Interface().(*wrapperspb.BoolValue).GetValue()
+	}
+	return v.Elem().Bool()
+}
+
+// decodeOptionalBool sets the optional bool field `v` from a scanned value, leaving it nil for NULL
+func decodeOptionalBool(src interface{}, v reflect.Value) error {
+	var b sql.NullBool
+	if err := b.Scan(src); err != nil {
+		return err
+	}
+	switch {
+	case !b.Valid:
+		v.Set(reflect.Zero(v.Type()))
+	case v.Type() == boolValueType:
+		v.Set(reflect.ValueOf(wrapperspb.Bool(b.Bool)))
+	default:
+		v.Set(reflect.ValueOf(&b.Bool))
+	}
+	return nil
+}
+
+// falseLiteral returns the literal replacing null bool columns in the select list. Postgres has no implicit cast from
+// integers to booleans, the others store booleans as integers.
+func (d Dialect) falseLiteral() string {
+	if d == Postgres {
+		return "FALSE"
+	}
+	return "0"
+}
+
+// defaultLiteral returns the literal replacing null values of the field in the select list, see WithNullDefault
+func (qb *queryBuilder) defaultLiteral(f *field) (string, error) {
+	if value, ok := qb.nullDefault(f); ok {
+		return qb.nullDefaultLiteral(f, value)
+	}
+	// named types, e.g. `type Cents int64`, take the default of their kind
+	kind := f.self.Type.Kind()
+	if kind == reflect.Bool {
+		return qb.dialect.falseLiteral(), nil
+	}
+	if isBytes(f.self.Type) {
+		return qb.dialect.emptyBytes(), nil
+	}
+	return getDefault(kind.String(), f.name), nil
+}
@@ -0,0 +1,92 @@
+package pbsql
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrOverBudget is wrapped by the BudgetError of a statement exceeding a limit of its Budget
+var ErrOverBudget = errors.New("pbsql: statement over budget")
+
+// Budget caps the complexity of read, count, and search queries, so that a malicious or buggy filter message can't
+// generate pathological SQL. Zero leaves a dimension unbounded:
+//
+//	pbsql.SetConfig(pbsql.Config{Budget: pbsql.Budget{MaxListItems: 1000, MaxJoins: 4, MaxSearchTerms: 8}})
+//
+// A statement exceeding any limit fails to build with a *BudgetError.
+type Budget struct {
+	// MaxListItems caps the values of a single list predicate: the IN lists of filter messages and fields tagged
+	// `multi_value`, and the arrays of fields tagged `array`
+	MaxListItems int
+	// MaxJoins caps the joins of a read, counting the relations it preloads, see WithPreload
+	MaxJoins int
+	// MaxSearchTerms caps the columns a search phrase is matched against, each an OR term of the statement
+	MaxSearchTerms int
+	// MaxPredicates caps the predicates ANDed in the WHERE clause
+	MaxPredicates int
+}
+
+// WithBudget builds the query within `budget` rather than the budget of the config
+func WithBudget(budget Budget) Option {
+	return func(o *options) {
+		o.budget = budget
+	}
+}
+
+// BudgetError describes the limit of a Budget a statement exceeds, it wraps ErrOverBudget
+type BudgetError struct {
+	// Limit names the exceeded field of the Budget, e.g. MaxListItems
+	Limit string
+	// Count is the number of items, joins, terms, or predicates of the statement
+	Count int
+	Max   int
+	// Table is the table the statement reads
+	Table string
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("pbsql: statement on %s over budget: %d exceeds %s of %d", e.Table, e.Count, e.Limit, e.Max)
+}
+
+func (e *BudgetError) Unwrap() error {
+	return ErrOverBudget
+}
+
+// check returns a BudgetError if `count` exceeds the limit `max` named `limit`, zero being unbounded
+func (b Budget) check(target string, limit string, count, max int) error {
+	if max > 0 && count > max {
+		return &BudgetError{Limit: limit, Count: count, Max: max, Table: target}
+	}
+	return nil
+}
+
+// checkQuery checks the statement of `qb` on `target` against the budget of `o`: its list predicates, its joins and
+// preloads, the `terms` its search phrase is matched against, and its predicates, `where` counting those which aren't
+// conditions of `qb`
+func (o *options) checkQuery(target string, qb *queryBuilder, terms int, where int) error {
+	for _, err := range []error{
+		o.budget.check(target, "MaxListItems", qb.listItems, o.budget.MaxListItems),
+		o.budget.check(target, "MaxJoins", len(qb.joins)+len(o.preload), o.budget.MaxJoins),
+		o.budget.check(target, "MaxSearchTerms", terms, o.budget.MaxSearchTerms),
+		o.budget.check(target, "MaxPredicates", len(qb.conditions)+where, o.budget.MaxPredicates),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countListItems records the values of a list predicate of `f`, see Budget.MaxListItems
+func (qb *queryBuilder) countListItems(f *field) {
+	n := 0
+	switch f.value.Kind() {
+	case reflect.String:
+		n = strings.Count(f.value.String(), ",") + 1
+	case reflect.Slice:
+		n = f.value.Len()
+	}
+	qb.listItems = max(qb.listItems, n)
+}
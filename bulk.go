@@ -0,0 +1,251 @@
+package pbsql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// bulkSetPrefix prefixes the params of the values assigned by a bulk update, whose names would otherwise clash with
+// those of the filter when both messages set the same field
+const bulkSetPrefix = "pbsql_set_"
+
+// BuildBulkUpdateQuery builds a single statement updating every row of `target` matched by `filter` with the fields
+// of `patch`, e.g. to close every task of a property:
+//
+//	qry, args, err := pbsql.BuildBulkUpdateQuery("task", &pb.Task{PropertyId: 7}, &pb.Task{Status: "closed"}, nil)
+//	// UPDATE task SET task.status = ? WHERE task.property_id = ?
+//
+// The predicate is written like that of BuildDeleteWhereQuery, so an empty filter is rejected with
+// ErrMissingPredicate rather than updating the whole table, and zero values filter rows when listed with
+// WithSetFields. The SET clause is written like that of BuildUpdateQuery: the fields of `patch` listed in `fieldMask`
+// and the fields holding a value are assigned, primary keys and readonly fields never are. `filter` and `patch` are
+// usually messages of the same type, only `patch` is read for the columns assigned.
+func BuildBulkUpdateQuery(target string, filter, patch interface{}, fieldMask []string, opts ...Option) (string, []interface{}, error) {
+	o := newOptions(opts)
+	target = o.table(target, filter)
+	qry, err := bulkUpdateQuery(target, filter, patch, fieldMask, o)
+	if err != nil {
+		return "", nil, err
+	}
+	return o.bind(qry, filter)
+}
+
+// bulkUpdateQuery returns the named update statement bound by BuildBulkUpdateQuery. The values assigned are added
+// to the params of `o`, the filter is bound from its fields.
+func bulkUpdateQuery(target string, filter, patch interface{}, fieldMask []string, o *options) (string, error) {
+	v := reflect.ValueOf(patch).Elem()
+	fieldMask, err := normalizeMask(v.Type(), target, fieldMask)
+	if err != nil {
+		return "", err
+	}
+	if err := o.checkStrict(target, reflect.ValueOf(filter).Elem()); err != nil {
+		return "", err
+	}
+	qb := newQueryBuilder(o.dialect)
+	defer qb.release()
+	// the time of the clock is added to the params before they are copied
+	now := o.nowExpr()
+	params := make(map[string]interface{}, len(o.params)+v.NumField())
+	for name, value := range o.params {
+		params[name] = value
+	}
+	hasSet := false
+	for i := 0; i < v.NumField(); i++ {
+		field := parseReflection(v, i, target)
+		if !field.value.CanInterface() || field.name == "" || field.isPrimaryKey || field.isCreatedAt || field.isReadonly || !field.permits(permUpdate) {
+			continue
+		}
+		if field.isUpdatedAt {
+			qb.writeAssignment(o.dialect.assignable(target, field.name), now)
+			continue
+		}
+		if findInMask(fieldMask, field.self.Name) && !field.shouldIgnore || field.isSet() {
+			value, err := fieldArg(field.self, field.value)
+			if err != nil {
+				return "", err
+			}
+			params[bulkSetPrefix+field.name] = value
+			qb.writeAssignment(o.dialect.assignable(target, field.name), ":"+bulkSetPrefix+field.name)
+			hasSet = true
+		}
+	}
+	if !hasSet {
+		return "", fmt.Errorf("%w: %s with field mask %v", ErrEmptyUpdate, target, fieldMask)
+	}
+	predicate, err := deleteWherePredicate(target, reflect.ValueOf(filter).Elem(), o)
+	if errors.Is(err, ErrMissingPredicate) {
+		return "", fmt.Errorf("%w: refusing to update every row of %s", ErrMissingPredicate, target)
+	}
+	if err != nil {
+		return "", err
+	}
+	o.params = params
+	return "UPDATE " + target + " SET " + strings.Join(qb.assignments, ", ") + " " + predicate, nil
+}
+
+// BulkUpdate builds a bulk update with BuildBulkUpdateQuery and executes it. Policies restrict the rows updated like
+// those of an update of `filter`, and with WithAuditTrail every matched row is recorded in the history table first.
+func (e *Executor) BulkUpdate(ctx context.Context, target string, filter, patch interface{}, fieldMask []string, opts ...Option) (res sql.Result, err error) {
+	e = e.route(target, filter, OpUpdate, opts...)
+	if opts, err = e.secure(ctx, target, OpUpdate, filter, opts); err != nil {
+		return nil, err
+	}
+	// the patch is only checked, the rows it updates are those of the filter
+	if _, err = e.secureUpdate(ctx, target, patch, fieldMask, nil); err != nil {
+		return nil, err
+	}
+	o := e.options(opts)
+	target = o.table(target, filter)
+	history := func() (string, interface{}, error) {
+		return historyWhereQuery(target, filter, OpUpdate, ActorFromContext(ctx), o)
+	}
+	err = e.auditedWith(ctx, target, history, func(e *Executor) error {
+		res, err = e.execBuilt(ctx, target, OpUpdate, func() (string, interface{}, error) {
+			qry, err := bulkUpdateQuery(target, filter, patch, fieldMask, o)
+			return qry, o.bindSource(filter), err
+		})
+		return err
+	})
+	return res, err
+}
+
+// deleteManyChunk caps the ids deleted by a single statement of BuildDeleteManyQuery, well below the limits drivers
+// put on the number of params of a statement
+const deleteManyChunk = 500
+
+// deleteManyParam prefixes the params of the ids deleted by BuildDeleteManyQuery, followed by their index
+const deleteManyParam = "pbsql_id_"
+
+// deleteManyStep is the named statement deleting a chunk of ids, with the params binding them
+type deleteManyStep struct {
+	query     string
+	predicate string
+	params    map[string]interface{}
+}
+
+// BuildDeleteManyQuery builds the statements deleting the rows of `target` whose primary key is one of `ids`, or
+// soft deleting them like BuildDeleteQuery, e.g. for admin bulk operations:
+//
+//	stmts, err := pbsql.BuildDeleteManyQuery("task", &pb.Task{}, []interface{}{4, 8, 15})
+//	// DELETE FROM task WHERE task.id IN (?, ?, ?)
+//
+// `prototype` is an empty message of the stored type, which must have a single primary key. Ids are bound through
+// the Converter of the key, if it has one. Large lists are split into statements of at most 500 ids, run them in a
+// transaction to delete all of them or none; an empty list builds no statement.
+func BuildDeleteManyQuery(target string, prototype interface{}, ids []interface{}, opts ...Option) ([]Statement, error) {
+	o := newOptions(opts)
+	target = o.table(target, prototype)
+	steps, err := deleteManyQueries(target, prototype, ids, o)
+	if err != nil {
+		return nil, err
+	}
+	stmts := make([]Statement, len(steps))
+	for i, step := range steps {
+		qry, args, err := o.binder.Bind(step.query, withParams(prototype, step.params), o.dialect)
+		if err != nil {
+			return nil, err
+		}
+		stmts[i] = Statement{Query: qry, Args: args}
+	}
+	return stmts, nil
+}
+
+// deleteManyQueries returns the named statements bound by BuildDeleteManyQuery
+func deleteManyQueries(target string, prototype interface{}, ids []interface{}, o *options) ([]deleteManyStep, error) {
+	v := reflect.ValueOf(prototype).Elem()
+	keys := primaryKeys(v, target)
+	if len(keys) != 1 {
+		return nil, fmt.Errorf("%w: deleting many rows of %s requires a single primary key", ErrMissingPrimaryKey, target)
+	}
+	key := keys[0]
+	c, err := converterOf(key.self)
+	if err != nil {
+		return nil, err
+	}
+	where, err := o.whereClauses()
+	if err != nil {
+		return nil, err
+	}
+	statement := deleteStatement(target, v.Type(), o)
+
+	var steps []deleteManyStep
+	for start := 0; start < len(ids); start += deleteManyChunk {
+		chunk := ids[start:min(start+deleteManyChunk, len(ids))]
+		params := make(map[string]interface{}, len(o.params)+len(chunk))
+		for name, value := range o.params {
+			params[name] = value
+		}
+		names := make([]string, len(chunk))
+		for i, id := range chunk {
+			if c != nil {
+				if id, err = c.Encode(id); err != nil {
+					return nil, err
+				}
+			}
+			name := deleteManyParam + strconv.Itoa(i)
+			params[name] = id
+			names[i] = ":" + name
+		}
+		predicate := "WHERE " + key.column() + " IN (" + strings.Join(names, ", ") + ")"
+		for _, clause := range where {
+			predicate += " AND " + clause
+		}
+		steps = append(steps, deleteManyStep{query: statement + predicate, predicate: predicate, params: params})
+	}
+	return steps, nil
+}
+
+// DeleteMany deletes the rows of `target` whose primary key is one of `ids` with the statements of
+// BuildDeleteManyQuery, all of them in a single transaction, and returns the number of rows deleted. With
+// WithAuditTrail every deleted row is recorded in the history table first.
+func (e *Executor) DeleteMany(ctx context.Context, target string, prototype interface{}, ids []interface{}, opts ...Option) (int64, error) {
+	e = e.route(target, prototype, OpDelete, opts...)
+	opts, err := e.secure(ctx, target, OpDelete, prototype, opts)
+	if err != nil {
+		return 0, err
+	}
+	o := e.options(opts)
+	target = o.table(target, prototype)
+	steps, err := deleteManyQueries(target, prototype, ids, o)
+	if err != nil || len(steps) == 0 {
+		return 0, err
+	}
+	var deleted int64
+	err = e.inTx(ctx, func(tx *Executor) error {
+		for _, step := range steps {
+			step := step
+			history := func() (string, interface{}, error) {
+				insert := historyInsert(target, reflect.ValueOf(prototype).Elem(), OpDelete, o)
+				params := map[string]interface{}{HistoryActorColumn: ActorFromContext(ctx)}
+				for name, value := range o.params {
+					params[name] = value
+				}
+				for name, value := range step.params {
+					params[name] = value
+				}
+				return insert + step.predicate, withParams(prototype, params), nil
+			}
+			err := tx.auditedWith(ctx, target, history, func(tx *Executor) error {
+				res, err := tx.execBuilt(ctx, target, OpDelete, func() (string, interface{}, error) {
+					return step.query, withParams(prototype, step.params), nil
+				})
+				if err != nil {
+					return err
+				}
+				n, err := res.RowsAffected()
+				deleted += n
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
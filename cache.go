@@ -0,0 +1,262 @@
+package pbsql
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ResultCache stores the results of reads and counts run by an Executor, see WithResultCache. Entries are stored per
+// table so that a write to a table can drop every result read from it. Implementations backed by a shared store such
+// as Redis typically keep the keys of each table in a set, which Invalidate deletes along with the keys.
+type ResultCache interface {
+	// Get returns the value stored under `key`, reporting false on a miss
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores `value` under `key` for `ttl`, or until the entries of `table` are invalidated. A zero ttl never
+	// expires.
+	Set(ctx context.Context, table string, key string, value []byte, ttl time.Duration) error
+	// Invalidate drops every entry stored for `table`
+	Invalidate(ctx context.Context, table string) error
+}
+
+// WithResultCache caches the results of Read and Count in `c` for `ttl`, keyed by the generated SQL and its args. A
+// create, update, upsert, or delete run by the Executor invalidates the entries of its table, once its transaction
+// commits if it runs in one. Reads within a transaction bypass the cache, as do reads given WithoutCache.
+//
+// Results are encoded as JSON, so messages holding oneof fields can't be cached, and messages holding fields tagged
+// `encrypt` are never cached since their entries would hold the decrypted values. Entries are only invalidated by
+// writes of the same table run through an Executor sharing the cache: reads joining or preloading other tables, and
+// writes made elsewhere, rely on the ttl to bound how stale a result may be. A read racing a write of its table
+// through the Executor isn't stored. Errors of the cache are treated as misses.
+func WithResultCache(c ResultCache, ttl time.Duration) ExecutorOption {
+	return func(e *Executor) {
+		e.cache = c
+		e.cacheTTL = ttl
+		e.cacheGens = &cacheGenerations{tables: make(map[string]uint64)}
+	}
+}
+
+// cacheGenerations counts the invalidations of each table. Results are stored under a read lock if the generation of
+// their table is the one they were read at, and invalidations hold the write lock, so an invalidation either drops a
+// result or keeps it from being stored.
+type cacheGenerations struct {
+	mu     sync.RWMutex
+	tables map[string]uint64
+}
+
+// current returns the generation of `table`
+func (g *cacheGenerations) current(table string) uint64 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.tables[table]
+}
+
+// store runs `set` unless `table` was invalidated since generation `gen`
+func (g *cacheGenerations) store(table string, gen uint64, set func()) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.tables[table] == gen {
+		set()
+	}
+}
+
+// invalidate starts a new generation of `table` and runs `drop`
+func (g *cacheGenerations) invalidate(table string, drop func()) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.tables[table]++
+	drop()
+}
+
+// encryptedTypes caches whether message types hold fields tagged `encrypt`
+var encryptedTypes sync.Map
+
+// holdsEncrypted reports whether the messages scanned into `dest` hold fields tagged `encrypt`
+func holdsEncrypted(dest interface{}) bool {
+	t := rowType(reflect.TypeOf(dest))
+	if t == nil || t.Kind() != reflect.Struct {
+		return false
+	}
+	if cached, ok := encryptedTypes.Load(t); ok {
+		return cached.(bool)
+	}
+	encrypted := false
+	for i := 0; i < t.NumField(); i++ {
+		encrypted = encrypted || t.Field(i).Tag.Get("encrypt") != ""
+	}
+	encryptedTypes.Store(t, encrypted)
+	return encrypted
+}
+
+// WithoutCache reads from the database even if the Executor has a ResultCache, and doesn't store the result.
+// Builders ignore it.
+func WithoutCache() Option {
+	return func(o *options) {
+		o.noCache = true
+	}
+}
+
+// cacheKey returns the key of the result of `qry` bound with `args` scanned into a value of type `dest`
+func (e *Executor) cacheKey(table string, qry string, args []interface{}, dest interface{}) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s", e.DB.DriverName(), reflect.TypeOf(dest), qry, table)
+	for _, arg := range args {
+		fmt.Fprintf(h, "\x00%T:%v", arg, arg)
+	}
+	return table + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// cached runs `fn` to fill `dest` on a miss and stores the result, or decodes a hit into `dest`. It reports whether
+// the result came from the cache.
+func (e *Executor) cached(ctx context.Context, run *queryRun, dest interface{}, o *options, fn func() error) (bool, error) {
+	if e.cache == nil || e.tx != nil || o.noCache || o.lock != 0 || holdsEncrypted(dest) {
+		return false, fn()
+	}
+	key := e.cacheKey(run.info.Table, run.info.Query, run.args, dest)
+	if data, ok, err := e.cache.Get(ctx, key); err == nil && ok {
+		if err := json.Unmarshal(data, dest); err == nil {
+			return true, nil
+		}
+	}
+	gen := e.cacheGens.current(run.info.Table)
+	if err := fn(); err != nil {
+		return false, err
+	}
+	if data, err := json.Marshal(dest); err == nil {
+		e.cacheGens.store(run.info.Table, gen, func() {
+			e.cache.Set(ctx, run.info.Table, key, data, e.cacheTTL)
+		})
+	}
+	return false, nil
+}
+
+// pendingInvalidations collects the tables written within a transaction, which are invalidated once it commits, and
+// the events of its writes, which are then reported to the hooks of the Executor
+type pendingInvalidations struct {
+	mu     sync.Mutex
+	tables []string
+	events []WriteEvent
+}
+
+// invalidate drops the cached results of `table` after a write and refreshes the materialized views computed from it,
+// or defers both to the commit of the transaction
+func (e *Executor) invalidate(ctx context.Context, table string) {
+	if e.cache == nil && e.matViews == nil {
+		return
+	}
+	if e.pending != nil {
+		e.pending.mu.Lock()
+		e.pending.tables = append(e.pending.tables, table)
+		e.pending.mu.Unlock()
+		return
+	}
+	if e.cache != nil {
+		e.cacheGens.invalidate(table, func() {
+			e.cache.Invalidate(ctx, table)
+		})
+	}
+	e.refreshViews(ctx, table)
+}
+
+// LRUCache is an in memory ResultCache holding at most a fixed number of entries, evicting the least recently used
+// one when full. It is safe for concurrent use by multiple goroutines.
+type LRUCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+	tables  map[string]map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	table   string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRUCache returns an in memory cache holding at most `size` results, a size below 1 is treated as 1
+func NewLRUCache(size int) *LRUCache {
+	if size < 1 {
+		size = 1
+	}
+	return &LRUCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		tables:  make(map[string]map[string]*list.Element),
+	}
+}
+
+// Get implements ResultCache
+func (c *LRUCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := el.Value.(*lruEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.remove(el)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(el)
+	return entry.value, true, nil
+}
+
+// Set implements ResultCache
+func (c *LRUCache) Set(ctx context.Context, table string, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	entry := &lruEntry{key: key, table: table, value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	el := c.order.PushFront(entry)
+	c.entries[key] = el
+	if c.tables[table] == nil {
+		c.tables[table] = make(map[string]*list.Element)
+	}
+	c.tables[table][key] = el
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	return nil
+}
+
+// Invalidate implements ResultCache
+func (c *LRUCache) Invalidate(ctx context.Context, table string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.tables[table] {
+		c.remove(el)
+	}
+	return nil
+}
+
+// Len returns the number of cached results
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// remove must be called with c.mu held
+func (c *LRUCache) remove(el *list.Element) {
+	entry := c.order.Remove(el).(*lruEntry)
+	delete(c.entries, entry.key)
+	delete(c.tables[entry.table], entry.key)
+	if len(c.tables[entry.table]) == 0 {
+		delete(c.tables, entry.table)
+	}
+}
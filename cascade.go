@@ -0,0 +1,144 @@
+package pbsql
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Dependent is a table whose rows reference another table through a field tagged `foreign_key` and
+// `foreign_table`, e.g. an `address` message with a `user_id` field tagged `foreign_key:"id" foreign_table:"user"`.
+// Message is a value or pointer of the message stored in Table, only its type is read.
+type Dependent struct {
+	Table   string
+	Message interface{}
+}
+
+// Statement is a bound statement along with its args
+type Statement struct {
+	Query string
+	Args  []interface{}
+}
+
+// cascadeStep is a named delete of the rows of a dependent table
+type cascadeStep struct {
+	table string
+	query string
+}
+
+// BuildCascadeDeleteQueries returns the statements deleting `source` from `target` along with every row of the
+// `dependents` referencing it, directly or through other dependents. Rows of dependent tables are matched with
+// a subquery on the table they reference, and deleted before it so that foreign key constraints hold, which means
+// the statements must run in order and should run within a single transaction, see Executor.DeleteCascade. The last
+// statement is the one BuildDeleteQuery returns.
+//
+// Soft deletes apply to every table with a soft delete field, unless WithHardDelete is given. A dependent
+// referencing a table already being deleted on its path, such as itself, is skipped.
+func BuildCascadeDeleteQueries(target string, source interface{}, dependents []Dependent, opts ...Option) ([]Statement, error) {
+	o := newOptions(opts)
+	target = o.table(target, source)
+	steps, err := cascadeDeleteQueries(target, source, dependents, o)
+	if err != nil {
+		return nil, err
+	}
+	statements := make([]Statement, len(steps))
+	for i, step := range steps {
+		qry, args, err := o.bind(step.query, source)
+		if err != nil {
+			return nil, err
+		}
+		statements[i] = Statement{Query: qry, Args: args}
+	}
+	return statements, nil
+}
+
+// cascadeDeleteQueries returns the named deletes bound by BuildCascadeDeleteQueries, the last of which deletes `source`
+func cascadeDeleteQueries(target string, source interface{}, dependents []Dependent, o *options) ([]cascadeStep, error) {
+	root, err := deleteQuery(target, source, o)
+	if err != nil {
+		return nil, err
+	}
+	keys := primaryKeys(reflect.ValueOf(source).Elem(), target)
+	predicate := strings.TrimPrefix(keyPredicate(keys), "WHERE ")
+	for _, clause := range o.where {
+		predicate += " AND " + clause
+	}
+	steps, err := o.cascadeDeletes(target, predicate, dependents, map[string]bool{target: true})
+	if err != nil {
+		return nil, err
+	}
+	return append(steps, cascadeStep{table: target, query: root}), nil
+}
+
+// cascadeDeletes returns the deletes of every dependent row referencing the rows of `parent` matched by `predicate`,
+// the deepest first
+func (o *options) cascadeDeletes(parent string, predicate string, dependents []Dependent, path map[string]bool) ([]cascadeStep, error) {
+	var steps []cascadeStep
+	for _, dependent := range dependents {
+		if path[dependent.Table] {
+			continue
+		}
+		v := reflect.Indirect(reflect.ValueOf(dependent.Message))
+		if v.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("pbsql: dependent %s has no message, got %T", dependent.Table, dependent.Message)
+		}
+		for i := 0; i < v.NumField(); i++ {
+			field := parseReflection(v, i, dependent.Table)
+			foreignKey := field.self.Tag.Get("foreign_key")
+			if !field.isColumn || foreignKey == "" || field.self.Tag.Get("foreign_table") != parent {
+				continue
+			}
+			if reflect.Indirect(field.value).Kind() == reflect.Struct {
+				continue
+			}
+			childPredicate := fmt.Sprintf("%s IN (SELECT %s.%s FROM %s WHERE %s)", field.column(), parent, foreignKey, parent, predicate)
+			path[dependent.Table] = true
+			nested, err := o.cascadeDeletes(dependent.Table, childPredicate, dependents, path)
+			delete(path, dependent.Table)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, nested...)
+			steps = append(steps, cascadeStep{
+				table: dependent.Table,
+				query: deleteStatement(dependent.Table, v.Type(), o) + "WHERE " + childPredicate,
+			})
+		}
+	}
+	return steps, nil
+}
+
+// DeleteCascade deletes `source` along with every row of the `dependents` referencing it, see
+// BuildCascadeDeleteQueries, within a single transaction. With WithAuditTrail only the row of `source` is recorded in
+// the history table.
+func (e *Executor) DeleteCascade(ctx context.Context, target string, source interface{}, dependents []Dependent, opts ...Option) error {
+	e = e.route(target, source, OpDelete)
+	opts, err := e.secure(ctx, target, OpDelete, source, opts)
+	if err != nil {
+		return err
+	}
+	o := e.options(opts)
+	target = o.table(target, source)
+	steps, err := cascadeDeleteQueries(target, source, dependents, o)
+	if err != nil {
+		return err
+	}
+	return e.inTx(ctx, func(tx *Executor) error {
+		for _, step := range steps[:len(steps)-1] {
+			qry := step.query
+			if _, err := tx.execBuilt(ctx, step.table, OpDelete, func() (string, interface{}, error) {
+				return qry, o.bindSource(source), nil
+			}); err != nil {
+				return err
+			}
+		}
+		root := steps[len(steps)-1].query
+		return tx.audited(ctx, target, source, OpDelete, o, func(tx *Executor) error {
+			_, err := tx.execBuilt(ctx, target, OpDelete, func() (string, interface{}, error) {
+				return root, o.bindSource(source), nil
+			})
+			return err
+		})
+	})
+}
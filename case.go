@@ -0,0 +1,52 @@
+package pbsql
+
+import (
+	"strings"
+)
+
+// WithCaseInsensitive ignores case in the LIKE predicates of string fields, including the phrase matches of
+// BuildSearchQuery: `ILIKE` on Postgres, whose LIKE is case sensitive, and `LOWER(column) LIKE LOWER(:param)`
+// elsewhere. Tag a field `case_insensitive:"y"` to ignore case for that field only. Comparisons by equality, e.g. of
+// converted fields, are left as is.
+//
+// LOWER defeats an index on the column, an index on `LOWER(column)` or a case insensitive collation keeps such
+// predicates fast.
+func WithCaseInsensitive() Option {
+	return func(o *options) {
+		o.caseInsensitive = true
+	}
+}
+
+// like returns the LIKE comparison of `target` with `value`, a named param or a literal, e.g. `user.name LIKE :name`.
+// Folded comparisons ignore case.
+func (d Dialect) like(target string, value string, not bool, fold bool) string {
+	operator := " LIKE "
+	if not {
+		operator = " NOT LIKE "
+	}
+	switch {
+	case !fold:
+		return target + operator + value
+	case d == Postgres:
+		return target + strings.Replace(operator, "LIKE", "ILIKE", 1) + value
+	default:
+		return "LOWER(" + target + ")" + operator + "LOWER(" + value + ")"
+	}
+}
+
+// likePredicate returns the predicate matching a string field by LIKE, e.g. ` AND user.name LIKE :name`
+func (qb *queryBuilder) likePredicate(f *field, predicateStr string, not bool) string {
+	conjunction := predicateStr[:strings.Index(predicateStr, "%")]
+	target := strings.TrimPrefix(f.predicateTarget(predicateStr), conjunction)
+	qb.likes = append(qb.likes, f)
+	predicate := conjunction + qb.dialect.like(target, ":"+f.param(), not, qb.foldsCase(f))
+	if qb.escapeLike {
+		predicate += qb.dialect.likeEscape()
+	}
+	return predicate
+}
+
+// foldsCase reports whether LIKE predicates on `f` ignore case
+func (qb *queryBuilder) foldsCase(f *field) bool {
+	return qb.caseInsensitive || f.isCaseInsensitive
+}
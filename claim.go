@@ -0,0 +1,224 @@
+package pbsql
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Claim describes how rows of a work queue table are claimed by a worker, see BuildClaimQuery
+type Claim struct {
+	// StatusColumn holds the state of a row: rows holding Pending are claimed by setting it to Claimed
+	StatusColumn string
+	Pending      interface{}
+	Claimed      interface{}
+	// WorkerColumn is set to Worker, which identifies the claim. It is required unless the dialect is Postgres,
+	// since the claimed rows are read back by it.
+	WorkerColumn string
+	Worker       interface{}
+	// ClaimedAtColumn is set to the current time if given
+	ClaimedAtColumn string
+	// OrderBy picks the rows claimed first, e.g. `priority desc, id asc`, by primary key if empty
+	OrderBy string
+	// Limit is the number of rows claimed at most, 1 if zero
+	Limit int
+}
+
+// Params bound for the values of a Claim
+const (
+	claimPendingParam = "pbsql_pending"
+	claimClaimedParam = "pbsql_claimed"
+	claimWorkerParam  = "pbsql_worker"
+)
+
+// BuildClaimQuery builds the statement claiming the next pending rows of the work queue `target` for a worker, so
+// that concurrent workers never claim the same row. `source` only describes the table, filter the rows which may be
+// claimed with WithWhere, e.g. by queue name. On Postgres the claimed rows are returned:
+//
+//	UPDATE job SET status = $1, claimed_by = $2, claimed_at = NOW() WHERE (job.id) IN (SELECT job.id FROM job
+//	WHERE job.status = $3 ORDER BY priority desc LIMIT 1 FOR UPDATE SKIP LOCKED) RETURNING job.id, ...
+//
+// MySQL has no RETURNING and can't select from the table it updates, so it claims with `UPDATE ... ORDER BY ...
+// LIMIT` instead, SQLite selects the rows in a subquery without locking them since its writers are serialized. On
+// both, the claimed rows are read with BuildClaimedQuery, which matches them by WorkerColumn: give every claim a
+// Worker of its own, e.g. the worker's name and a random suffix. Executor.Claim runs both statements.
+func BuildClaimQuery(target string, source interface{}, claim Claim, opts ...Option) (string, []interface{}, error) {
+	o := newOptions(opts)
+	target = o.table(target, source)
+	qry, err := claimQuery(target, source, claim, o)
+	if err != nil {
+		return "", nil, err
+	}
+	return o.bind(qry, source)
+}
+
+// BuildClaimedQuery builds the select statement reading the rows claimed by the Worker of `claim`, see
+// BuildClaimQuery
+func BuildClaimedQuery(target string, source interface{}, claim Claim, opts ...Option) (string, []interface{}, error) {
+	o := newOptions(opts)
+	target = o.readRelation(o.table(target, source), source)
+	qry, err := claimedQuery(target, source, claim, o)
+	if err != nil {
+		return "", nil, err
+	}
+	return o.bind(qry, source)
+}
+
+// claimQuery returns the named statement bound by BuildClaimQuery
+func claimQuery(target string, source interface{}, claim Claim, o *options) (string, error) {
+	v := reflect.ValueOf(source).Elem()
+	keys := primaryKeys(v, target)
+	if len(keys) == 0 {
+		return "", fmt.Errorf("%w: cannot claim rows of %s", ErrMissingPrimaryKey, target)
+	}
+	if err := claim.validate(o.dialect); err != nil {
+		return "", err
+	}
+	where, err := o.whereClauses()
+	if err != nil {
+		return "", err
+	}
+	orderBy := claim.OrderBy
+	if orderBy == "" {
+		var columns []string
+		for _, key := range keys {
+			columns = append(columns, key.column()+" asc")
+		}
+		orderBy = strings.Join(columns, ", ")
+	}
+	limit := claim.Limit
+	if limit <= 0 {
+		limit = 1
+	}
+	claim.bindParams(o)
+
+	var builder strings.Builder
+	builder.WriteString("UPDATE " + target + " SET ")
+	builder.WriteString(o.dialect.assignable(target, claim.StatusColumn) + " = :" + claimClaimedParam)
+	if claim.WorkerColumn != "" {
+		builder.WriteString(", " + o.dialect.assignable(target, claim.WorkerColumn) + " = :" + claimWorkerParam)
+	}
+	if claim.ClaimedAtColumn != "" {
+		builder.WriteString(", " + o.dialect.assignable(target, claim.ClaimedAtColumn) + " = " + o.nowExpr())
+	}
+	pending := append([]string{target + "." + claim.StatusColumn + " = :" + claimPendingParam}, where...)
+	if o.dialect == MySQL {
+		builder.WriteString(" WHERE " + strings.Join(pending, " AND "))
+		builder.WriteString(" ORDER BY " + orderBy + " LIMIT " + strconv.Itoa(limit))
+		return builder.String(), nil
+	}
+
+	var columns []string
+	for _, key := range keys {
+		columns = append(columns, key.column())
+	}
+	fmt.Fprintf(&builder, " WHERE (%s) IN (SELECT %s FROM %s WHERE %s ORDER BY %s LIMIT %d%s)",
+		strings.Join(columns, ", "), strings.Join(columns, ", "), target, strings.Join(pending, " AND "), orderBy, limit,
+		o.dialect.lockClause(ForUpdate|SkipLocked, target, false))
+	if o.dialect == Postgres {
+		qb := newQueryBuilder(o.dialect)
+		defer qb.release()
+		if err := qb.writeSelectList(v, target, o); err != nil {
+			return "", err
+		}
+		builder.WriteString(" RETURNING " + qb.selectList())
+	}
+	return builder.String(), nil
+}
+
+// claimedQuery returns the named statement bound by BuildClaimedQuery
+func claimedQuery(target string, source interface{}, claim Claim, o *options) (string, error) {
+	if claim.StatusColumn == "" || claim.WorkerColumn == "" {
+		return "", fmt.Errorf("pbsql: reading the rows claimed from %s requires a status and worker column", target)
+	}
+	qb := newQueryBuilder(o.dialect)
+	defer qb.release()
+	if err := qb.writeSelectList(reflect.ValueOf(source).Elem(), target, o); err != nil {
+		return "", err
+	}
+	if len(qb.selects) == 0 {
+		return "", fmt.Errorf("field mask %v does not match any selectable field of %s", o.fieldMask, target)
+	}
+	claim.bindParams(o)
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s.%s = :%s AND %s.%s = :%s", qb.selectList(), target,
+		target, claim.StatusColumn, claimClaimedParam, target, claim.WorkerColumn, claimWorkerParam), nil
+}
+
+// validate rejects claims missing a column, and orderings which could escape the statement
+func (c Claim) validate(d Dialect) error {
+	if c.StatusColumn == "" {
+		return fmt.Errorf("pbsql: a claim requires a status column")
+	}
+	if d != Postgres && c.WorkerColumn == "" {
+		return fmt.Errorf("pbsql: a claim on %s requires a worker column to read the claimed rows back", d)
+	}
+	if c.OrderBy != "" {
+		return validatePredicate(c.OrderBy, d)
+	}
+	return nil
+}
+
+// bindParams adds the values of the claim to the params of `o`
+func (c Claim) bindParams(o *options) {
+	if o.params == nil {
+		o.params = make(map[string]interface{})
+	}
+	o.params[claimPendingParam] = c.Pending
+	o.params[claimClaimedParam] = c.Claimed
+	o.params[claimWorkerParam] = c.Worker
+}
+
+// Claim claims the next pending rows of the work queue `target` for a worker with BuildClaimQuery and reads them into
+// `dest`, a pointer to a slice of messages of the same type as `source`. It reads nothing if no row is pending.
+// Policies restrict the rows which may be claimed like those of an update.
+func (e *Executor) Claim(ctx context.Context, target string, source interface{}, claim Claim, dest interface{}, opts ...Option) error {
+	e = e.route(target, source, OpUpdate, opts...)
+	opts, err := e.secure(ctx, target, OpUpdate, source, opts)
+	if err != nil {
+		return err
+	}
+	o := e.options(opts)
+	target = o.table(target, source)
+	if o.dialect == Postgres {
+		return e.withOutbox(ctx, func(e *Executor) error {
+			ctx, run := e.start(ctx, target, OpUpdate)
+			err := run.build(func() (string, interface{}, error) {
+				qry, err := claimQuery(target, source, claim, o)
+				return qry, o.bindSource(source), err
+			})
+			if err == nil {
+				if err = e.selectRows(ctx, source, dest, run.info.Query, run.args); err == nil {
+					run.info.Rows = int64(reflect.Indirect(reflect.ValueOf(dest)).Len())
+					err = e.written(ctx, target, OpUpdate, source)
+				}
+			}
+			run.finish(err)
+			return err
+		})
+	}
+
+	return e.inTx(ctx, func(tx *Executor) error {
+		res, err := tx.execBuilt(ctx, target, OpUpdate, func() (string, interface{}, error) {
+			qry, err := claimQuery(target, source, claim, o)
+			return qry, o.bindSource(source), err
+		})
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return nil
+		}
+		ctx, run := tx.start(ctx, target, OpRead)
+		err = run.build(func() (string, interface{}, error) {
+			qry, err := claimedQuery(o.readRelation(target, source), source, claim, o)
+			return qry, o.bindSource(source), err
+		})
+		if err == nil {
+			err = tx.selectRows(ctx, source, dest, run.info.Query, run.args)
+		}
+		run.finish(err)
+		return err
+	})
+}
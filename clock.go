@@ -0,0 +1,50 @@
+package pbsql
+
+import "time"
+
+// Clock tells the builders the current time, e.g. to resolve relative time filters and to set the columns tagged
+// `created_at` or `updated_at`. Tests inject a fixed clock with WithClock or Config.Clock to assert the exact SQL and
+// args of a statement.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc adapts a func to a Clock
+type ClockFunc func() time.Time
+
+// Now returns f()
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// WithClock makes the builder read the current time from `clock` rather than the system clock. Timestamps set by the
+// statement itself are then bound from the clock rather than written as NOW() of the database.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// now returns the current time according to the clock of the options
+func (o *options) now() time.Time {
+	if o.clock != nil {
+		return o.clock.Now()
+	}
+	return time.Now()
+}
+
+// nowParam is the param the time of the clock is bound to in place of NOW()
+const nowParam = "pbsql_now"
+
+// nowExpr returns the expression of the current time written by a statement: NOW() of the dialect, or a param bound to
+// the time of the clock of the options if one is set, so that the statement is deterministic
+func (o *options) nowExpr() string {
+	if o.clock == nil {
+		return o.dialect.now()
+	}
+	if o.params == nil {
+		o.params = make(map[string]interface{})
+	}
+	o.params[nowParam] = o.clock.Now()
+	return ":" + nowParam
+}
@@ -0,0 +1,231 @@
+package pbsql
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Config holds the settings shared by every query built for one database. The package default is used by the
+// Build functions and may be replaced with SetConfig, while a Builder carries its own so that databases with
+// different dialects or policies can be used side by side in one process.
+//
+// Column naming is not part of a Config: rows are scanned and named params are bound by field name without one, so
+// SetSnakeCaseFallback applies to the whole process.
+type Config struct {
+	Dialect Dialect
+	Binder  Binder
+	// SoftDelete turns BuildDeleteQuery into an update for messages holding its field, the zero value always deletes.
+	// WithHardDelete overrides it for a single statement.
+	SoftDelete SoftDelete
+	// Strict builds every query as if WithStrict were given
+	Strict bool
+	// Lifecycle configures the column recording whether a row is active, the zero value has none
+	Lifecycle Lifecycle
+	// TableResolver resolves the target table of every query, nil uses the name given to the builder
+	TableResolver TableResolver
+	// Clock tells the current time, nil writes NOW() of the database and reads the system clock, see WithClock
+	Clock Clock
+	// IDGenerator generates the keys tagged `pk_gen`, nil generates random uuids, see WithIDGenerator
+	IDGenerator IDGenerator
+	// Limits bounds the rows returned by read queries, the zero value leaves them unbounded
+	Limits Limits
+	// Budget caps the complexity of read queries, the zero value leaves it unbounded
+	Budget Budget
+}
+
+// SoftDelete configures soft deletes: messages with a field named Field are deleted by setting Column to Value
+type SoftDelete struct {
+	Field  string
+	Column string
+	// Value is bound for the column of deleted rows, nil binds 0
+	Value interface{}
+}
+
+// softDeleteParam is the named param the value of a soft delete is bound to
+const softDeleteParam = "pbsql_soft_delete"
+
+// DefaultSoftDelete is the soft delete policy of the package default config, which marks rows of messages with an
+// IsActive field as inactive
+var DefaultSoftDelete = SoftDelete{Field: "IsActive", Column: "is_active"}
+
+// DefaultConfig returns the config used before SetConfig, SetDialect, or SetBinder are called
+func DefaultConfig() Config {
+	return Config{Dialect: MySQL, Binder: SQLBinder, SoftDelete: DefaultSoftDelete}
+}
+
+var (
+	defaultConfigMu sync.RWMutex
+	defaultConfig   = DefaultConfig()
+)
+
+// SetConfig replaces the config used by builders that aren't given one with WithConfig. It is safe to call
+// concurrently with query building, though it is usually called once during initialization.
+func SetConfig(cfg Config) {
+	if cfg.Binder == nil {
+		cfg.Binder = SQLBinder
+	}
+	defaultConfigMu.Lock()
+	defer defaultConfigMu.Unlock()
+	defaultConfig = cfg
+}
+
+// CurrentConfig returns the config used by builders that aren't given one with WithConfig
+func CurrentConfig() Config {
+	defaultConfigMu.RLock()
+	defer defaultConfigMu.RUnlock()
+	return defaultConfig
+}
+
+// updateConfig applies `update` to the package default config
+func updateConfig(update func(*Config)) {
+	defaultConfigMu.Lock()
+	defer defaultConfigMu.Unlock()
+	update(&defaultConfig)
+}
+
+// WithConfig builds the query with the dialect, binder, soft delete policy, lifecycle column, strictness, table
+// resolver, clock, id generator, limits, and budget of `cfg` instead of the package default. Options given after it override its settings.
+func WithConfig(cfg Config) Option {
+	return func(o *options) {
+		o.dialect = cfg.Dialect
+		if cfg.Binder != nil {
+			o.binder = cfg.Binder
+		}
+		o.softDelete = cfg.SoftDelete
+		o.strict = cfg.Strict
+		o.lifecycle = cfg.Lifecycle
+		o.tableResolver = cfg.TableResolver
+		o.clock = cfg.Clock
+		o.ids = cfg.IDGenerator
+		o.limits = cfg.Limits
+		o.budget = cfg.Budget
+	}
+}
+
+// softDeleteColumn returns the column a delete of `t` should set instead of deleting the row, if any
+func (s SoftDelete) softDeleteColumn(t reflect.Type) string {
+	if s.Field == "" || s.Column == "" {
+		return ""
+	}
+	if _, ok := t.FieldByName(s.Field); !ok {
+		return ""
+	}
+	return s.Column
+}
+
+// value returns the value bound for the column of deleted rows
+func (s SoftDelete) value() interface{} {
+	if s.Value == nil {
+		return 0
+	}
+	return s.Value
+}
+
+// WithHardDelete deletes rows even if the message has a soft delete field, see Config.SoftDelete
+func WithHardDelete() Option {
+	return func(o *options) {
+		o.hardDelete = true
+	}
+}
+
+// Builder builds queries with a fixed Config. A Builder is immutable and safe for concurrent use by multiple
+// goroutines, so one is usually created per database during initialization and shared, e.g.
+//
+//	reporting := pbsql.NewBuilder(pbsql.Config{Dialect: pbsql.Postgres})
+//	qry, args, err := reporting.BuildReadQuery("user", &pb.User{Email: email})
+type Builder struct {
+	cfg Config
+}
+
+// NewBuilder returns a Builder generating queries with `cfg`. A nil Binder defaults to SQLBinder.
+func NewBuilder(cfg Config) *Builder {
+	if cfg.Binder == nil {
+		cfg.Binder = SQLBinder
+	}
+	return &Builder{cfg: cfg}
+}
+
+// Config returns the config of the Builder
+func (b *Builder) Config() Config {
+	return b.cfg
+}
+
+// with prepends the builder's config to `opts`
+func (b *Builder) with(opts []Option) []Option {
+	return append([]Option{WithConfig(b.cfg)}, opts...)
+}
+
+// BuildCreateQuery behaves like the package level BuildCreateQuery with the builder's config
+func (b *Builder) BuildCreateQuery(target string, source interface{}, opts ...Option) (string, []interface{}, error) {
+	return BuildCreateQuery(target, source, b.with(opts)...)
+}
+
+// BuildIdempotentCreateQuery behaves like the package level BuildIdempotentCreateQuery with the builder's config
+func (b *Builder) BuildIdempotentCreateQuery(target string, source interface{}, opts ...Option) (string, []interface{}, error) {
+	return BuildIdempotentCreateQuery(target, source, b.with(opts)...)
+}
+
+// BuildFilterQuery behaves like the package level BuildFilterQuery with the builder's config
+func (b *Builder) BuildFilterQuery(target string, prototype interface{}, filter interface{}, opts ...Option) (string, []interface{}, error) {
+	return BuildFilterQuery(target, prototype, filter, b.with(opts)...)
+}
+
+// BuildUpsertQuery behaves like the package level BuildUpsertQuery with the builder's config
+func (b *Builder) BuildUpsertQuery(target string, source interface{}, opts ...Option) (string, []interface{}, error) {
+	return BuildUpsertQuery(target, source, b.with(opts)...)
+}
+
+// BuildReadQuery behaves like BuildReadQueryWithOptions with the builder's config
+func (b *Builder) BuildReadQuery(target string, source interface{}, opts ...Option) (string, []interface{}, error) {
+	return BuildReadQueryWithOptions(target, source, b.with(opts)...)
+}
+
+// BuildSearchQuery behaves like the package level BuildSearchQuery with the builder's config
+func (b *Builder) BuildSearchQuery(target string, source interface{}, searchPhrase string, opts ...Option) (string, []interface{}, error) {
+	return BuildSearchQuery(target, source, searchPhrase, b.with(opts)...)
+}
+
+// BuildReadByPKQuery behaves like the package level BuildReadByPKQuery with the builder's config
+func (b *Builder) BuildReadByPKQuery(target string, source interface{}, opts ...Option) (string, []interface{}, error) {
+	return BuildReadByPKQuery(target, source, b.with(opts)...)
+}
+
+// BuildCountQuery behaves like BuildCountQueryWithOptions with the builder's config
+func (b *Builder) BuildCountQuery(target string, source interface{}, opts ...Option) (string, []interface{}, error) {
+	return BuildCountQueryWithOptions(target, source, b.with(opts)...)
+}
+
+// BuildUpdateQuery behaves like the package level BuildUpdateQuery with the builder's config
+func (b *Builder) BuildUpdateQuery(target string, source interface{}, fieldMask []string, opts ...Option) (string, []interface{}, error) {
+	return BuildUpdateQuery(target, source, fieldMask, b.with(opts)...)
+}
+
+// BuildBulkUpdateQuery behaves like the package level BuildBulkUpdateQuery with the builder's config
+func (b *Builder) BuildBulkUpdateQuery(target string, filter, patch interface{}, fieldMask []string, opts ...Option) (string, []interface{}, error) {
+	return BuildBulkUpdateQuery(target, filter, patch, fieldMask, b.with(opts)...)
+}
+
+// BuildDeleteQuery behaves like the package level BuildDeleteQuery with the builder's config
+func (b *Builder) BuildDeleteQuery(target string, source interface{}, opts ...Option) (string, []interface{}, error) {
+	return BuildDeleteQuery(target, source, b.with(opts)...)
+}
+
+// BuildDeleteWhereQuery behaves like the package level BuildDeleteWhereQuery with the builder's config
+func (b *Builder) BuildDeleteWhereQuery(target string, source interface{}, opts ...Option) (string, []interface{}, error) {
+	return BuildDeleteWhereQuery(target, source, b.with(opts)...)
+}
+
+// BuildCascadeDeleteQueries behaves like the package level BuildCascadeDeleteQueries with the builder's config
+func (b *Builder) BuildCascadeDeleteQueries(target string, source interface{}, dependents []Dependent, opts ...Option) ([]Statement, error) {
+	return BuildCascadeDeleteQueries(target, source, dependents, b.with(opts)...)
+}
+
+// BuildSelectQuery behaves like the package level BuildSelectQuery with the builder's config
+func (b *Builder) BuildSelectQuery(target string, source interface{}, opts ...Option) (*SelectQuery, error) {
+	return BuildSelectQuery(target, source, b.with(opts)...)
+}
+
+// BuildCreateTableQuery behaves like the package level BuildCreateTableQuery with the builder's config
+func (b *Builder) BuildCreateTableQuery(target string, source interface{}, opts ...Option) (string, error) {
+	return BuildCreateTableQuery(target, source, b.with(opts)...)
+}
@@ -0,0 +1,186 @@
+package pbsql
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// ConstraintKind is the kind of constraint a statement violated
+type ConstraintKind int
+
+// Kinds of constraint violations recognized by AsConstraintError
+const (
+	// UniqueViolation is a duplicate value of a primary key or unique index
+	UniqueViolation ConstraintKind = iota + 1
+	// ForeignKeyViolation is a reference to a missing row, or the deletion of a row still referenced
+	ForeignKeyViolation
+	// NotNullViolation is a NULL, or a missing value without default, inserted into a NOT NULL column
+	NotNullViolation
+	// CheckViolation is a value rejected by a CHECK constraint
+	CheckViolation
+)
+
+var constraintKindNames = map[ConstraintKind]string{
+	UniqueViolation:     "unique",
+	ForeignKeyViolation: "foreign key",
+	NotNullViolation:    "not null",
+	CheckViolation:      "check",
+}
+
+func (k ConstraintKind) String() string {
+	return constraintKindNames[k]
+}
+
+// ConstraintError describes the constraint violated by a statement, as far as the driver reports it. Its message
+// only names the kind of constraint and the column, never the values of the row, so it is safe to return to clients.
+// The error of the driver is available with errors.Unwrap.
+type ConstraintError struct {
+	Kind ConstraintKind
+	// Constraint is the name of the violated constraint or index, empty if the driver doesn't report it
+	Constraint string
+	// Column is the column holding the offending value, or the columns of the index separated by commas, empty if the
+	// driver doesn't report it
+	Column string
+	Err    error
+}
+
+func (e *ConstraintError) Error() string {
+	msg := "pbsql: " + e.Kind.String() + " constraint violated"
+	if e.Column != "" {
+		msg += " on " + e.Column
+	}
+	return msg
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.Err
+}
+
+// SQLSTATE codes of the constraint violations reported by Postgres
+var constraintStates = map[string]ConstraintKind{
+	"23505": UniqueViolation,
+	"23503": ForeignKeyViolation,
+	"23502": NotNullViolation,
+	"23514": CheckViolation,
+}
+
+// MySQL error numbers of the constraint violations
+var constraintNumbers = map[uint64]ConstraintKind{
+	1062: UniqueViolation,
+	1451: ForeignKeyViolation,
+	1452: ForeignKeyViolation,
+	1048: NotNullViolation,
+	1364: NotNullViolation,
+	3819: CheckViolation,
+}
+
+var (
+	// postgresKey extracts the columns from the detail of a Postgres unique violation, e.g. `Key (email)=(a@b.c)
+	// already exists.`
+	postgresKey = regexp.MustCompile(`Key \(([^)]*)\)=`)
+	// mysqlColumn extracts the column from MySQL not null violations, e.g. `Column 'name' cannot be null`
+	mysqlColumn = regexp.MustCompile(`(?:Column|Field) '([^']*)'`)
+	// mysqlForeignKey extracts the constraint and column from MySQL foreign key violations
+	mysqlForeignKey = regexp.MustCompile("CONSTRAINT `([^`]*)` FOREIGN KEY \\(`([^`]*)`\\)")
+	// mysqlUniqueKey extracts the index from MySQL unique violations, e.g. `Duplicate entry 'x' for key 'user.email'`
+	mysqlUniqueKey = regexp.MustCompile(`for key '([^']*)'$`)
+	// sqliteConstraint extracts the kind and columns of SQLite violations, e.g. `UNIQUE constraint failed: user.email`
+	sqliteConstraint = regexp.MustCompile(`(UNIQUE|NOT NULL|FOREIGN KEY|CHECK) constraint failed(?:: (.*))?$`)
+)
+
+var sqliteKinds = map[string]ConstraintKind{
+	"UNIQUE":      UniqueViolation,
+	"NOT NULL":    NotNullViolation,
+	"FOREIGN KEY": ForeignKeyViolation,
+	"CHECK":       CheckViolation,
+}
+
+// AsConstraintError reports whether `err` is a constraint violation, and if so describes it: Postgres errors of lib/pq
+// and pgx by SQLSTATE, MySQL errors of go-sql-driver/mysql by number, and SQLite errors by message. Like
+// IsTransientError the errors are recognized without linking the drivers.
+func AsConstraintError(err error) (*ConstraintError, bool) {
+	var constraint *ConstraintError
+	if errors.As(err, &constraint) {
+		return constraint, true
+	}
+	for cause := err; cause != nil; cause = errors.Unwrap(cause) {
+		if constraint := constraintOf(cause); constraint != nil {
+			constraint.Err = err
+			return constraint, true
+		}
+	}
+	return nil, false
+}
+
+// constraintOf describes the constraint violation reported by the driver error `err`, nil if it isn't one
+func constraintOf(err error) *ConstraintError {
+	v := reflect.ValueOf(err)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if state, ok := err.(interface{ SQLState() string }); ok {
+		kind, ok := constraintStates[state.SQLState()]
+		if !ok {
+			return nil
+		}
+		constraint := &ConstraintError{
+			Kind:       kind,
+			Constraint: stringField(v, "Constraint", "ConstraintName"),
+			Column:     stringField(v, "Column", "ColumnName"),
+		}
+		if m := postgresKey.FindStringSubmatch(stringField(v, "Detail")); m != nil && constraint.Column == "" {
+			constraint.Column = m[1]
+		}
+		return constraint
+	}
+	if v.Kind() == reflect.Struct {
+		if number := v.FieldByName("Number"); number.IsValid() && number.CanUint() {
+			kind, ok := constraintNumbers[number.Uint()]
+			if !ok {
+				return nil
+			}
+			constraint := &ConstraintError{Kind: kind}
+			msg := stringField(v, "Message")
+			if m := mysqlForeignKey.FindStringSubmatch(msg); m != nil {
+				constraint.Constraint, constraint.Column = m[1], m[2]
+			} else if m := mysqlColumn.FindStringSubmatch(msg); m != nil {
+				constraint.Column = m[1]
+			} else if m := mysqlUniqueKey.FindStringSubmatch(msg); m != nil {
+				// MySQL 8 prefixes the index with its table
+				constraint.Constraint = m[1][strings.LastIndex(m[1], ".")+1:]
+			}
+			return constraint
+		}
+	}
+	if m := sqliteConstraint.FindStringSubmatch(err.Error()); m != nil {
+		constraint := &ConstraintError{Kind: sqliteKinds[m[1]]}
+		if constraint.Kind == CheckViolation {
+			constraint.Constraint = m[2]
+			return constraint
+		}
+		var columns []string
+		for _, column := range strings.Split(m[2], ", ") {
+			if column != "" {
+				columns = append(columns, column[strings.LastIndex(column, ".")+1:])
+			}
+		}
+		constraint.Column = strings.Join(columns, ", ")
+		return constraint
+	}
+	return nil
+}
+
+// stringField returns the first of the string fields `names` of the struct `v` which holds a value
+func stringField(v reflect.Value, names ...string) string {
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	for _, name := range names {
+		if f := v.FieldByName(name); f.IsValid() && f.Kind() == reflect.String && f.String() != "" {
+			return f.String()
+		}
+	}
+	return ""
+}
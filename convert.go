@@ -0,0 +1,214 @@
+package pbsql
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Converter translates between the value of a field tagged `convert:"<name>"` and the value stored in its column
+type Converter interface {
+	// Encode returns the value bound for the column given the value of the field
+	Encode(value interface{}) (interface{}, error)
+	// Decode returns the value of the field given the value scanned from the column, which is nil for null columns
+	Decode(src interface{}) (interface{}, error)
+}
+
+// ColumnTyper may be implemented by a Converter to declare the column type BuildCreateTableQuery creates for its
+// fields, otherwise the type is derived from the field
+type ColumnTyper interface {
+	ColumnType(d Dialect) string
+}
+
+var (
+	convertersMu sync.RWMutex
+	converters   = map[string]Converter{
+		"money": moneyConverter{},
+		"uuid":  uuidConverter{},
+	}
+)
+
+// RegisterConverter makes a Converter available to fields tagged `convert:"<name>"`, replacing any converter
+// registered under the same name. The built in converters are:
+//
+//   - money: a decimal string such as "12.34" stored as integer cents
+//   - uuid: a canonical uuid string stored as 16 bytes
+func RegisterConverter(name string, c Converter) {
+	convertersMu.Lock()
+	defer convertersMu.Unlock()
+	converters[name] = c
+}
+
+// converterOf returns the Converter of a field, nil if the field isn't tagged `convert`, `enum`, or `encrypt`
+func converterOf(self reflect.StructField) (Converter, error) {
+	c, err := fieldConverterOf(self)
+	if err != nil || self.Tag.Get("encrypt") == "" {
+		return c, err
+	}
+	return encryptConverterOf(self, c)
+}
+
+// fieldConverterOf returns the Converter translating the value of a field, nil if the field isn't tagged `convert` or
+// `enum`
+func fieldConverterOf(self reflect.StructField) (Converter, error) {
+	name := self.Tag.Get("convert")
+	if name == "" {
+		return enumConverterOf(self)
+	}
+	convertersMu.RLock()
+	defer convertersMu.RUnlock()
+	c, ok := converters[name]
+	if !ok {
+		return nil, fmt.Errorf("pbsql: no converter registered as %q for field %s", name, self.Name)
+	}
+	return c, nil
+}
+
+// hasConverter reports whether a field is stored through a Converter, i.e. tagged `convert`, `enum`, or `encrypt`
+func hasConverter(self reflect.StructField) bool {
+	return self.Tag.Get("convert") != "" || self.Tag.Get("enum") != "" || self.Tag.Get("encrypt") != ""
+}
+
+// decodeInto decodes `src` with `c` and assigns the result to the field `v`
+func decodeInto(c Converter, src interface{}, v reflect.Value) error {
+	decoded, err := c.Decode(src)
+	if err != nil {
+		return err
+	}
+	if decoded == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	value := reflect.ValueOf(decoded)
+	if !value.Type().ConvertibleTo(v.Type()) {
+		return fmt.Errorf("pbsql: cannot assign decoded %T to a field of type %s", decoded, v.Type())
+	}
+	v.Set(value.Convert(v.Type()))
+	return nil
+}
+
+// asString returns a scanned or field value as a string, drivers return text columns as either []byte or string
+func asString(value interface{}) (string, bool) {
+	switch s := value.(type) {
+	case string:
+		return s, true
+	case []byte:
+		return string(s), true
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.String {
+		return v.String(), true
+	}
+	return "", false
+}
+
+// moneyConverter stores decimal strings with at most two fractional digits as integer cents
+type moneyConverter struct{}
+
+func (moneyConverter) Encode(value interface{}) (interface{}, error) {
+	s, ok := asString(value)
+	if !ok {
+		return nil, fmt.Errorf("pbsql: money converter expects a string, got %T", value)
+	}
+	if s == "" {
+		return nil, nil
+	}
+	negative := strings.HasPrefix(s, "-")
+	whole, fraction, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	if len(fraction) > 2 {
+		return nil, fmt.Errorf("pbsql: money value %q has more than two decimals", s)
+	}
+	cents, err := strconv.ParseInt(whole+(fraction + "00")[:2], 10, 64)
+	if err != nil || whole == "" {
+		return nil, fmt.Errorf("pbsql: invalid money value %q", s)
+	}
+	if negative {
+		cents = -cents
+	}
+	return cents, nil
+}
+
+func (moneyConverter) Decode(src interface{}) (interface{}, error) {
+	var cents int64
+	switch n := src.(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		cents = n
+	default:
+		s, ok := asString(src)
+		if !ok {
+			return nil, fmt.Errorf("pbsql: cannot decode money from %T", src)
+		}
+		if s == "" {
+			return "", nil
+		}
+		var err error
+		if cents, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, fmt.Errorf("pbsql: invalid money column value %q", s)
+		}
+	}
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100), nil
+}
+
+func (moneyConverter) ColumnType(d Dialect) string {
+	return "BIGINT"
+}
+
+// uuidConverter stores canonical uuid strings as 16 bytes
+type uuidConverter struct{}
+
+func (uuidConverter) Encode(value interface{}) (interface{}, error) {
+	s, ok := asString(value)
+	if !ok {
+		return nil, fmt.Errorf("pbsql: uuid converter expects a string, got %T", value)
+	}
+	if s == "" {
+		return nil, nil
+	}
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 {
+		return nil, fmt.Errorf("pbsql: invalid uuid %q", s)
+	}
+	return b, nil
+}
+
+func (uuidConverter) Decode(src interface{}) (interface{}, error) {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return nil, fmt.Errorf("pbsql: cannot decode uuid from %T", src)
+	}
+	if len(b) == 0 {
+		return "", nil
+	}
+	if len(b) != 16 {
+		return nil, fmt.Errorf("pbsql: uuid column value has %d bytes, expected 16", len(b))
+	}
+	h := hex.EncodeToString(b)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+func (uuidConverter) ColumnType(d Dialect) string {
+	switch d {
+	case Postgres:
+		return "BYTEA"
+	case SQLite:
+		return "BLOB"
+	default:
+		return "BINARY(16)"
+	}
+}
@@ -0,0 +1,364 @@
+package pbsql
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// CopySource reads the rows of a slice or channel of messages for bulk loads, see Executor.CopyFrom. Its methods
+// match pgx.CopyFromSource, so it can be handed to the CopyFrom method of a pgx connection as is, see
+// pbsqlpgx.CopyFrom.
+type CopySource struct {
+	// Columns lists the columns loaded, in the order of the values of each row
+	Columns []string
+
+	target    string
+	prototype interface{}
+	fields    []copyField
+	next      func() (reflect.Value, bool)
+	row       reflect.Value
+	now       time.Time
+	ids       IDGenerator
+	err       error
+}
+
+// copyField is a field of the copied messages stored in one of the Columns
+type copyField struct {
+	index int
+	self  reflect.StructField
+	// isAutoTimestamp is set for fields tagged `created_at` or `updated_at`, which are loaded with the current time
+	isAutoTimestamp bool
+}
+
+// NewCopySource returns a CopySource reading the messages of `source` into `target`. `source` is a slice of
+// messages, or a channel of them which is read until it is closed. Every column of the messages is loaded, zero
+// values included, except readonly columns and a single integer primary key, which is left to the database to
+// generate. Keys tagged `pk_gen` are generated while unset, and fields tagged `created_at` or `updated_at` are loaded
+// with the current time, both read from the clock and id generator of `opts`, see WithClock and WithIDGenerator.
+func NewCopySource(target string, source interface{}, opts ...Option) (*CopySource, error) {
+	v := reflect.ValueOf(source)
+	if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Slice {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice && (v.Kind() != reflect.Chan || v.Type().ChanDir()&reflect.RecvDir == 0) {
+		return nil, fmt.Errorf("pbsql: cannot copy from %T, expected a slice or channel of messages", source)
+	}
+	t := v.Type().Elem()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("pbsql: cannot copy from %T, expected a slice or channel of messages", source)
+	}
+	prototype := reflect.New(t)
+	o := newOptions(opts)
+	src := &CopySource{target: target, prototype: prototype.Interface(), now: o.now(), ids: o.idGenerator()}
+	keys := primaryKeys(prototype.Elem(), target)
+	for i := 0; i < prototype.Elem().NumField(); i++ {
+		f := parseReflection(prototype.Elem(), i, target)
+		if !f.isColumn || f.isReadonly || !f.permits(permCreate) || f.shouldIgnore || f.selectFunc.ok || !f.value.CanInterface() {
+			continue
+		}
+		if f.isPrimaryKey && len(keys) == 1 && f.self.Tag.Get("pk_gen") == "" && isIntegerKind(f.value.Kind()) {
+			continue
+		}
+		src.Columns = append(src.Columns, f.name)
+		src.fields = append(src.fields, copyField{index: i, self: f.self, isAutoTimestamp: f.isAutoTimestamp()})
+	}
+	if len(src.Columns) == 0 {
+		return nil, fmt.Errorf("pbsql: %s has no column to copy into %s", t, target)
+	}
+
+	if v.Kind() == reflect.Slice {
+		i := 0
+		src.next = func() (reflect.Value, bool) {
+			if i >= v.Len() {
+				return reflect.Value{}, false
+			}
+			i++
+			return v.Index(i - 1), true
+		}
+	} else {
+		src.next = func() (reflect.Value, bool) {
+			return v.Recv()
+		}
+	}
+	return src, nil
+}
+
+// Next advances to the next row, reporting false once every row has been read or reading one failed
+func (s *CopySource) Next() bool {
+	if s.err != nil {
+		return false
+	}
+	row, ok := s.next()
+	if !ok {
+		return false
+	}
+	if row.Kind() == reflect.Ptr {
+		if row.IsNil() {
+			s.err = fmt.Errorf("pbsql: cannot copy a nil message into %s", s.target)
+			return false
+		}
+		row = row.Elem()
+	}
+	if !row.CanAddr() {
+		// messages received from a channel by value are copied, so that generated keys can be set
+		addressable := reflect.New(row.Type()).Elem()
+		addressable.Set(row)
+		row = addressable
+	}
+	if s.err = generateKeys(row, s.target, s.ids); s.err != nil {
+		return false
+	}
+	s.row = row
+	return true
+}
+
+// Values returns the values of the current row, in the order of the Columns
+func (s *CopySource) Values() ([]interface{}, error) {
+	values := make([]interface{}, len(s.fields))
+	for i, f := range s.fields {
+		if f.isAutoTimestamp {
+			values[i] = s.now
+			continue
+		}
+		value, err := fieldArg(f.self, s.row.Field(f.index))
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// Err returns the error which stopped Next, if any
+func (s *CopySource) Err() error {
+	return s.err
+}
+
+// loadDataHandlers registers and removes the io.Reader a `LOAD DATA LOCAL INFILE 'Reader::<name>'` statement reads
+type loadDataHandlers struct {
+	register   func(name string, handler func() io.Reader)
+	deregister func(name string)
+}
+
+// loadDataReaders numbers the readers registered for LOAD DATA statements, so concurrent loads don't share one
+var loadDataReaders atomic.Uint64
+
+// WithLoadData enables `LOAD DATA LOCAL INFILE` for CopyFrom on MySQL. The MySQL driver reads the rows from a
+// registered reader, so the functions registering one must be handed over, e.g.
+//
+//	exec := pbsql.NewExecutor(db, pbsql.WithLoadData(mysql.RegisterReaderHandler, mysql.DeregisterReaderHandler))
+//
+// The server must allow `local_infile`.
+func WithLoadData(register func(name string, handler func() io.Reader), deregister func(name string)) ExecutorOption {
+	return func(e *Executor) {
+		e.loadData = &loadDataHandlers{register: register, deregister: deregister}
+	}
+}
+
+// CopyFrom loads the messages of `source`, a slice or channel of messages, into `target` in bulk and returns the
+// number of rows loaded, see NewCopySource for the columns loaded. It is meant for large imports which multi-row
+// inserts would make slow:
+//
+//   - on Postgres with lib/pq the rows are streamed with `COPY ... FROM STDIN` in a transaction, use
+//     pbsqlpgx.CopyFrom with pgx connections
+//   - on MySQL they are streamed with `LOAD DATA LOCAL INFILE` if the Executor was created WithLoadData
+//   - elsewhere every row is inserted by a single statement in a transaction
+//
+// Policies may deny the load like a Create, they are given `source` rather than a single message. Results cached for
+// `target` are invalidated.
+func (e *Executor) CopyFrom(ctx context.Context, target string, source interface{}, opts ...Option) (n int64, err error) {
+	src, err := NewCopySource(target, source)
+	if err != nil {
+		return 0, err
+	}
+	e = e.route(target, src.prototype, OpCreate, opts...)
+	if opts, err = e.secure(ctx, target, OpCreate, source, opts); err != nil {
+		return 0, err
+	}
+	o := e.options(opts)
+	target = o.table(target, src.prototype)
+	src.target, src.now, src.ids = target, o.now(), o.idGenerator()
+
+	ctx, run := e.start(ctx, target, OpCreate)
+	run.started = time.Now()
+	defer func() {
+		run.info.Rows = n
+		run.finish(err)
+	}()
+	switch {
+	case o.dialect == Postgres && e.DB.DriverName() == "postgres":
+		run.info.Query = pq.CopyIn(target, src.Columns...)
+		if schema, table := splitTable(target); schema != "" {
+			run.info.Query = pq.CopyInSchema(schema, table, src.Columns...)
+		}
+		err = e.inTx(ctx, func(tx *Executor) error {
+			n, err = tx.copyIn(ctx, run.info.Query, src)
+			return err
+		})
+	case o.dialect == MySQL && e.loadData != nil:
+		n, err = e.loadDataFrom(ctx, run, target, src)
+	default:
+		names := make([]string, len(src.Columns))
+		for i := range names {
+			names[i] = "?"
+		}
+		run.info.Query = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", target, strings.Join(src.Columns, ", "), strings.Join(names, ", "))
+		err = e.inTx(ctx, func(tx *Executor) error {
+			n, err = tx.insertRows(ctx, run.info.Query, src)
+			return err
+		})
+	}
+	if err == nil {
+		e.invalidate(ctx, target)
+	}
+	return n, err
+}
+
+// splitTable splits a schema qualified table, the schema is empty if `target` has none
+func splitTable(target string) (string, string) {
+	if dot := strings.LastIndex(target, "."); dot >= 0 {
+		return target[:dot], target[dot+1:]
+	}
+	return "", target
+}
+
+// copyIn streams the rows of `src` through the lib/pq COPY statement `qry`, which must run in a transaction
+func (e *Executor) copyIn(ctx context.Context, qry string, src *CopySource) (int64, error) {
+	stmt, err := e.tx.PrepareContext(ctx, qry)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	var n int64
+	for src.Next() {
+		values, err := src.Values()
+		if err != nil {
+			return n, err
+		}
+		if _, err := stmt.ExecContext(ctx, values...); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := src.Err(); err != nil {
+		return n, err
+	}
+	// executing the statement without values ends the COPY
+	_, err = stmt.ExecContext(ctx)
+	return n, err
+}
+
+// insertRows inserts every row of `src` with the insert statement `qry`
+func (e *Executor) insertRows(ctx context.Context, qry string, src *CopySource) (int64, error) {
+	stmt, err := e.tx.PrepareContext(ctx, e.DB.Rebind(qry))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	var n int64
+	for src.Next() {
+		values, err := src.Values()
+		if err != nil {
+			return n, err
+		}
+		if _, err := stmt.ExecContext(ctx, values...); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, src.Err()
+}
+
+// loadDataFrom streams the rows of `src` as tab separated values to a `LOAD DATA LOCAL INFILE` statement
+func (e *Executor) loadDataFrom(ctx context.Context, run *queryRun, target string, src *CopySource) (int64, error) {
+	name := "pbsql_copy_" + strconv.FormatUint(loadDataReaders.Add(1), 10)
+	r, w := io.Pipe()
+	e.loadData.register(name, func() io.Reader { return r })
+	defer e.loadData.deregister(name)
+
+	go func() {
+		w.CloseWithError(writeTSV(w, src))
+	}()
+	// unblocks the writer if the statement fails before reading every row
+	defer r.Close()
+
+	run.info.Query = fmt.Sprintf(`LOAD DATA LOCAL INFILE 'Reader::%s' INTO TABLE %s FIELDS TERMINATED BY '\t' ESCAPED BY '\\' LINES TERMINATED BY '\n' (%s)`,
+		name, target, strings.Join(src.Columns, ", "))
+	res, err := e.ext().ExecContext(ctx, run.info.Query)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// writeTSV writes the rows of `src` in the format read by LOAD DATA: values separated by tabs, rows by newlines,
+// NULL as `\N`, and tabs, newlines, and backslashes escaped with a backslash
+func writeTSV(w io.Writer, src *CopySource) error {
+	bw := bufio.NewWriter(w)
+	for src.Next() {
+		values, err := src.Values()
+		if err != nil {
+			return err
+		}
+		for i, value := range values {
+			if i > 0 {
+				bw.WriteByte('\t')
+			}
+			if err := writeTSVValue(bw, value); err != nil {
+				return err
+			}
+		}
+		bw.WriteByte('\n')
+	}
+	if err := src.Err(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`, "\x00", `\0`)
+
+// writeTSVValue writes a single value of a LOAD DATA row
+func writeTSVValue(w *bufio.Writer, value interface{}) error {
+	if valuer, ok := value.(driver.Valuer); ok {
+		var err error
+		if value, err = valuer.Value(); err != nil {
+			return err
+		}
+	}
+	switch v := value.(type) {
+	case nil:
+		_, err := w.WriteString(`\N`)
+		return err
+	case bool:
+		if v {
+			return w.WriteByte('1')
+		}
+		return w.WriteByte('0')
+	case time.Time:
+		_, err := w.WriteString(v.Format("2006-01-02 15:04:05.999999"))
+		return err
+	case []byte:
+		_, err := tsvEscaper.WriteString(w, string(v))
+		return err
+	case string:
+		_, err := tsvEscaper.WriteString(w, v)
+		return err
+	default:
+		_, err := tsvEscaper.WriteString(w, fmt.Sprint(v))
+		return err
+	}
+}
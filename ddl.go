@@ -0,0 +1,224 @@
+package pbsql
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// BuildCreateTableQuery accepts a target table name and a protobuf message and builds a CREATE TABLE statement with a
+// column for every field tagged with `db:""`, mapping go types to column types of the configured dialect, e.g.
+// int32 to INT, string to VARCHAR(255) or TEXT, float64 to DOUBLE, and Timestamp to TIMESTAMP.
+//
+// Columns are NOT NULL unless tagged `nullable`. Fields tagged `primary_key` form the primary key, a single integer
+// key is generated by the database as the other builders assume. Fields tagged `foreign_key` and `foreign_table`
+// reference that table, whereas related messages are left out since their key may live on either table. Fields
+// tagged `created_at` or `updated_at` are TIMESTAMP columns whatever their type, fields tagged `readonly` are left
+// out since they are usually computed or backed by a view.
+//
+// This is intended for spinning up schemas in tests, not for managing production migrations.
+func BuildCreateTableQuery(target string, source interface{}, opts ...Option) (string, error) {
+	o := newOptions(opts)
+	target = o.table(target, source)
+	columns, constraints, err := tableDefinition(target, source, o)
+	if err != nil {
+		return "", err
+	}
+	definitions := make([]string, 0, len(columns)+len(constraints))
+	for _, column := range columns {
+		definitions = append(definitions, column.definition)
+	}
+	return fmt.Sprintf("CREATE TABLE %s (%s)", target, strings.Join(append(definitions, constraints...), ", ")), nil
+}
+
+// columnDefinition is a column of a table generated from a message
+type columnDefinition struct {
+	ColumnInfo
+	// definition is the complete column definition, e.g. `name VARCHAR(255) NOT NULL`
+	definition string
+}
+
+// tableDefinition returns the columns and constraints of the table storing `source`
+func tableDefinition(target string, source interface{}, o *options) ([]columnDefinition, []string, error) {
+	v := reflect.Indirect(reflect.ValueOf(source))
+
+	keys := primaryKeys(v, target)
+	generatedKey := len(keys) == 1 && isIntegerKind(keys[0].value.Kind())
+
+	var columns []columnDefinition
+	var constraints []string
+	for i := 0; i < v.NumField(); i++ {
+		field := parseReflection(v, i, target)
+		if !field.isColumn || field.shouldIgnore || field.selectFunc.ok || field.isMultiValue || field.isReadonly {
+			continue
+		}
+		if generatedKey && field.isPrimaryKey {
+			columnType := o.dialect.generatedKeyType(field.value.Kind())
+			columns = append(columns, columnDefinition{
+				ColumnInfo: ColumnInfo{Name: field.name, DataType: strings.Fields(columnType)[0]},
+				definition: field.name + " " + columnType,
+			})
+			continue
+		}
+		columnType, ok := o.dialect.columnType(field.self.Type, field.isAutoTimestamp())
+		c, err := converterOf(field.self)
+		if err != nil {
+			return nil, nil, err
+		}
+		if typer, isTyper := c.(ColumnTyper); isTyper {
+			columnType = typer.ColumnType(o.dialect)
+			ok = columnType != ""
+		} else if field.isJSON {
+			columnType, ok = o.dialect.jsonType(), true
+		} else if field.array == arrayColumn {
+			columnType, ok = o.dialect.arrayType(field.self.Type)
+		}
+		if !ok {
+			return nil, nil, fmt.Errorf("pbsql: cannot map field %s of type %s to a column type", field.self.Name, field.self.Type)
+		}
+		definition := field.name + " " + columnType
+		if !field.isNullable {
+			definition += " NOT NULL"
+		}
+		columns = append(columns, columnDefinition{
+			ColumnInfo: ColumnInfo{Name: field.name, DataType: columnType, Nullable: field.isNullable},
+			definition: definition,
+		})
+
+		foreignKey := field.self.Tag.Get("foreign_key")
+		foreignTable := field.self.Tag.Get("foreign_table")
+		if foreignKey != "" && foreignTable != "" {
+			constraints = append(constraints, fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s (%s)", field.name, foreignTable, foreignKey))
+		}
+	}
+	if len(columns) == 0 {
+		return nil, nil, fmt.Errorf("pbsql: %s has no fields tagged with db", target)
+	}
+
+	if len(keys) > 0 && !(generatedKey && o.dialect == SQLite) {
+		names := make([]string, len(keys))
+		for i, key := range keys {
+			names[i] = key.name
+		}
+		constraints = append([]string{"PRIMARY KEY (" + strings.Join(names, ", ") + ")"}, constraints...)
+	}
+	return columns, constraints, nil
+}
+
+// columnType returns the column type storing values of `t`, or false if there is none
+func (d Dialect) columnType(t reflect.Type, isTimestamp bool) (string, bool) {
+	if isTimestamp || isTimestampType(t) {
+		return "TIMESTAMP", true
+	}
+	if isOptionalBool(t) {
+		return "BOOLEAN", true
+	}
+	if isOptionalValue(t) {
+		return d.columnType(optionalValueType(t), isTimestamp)
+	}
+	if isNullType(t) {
+		return d.columnType(nullValueType(t), isTimestamp)
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "BOOLEAN", true
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		if d == MySQL {
+			return "INT", true
+		}
+		return "INTEGER", true
+	case reflect.Uint32:
+		if d == MySQL {
+			return "INT UNSIGNED", true
+		}
+		return "BIGINT", true
+	case reflect.Int, reflect.Int64:
+		return "BIGINT", true
+	case reflect.Uint, reflect.Uint64:
+		if d == MySQL {
+			return "BIGINT UNSIGNED", true
+		}
+		return "NUMERIC(20)", true
+	case reflect.Float32:
+		if d == MySQL {
+			return "FLOAT", true
+		}
+		return "REAL", true
+	case reflect.Float64:
+		switch d {
+		case Postgres:
+			return "DOUBLE PRECISION", true
+		case SQLite:
+			return "REAL", true
+		}
+		return "DOUBLE", true
+	case reflect.String:
+		// MySQL can't index TEXT columns without a prefix length
+		if d == MySQL {
+			return "VARCHAR(255)", true
+		}
+		return "TEXT", true
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			if d == Postgres {
+				return "BYTEA", true
+			}
+			return "BLOB", true
+		}
+	}
+	return "", false
+}
+
+// jsonType returns the column type of fields tagged `json_column`
+func (d Dialect) jsonType() string {
+	switch d {
+	case Postgres:
+		return "JSONB"
+	case SQLite:
+		return "TEXT"
+	default:
+		return "JSON"
+	}
+}
+
+// generatedKeyType returns the column definition of a primary key generated by the database
+func (d Dialect) generatedKeyType(kind reflect.Kind) string {
+	wide := kind == reflect.Int || kind == reflect.Int64 || kind == reflect.Uint || kind == reflect.Uint64
+	switch d {
+	case Postgres:
+		if wide {
+			return "BIGSERIAL"
+		}
+		return "SERIAL"
+	case SQLite:
+		return "INTEGER PRIMARY KEY AUTOINCREMENT"
+	default:
+		if wide {
+			return "BIGINT NOT NULL AUTO_INCREMENT"
+		}
+		return "INT NOT NULL AUTO_INCREMENT"
+	}
+}
+
+// isTimestampType reports whether `t` is a time.Time or a protobuf Timestamp
+func isTimestampType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return true
+	}
+	return t.Name() == "Timestamp" && t.PkgPath() == "google.golang.org/protobuf/types/known/timestamppb"
+}
+
+func isIntegerKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package pbsql
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClauseKind identifies the part of a statement a FieldClause was written to
+type ClauseKind string
+
+// Kinds of clauses reported by DebugReadQuery
+const (
+	ClauseSelect ClauseKind = "select"
+	ClauseWhere  ClauseKind = "where"
+	ClauseJoin   ClauseKind = "join"
+)
+
+// FieldClause records a clause written for a struct field
+type FieldClause struct {
+	// Field is the name of the go struct field, related fields are written as `Role.Name` and geo filters as the
+	// name of their point
+	Field  string
+	Column string
+	Kind   ClauseKind
+	// Clause is the select list entry, predicate, or join as written to the named query
+	Clause string
+}
+
+// DebugQuery describes a read query without executing it, see DebugReadQuery
+type DebugQuery struct {
+	// Query and Args are the statement and args as bound by SQLBinder
+	Query string
+	Args  []interface{}
+	// SQL is the statement with every arg interpolated, which is meant for reading and logging only
+	SQL string
+	// Clauses lists the clauses written for each field in order
+	Clauses []FieldClause
+}
+
+// DebugReadQuery builds the read query BuildReadQueryWithOptions would and returns it along with its interpolated
+// SQL and the clauses each field contributed. The query is always bound with SQLBinder so the args are positional.
+func DebugReadQuery(target string, source interface{}, opts ...Option) (*DebugQuery, error) {
+	o := newOptions(opts)
+	target = o.readRelation(o.table(target, source), source)
+	o.trace = true
+	q, err := selectQuery(target, source, o)
+	if err != nil {
+		return nil, err
+	}
+	qry, args, err := SQLBinder.Bind(q.Named(), withParams(source, q.Params), o.dialect)
+	if err != nil {
+		return nil, err
+	}
+	interpolated, err := Interpolate(qry, args, o.dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &DebugQuery{Query: qry, Args: args, SQL: interpolated, Clauses: q.clauses}, nil
+}
+
+// Interpolate replaces the placeholders of a statement bound for `d` with its args written as escaped literals, e.g.
+// for logging a statement returned by any of the builders. Placeholders within quotes are left alone.
+//
+// The result is meant to be read, not executed: always run statements with bound args.
+func Interpolate(qry string, args []interface{}, d Dialect) (string, error) {
+	var builder strings.Builder
+	var quote byte
+	next := 0
+	for i := 0; i < len(qry); i++ {
+		c := qry[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?' && d != Postgres:
+			if next >= len(args) {
+				return "", fmt.Errorf("pbsql: statement has more placeholders than the %d args given", len(args))
+			}
+			literal, err := d.literal(args[next])
+			if err != nil {
+				return "", err
+			}
+			builder.WriteString(literal)
+			next++
+			continue
+		case c == '$' && d == Postgres && i+1 < len(qry) && qry[i+1] >= '0' && qry[i+1] <= '9':
+			j := i + 1
+			for j < len(qry) && qry[j] >= '0' && qry[j] <= '9' {
+				j++
+			}
+			n, _ := strconv.Atoi(qry[i+1 : j])
+			if n < 1 || n > len(args) {
+				return "", fmt.Errorf("pbsql: placeholder $%d has no matching arg", n)
+			}
+			literal, err := d.literal(args[n-1])
+			if err != nil {
+				return "", err
+			}
+			builder.WriteString(literal)
+			if n > next {
+				next = n
+			}
+			i = j - 1
+			continue
+		}
+		builder.WriteByte(c)
+	}
+	if next != len(args) {
+		return "", fmt.Errorf("pbsql: statement uses %d of the %d args given", next, len(args))
+	}
+	return builder.String(), nil
+}
+
+// literal writes an arg as an escaped SQL literal
+func (d Dialect) literal(arg interface{}) (string, error) {
+	if valuer, ok := arg.(driver.Valuer); ok {
+		value, err := valuer.Value()
+		if err != nil {
+			return "", err
+		}
+		return d.literal(value)
+	}
+	switch v := arg.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		return d.quote(v), nil
+	case []byte:
+		if d == Postgres {
+			return `'\x` + hex.EncodeToString(v) + `'`, nil
+		}
+		return "X'" + hex.EncodeToString(v) + "'", nil
+	case bool:
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case time.Time:
+		return d.quote(v.Format("2006-01-02 15:04:05.999999")), nil
+	}
+	v := reflect.ValueOf(arg)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), nil
+	case reflect.String:
+		return d.quote(v.String()), nil
+	case reflect.Bool:
+		return d.literal(v.Bool())
+	}
+	return "", fmt.Errorf("pbsql: cannot interpolate an arg of type %T", arg)
+}
+
+// quote writes a string literal, MySQL also treats backslashes as escapes
+func (d Dialect) quote(s string) string {
+	s = strings.ReplaceAll(s, "'", "''")
+	if d == MySQL {
+		s = strings.ReplaceAll(s, `\`, `\\`)
+	}
+	return "'" + s + "'"
+}
+
+// attribute records a clause written for `f`
+func (qb *queryBuilder) attribute(f *field, kind ClauseKind, clause string) {
+	if !qb.trace {
+		return
+	}
+	qb.clauses = append(qb.clauses, FieldClause{Field: f.self.Name, Column: f.name, Kind: kind, Clause: clause})
+}
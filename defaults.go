@@ -0,0 +1,74 @@
+package pbsql
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// BeforeCreater is implemented by messages which populate their defaults, such as a status, timestamps, or computed
+// codes, before an Executor creates them
+type BeforeCreater interface {
+	BeforeCreate(ctx context.Context) error
+}
+
+// BeforeCreateHook populates the defaults of `msg`, a pointer to a message of the type it was registered for, see
+// RegisterBeforeCreate
+type BeforeCreateHook func(ctx context.Context, msg interface{}) error
+
+var (
+	beforeCreateMu    sync.RWMutex
+	beforeCreateHooks = make(map[reflect.Type][]BeforeCreateHook)
+)
+
+// RegisterBeforeCreate makes an Executor call `hook` on messages of the type of `msg` before it creates them, e.g.:
+//
+//	pbsql.RegisterBeforeCreate(&pb.Order{}, func(ctx context.Context, msg interface{}) error {
+//		order := msg.(*pb.Order)
+//		if order.Status == "" {
+//			order.Status = "pending"
+//		}
+//		return nil
+//	})
+//
+// Hooks run in the order they were registered, after the BeforeCreate method of messages implementing BeforeCreater,
+// at the start of Create, CreateAndRead, CreateIdempotent, and Upsert, so that policies and validation see the
+// defaults. An error of a hook is returned as is and nothing is written. A nil hook removes the hooks of the type.
+func RegisterBeforeCreate(msg interface{}, hook BeforeCreateHook) {
+	t := reflect.TypeOf(msg)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	beforeCreateMu.Lock()
+	defer beforeCreateMu.Unlock()
+	if hook == nil {
+		delete(beforeCreateHooks, t)
+		return
+	}
+	beforeCreateHooks[t] = append(beforeCreateHooks[t], hook)
+}
+
+// beforeCreate populates the defaults of `source` before it is created, see RegisterBeforeCreate
+func beforeCreate(ctx context.Context, source interface{}) error {
+	if msg, ok := source.(BeforeCreater); ok {
+		if err := msg.BeforeCreate(ctx); err != nil {
+			return err
+		}
+	}
+	t := reflect.TypeOf(source)
+	if t == nil {
+		return nil
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	beforeCreateMu.RLock()
+	hooks := beforeCreateHooks[t]
+	beforeCreateMu.RUnlock()
+	for _, hook := range hooks {
+		if err := hook(ctx, source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
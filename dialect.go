@@ -0,0 +1,138 @@
+package pbsql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Dialect identifies the flavour of SQL generated by the query builders
+type Dialect int
+
+// Supported dialects, MySQL is the default
+const (
+	MySQL Dialect = iota
+	Postgres
+	SQLite
+)
+
+// SetDialect changes the dialect used by builders that aren't given one with WithDialect or WithConfig
+func SetDialect(d Dialect) {
+	updateConfig(func(cfg *Config) { cfg.Dialect = d })
+}
+
+// WithDialect generates SQL for the given dialect instead of the package default
+func WithDialect(d Dialect) Option {
+	return func(o *options) {
+		o.dialect = d
+	}
+}
+
+// DialectFromDriver returns the dialect matching a database/sql driver name, as reported by sqlx.DB.DriverName
+func DialectFromDriver(driverName string) Dialect {
+	switch sqlx.BindType(driverName) {
+	case sqlx.DOLLAR:
+		return Postgres
+	}
+	switch driverName {
+	case "sqlite3", "sqlite":
+		return SQLite
+	}
+	return MySQL
+}
+
+func (d Dialect) String() string {
+	switch d {
+	case Postgres:
+		return "postgres"
+	case SQLite:
+		return "sqlite"
+	default:
+		return "mysql"
+	}
+}
+
+// now returns the expression for the current timestamp
+func (d Dialect) now() string {
+	switch d {
+	case SQLite:
+		return "CURRENT_TIMESTAMP"
+	default:
+		return "NOW()"
+	}
+}
+
+// ifNull returns the name of the function replacing null values in the select list
+func (d Dialect) ifNull() string {
+	switch d {
+	case Postgres:
+		return "coalesce"
+	default:
+		return "ifnull"
+	}
+}
+
+// emptyBytes returns the literal of an empty blob replacing null bytes columns in the select list. Postgres reads the
+// X prefixed literal as a bit string, so its empty bytea is decoded from hex.
+func (d Dialect) emptyBytes() string {
+	if d == Postgres {
+		return "decode('', 'hex')"
+	}
+	return "X''"
+}
+
+// assignable returns a column name as it may appear in an insert column list or the SET clause of an update.
+// MySQL accepts table qualified names there, Postgres and SQLite do not.
+func (d Dialect) assignable(table, column string) string {
+	switch d {
+	case MySQL:
+		return table + "." + column
+	default:
+		return column
+	}
+}
+
+// bindType returns the sqlx placeholder style of the dialect
+func (d Dialect) bindType() int {
+	switch d {
+	case Postgres:
+		return sqlx.DOLLAR
+	default:
+		return sqlx.QUESTION
+	}
+}
+
+// upsertClause returns the clause turning an insert into an upsert, conflicting on `keys` and overwriting `columns`
+func (d Dialect) upsertClause(keys []string, columns []string) string {
+	var builder strings.Builder
+	switch d {
+	case Postgres, SQLite:
+		fmt.Fprintf(&builder, "ON CONFLICT (%s) DO ", strings.Join(keys, ", "))
+		if len(columns) == 0 {
+			builder.WriteString("NOTHING")
+			return builder.String()
+		}
+		builder.WriteString("UPDATE SET ")
+		for i, column := range columns {
+			if i != 0 {
+				builder.WriteString(", ")
+			}
+			fmt.Fprintf(&builder, "%s = EXCLUDED.%s", column, column)
+		}
+	default:
+		builder.WriteString("ON DUPLICATE KEY UPDATE ")
+		if len(columns) == 0 {
+			// a no-op assignment keeps MySQL from raising a duplicate key error
+			fmt.Fprintf(&builder, "%s = %s", keys[0], keys[0])
+			return builder.String()
+		}
+		for i, column := range columns {
+			if i != 0 {
+				builder.WriteString(", ")
+			}
+			fmt.Fprintf(&builder, "%s = VALUES(%s)", column, column)
+		}
+	}
+	return builder.String()
+}
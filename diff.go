@@ -0,0 +1,84 @@
+package pbsql
+
+import (
+	"context"
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// FieldChange is the change of a column made by an update, see UpdateWithDiff
+type FieldChange struct {
+	// Field is the column of the field
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+// UpdateWithDiff updates the row of `source` like Update, and returns the columns it changed with their values before
+// and after the update, for audit logs and event payloads. The row is read by primary key and locked before it is
+// updated, in a single transaction. Columns assigned the value they already held are left out, as are the columns
+// tagged `updated_at`, which are set by the database. Returns ErrNotFound if no row matches the primary key of
+// `source`.
+func (e *Executor) UpdateWithDiff(ctx context.Context, target string, source interface{}, fieldMask []string, opts ...Option) (changes []FieldChange, err error) {
+	e = e.route(target, source, OpUpdate, opts...)
+	secured, err := e.secureUpdate(ctx, target, source, fieldMask, opts)
+	if err != nil {
+		return nil, err
+	}
+	o := e.options(secured)
+	table := o.table(target, source)
+	o.lock = ForUpdate
+	v := reflect.ValueOf(source).Elem()
+	before := reflect.New(v.Type())
+	for i := 0; i < v.NumField(); i++ {
+		if field := parseReflection(v, i, table); field.isPrimaryKey && field.name != "" {
+			before.Elem().Field(i).Set(v.Field(i))
+		}
+	}
+	err = e.inTx(ctx, func(tx *Executor) error {
+		err := tx.getBuilt(ctx, table, before.Interface(), func() (string, interface{}, error) {
+			qry, err := readByKeyQuery(table, before.Interface(), o)
+			return qry, o.bindSource(before.Interface()), err
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Update(ctx, target, source, fieldMask, opts...); err != nil {
+			return err
+		}
+		changes, err = diffUpdate(table, before.Elem(), v, fieldMask)
+		return err
+	})
+	return changes, err
+}
+
+// diffUpdate returns the changes of the columns assigned by the update of `before` to `after` with `fieldMask`
+func diffUpdate(target string, before, after reflect.Value, fieldMask []string) ([]FieldChange, error) {
+	fieldMask, err := normalizeMask(after.Type(), target, fieldMask)
+	if err != nil {
+		return nil, err
+	}
+	var changes []FieldChange
+	for i := 0; i < after.NumField(); i++ {
+		field := parseReflection(after, i, target)
+		if field.isUpdatedAt || !field.assignedBy(fieldMask) {
+			continue
+		}
+		old, updated := before.Field(i).Interface(), field.value.Interface()
+		if !equalValues(old, updated) {
+			changes = append(changes, FieldChange{Field: field.name, Old: old, New: updated})
+		}
+	}
+	return changes, nil
+}
+
+// equalValues reports whether the values of two fields are equal, comparing messages with proto.Equal
+func equalValues(a, b interface{}) bool {
+	if ma, ok := a.(proto.Message); ok {
+		if mb, ok := b.(proto.Message); ok {
+			return proto.Equal(ma, mb)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
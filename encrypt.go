@@ -0,0 +1,188 @@
+package pbsql
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// KeyProvider supplies the AES keys of fields tagged `encrypt`, see SetKeyProvider. Keys are 16, 24, or 32 bytes long.
+type KeyProvider interface {
+	// CurrentKey returns the key new values are encrypted with, along with its id, which is stored with the values
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key of `id`, to decrypt values encrypted before the current key was rotated in
+	Key(id string) ([]byte, error)
+}
+
+// StaticKey is a KeyProvider holding a single key, whose id is empty
+type StaticKey []byte
+
+// CurrentKey implements KeyProvider
+func (k StaticKey) CurrentKey() (string, []byte, error) {
+	return "", k, nil
+}
+
+// Key implements KeyProvider
+func (k StaticKey) Key(id string) ([]byte, error) {
+	if id != "" {
+		return nil, fmt.Errorf("pbsql: unknown key %q", id)
+	}
+	return k, nil
+}
+
+var (
+	keyProviderMu sync.RWMutex
+	keyProvider   KeyProvider
+)
+
+// SetKeyProvider sets the provider of the keys encrypting the fields tagged `encrypt:"aes"`, which are encrypted with
+// AES-GCM before they are bound by creates, updates, and predicates, and decrypted when they are scanned, e.g.:
+//
+//	Ssn string `db:"ssn" encrypt:"aes"`
+//	Email string `db:"email" encrypt:"aes,deterministic"`
+//
+// Values are stored as text, `<key id>:<base64 of the nonce and ciphertext>`, in columns which must be wide enough.
+// Each value gets a random nonce, so encrypted columns can't be filtered by. Deterministic fields derive the nonce
+// from the value instead, so that a value always encrypts to the same text under the same key and reads can filter
+// them by equality, at the cost of revealing which rows hold equal values. Such filters only match the values
+// encrypted with the current key. Fields which are also tagged `convert` are encoded before they are encrypted and
+// decoded after they are decrypted. Empty strings and nulls are stored as is.
+func SetKeyProvider(p KeyProvider) {
+	keyProviderMu.Lock()
+	defer keyProviderMu.Unlock()
+	keyProvider = p
+}
+
+// currentKeyProvider returns the provider set with SetKeyProvider, nil if there is none
+func currentKeyProvider() KeyProvider {
+	keyProviderMu.RLock()
+	defer keyProviderMu.RUnlock()
+	return keyProvider
+}
+
+// encryptConverter encrypts the values of a field tagged `encrypt`, after encoding them with the converter of the field
+// if it has one
+type encryptConverter struct {
+	field         string
+	deterministic bool
+	inner         Converter
+}
+
+// encryptConverterOf returns the converter encrypting the field `self`, wrapping its converter `inner` which may be nil
+func encryptConverterOf(self reflect.StructField, inner Converter) (Converter, error) {
+	algorithm, mode, _ := strings.Cut(self.Tag.Get("encrypt"), ",")
+	if algorithm != "aes" || (mode != "" && mode != "deterministic") {
+		return nil, fmt.Errorf("pbsql: unknown encrypt tag %q on %s", self.Tag.Get("encrypt"), self.Name)
+	}
+	return encryptConverter{field: self.Name, deterministic: mode == "deterministic", inner: inner}, nil
+}
+
+func (c encryptConverter) Encode(value interface{}) (interface{}, error) {
+	if c.inner != nil {
+		encoded, err := c.inner.Encode(value)
+		if err != nil {
+			return nil, err
+		}
+		value = encoded
+	}
+	var plaintext []byte
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		plaintext = v
+	default:
+		s, ok := asString(value)
+		if !ok {
+			s = fmt.Sprint(value)
+		}
+		plaintext = []byte(s)
+	}
+	if len(plaintext) == 0 {
+		return value, nil
+	}
+	p := currentKeyProvider()
+	if p == nil {
+		return nil, fmt.Errorf("pbsql: no KeyProvider set to encrypt %s", c.field)
+	}
+	id, key, err := p.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if c.deterministic {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte("pbsql nonce\x00" + c.field + "\x00"))
+		mac.Write(plaintext)
+		copy(nonce, mac.Sum(nil))
+	} else if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	return id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (c encryptConverter) Decode(src interface{}) (interface{}, error) {
+	s, ok := asString(src)
+	if src == nil || (ok && s == "") {
+		return c.decoded(src)
+	}
+	id, encoded, found := strings.Cut(s, ":")
+	if !ok || !found {
+		return nil, fmt.Errorf("pbsql: %s doesn't hold an encrypted value", c.field)
+	}
+	p := currentKeyProvider()
+	if p == nil {
+		return nil, fmt.Errorf("pbsql: no KeyProvider set to decrypt %s", c.field)
+	}
+	key, err := p.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("pbsql: %s doesn't hold an encrypted value", c.field)
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("pbsql: decrypting %s: %w", c.field, err)
+	}
+	return c.decoded(plaintext)
+}
+
+// decoded returns the value of the field given its decrypted value
+func (c encryptConverter) decoded(plaintext interface{}) (interface{}, error) {
+	if c.inner != nil {
+		return c.inner.Decode(plaintext)
+	}
+	return plaintext, nil
+}
+
+// ColumnType stores encrypted values as text
+func (encryptConverter) ColumnType(d Dialect) string {
+	return "TEXT"
+}
+
+// newAEAD returns AES-GCM with `key`
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,178 @@
+package pbsql
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/lib/pq"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// enumNames maps the numbers of an enum type to the strings stored for them and back
+type enumNames struct {
+	names  map[int64]string
+	values map[string]int64
+}
+
+var (
+	enumsMu sync.RWMutex
+	enums   = make(map[reflect.Type]*enumNames)
+)
+
+// RegisterEnum sets the strings stored for the values of the enum type of `enum`, a value of the type such as
+// `pb.Status(0)`, in columns of fields tagged `enum:"string"`. `names` maps each number to its string, e.g. the
+// generated `pb.Status_name` map or a map of the strings already stored in the database:
+//
+//	pbsql.RegisterEnum(pb.Status(0), map[int32]string{1: "OPEN", 2: "CLOSED"})
+//
+// Protobuf enums which aren't registered are stored by the names of their values in the proto definition.
+func RegisterEnum(enum interface{}, names map[int32]string) {
+	e := &enumNames{names: make(map[int64]string, len(names)), values: make(map[string]int64, len(names))}
+	for number, name := range names {
+		e.names[int64(number)] = name
+		e.values[name] = int64(number)
+	}
+	enumsMu.Lock()
+	defer enumsMu.Unlock()
+	enums[reflect.TypeOf(enum)] = e
+}
+
+// enumNamesOf returns the strings of the enum type `t`, from the registry or its protobuf descriptor
+func enumNamesOf(t reflect.Type) (*enumNames, error) {
+	enumsMu.RLock()
+	e, ok := enums[t]
+	enumsMu.RUnlock()
+	if ok {
+		return e, nil
+	}
+	enum, ok := reflect.Zero(t).Interface().(protoreflect.Enum)
+	if !ok {
+		return nil, fmt.Errorf("pbsql: enum %s is neither registered with RegisterEnum nor a protobuf enum", t)
+	}
+	values := enum.Descriptor().Values()
+	e = &enumNames{names: make(map[int64]string, values.Len()), values: make(map[string]int64, values.Len())}
+	for i := 0; i < values.Len(); i++ {
+		value := values.Get(i)
+		e.names[int64(value.Number())] = string(value.Name())
+		e.values[string(value.Name())] = int64(value.Number())
+	}
+	enumsMu.Lock()
+	defer enumsMu.Unlock()
+	enums[t] = e
+	return e, nil
+}
+
+// enumConverter stores the values of a field tagged `enum:"string"`, or each value of a repeated one, as strings.
+// Zero values are stored as NULL, as proto enums reserve zero for an unspecified value.
+type enumConverter struct {
+	t reflect.Type
+}
+
+// enumConverterOf returns the converter of a field tagged `enum:"string"`, nil if it isn't
+func enumConverterOf(self reflect.StructField) (Converter, error) {
+	switch mode := self.Tag.Get("enum"); mode {
+	case "":
+		return nil, nil
+	case "string":
+	default:
+		return nil, fmt.Errorf("pbsql: unknown enum mode %q for field %s", mode, self.Name)
+	}
+	elem := self.Type
+	if elem.Kind() == reflect.Slice {
+		elem = elem.Elem()
+	}
+	switch elem.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return enumConverter{t: self.Type}, nil
+	}
+	return nil, fmt.Errorf("pbsql: field %s tagged enum must hold an enum, got %s", self.Name, self.Type)
+}
+
+func (c enumConverter) Encode(value interface{}) (interface{}, error) {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice {
+		if v.Int() == 0 {
+			return nil, nil
+		}
+		return c.name(v)
+	}
+	if v.IsNil() {
+		return nil, nil
+	}
+	names := make([]string, v.Len())
+	for i := range names {
+		name, err := c.name(v.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		names[i] = name
+	}
+	return pq.Array(names), nil
+}
+
+func (c enumConverter) Decode(src interface{}) (interface{}, error) {
+	if src == nil {
+		return nil, nil
+	}
+	if c.t.Kind() != reflect.Slice {
+		s, ok := asString(src)
+		if !ok {
+			return nil, fmt.Errorf("pbsql: cannot decode enum %s from %T", c.t, src)
+		}
+		return c.value(c.t, s)
+	}
+	var names pq.StringArray
+	if err := names.Scan(src); err != nil {
+		return nil, fmt.Errorf("pbsql: decoding enum array %s: %w", c.t, err)
+	}
+	values := reflect.MakeSlice(c.t, len(names), len(names))
+	for i, name := range names {
+		value, err := c.value(c.t.Elem(), name)
+		if err != nil {
+			return nil, err
+		}
+		values.Index(i).Set(reflect.ValueOf(value))
+	}
+	return values.Interface(), nil
+}
+
+func (c enumConverter) ColumnType(d Dialect) string {
+	if c.t.Kind() == reflect.Slice {
+		columnType, _ := d.arrayType(reflect.TypeOf([]string{}))
+		return columnType
+	}
+	columnType, _ := d.columnType(reflect.TypeOf(""), false)
+	return columnType
+}
+
+// name returns the string stored for the enum value `v`
+func (c enumConverter) name(v reflect.Value) (string, error) {
+	e, err := enumNamesOf(v.Type())
+	if err != nil {
+		return "", err
+	}
+	name, ok := e.names[v.Int()]
+	if !ok {
+		return "", fmt.Errorf("pbsql: %s has no string for value %d", v.Type(), v.Int())
+	}
+	return name, nil
+}
+
+// value returns the value of the enum type `t` stored as `name`, the zero value for an empty string
+func (c enumConverter) value(t reflect.Type, name string) (interface{}, error) {
+	if name == "" {
+		return reflect.Zero(t).Interface(), nil
+	}
+	e, err := enumNamesOf(t)
+	if err != nil {
+		return nil, err
+	}
+	number, ok := e.values[name]
+	if !ok {
+		return nil, fmt.Errorf("pbsql: %s has no value stored as %q", t, name)
+	}
+	value := reflect.New(t).Elem()
+	value.SetInt(number)
+	return value.Interface(), nil
+}
@@ -0,0 +1,47 @@
+package pbsql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the query builders. They are wrapped with additional context, use errors.Is to test for them.
+var (
+	// ErrEmptyUpdate is returned when an update statement would not set any columns
+	ErrEmptyUpdate = errors.New("pbsql: update does not set any columns")
+	// ErrMissingPrimaryKey is returned when a statement that must be restricted to a single row can't find a field
+	// tagged `primary_key`
+	ErrMissingPrimaryKey = errors.New("pbsql: no primary key field")
+	// ErrMissingPredicate is returned when a statement built from the fields of a filter message would match every
+	// row because none of them are set
+	ErrMissingPredicate = errors.New("pbsql: no predicate")
+	// ErrUnsafePredicate is returned when a clause given with WithWhere could end the statement or comment out the
+	// rest of it
+	ErrUnsafePredicate = errors.New("pbsql: unsafe predicate")
+	// ErrMissingClaims is returned by an Executor when a Policy requires claims the context of a statement doesn't hold
+	ErrMissingClaims = errors.New("pbsql: missing claims")
+	// ErrInvalidPageToken is returned by Executor.List for a page token it didn't issue for the same filter
+	ErrInvalidPageToken = errors.New("pbsql: invalid page token")
+	// ErrUnknownField is returned when a field mask lists a name which isn't a field of the message
+	ErrUnknownField = errors.New("pbsql: unknown field")
+	// ErrUnmappedField is returned in strict mode for a field holding a value which isn't stored in a column, see
+	// WithStrict
+	ErrUnmappedField = errors.New("pbsql: field has no column")
+	// ErrPolicyDenied is returned when the values of a message written by an Executor break one of its policies, e.g.
+	// the owner of a row created for another user, see OwnerPolicy
+	ErrPolicyDenied = errors.New("pbsql: denied by policy")
+	// ErrForbiddenField is returned in strict mode when a field mask lists a field whose `perm` tag excludes the
+	// operation of the statement
+	ErrForbiddenField = errors.New("pbsql: field not permitted")
+	// ErrMissingIdempotencyKey is returned by idempotent creates when the message has no field tagged
+	// `idempotency_key` or one of them is unset
+	ErrMissingIdempotencyKey = errors.New("pbsql: no idempotency key")
+	// ErrUnboundedRead is returned for a read query of a table listed in Limits.Large which isn't given a limit
+	ErrUnboundedRead = errors.New("pbsql: unbounded read")
+	// ErrInvalidMessage is returned by an Executor for a message rejected by its validation, see ValidationError
+	ErrInvalidMessage = errors.New("pbsql: invalid message")
+	// ErrNotFound is returned by an Executor when no row matches the primary key of a read, update, or delete. It
+	// wraps sql.ErrNoRows, so existing errors.Is(err, sql.ErrNoRows) checks keep working.
+	ErrNotFound = fmt.Errorf("pbsql: not found: %w", sql.ErrNoRows)
+)
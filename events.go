@@ -0,0 +1,149 @@
+package pbsql
+
+import (
+	"context"
+	"reflect"
+)
+
+// Columns of the outbox table written by an Executor configured WithOutbox
+const (
+	OutboxTableColumn     = "aggregate_type"
+	OutboxOperationColumn = "event_type"
+	OutboxPayloadColumn   = "payload"
+	OutboxCreatedAtColumn = "created_at"
+)
+
+// WriteEvent describes a write run by an Executor, see WithWriteHook
+type WriteEvent struct {
+	Operation Operation
+	Table     string
+	// Message is the message the statement was built from: the row written, the filter of updates and deletes by
+	// predicate, or the slice of messages of bulk writes
+	Message interface{}
+}
+
+// WriteHook is called after a write run by an Executor, see WithWriteHook
+type WriteHook func(ctx context.Context, event WriteEvent)
+
+// WithWriteHook calls `hook` after every create, upsert, update, and delete run by the Executor, once its transaction
+// commits if it runs in one; writes rolled back, including those of a savepoint rolled back, are never reported.
+// Hooks run in the order of the writes, before the write returns when it doesn't run in a transaction. Bulk loads of
+// CopyFrom and the rows recorded by WithAuditTrail are not reported.
+func WithWriteHook(hook WriteHook) ExecutorOption {
+	return func(e *Executor) {
+		e.hooks = append(e.hooks, hook)
+	}
+}
+
+// WithOutbox makes the Executor insert an event row into the outbox table `table` for every write it reports to its
+// hooks, within the transaction of the write, so that a relay publishing the rows of the outbox, e.g. to Kafka, sees
+// every committed write exactly once and no rolled back one. Writes which don't run in a transaction are run in one.
+// The outbox table has the columns OutboxTableColumn, OutboxOperationColumn, OutboxPayloadColumn holding the message
+// encoded as JSON, with protojson for protobuf messages, and OutboxCreatedAtColumn, along with any key generated by
+// the database.
+func WithOutbox(table string) ExecutorOption {
+	return func(e *Executor) {
+		e.outbox = table
+	}
+}
+
+// emits reports whether the writes of the Executor are reported to hooks or to an outbox
+func (e *Executor) emits() bool {
+	return len(e.hooks) > 0 || e.outbox != ""
+}
+
+// silent returns a copy of the Executor which doesn't report its writes, for the statements recording writes
+// themselves
+func (e *Executor) silent() *Executor {
+	s := *e
+	s.hooks, s.outbox = nil, ""
+	return &s
+}
+
+// withOutbox runs the write `fn` in a transaction if the Executor writes to an outbox and isn't bound to one already
+func (e *Executor) withOutbox(ctx context.Context, fn func(*Executor) error) error {
+	if e.outbox == "" || e.tx != nil {
+		return fn(e)
+	}
+	return e.inTx(ctx, fn)
+}
+
+// written records a successful write of `table` built from `source`: it invalidates the results cached for the table,
+// inserts the event into the outbox, and reports it to the hooks, once the transaction commits if there is one
+func (e *Executor) written(ctx context.Context, table string, op Operation, source interface{}) error {
+	if !e.emits() {
+		e.invalidate(ctx, table)
+		return nil
+	}
+	if p, ok := source.(paramSource); ok {
+		source = p.source
+	}
+	event := WriteEvent{Operation: op, Table: table, Message: source}
+	if e.outbox != "" {
+		if err := e.writeOutbox(ctx, event); err != nil {
+			return err
+		}
+	}
+	e.invalidate(ctx, table)
+	if e.pending != nil {
+		e.pending.mu.Lock()
+		e.pending.events = append(e.pending.events, event)
+		e.pending.mu.Unlock()
+		return nil
+	}
+	e.emit(ctx, event)
+	return nil
+}
+
+// writeOutbox inserts `event` into the outbox table
+func (e *Executor) writeOutbox(ctx context.Context, event WriteEvent) error {
+	_, err := e.silent().execBuilt(ctx, e.outbox, OpCreate, func() (string, interface{}, error) {
+		payload, err := encodeJSON(reflect.ValueOf(event.Message))
+		if err != nil {
+			return "", nil, err
+		}
+		o := e.options(nil)
+		now := o.nowExpr()
+		arg := map[string]interface{}{
+			OutboxTableColumn:     event.Table,
+			OutboxOperationColumn: string(event.Operation),
+			OutboxPayloadColumn:   payload,
+		}
+		for name, value := range o.params {
+			arg[name] = value
+		}
+		return "INSERT INTO " + e.outbox + " (" + OutboxTableColumn + ", " + OutboxOperationColumn + ", " +
+			OutboxPayloadColumn + ", " + OutboxCreatedAtColumn + ") VALUES (:" + OutboxTableColumn + ", :" +
+			OutboxOperationColumn + ", :" + OutboxPayloadColumn + ", " + now + ")", arg, nil
+	})
+	return err
+}
+
+// pendingEvents returns the number of events pending, zero for a nil collection
+func (p *pendingInvalidations) pendingEvents() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.events)
+}
+
+// dropEvents drops the events recorded after the first `n`, which were rolled back
+func (p *pendingInvalidations) dropEvents(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.events) > n {
+		p.events = p.events[:n]
+	}
+}
+
+// emit reports `event` to the hooks of the Executor
+func (e *Executor) emit(ctx context.Context, event WriteEvent) {
+	for _, hook := range e.hooks {
+		hook(ctx, event)
+	}
+}
@@ -0,0 +1,797 @@
+package pbsql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"google.golang.org/protobuf/proto"
+)
+
+// Executor builds queries with pbsql and runs them against a database. The zero configuration runs every
+// statement directly on the underlying *sqlx.DB, options such as WithStmtCache layer additional behaviour on top.
+//
+// An Executor is safe for concurrent use by multiple goroutines.
+type Executor struct {
+	DB      *sqlx.DB
+	tx      *sqlx.Tx
+	dialect Dialect
+	stmts   *StmtCache
+	tracer  Tracer
+	logger  Logger
+	audit   bool
+	builder *Builder
+	router  *Router
+	// replica is the replica a routed executor reads from, whose latency is observed by every run
+	replica  *replica
+	cache    ResultCache
+	cacheTTL time.Duration
+	// cacheGens counts the invalidations of each table, so that results read across one aren't stored
+	cacheGens *cacheGenerations
+	// pending collects the tables written by an executor bound to a transaction, see WithResultCache
+	pending *pendingInvalidations
+	// hooks are called after each write, which is recorded in the outbox table if there is one, see WithWriteHook and
+	// WithOutbox
+	hooks  []WriteHook
+	outbox string
+	// validation validates messages before they are written, see WithValidation
+	validation bool
+	validators []ValidateFunc
+	// matViews lists the materialized views refreshed after writes to each table, see WithMaterializedViews
+	matViews map[string][]*matView
+	policies []Policy
+	claims   ClaimsExtractor
+	// loadData registers the readers of LOAD DATA statements, see WithLoadData
+	loadData *loadDataHandlers
+	// retryPolicy retries statements and transactions failing with transient errors, see WithRetry
+	retryPolicy *RetryPolicy
+	// savepoints counts the scopes of InTx nested within the transaction, see savepoint
+	savepoints int
+}
+
+// ExecutorOption configures an Executor
+type ExecutorOption func(*Executor)
+
+// NewExecutor returns an Executor running queries against `db`
+func NewExecutor(db *sqlx.DB, opts ...ExecutorOption) *Executor {
+	e := &Executor{DB: db, dialect: DialectFromDriver(db.DriverName())}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// WithStmtCache enables a prepared statement cache holding at most `size` statements, see StmtCache
+func WithStmtCache(size int) ExecutorOption {
+	return func(e *Executor) {
+		e.stmts = NewStmtCache(size)
+	}
+}
+
+// WithTracer reports every query run by the Executor to `t`
+func WithTracer(t Tracer) ExecutorOption {
+	return func(e *Executor) {
+		e.tracer = t
+	}
+}
+
+// WithLogger logs every query run by the Executor to `l`. Args bound from fields tagged `sensitive:"y"` are
+// redacted before they are handed to the logger.
+func WithLogger(l Logger) ExecutorOption {
+	return func(e *Executor) {
+		e.logger = l
+	}
+}
+
+// WithBuilder builds the executor's queries with the config of `b`, e.g. its soft delete policy. The dialect is
+// still derived from the database driver.
+func WithBuilder(b *Builder) ExecutorOption {
+	return func(e *Executor) {
+		e.builder = b
+	}
+}
+
+// StmtCacheStats returns the current statement cache metrics, or zero values if caching is disabled
+func (e *Executor) StmtCacheStats() StmtCacheStats {
+	if e.stmts == nil {
+		return StmtCacheStats{}
+	}
+	return e.stmts.Stats()
+}
+
+// Close releases every cached prepared statement. The underlying *sqlx.DB is left open.
+func (e *Executor) Close() error {
+	if e.stmts == nil {
+		return nil
+	}
+	return e.stmts.Close()
+}
+
+// Create builds an insert statement with BuildCreateQuery and executes it
+func (e *Executor) Create(ctx context.Context, target string, source interface{}, opts ...Option) (sql.Result, error) {
+	if err := beforeCreate(ctx, source); err != nil {
+		return nil, err
+	}
+	e = e.route(target, source, OpCreate, opts...)
+	opts, err := e.secure(ctx, target, OpCreate, source, opts)
+	if err != nil {
+		return nil, err
+	}
+	o := e.options(opts)
+	target = o.table(target, source)
+	if err := e.validate(ctx, target, OpCreate, source, nil); err != nil {
+		return nil, err
+	}
+	return e.execBuilt(ctx, target, OpCreate, func() (string, interface{}, error) {
+		qry, err := createQuery(target, source, o)
+		return qry, o.bindSource(source), err
+	})
+}
+
+// CreateAndRead inserts `source` and reads the complete row back into it, including any columns populated by the
+// database such as defaults, trigger output, and generated keys, so the result can be returned to clients as is.
+//
+// On Postgres this is a single `INSERT ... RETURNING` statement. Elsewhere, and for messages read from a view
+// registered with RegisterReadView, the generated key is read from the insert and the row is selected by primary key
+// within the same transaction.
+func (e *Executor) CreateAndRead(ctx context.Context, target string, source interface{}, opts ...Option) error {
+	if err := beforeCreate(ctx, source); err != nil {
+		return err
+	}
+	e = e.route(target, source, OpCreate, opts...)
+	opts, err := e.secure(ctx, target, OpCreate, source, opts)
+	if err != nil {
+		return err
+	}
+	o := e.options(opts)
+	target = o.table(target, source)
+	if err := e.validate(ctx, target, OpCreate, source, nil); err != nil {
+		return err
+	}
+	// the row is read back whatever its lifecycle
+	o.scope = All
+	view := o.readRelation(target, source)
+	if o.dialect == Postgres && view == target {
+		return e.withOutbox(ctx, func(e *Executor) error {
+			return e.createReturning(ctx, target, source, o, false)
+		})
+	}
+
+	return e.inTx(ctx, func(tx *Executor) error {
+		if o.dialect == Postgres {
+			if err := tx.createReturning(ctx, target, source, o, true); err != nil {
+				return err
+			}
+		} else {
+			res, err := tx.execBuilt(ctx, target, OpCreate, func() (string, interface{}, error) {
+				qry, err := createQuery(target, source, o)
+				return qry, o.bindSource(source), err
+			})
+			if err != nil {
+				return err
+			}
+			if id, err := res.LastInsertId(); err == nil {
+				setGeneratedKey(source, target, id)
+			}
+		}
+		return tx.getBuilt(ctx, target, source, func() (string, interface{}, error) {
+			qry, err := readByKeyQuery(view, source, o)
+			return qry, o.bindSource(source), err
+		})
+	})
+}
+
+// createReturning inserts `source` with `INSERT ... RETURNING`, scanning the returned columns into it: its primary
+// keys if `keysOnly`, every selected column otherwise
+func (e *Executor) createReturning(ctx context.Context, target string, source interface{}, o *options, keysOnly bool) error {
+	ctx, run := e.start(ctx, target, OpCreate)
+	err := run.build(func() (string, interface{}, error) {
+		v := reflect.ValueOf(source).Elem()
+		var returning string
+		if keysOnly {
+			var columns []string
+			for _, key := range primaryKeys(v, target) {
+				columns = append(columns, key.column())
+			}
+			if len(columns) == 0 {
+				return "", nil, fmt.Errorf("%w: cannot read %s back", ErrMissingPrimaryKey, target)
+			}
+			returning = strings.Join(columns, ", ")
+		} else {
+			qb := newQueryBuilder(o.dialect)
+			defer qb.release()
+			if err := qb.writeSelectList(v, target, o); err != nil {
+				return "", nil, err
+			}
+			returning = qb.selectList()
+		}
+		qry, err := createQuery(target, source, o)
+		return qry + " RETURNING " + returning, o.bindSource(source), err
+	})
+	if err == nil {
+		if err = e.get(ctx, source, source, run.info.Query, run.args); err == nil {
+			run.info.Rows = 1
+			err = e.written(ctx, target, OpCreate, source)
+		}
+	}
+	run.finish(err)
+	return err
+}
+
+// Upsert builds an insert or update statement with BuildUpsertQuery and executes it
+func (e *Executor) Upsert(ctx context.Context, target string, source interface{}, opts ...Option) (sql.Result, error) {
+	if err := beforeCreate(ctx, source); err != nil {
+		return nil, err
+	}
+	e = e.route(target, source, OpUpsert, opts...)
+	opts, err := e.secure(ctx, target, OpUpsert, source, opts)
+	if err != nil {
+		return nil, err
+	}
+	o := e.options(opts)
+	target = o.table(target, source)
+	if err := e.validate(ctx, target, OpUpsert, source, nil); err != nil {
+		return nil, err
+	}
+	return e.execBuilt(ctx, target, OpUpsert, func() (string, interface{}, error) {
+		qry, err := upsertQuery(target, source, o)
+		return qry, o.bindSource(source), err
+	})
+}
+
+// Read builds a select statement with BuildReadQueryWithOptions and scans every resulting row into `dest`, which
+// must be a pointer to a slice of structs or struct pointers. Relations given with WithPreload are read afterwards.
+func (e *Executor) Read(ctx context.Context, target string, source interface{}, dest interface{}, opts ...Option) error {
+	e = e.route(target, source, OpRead, opts...)
+	opts, err := e.secure(ctx, target, OpRead, source, opts)
+	if err != nil {
+		return err
+	}
+	o := e.options(opts)
+	if err := e.read(ctx, o.table(target, source), source, dest, o); err != nil {
+		return err
+	}
+	return e.preload(ctx, dest, o)
+}
+
+func (e *Executor) read(ctx context.Context, target string, source interface{}, dest interface{}, o *options) (err error) {
+	ctx, run := e.start(ctx, target, OpRead)
+	defer func() { run.finish(err) }()
+
+	if err = run.build(func() (string, interface{}, error) {
+		qry, err := readQuery(o.readRelation(target, source), source, o)
+		return qry, o.bindSource(source), err
+	}); err != nil {
+		return err
+	}
+	run.info.Cached, err = e.cached(ctx, run, dest, o, func() error {
+		return e.selectRows(ctx, source, dest, run.info.Query, run.args)
+	})
+	if err == nil {
+		run.info.Rows = int64(reflect.Indirect(reflect.ValueOf(dest)).Len())
+	}
+	return err
+}
+
+// ListStream builds a select statement with BuildReadQueryWithOptions from `filter` and calls `fn` with every resulting
+// row as soon as it is read, scanned into a freshly allocated message of the same type as `filter`. Rows are never
+// buffered, which makes this suitable for server-streaming RPCs over large result sets. Iteration stops at the first
+// error returned by `fn`, which is returned as is.
+func (e *Executor) ListStream(ctx context.Context, target string, filter proto.Message, fn func(msg proto.Message) error, opts ...Option) (err error) {
+	e = e.route(target, filter, OpRead, opts...)
+	if opts, err = e.secure(ctx, target, OpRead, filter, opts); err != nil {
+		return err
+	}
+	o := e.options(opts)
+	target = o.table(target, filter)
+	ctx, run := e.start(ctx, target, OpRead)
+	defer func() { run.finish(err) }()
+
+	if err = run.build(func() (string, interface{}, error) {
+		qry, err := readQuery(o.readRelation(target, filter), filter, o)
+		return qry, o.bindSource(filter), err
+	}); err != nil {
+		return err
+	}
+	rows, release, err := e.queryRows(ctx, filter, run.info.Query, run.args)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer rows.Close()
+
+	msgType := reflect.TypeOf(filter).Elem()
+	for rows.Next() {
+		msg := reflect.New(msgType).Interface().(proto.Message)
+		if err = scanStruct(rows, msg); err != nil {
+			return err
+		}
+		run.info.Rows++
+		if err = fn(msg); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Get builds a select statement with BuildReadByPKQuery and scans the matching row into `source`. Returns
+// ErrNotFound if there is no such row.
+func (e *Executor) Get(ctx context.Context, target string, source interface{}, opts ...Option) error {
+	e = e.route(target, source, OpRead, opts...)
+	opts, err := e.secure(ctx, target, OpRead, source, opts)
+	if err != nil {
+		return err
+	}
+	o := e.options(opts)
+	target = o.table(target, source)
+	return e.getBuilt(ctx, target, source, func() (string, interface{}, error) {
+		qry, err := readByKeyQuery(o.readRelation(target, source), source, o)
+		return qry, o.bindSource(source), err
+	})
+}
+
+// Count builds a count statement with BuildCountQuery and returns the result
+func (e *Executor) Count(ctx context.Context, target string, source interface{}, fieldMask ...string) (int64, error) {
+	return e.count(ctx, target, source, fieldMask, nil)
+}
+
+// CountWithOptions behaves like Count but builds the statement with BuildCountQueryWithOptions
+func (e *Executor) CountWithOptions(ctx context.Context, target string, source interface{}, opts ...Option) (int64, error) {
+	return e.count(ctx, target, source, nil, opts)
+}
+
+func (e *Executor) count(ctx context.Context, target string, source interface{}, fieldMask []string, opts []Option) (count int64, err error) {
+	e = e.route(target, source, OpCount, opts...)
+	if opts, err = e.secure(ctx, target, OpCount, source, opts); err != nil {
+		return 0, err
+	}
+	o := e.options(opts)
+	target = o.table(target, source)
+	ctx, run := e.start(ctx, target, OpCount)
+	defer func() { run.finish(err) }()
+
+	if err = run.build(func() (string, interface{}, error) {
+		qry, err := countQuery(o.readRelation(target, source), source, fieldMask, o)
+		return qry, o.bindSource(source), err
+	}); err != nil {
+		return 0, err
+	}
+	run.info.Cached, err = e.cached(ctx, run, &count, o, func() error {
+		return e.get(ctx, source, &count, run.info.Query, run.args)
+	})
+	if err == nil {
+		run.info.Rows = 1
+	}
+	return count, err
+}
+
+// Update builds an update statement with BuildUpdateQuery and executes it. Returns ErrNotFound if no row matches the
+// primary key of `source`.
+func (e *Executor) Update(ctx context.Context, target string, source interface{}, fieldMask []string, opts ...Option) (res sql.Result, err error) {
+	e = e.route(target, source, OpUpdate, opts...)
+	if opts, err = e.secureUpdate(ctx, target, source, fieldMask, opts); err != nil {
+		return nil, err
+	}
+	o := e.options(opts)
+	target = o.table(target, source)
+	if err := e.validate(ctx, target, OpUpdate, source, fieldMask); err != nil {
+		return nil, err
+	}
+	err = e.audited(ctx, target, source, OpUpdate, o, func(e *Executor) error {
+		res, err = e.execBuilt(ctx, target, OpUpdate, func() (string, interface{}, error) {
+			qry, err := updateQuery(target, source, fieldMask, o)
+			return qry, o.bindSource(source), err
+		})
+		return err
+	})
+	if err != nil {
+		return res, err
+	}
+	return res, e.notFound(ctx, target, source, o, res)
+}
+
+// Delete builds a delete statement with BuildDeleteQuery and executes it. Returns ErrNotFound if no row matches the
+// primary key of `source`.
+func (e *Executor) Delete(ctx context.Context, target string, source interface{}, opts ...Option) (res sql.Result, err error) {
+	e = e.route(target, source, OpDelete, opts...)
+	if opts, err = e.secure(ctx, target, OpDelete, source, opts); err != nil {
+		return nil, err
+	}
+	o := e.options(opts)
+	target = o.table(target, source)
+	err = e.audited(ctx, target, source, OpDelete, o, func(e *Executor) error {
+		res, err = e.execBuilt(ctx, target, OpDelete, func() (string, interface{}, error) {
+			qry, err := deleteQuery(target, source, o)
+			return qry, o.bindSource(source), err
+		})
+		return err
+	})
+	if err != nil {
+		return res, err
+	}
+	return res, e.notFound(ctx, target, source, o, res)
+}
+
+// notFound returns ErrNotFound when `res`, the result of a statement matching the primary key of `source`, reports
+// that no row was affected. MySQL also reports the rows a statement left unchanged as unaffected, so there the row is
+// looked up before reporting it missing.
+func (e *Executor) notFound(ctx context.Context, target string, source interface{}, o *options, res sql.Result) error {
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return nil
+	}
+	if o.dialect == MySQL {
+		var found int
+		err := e.getBuilt(ctx, target, &found, func() (string, interface{}, error) {
+			qry, err := existsByKeyQuery(target, source, o)
+			return qry, o.bindSource(source), err
+		})
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return fmt.Errorf("%w: no row of %s matched the primary key", ErrNotFound, target)
+}
+
+// DeleteWhere builds a delete statement with BuildDeleteWhereQuery and executes it. With WithAuditTrail every
+// matched row is recorded in the history table first.
+func (e *Executor) DeleteWhere(ctx context.Context, target string, source interface{}, opts ...Option) (res sql.Result, err error) {
+	e = e.route(target, source, OpDelete, opts...)
+	if opts, err = e.secure(ctx, target, OpDelete, source, opts); err != nil {
+		return nil, err
+	}
+	o := e.options(opts)
+	target = o.table(target, source)
+	history := func() (string, interface{}, error) {
+		return historyWhereQuery(target, source, OpDelete, ActorFromContext(ctx), o)
+	}
+	err = e.auditedWith(ctx, target, history, func(e *Executor) error {
+		res, err = e.execBuilt(ctx, target, OpDelete, func() (string, interface{}, error) {
+			qry, err := deleteWhereQuery(target, source, o)
+			return qry, o.bindSource(source), err
+		})
+		return err
+	})
+	return res, err
+}
+
+// InTx runs `fn` with an Executor bound to a transaction, which is committed if `fn` returns nil and rolled back
+// otherwise, e.g. to lock rows read WithLock until they are updated. Statements run by the Executor handed to `fn`
+// aren't routed, they all run on the Executor's own database. With WithRetry a transaction failing with a transient
+// error is rolled back and `fn` is run again in a new one.
+//
+// If the Executor is already bound to a transaction `fn` runs within a savepoint of it, so a failed nested scope only
+// rolls back its own statements and the outer scope decides whether to go on, e.g. when an optional related insert
+// fails:
+//
+//	err := exec.InTx(ctx, func(tx *pbsql.Executor) error {
+//		if err := tx.Create(ctx, "task", task); err != nil {
+//			return err
+//		}
+//		if err := tx.InTx(ctx, func(tx *pbsql.Executor) error { return tx.Create(ctx, "tag", tag) }); err != nil {
+//			log.Print("task created without its tag: ", err)
+//		}
+//		return nil
+//	})
+func (e *Executor) InTx(ctx context.Context, fn func(tx *Executor) error) error {
+	if e.tx != nil {
+		return e.savepoint(ctx, fn)
+	}
+	return e.retry(ctx, func() error {
+		return e.inTx(ctx, fn)
+	})
+}
+
+// savepoint runs `fn` within a savepoint of the transaction the Executor is bound to, which is released if `fn`
+// returns nil and rolled back to otherwise. Savepoints are named after their depth, so nested scopes don't clash.
+func (e *Executor) savepoint(ctx context.Context, fn func(*Executor) error) (err error) {
+	name := "pbsql_sp_" + strconv.Itoa(e.savepoints+1)
+	if _, err = e.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			e.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			panic(p)
+		}
+	}()
+	child := *e
+	child.savepoints++
+	events := e.pending.pendingEvents()
+	if err = fn(&child); err != nil {
+		e.pending.dropEvents(events)
+		if _, rerr := e.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rerr != nil {
+			return fmt.Errorf("%w (rolling back to savepoint %s: %v)", err, name, rerr)
+		}
+		return err
+	}
+	_, err = e.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
+
+// inTx runs `fn` with an Executor bound to a transaction, which is committed if `fn` returns nil and rolled back
+// otherwise. If the Executor is already bound to a transaction `fn` joins it.
+func (e *Executor) inTx(ctx context.Context, fn func(*Executor) error) (err error) {
+	if e.tx != nil {
+		return fn(e)
+	}
+	tx, err := e.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+	txe := *e
+	txe.tx = tx
+	if e.cache != nil || e.matViews != nil || e.emits() {
+		txe.pending = &pendingInvalidations{}
+	}
+	if err = fn(&txe); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	if txe.pending != nil {
+		written := make(map[string]bool, len(txe.pending.tables))
+		for _, table := range txe.pending.tables {
+			if !written[table] {
+				written[table] = true
+				e.invalidate(ctx, table)
+			}
+		}
+		for _, event := range txe.pending.events {
+			e.emit(ctx, event)
+		}
+	}
+	return nil
+}
+
+// ext returns the handle statements are run on
+func (e *Executor) ext() sqlx.ExtContext {
+	if e.tx != nil {
+		return e.tx
+	}
+	return e.DB
+}
+
+// options applies `opts` on top of the executor's builder config and dialect, which is derived from the database
+// driver
+func (e *Executor) options(opts []Option) *options {
+	base := []Option{WithDialect(e.dialect)}
+	if e.builder != nil {
+		base = append([]Option{WithConfig(e.builder.cfg)}, base...)
+	}
+	return newOptions(append(base, opts...))
+}
+
+// buildFunc returns a named query along with the value it is bound against, usually the source message
+type buildFunc func() (string, interface{}, error)
+
+// queryRun tracks a single build and execution for instrumentation
+type queryRun struct {
+	ctx     context.Context
+	info    QueryInfo
+	named   string
+	source  interface{}
+	args    []interface{}
+	span    QuerySpan
+	logger  Logger
+	replica *replica
+	started time.Time
+}
+
+func (e *Executor) start(ctx context.Context, table string, op Operation) (context.Context, *queryRun) {
+	run := &queryRun{info: QueryInfo{Table: table, Operation: op}, logger: e.logger, replica: e.replica}
+	if e.tracer != nil {
+		ctx, run.span = e.tracer.StartQuery(ctx, table, op)
+	}
+	run.ctx = ctx
+	return ctx, run
+}
+
+func (r *queryRun) build(fn buildFunc) error {
+	start := time.Now()
+	named, source, err := fn()
+	if err == nil {
+		// placeholders are rebound for the driver when the statement runs
+		r.info.Query, r.args, err = SQLBinder.Bind(named, source, MySQL)
+	}
+	r.info.BuildDuration = time.Since(start)
+	r.named = named
+	r.source = source
+	r.started = time.Now()
+	return err
+}
+
+func (r *queryRun) finish(err error) {
+	if !r.started.IsZero() {
+		r.info.Duration = time.Since(r.started)
+	}
+	if r.replica != nil && err == nil {
+		r.replica.observe(r.info.Duration)
+	}
+	if r.span != nil {
+		r.span.End(r.info, err)
+	}
+	if r.logger != nil {
+		r.logger.LogQuery(r.ctx, LogEntry{
+			QueryInfo: r.info,
+			Args:      redactArgs(r.source, r.named, r.args),
+			Err:       err,
+		})
+	}
+}
+
+func (e *Executor) execBuilt(ctx context.Context, target string, op Operation, fn buildFunc) (res sql.Result, err error) {
+	if e.outbox != "" && e.tx == nil {
+		err = e.inTx(ctx, func(tx *Executor) error {
+			res, err = tx.execBuilt(ctx, target, op, fn)
+			return err
+		})
+		return res, err
+	}
+	ctx, run := e.start(ctx, target, op)
+	defer func() { run.finish(err) }()
+
+	if err = run.build(fn); err != nil {
+		return nil, err
+	}
+	if res, err = e.exec(ctx, run.source, run.info.Query, run.args); err == nil {
+		run.info.Rows, _ = res.RowsAffected()
+		err = e.written(ctx, target, op, run.source)
+	}
+	return res, err
+}
+
+// getBuilt runs a query expected to return a single row and scans it into `dest`
+func (e *Executor) getBuilt(ctx context.Context, target string, dest interface{}, fn buildFunc) (err error) {
+	ctx, run := e.start(ctx, target, OpRead)
+	defer func() { run.finish(err) }()
+
+	if err = run.build(fn); err != nil {
+		return err
+	}
+	if err = e.get(ctx, run.source, dest, run.info.Query, run.args); err == nil {
+		run.info.Rows = 1
+	} else if errors.Is(err, sql.ErrNoRows) {
+		err = fmt.Errorf("%w: no row of %s matched", ErrNotFound, target)
+	}
+	return err
+}
+
+// exec runs a statement, again if it fails with a transient error and the Executor has a retry policy
+func (e *Executor) exec(ctx context.Context, source interface{}, qry string, args []interface{}) (res sql.Result, err error) {
+	err = e.retry(ctx, func() error {
+		res, err = e.execOnce(ctx, source, qry, args)
+		return err
+	})
+	return res, err
+}
+
+func (e *Executor) execOnce(ctx context.Context, source interface{}, qry string, args []interface{}) (sql.Result, error) {
+	stmt, release, err := e.prepare(ctx, source, qry)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return e.ext().ExecContext(ctx, e.DB.Rebind(qry), args...)
+	}
+	defer release()
+	return stmt.ExecContext(ctx, args...)
+}
+
+// selectRows runs a query scanning every row into the slice `dest` points to, again if it fails with a transient error
+// and the Executor has a retry policy, dropping the rows scanned by the failed attempt
+func (e *Executor) selectRows(ctx context.Context, source interface{}, dest interface{}, qry string, args []interface{}) error {
+	slice := reflect.Indirect(reflect.ValueOf(dest))
+	n := 0
+	if slice.Kind() == reflect.Slice {
+		n = slice.Len()
+	}
+	return e.retry(ctx, func() error {
+		if slice.Kind() == reflect.Slice && slice.Len() > n {
+			slice.SetLen(n)
+		}
+		return e.selectOnce(ctx, source, dest, qry, args)
+	})
+}
+
+func (e *Executor) selectOnce(ctx context.Context, source interface{}, dest interface{}, qry string, args []interface{}) error {
+	if hasCustomColumns(reflect.TypeOf(dest)) {
+		rows, release, err := e.queryRows(ctx, source, qry, args)
+		if err != nil {
+			return err
+		}
+		defer release()
+		defer rows.Close()
+		return ScanAll(rows, dest, 0)
+	}
+	stmt, release, err := e.prepare(ctx, source, qry)
+	if err != nil {
+		return err
+	}
+	if stmt == nil {
+		return sqlx.SelectContext(ctx, e.ext(), dest, e.DB.Rebind(qry), args...)
+	}
+	defer release()
+	return stmt.SelectContext(ctx, dest, args...)
+}
+
+// queryRows runs a query returning rows, the returned release func must be called once the rows are closed
+func (e *Executor) queryRows(ctx context.Context, source interface{}, qry string, args []interface{}) (*sqlx.Rows, func(), error) {
+	stmt, release, err := e.prepare(ctx, source, qry)
+	if err != nil {
+		return nil, nil, err
+	}
+	if stmt == nil {
+		rows, err := e.ext().QueryxContext(ctx, e.DB.Rebind(qry), args...)
+		return rows, func() {}, err
+	}
+	rows, err := stmt.QueryxContext(ctx, args...)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return rows, release, nil
+}
+
+// get runs a query scanning a single row into `dest`, again if it fails with a transient error and the Executor has
+// a retry policy
+func (e *Executor) get(ctx context.Context, source interface{}, dest interface{}, qry string, args []interface{}) error {
+	return e.retry(ctx, func() error {
+		return e.getOnce(ctx, source, dest, qry, args)
+	})
+}
+
+func (e *Executor) getOnce(ctx context.Context, source interface{}, dest interface{}, qry string, args []interface{}) error {
+	if hasCustomColumns(reflect.TypeOf(dest)) {
+		rows, release, err := e.queryRows(ctx, source, qry, args)
+		if err != nil {
+			return err
+		}
+		defer release()
+		defer rows.Close()
+		return scanOne(rows, dest)
+	}
+	stmt, release, err := e.prepare(ctx, source, qry)
+	if err != nil {
+		return err
+	}
+	if stmt == nil {
+		return sqlx.GetContext(ctx, e.ext(), dest, e.DB.Rebind(qry), args...)
+	}
+	defer release()
+	return stmt.GetContext(ctx, dest, args...)
+}
+
+// prepare returns a cached prepared statement for `qry`, or a nil statement if caching is disabled. The returned
+// release func must be called once the statement is no longer in use.
+func (e *Executor) prepare(ctx context.Context, source interface{}, qry string) (*sqlx.Stmt, func(), error) {
+	if e.stmts == nil {
+		return nil, nil, nil
+	}
+	key := stmtKey{typ: reflect.TypeOf(source), db: e.DB, driver: e.DB.DriverName(), query: qry}
+	stmt, release, err := e.stmts.get(key, func() (*sqlx.Stmt, error) {
+		return e.DB.PreparexContext(ctx, e.DB.Rebind(qry))
+	})
+	if err != nil || e.tx == nil {
+		return stmt, release, err
+	}
+	// cached statements are prepared on the database, rebind them to the transaction for this use only
+	txStmt := e.tx.StmtxContext(ctx, stmt)
+	return txStmt, func() {
+		txStmt.Close()
+		release()
+	}, nil
+}
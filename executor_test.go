@@ -0,0 +1,1737 @@
+package pbsql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// fakeDriver is a minimal database/sql driver recording every statement it is asked to run
+type fakeDriver struct {
+	mu       sync.Mutex
+	prepares int
+	queries  []string
+	args     [][]driver.Value
+	columns  []string
+	rows     [][]driver.Value
+	// results are returned by successive queries ahead of columns and rows
+	results []fakeRows
+	lastID  int64
+	// errs are returned by successive statements before they run
+	errs []error
+	// unaffected makes statements report that they affected no rows
+	unaffected bool
+	// rollbacks counts the transactions rolled back
+	rollbacks int
+	// closed counts the result sets closed
+	closed int
+}
+
+var fakeDrivers sync.Map
+
+func init() {
+	sql.Register("pbsqlfake", fakeConnector{})
+}
+
+type fakeConnector struct{}
+
+func (fakeConnector) Open(name string) (driver.Conn, error) {
+	d, _ := fakeDrivers.Load(name)
+	return &fakeConn{d.(*fakeDriver)}, nil
+}
+
+// newFakeDB returns a *sqlx.DB backed by a fresh fakeDriver, using `driverName` for placeholder rebinding
+func newFakeDB(t *testing.T, driverName string) (*sqlx.DB, *fakeDriver) {
+	return newNamedFakeDB(t, "", driverName)
+}
+
+// newNamedFakeDB behaves like newFakeDB for tests opening several databases, which are told apart by `name`
+func newNamedFakeDB(t *testing.T, name string, driverName string) (*sqlx.DB, *fakeDriver) {
+	d := &fakeDriver{}
+	fakeDrivers.Store(t.Name()+name, d)
+	db, err := sql.Open("pbsqlfake", t.Name()+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return sqlx.NewDb(db, driverName), d
+}
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.mu.Lock()
+	c.d.prepares++
+	c.d.mu.Unlock()
+	return &fakeStmt{d: c.d, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{c.d}, nil }
+
+type fakeTx struct{ d *fakeDriver }
+
+func (fakeTx) Commit() error { return nil }
+func (tx fakeTx) Rollback() error {
+	tx.d.mu.Lock()
+	tx.d.rollbacks++
+	tx.d.mu.Unlock()
+	return nil
+}
+
+type fakeStmt struct {
+	d     *fakeDriver
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) record(args []driver.Value) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.queries = append(s.d.queries, s.query)
+	s.d.args = append(s.d.args, args)
+	if len(s.d.errs) > 0 {
+		err := s.d.errs[0]
+		s.d.errs = s.d.errs[1:]
+		return err
+	}
+	return nil
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if err := s.record(args); err != nil {
+		return nil, err
+	}
+	if s.d.unaffected {
+		return fakeResult{lastID: s.d.lastID}, nil
+	}
+	return fakeResult{lastID: s.d.lastID, rows: 1}, nil
+}
+
+type fakeResult struct{ lastID, rows int64 }
+
+func (r fakeResult) LastInsertId() (int64, error) { return r.lastID, nil }
+func (r fakeResult) RowsAffected() (int64, error) { return r.rows, nil }
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if err := s.record(args); err != nil {
+		return nil, err
+	}
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if len(s.d.results) > 0 {
+		result := s.d.results[0]
+		s.d.results = s.d.results[1:]
+		result.d = s.d
+		return &result, nil
+	}
+	return &fakeRows{d: s.d, columns: s.d.columns, rows: s.d.rows}, nil
+}
+
+type fakeRows struct {
+	d       *fakeDriver
+	columns []string
+	rows    [][]driver.Value
+	i       int
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error {
+	r.d.mu.Lock()
+	r.d.closed++
+	r.d.mu.Unlock()
+	return nil
+}
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.i])
+	r.i++
+	return nil
+}
+
+func TestExecutorStmtCache(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	exec := NewExecutor(db, WithStmtCache(1))
+	defer exec.Close()
+
+	source := TestStruct{Name: "name"}
+	for i := 0; i < 3; i++ {
+		if _, err := exec.Create(context.Background(), "test_table", &source); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := TestStruct{GeoLat: 1.5}
+	if _, err := exec.Create(context.Background(), "test_table", &other); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := exec.StmtCacheStats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Evictions != 1 || stats.Size != 1 {
+		t.Fatal("unexpected cache stats", stats)
+	}
+	if d.prepares != 2 {
+		t.Fatal("expected 2 prepared statements, got " + strconv.Itoa(d.prepares))
+	}
+}
+
+type recordingTracer struct {
+	infos []QueryInfo
+}
+
+func (r *recordingTracer) StartQuery(ctx context.Context, table string, op Operation) (context.Context, QuerySpan) {
+	return ctx, r
+}
+
+func (r *recordingTracer) End(info QueryInfo, err error) {
+	r.infos = append(r.infos, info)
+}
+
+func TestExecutorTracer(t *testing.T) {
+	db, _ := newFakeDB(t, "mysql")
+	tracer := &recordingTracer{}
+	exec := NewExecutor(db, WithTracer(tracer))
+
+	source := TestStruct{ID: 1, Name: "name"}
+	if _, err := exec.Update(context.Background(), "test_table", &source, []string{"Name"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(tracer.infos) != 1 {
+		t.Fatal("expected a single span, got", len(tracer.infos))
+	}
+	info := tracer.infos[0]
+	if info.Table != "test_table" || info.Operation != OpUpdate || info.Rows != 1 || info.Query == "" {
+		t.Fatal("unexpected query info", info)
+	}
+}
+
+type sensitiveUser struct {
+	ID    int32  `db:"id" primary_key:"y"`
+	Email string `db:"email" sensitive:"y"`
+	Name  string `db:"name"`
+}
+
+func TestExecutorLoggerRedaction(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	var entries []LogEntry
+	exec := NewExecutor(db, WithLogger(LoggerFunc(func(ctx context.Context, entry LogEntry) {
+		entries = append(entries, entry)
+	})))
+
+	source := sensitiveUser{Email: "someone@example.com", Name: "someone"}
+	if _, err := exec.Create(context.Background(), "user", &source); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatal("expected a single log entry, got", len(entries))
+	}
+	args := entries[0].Args
+	if len(args) != 2 || args[0] != RedactedArg || args[1] != "someone" {
+		t.Fatal("unexpected logged args", args)
+	}
+	if d.args[0][0] != "someone@example.com" {
+		t.Fatal("redaction must not affect the executed args", d.args[0])
+	}
+
+	// values bound under a param named after the field rather than its column are redacted too, as are the values
+	// of the fields negating a sensitive one
+	type negatedUser struct {
+		ID       int32  `db:"id" primary_key:"y"`
+		Email    string `db:"email" sensitive:"y"`
+		EmailNot string
+		Excluded string `db:"email" negate:"y" sensitive:"y"`
+		Name     string `db:"name"`
+	}
+	entries = nil
+	var negated []negatedUser
+	filter := &negatedUser{EmailNot: "a@example.com", Excluded: "b@example.com", Name: "someone"}
+	if err := exec.Read(context.Background(), "user", filter, &negated); err != nil {
+		t.Fatal(err)
+	}
+	if args := entries[0].Args; len(args) != 3 || args[0] != RedactedArg || args[1] != RedactedArg || args[2] != "someone" {
+		t.Fatal("unexpected logged args of renamed params", entries[0].Query, args)
+	}
+
+	// and so are the filter values compared with sensitive fields
+	type userFilter struct {
+		Email string
+		Name  string
+	}
+	entries = nil
+	var users []sensitiveUser
+	err := exec.Read(context.Background(), "user", &sensitiveUser{}, &users, WithFilter(&userFilter{Email: "someone@example.com", Name: "someone"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args := entries[0].Args; len(args) != 2 || args[0] != RedactedArg || args[1] != "someone" {
+		t.Fatal("unexpected logged args of a filter", entries[0].Query, args)
+	}
+}
+
+func TestExecutorAuditTrail(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	exec := NewExecutor(db, WithAuditTrail())
+
+	source := sensitiveUser{ID: 7, Name: "someone"}
+	ctx := ContextWithActor(context.Background(), int64(42))
+	if _, err := exec.Update(ctx, "user", &source, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"INSERT INTO user_history (id, email, name, history_operation, history_changed_at, history_actor) SELECT user.id, user.email, user.name, 'update', NOW(), ? FROM user WHERE user.id = ?",
+		"UPDATE user SET user.name = ? WHERE user.id = ?",
+	}
+	if len(d.queries) != len(expected) {
+		t.Fatal("unexpected queries", d.queries)
+	}
+	for i, qry := range expected {
+		if d.queries[i] != qry {
+			t.Log("Got:", d.queries[i])
+			t.Fatal("Expected:", qry)
+		}
+	}
+	if d.args[0][0] != int64(42) || d.args[0][1] != int64(7) {
+		t.Fatal("unexpected history args", d.args[0])
+	}
+}
+
+func TestExecutorBulkUpdateAuditTrail(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	exec := NewExecutor(db, WithAuditTrail())
+
+	ctx := ContextWithActor(context.Background(), int64(42))
+	res, err := exec.BulkUpdate(ctx, "user", &sensitiveUser{Name: "someone"}, &sensitiveUser{Name: "someone else"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		t.Fatal("unexpected rows affected", n)
+	}
+
+	expected := []string{
+		"INSERT INTO user_history (id, email, name, history_operation, history_changed_at, history_actor) SELECT user.id, user.email, user.name, 'update', NOW(), ? FROM user WHERE user.name = ?",
+		"UPDATE user SET user.name = ? WHERE user.name = ?",
+	}
+	if len(d.queries) != len(expected) {
+		t.Fatal("unexpected queries", d.queries)
+	}
+	for i, qry := range expected {
+		if d.queries[i] != qry {
+			t.Log("Got:", d.queries[i])
+			t.Fatal("Expected:", qry)
+		}
+	}
+	if d.args[1][0] != "someone else" || d.args[1][1] != "someone" {
+		t.Fatal("unexpected update args", d.args[1])
+	}
+}
+
+func TestExecutorDeleteMany(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	exec := NewExecutor(db)
+
+	ids := make([]interface{}, 600)
+	for i := range ids {
+		ids[i] = int64(i)
+	}
+	deleted, err := exec.DeleteMany(context.Background(), "user", &sensitiveUser{}, ids, WithHardDelete())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 || len(d.queries) != 2 || len(d.args[1]) != 100 || d.args[1][0] != int64(500) {
+		t.Fatal("expected two deletes in chunks", deleted, len(d.queries))
+	}
+	if !strings.HasPrefix(d.queries[0], "DELETE FROM user WHERE user.id IN (?, ?, ") {
+		t.Fatal("unexpected statement", d.queries[0][:60])
+	}
+}
+
+func TestExecutorCopyFrom(t *testing.T) {
+	type row struct {
+		ID    int64   `db:"id" primary_key:"y"`
+		Name  string  `db:"name"`
+		Note  *string `db:"note"`
+		Score int32   `db:"score" readonly:"y"`
+	}
+	note := "tab\there"
+	rows := []row{{Name: "a", Note: &note}, {Name: `b\c`}}
+
+	db, d := newFakeDB(t, "postgres")
+	n, err := NewExecutor(db).CopyFrom(context.Background(), "import.row", rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `COPY "import"."row" ("name", "note") FROM STDIN`
+	if n != 2 || len(d.queries) != 3 || d.queries[0] != expected || len(d.args[2]) != 0 {
+		t.Log("Got:", n, d.queries)
+		t.Fatal("Expected:", expected)
+	}
+	if d.args[0][0] != "a" || d.args[0][1] != note || d.args[1][1] != nil {
+		t.Fatal("unexpected values", d.args)
+	}
+
+	db, d = newNamedFakeDB(t, "sqlite", "sqlite3")
+	ch := make(chan *row, 2)
+	ch <- &rows[0]
+	ch <- &rows[1]
+	close(ch)
+	if n, err = NewExecutor(db).CopyFrom(context.Background(), "row", ch); err != nil || n != 2 {
+		t.Fatal("expected 2 rows to be inserted, got", n, err)
+	}
+	if expected := "INSERT INTO row (name, note) VALUES (?, ?)"; d.queries[1] != expected {
+		t.Log("Got:", d.queries[1])
+		t.Fatal("Expected:", expected)
+	}
+
+	src, err := NewCopySource("row", rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tsv bytes.Buffer
+	if err := writeTSV(&tsv, src); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "a\ttab\\there\nb\\\\c\t\\N\n"; tsv.String() != expected {
+		t.Fatalf("unexpected LOAD DATA rows %q", tsv.String())
+	}
+
+	if _, err := NewCopySource("row", &row{}); err == nil {
+		t.Fatal("expected an error copying from a single message")
+	}
+}
+
+func TestExecutorDeleteWhereAuditTrail(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	exec := NewExecutor(db, WithAuditTrail())
+
+	ctx := ContextWithActor(context.Background(), int64(42))
+	if _, err := exec.DeleteWhere(ctx, "user", &sensitiveUser{Name: "someone"}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"INSERT INTO user_history (id, email, name, history_operation, history_changed_at, history_actor) SELECT user.id, user.email, user.name, 'delete', NOW(), ? FROM user WHERE user.name = ?",
+		"DELETE FROM user WHERE user.name = ?",
+	}
+	if len(d.queries) != len(expected) {
+		t.Fatal("unexpected queries", d.queries)
+	}
+	for i, qry := range expected {
+		if d.queries[i] != qry {
+			t.Log("Got:", d.queries[i])
+			t.Fatal("Expected:", qry)
+		}
+	}
+	if d.args[0][0] != int64(42) || d.args[0][1] != "someone" {
+		t.Fatal("unexpected history args", d.args[0])
+	}
+}
+
+func TestExecutorCreateAndRead(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	d.lastID = 9
+	d.columns = []string{"id", "email", "name"}
+	d.rows = [][]driver.Value{{int64(9), "someone@example.com", "someone"}}
+	exec := NewExecutor(db)
+
+	source := sensitiveUser{Name: "someone"}
+	if err := exec.CreateAndRead(context.Background(), "user", &source); err != nil {
+		t.Fatal(err)
+	}
+	if source.ID != 9 || source.Email != "someone@example.com" {
+		t.Fatal("row was not read back", source)
+	}
+	expected := "SELECT user.id, user.email, user.name FROM user WHERE user.id = ?"
+	if len(d.queries) != 2 || d.queries[1] != expected {
+		t.Log("Got:", d.queries)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestExecutorReadView(t *testing.T) {
+	type task struct {
+		ID           int32  `db:"id" primary_key:"y"`
+		Title        string `db:"title"`
+		AssigneeName string `db:"assignee_name" readonly:"y"`
+	}
+	RegisterReadView(&task{}, "task_detail_view")
+	t.Cleanup(func() { RegisterReadView(&task{}, "") })
+
+	db, d := newFakeDB(t, "postgres")
+	d.results = []fakeRows{
+		{columns: []string{"id"}, rows: [][]driver.Value{{int64(5)}}},
+		{columns: []string{"id", "title", "assignee_name"}, rows: [][]driver.Value{{int64(5), "docs", "someone"}}},
+	}
+	exec := NewExecutor(db)
+
+	source := task{Title: "docs"}
+	if err := exec.CreateAndRead(context.Background(), "task", &source); err != nil {
+		t.Fatal(err)
+	}
+	if source.ID != 5 || source.AssigneeName != "someone" {
+		t.Fatal("row was not read back from the view", source)
+	}
+	expected := []string{
+		"INSERT INTO task (title) VALUES ($1) RETURNING task.id",
+		"SELECT task_detail_view.id, task_detail_view.title, task_detail_view.assignee_name FROM task_detail_view WHERE task_detail_view.id = $1",
+	}
+	if !reflect.DeepEqual(d.queries, expected) {
+		t.Log("Got:", d.queries)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestExecutorColumnAliases(t *testing.T) {
+	type task struct {
+		ID    int32  `db:"id" primary_key:"y"`
+		Title string `db:"title"`
+	}
+	if err := RegisterColumnAliases(&task{}, map[string]string{"id": "tsk_id", "title": "tsk_ttl"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { RegisterColumnAliases(&task{}, nil) })
+
+	db, d := newFakeDB(t, "mysql")
+	d.columns = []string{"tsk_id", "tsk_ttl"}
+	d.rows = [][]driver.Value{{int64(3), "docs"}}
+	exec := NewExecutor(db)
+
+	var tasks []task
+	if err := exec.Read(context.Background(), "task", &task{}, &tasks); err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != 3 || tasks[0].Title != "docs" {
+		t.Fatal("aliased columns were not scanned", tasks)
+	}
+}
+
+// streamedTask is a message read by ListStream, described by the descriptor of an empty message
+type streamedTask struct {
+	ID    int32  `db:"id" primary_key:"y"`
+	Title string `db:"title"`
+	Done  bool   `db:"done"`
+}
+
+func (*streamedTask) ProtoReflect() protoreflect.Message { return (&emptypb.Empty{}).ProtoReflect() }
+
+func TestExecutorListStream(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	d.columns = []string{"id", "title"}
+	d.rows = [][]driver.Value{{int64(1), "docs"}, {int64(2), "tests"}, {int64(3), "release"}}
+	exec := NewExecutor(db)
+	ctx := context.Background()
+
+	var streamed []*streamedTask
+	err := exec.ListStream(ctx, "task", &streamedTask{}, func(msg proto.Message) error {
+		streamed = append(streamed, msg.(*streamedTask))
+		return nil
+	}, WithSetFields("Done"), WithFieldMask("id", "title"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "SELECT task.id, task.title FROM task WHERE true AND task.done = ?"
+	if len(d.queries) != 1 || d.queries[0] != expected || len(d.args[0]) != 1 || d.args[0][0] != false {
+		t.Log("Got:", d.queries, d.args)
+		t.Fatal("Expected:", expected)
+	}
+	if len(streamed) != 3 || streamed[0] == streamed[1] || streamed[0].ID != 1 || streamed[2].Title != "release" {
+		t.Fatal("expected every row in a message of its own, got", streamed)
+	}
+	if d.closed != 1 {
+		t.Fatal("expected the rows to be closed, got", d.closed)
+	}
+
+	// an error of fn stops the iteration before the next row is scanned
+	d.queries, d.args, d.closed = nil, nil, 0
+	stop := errors.New("client went away")
+	calls := 0
+	err = exec.ListStream(ctx, "task", &streamedTask{Title: "docs"}, func(msg proto.Message) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 || d.closed != 1 {
+		t.Fatal("expected the first error of fn to stop the stream and close the rows, got", err, calls, d.closed)
+	}
+	expected = "SELECT task.id, task.title, task.done FROM task WHERE true AND task.title LIKE ?"
+	if d.queries[0] != expected || d.args[0][0] != "docs" {
+		t.Log("Got:", d.queries, d.args)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestExecutorJSONColumns(t *testing.T) {
+	type document struct {
+		ID       int32             `db:"id" primary_key:"y"`
+		Metadata map[string]string `db:"metadata" json_column:"y"`
+	}
+	db, d := newFakeDB(t, "mysql")
+	d.columns = []string{"id", "metadata"}
+	d.rows = [][]driver.Value{{int64(1), []byte(`{"owner":"someone"}`)}, {int64(2), nil}}
+	exec := NewExecutor(db)
+
+	var docs []document
+	if err := exec.Read(context.Background(), "document", &document{}, &docs); err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 || docs[0].Metadata["owner"] != "someone" || docs[1].Metadata != nil {
+		t.Fatal("json column was not decoded", docs)
+	}
+}
+
+func TestExecutorConverters(t *testing.T) {
+	type invoice struct {
+		ID    int32  `db:"id" primary_key:"y"`
+		Ref   string `db:"ref" convert:"uuid"`
+		Total string `db:"total" convert:"money"`
+	}
+	db, d := newFakeDB(t, "mysql")
+	d.columns = []string{"id", "ref", "total"}
+	ref := []byte{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+	d.rows = [][]driver.Value{{int64(1), ref, int64(1205)}}
+	exec := NewExecutor(db)
+
+	source := invoice{ID: 1}
+	if err := exec.Get(context.Background(), "invoice", &source); err != nil {
+		t.Fatal(err)
+	}
+	if source.Ref != "6ba7b810-9dad-11d1-80b4-00c04fd430c8" || source.Total != "12.05" {
+		t.Fatal("converted columns were not decoded", source)
+	}
+}
+
+func TestExecutorOptionalBools(t *testing.T) {
+	type flag struct {
+		ID       int32                 `db:"id" primary_key:"y"`
+		Archived *bool                 `db:"archived"`
+		Public   *wrapperspb.BoolValue `db:"public"`
+	}
+	db, d := newFakeDB(t, "mysql")
+	d.columns = []string{"id", "archived", "public"}
+	d.rows = [][]driver.Value{{int64(1), int64(0), nil}, {int64(2), nil, int64(1)}}
+	exec := NewExecutor(db)
+
+	var flags []flag
+	if err := exec.Read(context.Background(), "flag", &flag{}, &flags); err != nil {
+		t.Fatal(err)
+	}
+	if len(flags) != 2 || flags[0].Archived == nil || *flags[0].Archived || flags[0].Public != nil {
+		t.Fatal("optional bools were not scanned", flags)
+	}
+	if flags[1].Archived != nil || !flags[1].Public.GetValue() {
+		t.Fatal("optional bools were not scanned", flags[1])
+	}
+}
+
+func TestExecutorOptionalValues(t *testing.T) {
+	type counter struct {
+		ID    int32                   `db:"id" primary_key:"y"`
+		Limit *int64                  `db:"limit_count"`
+		Label *wrapperspb.StringValue `db:"label"`
+	}
+	db, d := newFakeDB(t, "mysql")
+	d.columns = []string{"id", "limit_count", "label"}
+	d.rows = [][]driver.Value{{int64(1), int64(0), nil}, {int64(2), nil, []byte("spare")}}
+	exec := NewExecutor(db)
+
+	var counters []counter
+	if err := exec.Read(context.Background(), "counter", &counter{}, &counters); err != nil {
+		t.Fatal(err)
+	}
+	if len(counters) != 2 || counters[0].Limit == nil || *counters[0].Limit != 0 || counters[0].Label != nil {
+		t.Fatal("optional values were not scanned", counters)
+	}
+	if counters[1].Limit != nil || counters[1].Label.GetValue() != "spare" {
+		t.Fatal("optional values were not scanned", counters[1])
+	}
+}
+
+func TestExecutorExplain(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	d.columns = []string{"id", "select_type", "table", "type", "key", "rows"}
+	d.rows = [][]driver.Value{
+		{int64(1), "SIMPLE", "user", "ref", "idx_email", int64(1)},
+		{int64(1), "SIMPLE", "role", "ALL", nil, int64(40)},
+	}
+	exec := NewExecutor(db)
+
+	plan, err := exec.Explain(context.Background(), "SELECT user.id FROM user WHERE user.email = ?", []interface{}{"someone@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.queries[0] != "EXPLAIN SELECT user.id FROM user WHERE user.email = ?" {
+		t.Fatal("unexpected statement", d.queries[0])
+	}
+	if !plan.UsesIndex("idx_email") || len(plan.FullScans()) != 1 || plan.FullScans()[0] != "role" || plan.Steps[1].Rows != 40 {
+		t.Fatal("unexpected plan", plan.Steps)
+	}
+
+	plan, err = Postgres.parsePlan([]map[string]interface{}{{"QUERY PLAN": []byte(`[{"Plan": {"Node Type": "Nested Loop", "Plans": [
+		{"Node Type": "Index Scan", "Relation Name": "user", "Index Name": "idx_email", "Plan Rows": 1},
+		{"Node Type": "Seq Scan", "Relation Name": "role", "Plan Rows": 40}]}}]`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.UsesIndex("idx_email") || len(plan.FullScans()) != 1 || plan.FullScans()[0] != "role" {
+		t.Fatal("unexpected plan", plan.Steps)
+	}
+
+	pg, pd := newFakeDB(t, "postgres")
+	pd.columns = []string{"QUERY PLAN"}
+	pd.rows = [][]driver.Value{{[]byte(`[{"Plan": {"Node Type": "Index Scan", "Relation Name": "task", "Index Name": "task_pkey"}}]`)}}
+	plan, err = NewExecutor(pg).ExplainAnalyze(context.Background(), "DELETE FROM task WHERE task.id = ?", []interface{}{1})
+	if err != nil || !plan.UsesIndex("task_pkey") {
+		t.Fatal("unexpected plan", plan, err)
+	}
+	if !strings.HasPrefix(pd.queries[0], "EXPLAIN ") || pd.rollbacks != 1 {
+		t.Fatal("expected the analyzed statement to be rolled back", pd.queries, pd.rollbacks)
+	}
+
+	step := sqliteStep("SEARCH user USING INDEX idx_email (email=?)")
+	if step.Table != "user" || step.Index != "idx_email" || step.FullScan {
+		t.Fatal("unexpected step", step)
+	}
+	if step := sqliteStep("SCAN role"); !step.FullScan {
+		t.Fatal("unexpected step", step)
+	}
+}
+
+func TestExecutorRouter(t *testing.T) {
+	db, main := newNamedFakeDB(t, "main", "mysql")
+	shard, primary := newNamedFakeDB(t, "shard", "postgres")
+	replicaA, a := newNamedFakeDB(t, "replicaA", "postgres")
+	replicaB, b := newNamedFakeDB(t, "replicaB", "postgres")
+	exec := NewExecutor(db, WithStmtCache(4), WithRouter(NewRouter().Table("user_role", shard, replicaA, replicaB)))
+	defer exec.Close()
+
+	ctx := context.Background()
+	role := UserRole{UserID: 1, RoleID: 2, Level: 3}
+	if _, err := exec.Create(ctx, "user_role", &role); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		var roles []UserRole
+		if err := exec.Read(ctx, "user_role", &UserRole{UserID: 1}, &roles); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := exec.Create(ctx, "test_table", &TestStruct{Name: "name"}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "INSERT INTO user_role (user_id, role_id, level) VALUES ($1, $2, $3)"
+	if len(primary.queries) != 1 || primary.queries[0] != expected {
+		t.Fatal("expected the insert on the shard primary, got", primary.queries)
+	}
+	if len(a.queries) != 1 || len(b.queries) != 1 {
+		t.Fatal("expected reads to alternate between replicas, got", a.queries, b.queries)
+	}
+	if len(main.queries) != 1 {
+		t.Fatal("expected unrouted tables on the executor's database, got", main.queries)
+	}
+	if main.prepares != 1 || primary.prepares != 1 || a.prepares != 1 || b.prepares != 1 {
+		t.Fatal("expected statements to be prepared on the database they run on")
+	}
+}
+
+func TestExecutorReplicas(t *testing.T) {
+	db, main := newNamedFakeDB(t, "main", "mysql")
+	replicaA, a := newNamedFakeDB(t, "replicaA", "mysql")
+	replicaB, b := newNamedFakeDB(t, "replicaB", "mysql")
+	router := NewRouter().Balance(LeastLatency).Replicas(replicaA, replicaB)
+	exec := NewExecutor(db, WithRouter(router))
+
+	ctx := context.Background()
+	read := func(opts ...Option) {
+		var roles []UserRole
+		if err := exec.Read(ctx, "user_role", &UserRole{UserID: 1}, &roles, opts...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// replicas without a latency are tried first
+	read()
+	read()
+	if len(a.queries) != 1 || len(b.queries) != 1 {
+		t.Fatal("expected a read on each replica, got", a.queries, b.queries)
+	}
+	router.replicas.replicas[0].latency = int64(time.Second)
+	read()
+	if len(b.queries) != 2 {
+		t.Fatal("expected the read on the faster replica, got", b.queries)
+	}
+
+	read(WithPrimary())
+	if len(main.queries) != 1 {
+		t.Fatal("expected WithPrimary to read from the primary, got", main.queries)
+	}
+
+	router.MaxStaleness(time.Minute)
+	if _, err := exec.Create(ctx, "user_role", &UserRole{UserID: 1, RoleID: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec.Count(ctx, "user_role", &UserRole{UserID: 1}); err != nil && err != sql.ErrNoRows {
+		t.Fatal(err)
+	}
+	if len(main.queries) != 3 {
+		t.Fatal("expected reads within the staleness window on the primary, got", main.queries)
+	}
+}
+
+func TestExecutorDeleteCascade(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	exec := NewExecutor(db)
+
+	dependents := []Dependent{{Table: "test_note", Message: testNote{}}}
+	if err := exec.DeleteCascade(context.Background(), "test_table", &TestStruct{ID: 2}, dependents, WithHardDelete()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"DELETE FROM test_note WHERE test_note.test_id IN (SELECT test_table.id FROM test_table WHERE test_table.id = ?)",
+		"DELETE FROM test_table WHERE test_table.id = ?",
+	}
+	if len(d.queries) != len(expected) || d.queries[0] != expected[0] || d.queries[1] != expected[1] {
+		t.Fatal("unexpected queries", d.queries)
+	}
+}
+
+type preloadUser struct {
+	ID         int32         `db:"id" primary_key:"y"`
+	PropertyID int32         `db:"property_id"`
+	Property   *testProperty `foreign_key:"property_id" foreign_table:"properties"`
+	Notes      []*testNote   `foreign_key:"test_id" foreign_table:"test_note" local_name:"id"`
+}
+
+func TestExecutorPreload(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	d.results = []fakeRows{
+		{columns: []string{"id", "property_id"}, rows: [][]driver.Value{{int64(1), int64(4)}, {int64(2), int64(4)}, {int64(3), int64(0)}}},
+		{columns: []string{"property_id"}, rows: [][]driver.Value{{int64(4)}}},
+		{columns: []string{"id", "test_id"}, rows: [][]driver.Value{{int64(10), int64(1)}, {int64(11), int64(1)}, {int64(12), int64(2)}}},
+	}
+	exec := NewExecutor(db)
+
+	var users []*preloadUser
+	if err := exec.Read(context.Background(), "user", &preloadUser{}, &users, WithPreload("Property", "Notes")); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"SELECT properties.property_id FROM properties WHERE properties.property_id IN (?)",
+		"SELECT test_note.id, test_note.test_id FROM test_note WHERE test_note.test_id IN (?, ?, ?)",
+	}
+	if len(d.queries) != 3 || d.queries[1] != expected[0] || d.queries[2] != expected[1] {
+		t.Fatal("unexpected queries", d.queries)
+	}
+	if users[0].Property == nil || users[0].Property != users[1].Property || users[2].Property != nil {
+		t.Fatal("expected the property to be shared by the rows referencing it")
+	}
+	if len(users[0].Notes) != 2 || len(users[1].Notes) != 1 || users[1].Notes[0].ID != 12 || len(users[2].Notes) != 0 {
+		t.Fatal("unexpected notes", users[0].Notes, users[1].Notes, users[2].Notes)
+	}
+}
+
+func TestExecutorAssociations(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	d.results = []fakeRows{
+		{columns: []string{"id"}, rows: [][]driver.Value{{int64(1)}, {int64(2)}}},
+		{columns: []string{"member_id", "role_id"}, rows: [][]driver.Value{{int64(1), int64(7)}, {int64(2), int64(7)}, {int64(2), int64(8)}}},
+		{columns: []string{"id", "name"}, rows: [][]driver.Value{{int64(7), "admin"}, {int64(8), "editor"}}},
+	}
+	exec := NewExecutor(db)
+
+	var members []testMember
+	if err := exec.Read(context.Background(), "member", &testMember{}, &members, WithPreload("Roles")); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"SELECT member_role.member_id, member_role.role_id FROM member_role WHERE member_role.member_id IN (?, ?)",
+		"SELECT role.id, role.name FROM role WHERE role.id IN (?, ?)",
+	}
+	if len(d.queries) != 3 || d.queries[1] != expected[0] || d.queries[2] != expected[1] {
+		t.Fatal("unexpected queries", d.queries)
+	}
+	if len(members[0].Roles) != 1 || len(members[1].Roles) != 2 || members[1].Roles[1].Name != "editor" {
+		t.Fatal("unexpected roles", members[0].Roles, members[1].Roles)
+	}
+
+	d.queries = nil
+	d.results = []fakeRows{{columns: []string{"member_id", "role_id"}, rows: [][]driver.Value{{int64(2), int64(7)}}}}
+	member := testMember{ID: 2, Roles: []*testRole{{ID: 8}}}
+	if err := exec.SyncAssociation(context.Background(), "member", &member, "Roles"); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{
+		"SELECT member_role.member_id, member_role.role_id FROM member_role WHERE member_role.member_id IN (?)",
+		"DELETE FROM member_role WHERE member_role.member_id = ? AND member_role.role_id IN (?)",
+		"INSERT INTO member_role (member_id, role_id) VALUES (?, ?)",
+	}
+	if !reflect.DeepEqual(d.queries, expected) {
+		t.Fatal("unexpected queries", d.queries)
+	}
+}
+
+func TestExecutorAssociationBatches(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	members := make([][]driver.Value, preloadBatchSize+1)
+	for i := range members {
+		members[i] = []driver.Value{int64(i + 1)}
+	}
+	d.results = []fakeRows{
+		{columns: []string{"id"}, rows: members},
+		{columns: []string{"member_id", "role_id"}},
+		{columns: []string{"member_id", "role_id"}, rows: [][]driver.Value{{int64(preloadBatchSize + 1), int64(7)}}},
+		{columns: []string{"id", "name"}, rows: [][]driver.Value{{int64(7), "admin"}}},
+	}
+	exec := NewExecutor(db)
+
+	var read []testMember
+	if err := exec.Read(context.Background(), "member", &testMember{}, &read, WithPreload("Roles")); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.queries) != 4 {
+		t.Fatal("expected a junction query per batch, got", len(d.queries))
+	}
+	last := read[preloadBatchSize]
+	if len(read[0].Roles) != 0 || len(last.Roles) != 1 || last.Roles[0].Name != "admin" {
+		t.Fatal("expected the batches after one without associations to be preloaded, got", last.Roles)
+	}
+}
+
+func TestExecutorSyncAssociationPolicies(t *testing.T) {
+	type ownedMember struct {
+		ID      int32       `db:"id" primary_key:"y"`
+		OwnerID string      `db:"owner_user_id"`
+		Roles   []*testRole `m2m:"member_role,member_id,role_id" foreign_table:"role"`
+	}
+	db, d := newFakeDB(t, "mysql")
+	exec := NewExecutor(db, WithPolicy(OwnerPolicy("owner_user_id")))
+	user := ContextWithClaims(context.Background(), Claims{UserID: "u1"})
+
+	d.columns, d.rows = []string{"exists"}, [][]driver.Value{{false}}
+	member := ownedMember{ID: 2, Roles: []*testRole{{ID: 8}}}
+	if err := exec.SyncAssociation(user, "member", &member, "Roles"); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected the junction rows of another owner's member to be left alone, got", err)
+	}
+	expected := []string{"SELECT EXISTS(SELECT 1 FROM member WHERE member.id = ? AND member.owner_user_id = ?)"}
+	if !reflect.DeepEqual(d.queries, expected) {
+		t.Log("Got:", d.queries)
+		t.Fatal("Expected:", expected)
+	}
+	if err := exec.SyncAssociation(context.Background(), "member", &member, "Roles"); !errors.Is(err, ErrMissingClaims) {
+		t.Fatal("expected ErrMissingClaims without claims, got", err)
+	}
+}
+
+func TestScanAll(t *testing.T) {
+	type taskState int32
+	type owner struct {
+		Name string `db:"name"`
+	}
+	type task struct {
+		ID    int32                  `db:"id" primary_key:"y"`
+		State taskState              `db:"state"`
+		Due   *timestamppb.Timestamp `db:"due"`
+		Owner *owner                 `foreign_table:"owner" foreign_key:"id" local_name:"owner_id"`
+	}
+	db, d := newFakeDB(t, "mysql")
+	due := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	d.columns = []string{"id", "state", "due", "owner.name"}
+	d.rows = [][]driver.Value{
+		{int64(1), int64(2), due, "ann"},
+		{int64(2), int64(1), []byte("2024-01-02 03:04:05"), "bob"},
+		{int64(3), int64(0), nil, ""},
+	}
+	rows, err := db.Queryx("SELECT id, state, due, owner.name FROM task")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var tasks []*task
+	if err := ScanAll(rows, &tasks, 3); err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 3 || cap(tasks) != 3 {
+		t.Fatalf("expected 3 preallocated tasks, got %d of %d", len(tasks), cap(tasks))
+	}
+	if tasks[0].State != 2 || !tasks[0].Due.AsTime().Equal(due) || tasks[0].Owner.Name != "ann" {
+		t.Fatal("first row was not scanned", tasks[0])
+	}
+	if !tasks[1].Due.AsTime().Equal(due) || tasks[1].Owner.Name != "bob" {
+		t.Fatal("text timestamp was not parsed", tasks[1])
+	}
+	if tasks[2].Due != nil {
+		t.Fatal("expected a NULL timestamp to leave the field nil", tasks[2].Due)
+	}
+}
+
+func TestExecutorResultCacheRaces(t *testing.T) {
+	db, _ := newFakeDB(t, "mysql")
+	cache := NewLRUCache(8)
+	exec := NewExecutor(db, WithResultCache(cache, time.Minute))
+	ctx := context.Background()
+	run := &queryRun{info: QueryInfo{Table: "test_table", Query: "SELECT test_table.id FROM test_table"}}
+
+	var rows []TestStruct
+	if _, err := exec.cached(ctx, run, &rows, &options{}, func() error {
+		// a write of the table commits while the read runs
+		exec.invalidate(ctx, "test_table")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Len() != 0 {
+		t.Fatal("expected a result read across an invalidation not to be stored")
+	}
+	if _, err := exec.cached(ctx, run, &rows, &options{}, func() error { return nil }); err != nil || cache.Len() != 1 {
+		t.Fatal("expected the next read to be stored", err)
+	}
+
+	type patient struct {
+		ID  int64  `db:"id" primary_key:"y"`
+		Ssn string `db:"ssn" encrypt:"aes"`
+	}
+	var patients []*patient
+	run = &queryRun{info: QueryInfo{Table: "patient", Query: "SELECT patient.id, patient.ssn FROM patient"}}
+	if _, err := exec.cached(ctx, run, &patients, &options{}, func() error { return nil }); err != nil || cache.Len() != 1 {
+		t.Fatal("expected messages holding encrypted fields not to be cached", err)
+	}
+}
+
+func TestExecutorResultCache(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	d.columns = []string{"id", "name"}
+	d.rows = [][]driver.Value{{int64(1), "first"}}
+	cache := NewLRUCache(8)
+	exec := NewExecutor(db, WithResultCache(cache, time.Minute))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		var rows []TestStruct
+		if err := exec.Read(ctx, "test_table", &TestStruct{ID: 1}, &rows); err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 1 || rows[0].Name != "first" {
+			t.Fatal("unexpected rows", rows)
+		}
+	}
+	if len(d.queries) != 1 || cache.Len() != 1 {
+		t.Fatalf("expected the second read to be served from the cache, ran %d queries", len(d.queries))
+	}
+
+	var rows []TestStruct
+	if err := exec.Read(ctx, "test_table", &TestStruct{ID: 1}, &rows, WithoutCache()); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.queries) != 2 {
+		t.Fatal("expected WithoutCache to bypass the cache")
+	}
+
+	if _, err := exec.Update(ctx, "test_table", &TestStruct{ID: 1, Name: "second"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Len() != 0 {
+		t.Fatal("expected the update to invalidate the cached read")
+	}
+
+	dependents := []Dependent{{Table: "test_note", Message: testNote{}}}
+	if err := exec.Read(ctx, "test_table", &TestStruct{ID: 1}, &rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.DeleteCascade(ctx, "test_table", &TestStruct{ID: 1}, dependents, WithHardDelete()); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Len() != 0 {
+		t.Fatal("expected the committed delete to invalidate the cached read")
+	}
+}
+
+func TestExecutorPolicyWrites(t *testing.T) {
+	type task struct {
+		ID       int32  `db:"id" primary_key:"y"`
+		Title    string `db:"title"`
+		TenantID int64  `db:"tenant_id"`
+	}
+	db, d := newFakeDB(t, "mysql")
+	exec := NewExecutor(db, WithPolicy(TenantPolicy("tenant_id", "admin")))
+	user := ContextWithClaims(context.Background(), Claims{UserID: "u1", Tenant: "7"})
+
+	created := task{Title: "a"}
+	if _, err := exec.Create(user, "task", &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.TenantID != 7 || d.queries[0] != "INSERT INTO task (task.title, task.tenant_id) VALUES (?, ?)" {
+		t.Fatal("expected creates to be given the tenant of the caller, got", created.TenantID, d.queries)
+	}
+	if _, err := exec.Create(user, "task", &task{Title: "a", TenantID: 8}); !errors.Is(err, ErrPolicyDenied) {
+		t.Fatal("expected creates for another tenant to be denied, got", err)
+	}
+	if _, err := exec.Upsert(user, "task", &task{ID: 1, TenantID: 8}); !errors.Is(err, ErrPolicyDenied) {
+		t.Fatal("expected upserts for another tenant to be denied, got", err)
+	}
+	if _, err := exec.CopyFrom(user, "task", []task{{Title: "a"}, {Title: "b", TenantID: 8}}); !errors.Is(err, ErrPolicyDenied) {
+		t.Fatal("expected loads holding rows of another tenant to be denied, got", err)
+	}
+	if _, err := exec.Update(user, "task", &task{ID: 1, TenantID: 8}, nil); !errors.Is(err, ErrPolicyDenied) {
+		t.Fatal("expected updates handing the row to another tenant to be denied, got", err)
+	}
+	if _, err := exec.Update(user, "task", &task{ID: 1}, []string{"tenant_id"}); !errors.Is(err, ErrPolicyDenied) {
+		t.Fatal("expected updates clearing the tenant to be denied, got", err)
+	}
+	if _, err := exec.BulkUpdate(user, "task", &task{Title: "a"}, &task{TenantID: 8}, []string{"tenant_id"}); !errors.Is(err, ErrPolicyDenied) {
+		t.Fatal("expected bulk updates handing rows to another tenant to be denied, got", err)
+	}
+
+	d.queries = nil
+	if _, err := exec.Update(user, "task", &task{ID: 1, Title: "b", TenantID: 7}, nil); err != nil {
+		t.Fatal(err)
+	}
+	admin := ContextWithClaims(context.Background(), Claims{UserID: "u2", Tenant: "7", Roles: []string{"admin"}})
+	if _, err := exec.Create(admin, "task", &task{Title: "a", TenantID: 8}); err != nil {
+		t.Fatal("expected admins to create rows of any tenant, got", err)
+	}
+	if len(d.queries) != 2 {
+		t.Fatal("unexpected queries", d.queries)
+	}
+}
+
+func TestExecutorPolicies(t *testing.T) {
+	type task struct {
+		ID      int32  `db:"id" primary_key:"y"`
+		Title   string `db:"title"`
+		OwnerID string `db:"owner_user_id"`
+	}
+	db, d := newFakeDB(t, "postgres")
+	exec := NewExecutor(db, WithPolicy(OwnerPolicy("owner_user_id", "admin")))
+	user := ContextWithClaims(context.Background(), Claims{UserID: "u1", Roles: []string{"member"}})
+
+	var tasks []task
+	if err := exec.Read(user, "task", &task{}, &tasks); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec.Update(user, "task", &task{ID: 3, Title: "renamed"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	admin := ContextWithClaims(context.Background(), Claims{UserID: "u2", Roles: []string{"admin"}})
+	if _, err := exec.Delete(admin, "task", &task{ID: 3}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"SELECT task.id, task.title, task.owner_user_id FROM task WHERE true AND task.owner_user_id = $1",
+		"UPDATE task SET title = $1 WHERE task.id = $2 AND task.owner_user_id = $3",
+		"DELETE FROM task WHERE task.id = $1",
+	}
+	if !reflect.DeepEqual(d.queries, expected) {
+		t.Log("Got:", d.queries)
+		t.Fatal("Expected:", expected)
+	}
+	if d.args[1][2] != "u1" {
+		t.Fatal("expected the user id to be bound, got", d.args[1])
+	}
+
+	if _, err := exec.Count(context.Background(), "task", &task{}); !errors.Is(err, ErrMissingClaims) {
+		t.Fatal("expected ErrMissingClaims without claims, got", err)
+	}
+	d.columns, d.rows = []string{"count"}, [][]driver.Value{{int64(2)}}
+	if _, err := exec.Count(context.Background(), "test_table", &TestStruct{}); err != nil {
+		t.Fatal("expected messages without the owner column to be left as is, got", err)
+	}
+}
+
+func TestExecutorList(t *testing.T) {
+	type task struct {
+		ID    int32  `db:"id" primary_key:"y"`
+		Title string `db:"title"`
+	}
+	db, d := newFakeDB(t, "mysql")
+	d.results = []fakeRows{
+		{columns: []string{"count"}, rows: [][]driver.Value{{int64(3)}}},
+		{columns: []string{"id", "title"}, rows: [][]driver.Value{{int64(1), "a"}, {int64(2), "b"}}},
+		{columns: []string{"count"}, rows: [][]driver.Value{{int64(3)}}},
+		{columns: []string{"id", "title"}, rows: [][]driver.Value{{int64(3), "c"}}},
+	}
+	exec := NewExecutor(db)
+	ctx := context.Background()
+
+	var first []task
+	page, err := exec.List(ctx, "task", &task{}, &first, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 2 || page.TotalCount != 3 || page.NextPageToken == "" {
+		t.Fatal("unexpected first page", first, page)
+	}
+	var second []task
+	page, err = exec.List(ctx, "task", &task{}, &second, 2, page.NextPageToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != 1 || page.NextPageToken != "" {
+		t.Fatal("unexpected last page", second, page)
+	}
+	expected := []string{
+		"SELECT COUNT(*) FROM task WHERE TRUE",
+		"SELECT task.id, task.title FROM task WHERE true order by task.id asc LIMIT 2",
+		"SELECT COUNT(*) FROM task WHERE TRUE",
+		"SELECT task.id, task.title FROM task WHERE true order by task.id asc LIMIT 2 OFFSET 2",
+	}
+	if !reflect.DeepEqual(d.queries, expected) {
+		t.Log("Got:", d.queries)
+		t.Fatal("Expected:", expected)
+	}
+
+	token := encodePageToken(2, "elsewhere")
+	d.columns, d.rows = []string{"count"}, [][]driver.Value{{int64(3)}}
+	if _, err := exec.List(ctx, "task", &task{Title: "a"}, &second, 2, token); !errors.Is(err, ErrInvalidPageToken) {
+		t.Fatal("expected ErrInvalidPageToken for a token of another filter, got", err)
+	}
+}
+
+func TestExecutorInTxLock(t *testing.T) {
+	db, d := newFakeDB(t, "postgres")
+	d.columns = []string{"id", "name", "email", "is_active"}
+	d.rows = [][]driver.Value{{int64(4), "job", "", int64(1)}}
+	exec := NewExecutor(db)
+
+	err := exec.InTx(context.Background(), func(tx *Executor) error {
+		var claimed []ContactFilter
+		if err := tx.Read(context.Background(), "contact", &ContactFilter{}, &claimed, WithLimit(1, 0), WithLock(ForUpdate|SkipLocked)); err != nil {
+			return err
+		}
+		if len(claimed) != 1 {
+			t.Fatal("expected a claimed row, got", claimed)
+		}
+		claimed[0].Name = "claimed"
+		_, err := tx.Update(context.Background(), "contact", &claimed[0], []string{"name"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"SELECT contact.id, contact.name, contact.email, contact.is_active FROM contact WHERE true order by contact.id asc LIMIT 1 FOR UPDATE SKIP LOCKED",
+		"UPDATE contact SET name = $1, is_active = $2 WHERE contact.id = $3",
+	}
+	if !reflect.DeepEqual(d.queries, expected) {
+		t.Log("Got:", d.queries)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestExecutorInTxSavepoint(t *testing.T) {
+	db, d := newFakeDB(t, "postgres")
+	exec := NewExecutor(db)
+	ctx := context.Background()
+	failed := errors.New("optional insert failed")
+
+	err := exec.InTx(ctx, func(tx *Executor) error {
+		if _, err := tx.Update(ctx, "contact", &ContactFilter{ID: 1, Name: "a"}, []string{"name"}); err != nil {
+			return err
+		}
+		err := tx.InTx(ctx, func(tx *Executor) error {
+			if err := tx.InTx(ctx, func(*Executor) error { return nil }); err != nil {
+				return err
+			}
+			return failed
+		})
+		if !errors.Is(err, failed) {
+			t.Fatal("expected the error of the nested scope, got", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"UPDATE contact SET name = $1 WHERE contact.id = $2",
+		"SAVEPOINT pbsql_sp_1",
+		"SAVEPOINT pbsql_sp_2",
+		"RELEASE SAVEPOINT pbsql_sp_2",
+		"ROLLBACK TO SAVEPOINT pbsql_sp_1",
+	}
+	if !reflect.DeepEqual(d.queries, expected) {
+		t.Log("Got:", d.queries)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestExecutorRetry(t *testing.T) {
+	deadlock := &pq.Error{Code: "40P01"}
+	for err, expected := range map[error]bool{
+		deadlock:                           true,
+		fmt.Errorf("update: %w", deadlock): true,
+		&pq.Error{Code: "23505"}:           false,
+		mysqlErrorValue{Number: 1213}:      true,
+		errors.New("deadlock"):             false,
+	} {
+		if IsTransientError(err) != expected {
+			t.Fatalf("expected IsTransientError(%v) to be %v", err, expected)
+		}
+	}
+
+	db, d := newFakeDB(t, "postgres")
+	var retries []int
+	exec := NewExecutor(db, WithRetry(RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Microsecond,
+		OnRetry: func(_ context.Context, attempt int, err error, _ time.Duration) {
+			retries = append(retries, attempt)
+		},
+	}))
+	ctx := context.Background()
+
+	d.errs = []error{deadlock, deadlock}
+	if _, err := exec.Update(ctx, "contact", &ContactFilter{ID: 1, Name: "a"}, []string{"name"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.queries) != 3 || !reflect.DeepEqual(retries, []int{1, 2}) {
+		t.Fatal("expected the update to succeed on its third attempt, got", d.queries, retries)
+	}
+
+	d.queries, retries = nil, nil
+	d.errs = []error{deadlock, deadlock, deadlock}
+	if _, err := exec.Update(ctx, "contact", &ContactFilter{ID: 1, Name: "a"}, []string{"name"}); !errors.Is(err, deadlock) {
+		t.Fatal("expected the deadlock once attempts are exhausted, got", err)
+	}
+	if len(d.queries) != 3 {
+		t.Fatal("expected 3 attempts, got", d.queries)
+	}
+
+	d.queries, retries = nil, nil
+	d.errs = []error{nil, deadlock}
+	runs := 0
+	err := exec.InTx(ctx, func(tx *Executor) error {
+		runs++
+		if _, err := tx.Update(ctx, "contact", &ContactFilter{ID: 1, Name: "a"}, []string{"name"}); err != nil {
+			return err
+		}
+		_, err := tx.Update(ctx, "contact", &ContactFilter{ID: 2, Name: "b"}, []string{"name"})
+		return err
+	})
+	if err != nil || runs != 2 || len(d.queries) != 4 {
+		t.Fatal("expected the transaction to be run again as a whole, got", err, runs, d.queries)
+	}
+
+	// the delay stays within MaxDelay however many attempts double it
+	attempts := 0
+	policy := RetryPolicy{
+		MaxAttempts: 100,
+		MaxDelay:    time.Nanosecond,
+		OnRetry: func(_ context.Context, attempt int, _ error, delay time.Duration) {
+			if delay <= 0 || delay > time.Nanosecond {
+				t.Fatalf("expected retry %d to wait at most 1ns, got %v", attempt, delay)
+			}
+		},
+	}
+	err = policy.run(ctx, func() error {
+		attempts++
+		return deadlock
+	})
+	if !errors.Is(err, deadlock) || attempts != 100 {
+		t.Fatal("expected 100 attempts, got", attempts, err)
+	}
+}
+
+func TestConstraintErrors(t *testing.T) {
+	for err, expected := range map[error]ConstraintError{
+		&pq.Error{Code: "23505", Constraint: "user_email_key", Detail: "Key (email)=(a@b.c) already exists."}: {
+			Kind: UniqueViolation, Constraint: "user_email_key", Column: "email",
+		},
+		fmt.Errorf("create: %w", &pq.Error{Code: "23502", Column: "name"}): {Kind: NotNullViolation, Column: "name"},
+		mysqlErrorValue{Number: 1062, Message: "Duplicate entry 'a@b.c' for key 'user.idx_email'"}: {
+			Kind: UniqueViolation, Constraint: "idx_email",
+		},
+		mysqlErrorValue{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails (`db`.`task`, CONSTRAINT `fk_task_user` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`))"}: {
+			Kind: ForeignKeyViolation, Constraint: "fk_task_user", Column: "user_id",
+		},
+		mysqlErrorValue{Number: 1048, Message: "Column 'name' cannot be null"}: {Kind: NotNullViolation, Column: "name"},
+		errors.New("UNIQUE constraint failed: user.tenant, user.email"):        {Kind: UniqueViolation, Column: "tenant, email"},
+	} {
+		constraint, ok := AsConstraintError(err)
+		if !ok {
+			t.Fatalf("expected %v to be a constraint violation", err)
+		}
+		if constraint.Kind != expected.Kind || constraint.Constraint != expected.Constraint || constraint.Column != expected.Column {
+			t.Log("Got:", *constraint)
+			t.Fatal("Expected:", expected)
+		}
+		if !errors.Is(constraint, err) || strings.Contains(constraint.Error(), "a@b.c") {
+			t.Fatal("expected the driver error to be wrapped and its values left out of the message, got", constraint)
+		}
+	}
+	for _, err := range []error{&pq.Error{Code: "40001"}, mysqlErrorValue{Number: 1213}, errors.New("boom")} {
+		if _, ok := AsConstraintError(err); ok {
+			t.Fatalf("expected %v not to be a constraint violation", err)
+		}
+	}
+}
+
+// mysqlErrorValue mirrors the error of go-sql-driver/mysql
+type mysqlErrorValue struct {
+	Number  uint16
+	Message string
+}
+
+func (e mysqlErrorValue) Error() string { return e.Message }
+
+func TestExecutorCreateIdempotent(t *testing.T) {
+	type payment struct {
+		ID        int64  `db:"id" primary_key:"y"`
+		RequestID string `db:"request_id" idempotency_key:"y"`
+		Amount    int64  `db:"amount"`
+	}
+	db, d := newFakeDB(t, "postgres")
+	d.columns = []string{"id", "request_id", "amount"}
+	d.rows = [][]driver.Value{{int64(9), "req-1", int64(3)}}
+
+	source := payment{RequestID: "req-1", Amount: 5}
+	created, err := NewExecutor(db).CreateIdempotent(context.Background(), "payment", &source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"INSERT INTO payment (request_id, amount) VALUES ($1, $2) ON CONFLICT (request_id) DO NOTHING",
+		"SELECT payment.id, payment.request_id, payment.amount FROM payment WHERE payment.request_id = $1",
+	}
+	if !created || !reflect.DeepEqual(d.queries, expected) {
+		t.Log("Got:", created, d.queries)
+		t.Fatal("Expected:", expected)
+	}
+	if source.ID != 9 || source.Amount != 3 {
+		t.Fatal("expected the stored row to be read back, got", source)
+	}
+}
+
+func TestExecutorNotFound(t *testing.T) {
+	ctx := context.Background()
+	db, d := newFakeDB(t, "postgres")
+	d.unaffected = true
+	exec := NewExecutor(db)
+	if _, err := exec.Delete(ctx, "contact", &ContactFilter{ID: 1}); !errors.Is(err, ErrNotFound) || !errors.Is(err, sql.ErrNoRows) {
+		t.Fatal("expected ErrNotFound for a delete matching no row, got", err)
+	}
+	if err := exec.Get(ctx, "contact", &ContactFilter{ID: 1}); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected ErrNotFound for a get matching no row, got", err)
+	}
+
+	// MySQL reports unchanged rows as unaffected, the row is looked up before reporting it missing
+	db, d = newFakeDB(t, "mysql")
+	d.unaffected = true
+	d.columns, d.rows = []string{"1"}, [][]driver.Value{{int64(1)}}
+	exec = NewExecutor(db)
+	if _, err := exec.Update(ctx, "contact", &ContactFilter{ID: 1, Name: "a"}, []string{"name"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"UPDATE contact SET contact.name = ? WHERE contact.id = ?",
+		"SELECT 1 FROM contact WHERE contact.id = ?",
+	}
+	if !reflect.DeepEqual(d.queries, expected) {
+		t.Log("Got:", d.queries)
+		t.Fatal("Expected:", expected)
+	}
+	d.rows = nil
+	if _, err := exec.Update(ctx, "contact", &ContactFilter{ID: 1, Name: "a"}, []string{"name"}); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected ErrNotFound for an update matching no row, got", err)
+	}
+}
+
+func TestExecutorExists(t *testing.T) {
+	db, d := newFakeDB(t, "postgres")
+	d.columns, d.rows = []string{"exists"}, [][]driver.Value{{true}}
+	exists, err := NewExecutor(db).Exists(context.Background(), "contact", &ContactFilter{Email: "a@b.c"}, "email")
+	if err != nil || !exists {
+		t.Fatal("expected the row to exist, got", exists, err)
+	}
+	if expected := "SELECT EXISTS(SELECT 1 FROM contact WHERE contact.email = $1)"; d.queries[0] != expected {
+		t.Log("Got:", d.queries[0])
+		t.Fatal("Expected:", expected)
+	}
+
+	db, d = newFakeDB(t, "mysql")
+	d.columns, d.rows = []string{"exists"}, [][]driver.Value{{int64(0)}}
+	if exists, err = NewExecutor(db).Exists(context.Background(), "contact", &ContactFilter{ID: 4}); err != nil || exists {
+		t.Fatal("expected the row not to exist, got", exists, err)
+	}
+}
+
+func TestExecutorFacets(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	d.columns, d.rows = []string{"value", "count"}, [][]driver.Value{{[]byte("a@b.c"), int64(3)}, {nil, int64(1)}}
+	facets, err := NewExecutor(db).Facets(context.Background(), "contact", &ContactFilter{Name: "ann"}, "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []FacetCount{{Value: "a@b.c", Count: 3}, {Value: nil, Count: 1}}
+	if !reflect.DeepEqual(facets["email"], expected) {
+		t.Fatal("unexpected facets", facets)
+	}
+	if expected := "SELECT contact.email AS value, COUNT(*) AS count FROM contact WHERE TRUE AND contact.name LIKE ? GROUP BY contact.email ORDER BY COUNT(*) DESC, contact.email"; d.queries[0] != expected {
+		t.Log("Got:", d.queries[0])
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestExecutorReadUnion(t *testing.T) {
+	type contact struct {
+		ID     int64  `db:"id" primary_key:"y"`
+		Name   string `db:"name"`
+		Source string `db:"source_table"`
+	}
+	db, d := newFakeDB(t, "mysql")
+	d.columns, d.rows = []string{"id", "name", "source_table"}, [][]driver.Value{{int64(1), "ann", "contact"}, {int64(1), "ann", "contact_archive"}}
+	var contacts []contact
+	if err := NewExecutor(db).ReadUnion(context.Background(), []string{"contact", "contact_archive"}, &contact{Name: "ann"}, &contacts); err != nil {
+		t.Fatal(err)
+	}
+	if len(contacts) != 2 || contacts[1].Source != "contact_archive" {
+		t.Fatal("unexpected rows", contacts)
+	}
+	if expected := "SELECT * FROM (SELECT contact.id, contact.name, 'contact' AS source_table FROM contact WHERE true AND contact.name LIKE ? UNION ALL SELECT contact_archive.id, contact_archive.name, 'contact_archive' AS source_table FROM contact_archive WHERE true AND contact_archive.name LIKE ?) AS pbsql_union order by id asc, source_table asc"; d.queries[0] != expected {
+		t.Log("Got:", d.queries[0])
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestExecutorMaterializedViews(t *testing.T) {
+	db, d := newFakeDB(t, "postgres")
+	exec := NewExecutor(db, WithMaterializedViews(
+		MaterializedView{Name: "task_report", Tables: []string{"test_table"}, Concurrently: true},
+		MaterializedView{Name: "daily_report", Tables: []string{"test_table"}, Debounce: time.Hour},
+	))
+	ctx := context.Background()
+
+	if _, err := exec.Update(ctx, "test_table", &TestStruct{ID: 1, Name: "second"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.queries) != 2 || d.queries[1] != "REFRESH MATERIALIZED VIEW CONCURRENTLY task_report" {
+		t.Fatal("expected the write to refresh the view, ran", d.queries)
+	}
+
+	d.queries = nil
+	err := exec.InTx(ctx, func(tx *Executor) error {
+		for i := 0; i < 2; i++ {
+			if _, err := tx.Update(ctx, "test_table", &TestStruct{ID: 1, Name: "third"}, nil); err != nil {
+				return err
+			}
+		}
+		if len(d.queries) != 2 {
+			t.Fatal("expected the refresh to wait for the commit, ran", d.queries)
+		}
+		return nil
+	})
+	if err != nil || len(d.queries) != 3 || d.queries[2] != "REFRESH MATERIALIZED VIEW CONCURRENTLY task_report" {
+		t.Fatal("expected a single refresh after the commit, ran", d.queries, err)
+	}
+}
+
+func TestExecutorWriteEvents(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	var events []WriteEvent
+	exec := NewExecutor(db, WithOutbox("outbox"), WithWriteHook(func(ctx context.Context, event WriteEvent) {
+		events = append(events, event)
+	}))
+	ctx := context.Background()
+
+	row := &TestStruct{ID: 1, Name: "first"}
+	if _, err := exec.Update(ctx, "test_table", row, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0] != (WriteEvent{Operation: OpUpdate, Table: "test_table", Message: row}) {
+		t.Fatal("unexpected events", events)
+	}
+	if len(d.queries) != 2 || d.queries[1] != "INSERT INTO outbox (aggregate_type, event_type, payload, created_at) VALUES (?, ?, ?, NOW())" {
+		t.Fatal("expected the event to be written to the outbox, ran", d.queries)
+	}
+
+	events = nil
+	err := exec.InTx(ctx, func(tx *Executor) error {
+		if _, err := tx.Delete(ctx, "test_table", row); err != nil {
+			return err
+		}
+		if len(events) != 0 {
+			t.Fatal("expected the hook to wait for the commit")
+		}
+		return errors.New("rolled back")
+	})
+	if err == nil || len(events) != 0 {
+		t.Fatal("expected no event for a rolled back write, got", events)
+	}
+}
+
+func TestExecutorUpdateWithDiff(t *testing.T) {
+	db, d := newFakeDB(t, "postgres")
+	d.columns = []string{"id", "name", "email", "is_active"}
+	d.rows = [][]driver.Value{{int64(4), "ann", "a@b.c", int64(1)}}
+	source := &ContactFilter{ID: 4, Name: "bob", Email: "a@b.c"}
+	changes, err := NewExecutor(db).UpdateWithDiff(context.Background(), "contact", source, []string{"is_active"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []FieldChange{{Field: "name", Old: "ann", New: "bob"}, {Field: "is_active", Old: int32(1), New: int32(0)}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatal("unexpected changes", changes)
+	}
+	if expected := "SELECT contact.id, contact.name, contact.email, contact.is_active FROM contact WHERE contact.id = $1 FOR UPDATE"; d.queries[0] != expected {
+		t.Log("Got:", d.queries[0])
+		t.Fatal("Expected:", expected)
+	}
+}
+
+// validatedContact validates itself like messages generated by protoc-gen-validate
+type validatedContact struct {
+	ID    int32  `db:"id" primary_key:"y"`
+	Name  string `db:"name"`
+	Email string `db:"email"`
+}
+
+type contactViolation struct{ field, reason string }
+
+func (v contactViolation) Error() string  { return v.field + ": " + v.reason }
+func (v contactViolation) Field() string  { return v.field }
+func (v contactViolation) Reason() string { return v.reason }
+
+type contactMultiError []error
+
+func (m contactMultiError) Error() string      { return "invalid contact" }
+func (m contactMultiError) AllErrors() []error { return m }
+
+func (c *validatedContact) Validate() error { return errors.New("use ValidateAll") }
+
+func (c *validatedContact) ValidateAll() error {
+	var errs contactMultiError
+	if c.Name == "" {
+		errs = append(errs, contactViolation{"name", "value length must be at least 1 runes"})
+	}
+	if !strings.Contains(c.Email, "@") {
+		errs = append(errs, contactViolation{"email", "value must be a valid email address"})
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func TestExecutorValidation(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	exec := NewExecutor(db, WithValidation(func(ctx context.Context, table string, op Operation, msg interface{}) error {
+		if op == OpCreate && msg.(*validatedContact).ID != 0 {
+			return errors.New("ids are generated")
+		}
+		return nil
+	}))
+	ctx := context.Background()
+
+	_, err := exec.Create(ctx, "contact", &validatedContact{ID: 3, Email: "ann"})
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || !errors.Is(err, ErrInvalidMessage) || len(d.queries) != 0 {
+		t.Fatal("expected the create to be rejected, got", err)
+	}
+	expected := []FieldViolation{
+		{Field: "name", Reason: "value length must be at least 1 runes"},
+		{Field: "email", Reason: "value must be a valid email address"},
+		{Reason: "ids are generated"},
+	}
+	if !reflect.DeepEqual(invalid.Violations, expected) {
+		t.Fatal("unexpected violations", invalid.Violations)
+	}
+
+	// the update doesn't assign the name, which is left out of the violations
+	if _, err := exec.Update(ctx, "contact", &validatedContact{ID: 3, Email: "ann@b.c"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec.Update(ctx, "contact", &validatedContact{ID: 3, Email: "ann@b.c"}, []string{"name"}); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatal("expected the update of an empty name to be rejected, got", err)
+	}
+}
+
+// defaultedContact sets its default name before it is created
+type defaultedContact struct {
+	ID    int32  `db:"id" primary_key:"y"`
+	Name  string `db:"name"`
+	Email string `db:"email"`
+}
+
+func (c *defaultedContact) BeforeCreate(ctx context.Context) error {
+	if c.Name == "" {
+		c.Name = "anonymous"
+	}
+	return nil
+}
+
+func TestExecutorBeforeCreate(t *testing.T) {
+	RegisterBeforeCreate(&defaultedContact{}, func(ctx context.Context, msg interface{}) error {
+		contact := msg.(*defaultedContact)
+		contact.Email = strings.ToLower(contact.Name) + "@example.com"
+		return nil
+	})
+	defer RegisterBeforeCreate(&defaultedContact{}, nil)
+	db, d := newFakeDB(t, "mysql")
+	contact := &defaultedContact{}
+	if _, err := NewExecutor(db).Create(context.Background(), "contact", contact); err != nil {
+		t.Fatal(err)
+	}
+	if contact.Name != "anonymous" || contact.Email != "anonymous@example.com" {
+		t.Fatal("expected the defaults to be populated, got", contact)
+	}
+	if expected := "INSERT INTO contact (contact.name, contact.email) VALUES (?, ?)"; d.queries[0] != expected {
+		t.Log("Got:", d.queries[0])
+		t.Fatal("Expected:", expected)
+	}
+
+	RegisterBeforeCreate(&defaultedContact{}, func(ctx context.Context, msg interface{}) error {
+		return errors.New("no contacts today")
+	})
+	if _, err := NewExecutor(db).Create(context.Background(), "contact", &defaultedContact{}); err == nil || len(d.queries) != 1 {
+		t.Fatal("expected the hook to stop the create, got", err)
+	}
+}
+
+func TestExecutorClaim(t *testing.T) {
+	claim := Claim{StatusColumn: "name", Pending: "pending", Claimed: "running", WorkerColumn: "email", Worker: "worker-1"}
+	for driverName, expected := range map[string][]string{
+		"postgres": {
+			"UPDATE contact SET name = $1, email = $2 WHERE (contact.id) IN (SELECT contact.id FROM contact WHERE contact.name = $3 ORDER BY contact.id asc LIMIT 1 FOR UPDATE SKIP LOCKED) RETURNING contact.id, contact.name, contact.email, contact.is_active",
+		},
+		"sqlite3": {
+			"UPDATE contact SET name = ?, email = ? WHERE (contact.id) IN (SELECT contact.id FROM contact WHERE contact.name = ? ORDER BY contact.id asc LIMIT 1)",
+			"SELECT contact.id, contact.name, contact.email, contact.is_active FROM contact WHERE contact.name = ? AND contact.email = ?",
+		},
+	} {
+		db, d := newNamedFakeDB(t, driverName, driverName)
+		d.columns = []string{"id", "name", "email", "is_active"}
+		d.rows = [][]driver.Value{{int64(4), "running", "worker-1", int64(1)}}
+		exec := NewExecutor(db)
+
+		var claimed []ContactFilter
+		if err := exec.Claim(context.Background(), "contact", &ContactFilter{}, claim, &claimed); err != nil {
+			t.Fatal(err)
+		}
+		if len(claimed) != 1 || claimed[0].ID != 4 {
+			t.Fatal("expected a claimed row, got", claimed)
+		}
+		if !reflect.DeepEqual(d.queries, expected) {
+			t.Log("Got:", d.queries)
+			t.Fatal("Expected:", expected)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package pbsql
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// BuildExistsQuery builds a statement checking whether a row of `target` holds the values of `source` in `columns`,
+// e.g. to validate that an email is free before creating a user:
+//
+//	qry, args, err := pbsql.BuildExistsQuery("user", &pb.User{Email: "a@b.c"}, "email")
+//	// SELECT EXISTS(SELECT 1 FROM user WHERE user.email = ?)
+//
+// Columns name fields like WithFieldMask and are compared by equality, zero values included, so that the statement
+// matches what a unique index would. Without columns the primary keys of `source` are compared. Soft deleted rows are
+// matched too, since they still hold their unique values. The statement returns a single boolean column.
+func BuildExistsQuery(target string, source interface{}, columns ...string) (string, []interface{}, error) {
+	o := newOptions(nil)
+	target = o.table(target, source)
+	qry, err := existsQuery(target, source, columns, o)
+	if err != nil {
+		return "", nil, err
+	}
+	return o.bind(qry, source)
+}
+
+// existsQuery returns the named statement bound by BuildExistsQuery
+func existsQuery(target string, source interface{}, columns []string, o *options) (string, error) {
+	v := reflect.ValueOf(source).Elem()
+	names, err := normalizeMask(v.Type(), target, columns)
+	if err != nil {
+		return "", err
+	}
+	var fields []*field
+	if len(names) == 0 {
+		if fields = primaryKeys(v, target); len(fields) == 0 {
+			return "", fmt.Errorf("%w: no columns given to check %s", ErrMissingPrimaryKey, target)
+		}
+	}
+	for _, name := range names {
+		self, _ := v.Type().FieldByName(name)
+		field := parseReflection(v, self.Index[0], target)
+		if field.name == "" || !field.isColumn {
+			return "", fmt.Errorf("%w: %s of %s", ErrUnmappedField, name, target)
+		}
+		fields = append(fields, field)
+	}
+	where, err := o.whereClauses()
+	if err != nil {
+		return "", err
+	}
+	predicates := make([]string, 0, len(fields)+len(where))
+	for _, field := range fields {
+		predicates = append(predicates, field.column()+" = :"+field.name)
+	}
+	predicates = append(predicates, where...)
+	return "SELECT EXISTS(SELECT 1 FROM " + target + " WHERE " + strings.Join(predicates, " AND ") + ")", nil
+}
+
+// Exists runs the statement of BuildExistsQuery and reports whether a row holds the values of `source` in
+// `columns`, or its primary keys without columns
+func (e *Executor) Exists(ctx context.Context, target string, source interface{}, columns ...string) (exists bool, err error) {
+	e = e.route(target, source, OpRead)
+	opts, err := e.secure(ctx, target, OpRead, source, nil)
+	if err != nil {
+		return false, err
+	}
+	o := e.options(opts)
+	target = o.table(target, source)
+	err = e.getBuilt(ctx, target, &exists, func() (string, interface{}, error) {
+		qry, err := existsQuery(target, source, columns, o)
+		return qry, o.bindSource(source), err
+	})
+	return exists, err
+}
@@ -0,0 +1,216 @@
+package pbsql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Plan summarizes the query plan reported by EXPLAIN
+type Plan struct {
+	Steps []PlanStep
+	// Raw holds the unparsed EXPLAIN output, one entry per row (MySQL, SQLite) or the JSON document (Postgres)
+	Raw []string
+}
+
+// PlanStep describes how a single table is read
+type PlanStep struct {
+	Table string
+	// Index is the index used to read the table, empty if none is
+	Index string
+	// FullScan is set if every row of the table is read
+	FullScan bool
+	// Rows is the number of rows the planner expects to read, or the actual number if the plan was analyzed.
+	// SQLite doesn't estimate it.
+	Rows int64
+	// Detail is the access type (MySQL), node type (Postgres), or plan detail (SQLite)
+	Detail string
+}
+
+// UsesIndex reports whether any step reads `index`
+func (p *Plan) UsesIndex(index string) bool {
+	for _, step := range p.Steps {
+		if step.Index == index {
+			return true
+		}
+	}
+	return false
+}
+
+// FullScans returns the tables which are read in full
+func (p *Plan) FullScans() []string {
+	var tables []string
+	for _, step := range p.Steps {
+		if step.FullScan {
+			tables = append(tables, step.Table)
+		}
+	}
+	return tables
+}
+
+// Explain runs EXPLAIN on a statement generated by one of the builders, bound with the executor's dialect, and
+// returns a summary of the plan. It is meant for tests asserting that generated queries use an index, e.g.
+//
+//	qry, args, _ := pbsql.BuildReadQuery("user", &pb.User{Email: "someone@example.com"})
+//	plan, err := exec.Explain(ctx, qry, args)
+//	if len(plan.FullScans()) > 0 { ... }
+func (e *Executor) Explain(ctx context.Context, qry string, args []interface{}) (*Plan, error) {
+	return e.explain(ctx, qry, args, false)
+}
+
+// ExplainAnalyze behaves like Explain but runs EXPLAIN ANALYZE on Postgres, which executes the statement and reports
+// actual row counts. Other dialects report the estimated plan. The statement runs in a transaction, or a savepoint of
+// the transaction of the Executor, which is always rolled back, so analyzing an insert, update, or delete changes
+// nothing. The statement is run as given, without the options of the executor's policies, so it must not come from
+// callers.
+func (e *Executor) ExplainAnalyze(ctx context.Context, qry string, args []interface{}) (plan *Plan, err error) {
+	err = e.InTx(ctx, func(tx *Executor) error {
+		analyzed, err := tx.explain(ctx, qry, args, true)
+		if err != nil {
+			return err
+		}
+		plan = analyzed
+		return errExplained
+	})
+	if errors.Is(err, errExplained) {
+		err = nil
+	}
+	return plan, err
+}
+
+// errExplained rolls back the transaction of ExplainAnalyze
+var errExplained = errors.New("pbsql: statement explained")
+
+func (e *Executor) explain(ctx context.Context, qry string, args []interface{}, analyze bool) (*Plan, error) {
+	rows, err := e.ext().QueryxContext(ctx, e.DB.Rebind(e.dialect.explain(analyze)+qry), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []map[string]interface{}
+	for rows.Next() {
+		row := make(map[string]interface{})
+		if err := rows.MapScan(row); err != nil {
+			return nil, err
+		}
+		results = append(results, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return e.dialect.parsePlan(results)
+}
+
+// explain returns the statement prefix requesting a plan
+func (d Dialect) explain(analyze bool) string {
+	switch d {
+	case Postgres:
+		if analyze {
+			return "EXPLAIN (ANALYZE, FORMAT JSON) "
+		}
+		return "EXPLAIN (FORMAT JSON) "
+	case SQLite:
+		return "EXPLAIN QUERY PLAN "
+	default:
+		return "EXPLAIN "
+	}
+}
+
+// parsePlan summarizes the rows returned by the statement of explain
+func (d Dialect) parsePlan(results []map[string]interface{}) (*Plan, error) {
+	plan := &Plan{}
+	switch d {
+	case Postgres:
+		for _, row := range results {
+			for _, value := range row {
+				doc := planString(value)
+				plan.Raw = append(plan.Raw, doc)
+				var nodes []struct {
+					Plan pgPlanNode `json:"Plan"`
+				}
+				if err := json.Unmarshal([]byte(doc), &nodes); err != nil {
+					return nil, fmt.Errorf("pbsql: parsing plan: %w", err)
+				}
+				for _, node := range nodes {
+					node.Plan.steps(plan)
+				}
+			}
+		}
+	case SQLite:
+		for _, row := range results {
+			detail := planString(row["detail"])
+			plan.Raw = append(plan.Raw, detail)
+			plan.Steps = append(plan.Steps, sqliteStep(detail))
+		}
+	default:
+		for _, row := range results {
+			step := PlanStep{
+				Table:  planString(row["table"]),
+				Index:  planString(row["key"]),
+				Detail: planString(row["type"]),
+			}
+			step.FullScan = step.Detail == "ALL"
+			step.Rows, _ = strconv.ParseInt(planString(row["rows"]), 10, 64)
+			plan.Steps = append(plan.Steps, step)
+			plan.Raw = append(plan.Raw, fmt.Sprint(row))
+		}
+	}
+	return plan, nil
+}
+
+// pgPlanNode is a node of a Postgres JSON plan
+type pgPlanNode struct {
+	NodeType   string       `json:"Node Type"`
+	Relation   string       `json:"Relation Name"`
+	Index      string       `json:"Index Name"`
+	PlanRows   int64        `json:"Plan Rows"`
+	ActualRows *int64       `json:"Actual Rows"`
+	Plans      []pgPlanNode `json:"Plans"`
+}
+
+// steps appends a step for every node reading a table, depth first
+func (n pgPlanNode) steps(plan *Plan) {
+	if n.Relation != "" {
+		step := PlanStep{Table: n.Relation, Index: n.Index, Detail: n.NodeType, Rows: n.PlanRows}
+		step.FullScan = n.NodeType == "Seq Scan"
+		if n.ActualRows != nil {
+			step.Rows = *n.ActualRows
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+	for _, child := range n.Plans {
+		child.steps(plan)
+	}
+}
+
+// sqliteStep parses a detail of EXPLAIN QUERY PLAN, e.g. `SCAN user` or `SEARCH user USING INDEX idx_email (email=?)`
+func sqliteStep(detail string) PlanStep {
+	step := PlanStep{Detail: detail}
+	words := strings.Fields(detail)
+	if len(words) < 2 || (words[0] != "SCAN" && words[0] != "SEARCH") {
+		return step
+	}
+	step.Table = words[1]
+	for i, word := range words {
+		if word == "INDEX" && i+1 < len(words) {
+			step.Index = words[i+1]
+		}
+	}
+	step.FullScan = words[0] == "SCAN" && step.Index == "" && !strings.Contains(detail, "PRIMARY KEY")
+	return step
+}
+
+// planString returns a column of EXPLAIN output as a string, drivers return text as either []byte or string
+func planString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if s, ok := asString(value); ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,106 @@
+package pbsql
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// FacetCount is the number of rows holding a value of a facet column, see Executor.Facets
+type FacetCount struct {
+	// Value is the value of the column, nil for NULL. Text is always a string, whatever the driver scans it as.
+	Value interface{} `db:"value"`
+	Count int64       `db:"count"`
+}
+
+// BuildFacetQuery builds a statement per column of `facetColumns` counting the rows matching `filter` by value of the
+// column, with the predicates BuildCountQueryWithOptions writes for a list of `filter`, so that list UIs can show the
+// number of rows per status or category next to the page they read:
+//
+//	stmts, err := pbsql.BuildFacetQuery("task", &pb.Task{ProjectId: 7}, "status")
+//	// SELECT task.status AS value, COUNT(*) AS count FROM task WHERE TRUE AND task.project_id = ?
+//	// GROUP BY task.status ORDER BY COUNT(*) DESC, task.status
+//
+// Columns name fields like WithFieldMask. The most frequent values come first.
+func BuildFacetQuery(target string, filter interface{}, facetColumns ...string) ([]Statement, error) {
+	o := newOptions(nil)
+	target = o.readRelation(o.table(target, filter), filter)
+	statements := make([]Statement, 0, len(facetColumns))
+	for _, column := range facetColumns {
+		qry, err := facetQuery(target, filter, column, o)
+		if err != nil {
+			return nil, err
+		}
+		qry, args, err := o.bind(qry, filter)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, Statement{Query: qry, Args: args})
+	}
+	return statements, nil
+}
+
+// facetQuery returns the named statement counting the rows matching `filter` by value of the field named `name`
+func facetQuery(target string, filter interface{}, name string, o *options) (string, error) {
+	v := reflect.ValueOf(filter).Elem()
+	names, err := normalizeMask(v.Type(), target, []string{name})
+	if err != nil {
+		return "", err
+	}
+	self, _ := v.Type().FieldByName(names[0])
+	field := parseReflection(v, self.Index[0], target)
+	if field.name == "" || !field.isColumn {
+		return "", fmt.Errorf("%w: facet %s of %s", ErrUnmappedField, name, target)
+	}
+	count, err := countQuery(target, filter, nil, o)
+	if err != nil {
+		return "", err
+	}
+	column := field.column()
+	qry := strings.Replace(count, "SELECT COUNT(*)", "SELECT "+column+" AS value, COUNT(*) AS count", 1)
+	return qry + " GROUP BY " + column + " ORDER BY COUNT(*) DESC, " + column, nil
+}
+
+// Facets runs the statements of BuildFacetQuery and returns the counts of the values of each of `facetColumns` among
+// the rows matching `filter`, keyed by the names given. Policies restrict the rows counted like those of a count.
+func (e *Executor) Facets(ctx context.Context, target string, filter interface{}, facetColumns ...string) (map[string][]FacetCount, error) {
+	e = e.route(target, filter, OpCount)
+	opts, err := e.secure(ctx, target, OpCount, filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	o := e.options(opts)
+	target = o.readRelation(o.table(target, filter), filter)
+	facets := make(map[string][]FacetCount, len(facetColumns))
+	for _, column := range facetColumns {
+		var counts []FacetCount
+		if err := e.facet(ctx, target, filter, column, &counts, o); err != nil {
+			return nil, err
+		}
+		for i, count := range counts {
+			if b, ok := count.Value.([]byte); ok {
+				counts[i].Value = string(b)
+			}
+		}
+		facets[column] = counts
+	}
+	return facets, nil
+}
+
+// facet runs the statement counting the rows matching `filter` by value of `column` into `dest`
+func (e *Executor) facet(ctx context.Context, target string, filter interface{}, column string, dest *[]FacetCount, o *options) (err error) {
+	ctx, run := e.start(ctx, target, OpCount)
+	defer func() { run.finish(err) }()
+
+	if err = run.build(func() (string, interface{}, error) {
+		qry, err := facetQuery(target, filter, column, o)
+		return qry, o.bindSource(filter), err
+	}); err != nil {
+		return err
+	}
+	if err = e.selectRows(ctx, filter, dest, run.info.Query, run.args); err == nil {
+		run.info.Rows = int64(len(*dest))
+	}
+	return err
+}
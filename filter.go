@@ -0,0 +1,215 @@
+package pbsql
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// filterParam prefixes the params of the values compared by the predicates of a filter message, followed by the
+// index of the field and of the value for lists
+const filterParam = "pbsql_filter_"
+
+// filterOps are the operators of the `filter_op` tag
+var filterOps = map[string]string{
+	"eq":     "=",
+	"ne":     "!=",
+	"gt":     ">",
+	"gte":    ">=",
+	"lt":     "<",
+	"lte":    "<=",
+	"like":   "LIKE",
+	"in":     "IN",
+	"not_in": "NOT IN",
+}
+
+// filterSuffixes are the suffixes of the names of filter fields implying an operator when they aren't tagged with
+// one, e.g. DateFrom compares the date column with >=
+var filterSuffixes = []struct{ suffix, op string }{
+	{"From", "gte"},
+	{"Min", "gte"},
+	{"To", "lte"},
+	{"Max", "lte"},
+}
+
+// WithFilter writes the predicates of reads and counts from `filter`, a filter message distinct from the entity
+// message whose columns are selected, e.g. a TaskFilter with date and amount ranges and id lists:
+//
+//	type TaskFilter struct {
+//		DateFrom  *timestamppb.Timestamp // task.date >= ?
+//		DateTo    *timestamppb.Timestamp // task.date <= ?
+//		AmountMin int64                  // task.amount >= ?
+//		Ids       []int64                // task.id IN (?, ?)
+//		Status    string `filter_col:"state" filter_op:"ne"`
+//	}
+//
+// Each field set in the filter compares the column of the entity field named by its `filter_col` or `db` tag, or by
+// its own name or its snake case, less a From, To, Min, or Max suffix and the plural s of a list. The operator is
+// given by the `filter_op` tag, one of eq, ne, gt, gte, lt, lte, like, in, and not_in, and otherwise implied by the
+// suffix: >= for From and Min, <= for To and Max, IN for lists, and = for anything else. Zero values and empty lists
+// are left out. Untagged fields which don't name an entity field, such as page tokens, are ignored, tagged ones fail
+// with ErrUnknownField. The predicates are ANDed with those of the entity message, which is usually left empty.
+func WithFilter(filter interface{}) Option {
+	return func(o *options) {
+		o.filter = filter
+	}
+}
+
+// BuildFilterQuery builds a select statement for the columns of `prototype`, an empty entity message, filtered by the
+// fields of the filter message `filter`, see WithFilter
+func BuildFilterQuery(target string, prototype interface{}, filter interface{}, opts ...Option) (string, []interface{}, error) {
+	return BuildReadQueryWithOptions(target, prototype, append(opts, WithFilter(filter))...)
+}
+
+// filterClauses returns the predicates written from the filter message of `o` on the columns of the entity `v`, and
+// adds the values they compare to the params of `o`
+func (o *options) filterClauses(target string, v reflect.Value) ([]string, error) {
+	if o.filter == nil {
+		return nil, nil
+	}
+	filter := reflect.Indirect(reflect.ValueOf(o.filter))
+	if filter.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("pbsql: filter of %s must be a struct, got %T", target, o.filter)
+	}
+	params := make(map[string]interface{}, len(o.params)+filter.NumField())
+	for name, value := range o.params {
+		params[name] = value
+	}
+	var clauses []string
+	for i := 0; i < filter.NumField(); i++ {
+		self := filter.Type().Field(i)
+		value := filter.Field(i)
+		if self.PkgPath != "" || self.Tag.Get("db") == "-" || value.IsZero() || isEmptySlice(value) {
+			continue
+		}
+		entity, op, err := filterField(v, target, self)
+		if err != nil {
+			return nil, err
+		}
+		if entity == nil {
+			continue
+		}
+		name := filterParam + strconv.Itoa(i)
+		if entity.isSensitive {
+			if o.sensitiveParams == nil {
+				o.sensitiveParams = make(map[string]bool)
+			}
+			o.sensitiveParams[name] = true
+		}
+		if op != "in" && op != "not_in" {
+			if params[name], err = o.filterArg(entity, value, op); err != nil {
+				return nil, err
+			}
+			clause := entity.column() + " " + filterOps[op] + " :" + name
+			if op == "like" && o.escapeLike {
+				clause += o.dialect.likeEscape()
+			}
+			clauses = append(clauses, clause)
+			continue
+		}
+		if value.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("pbsql: filter field %s compares %s with %s, it must be a list", self.Name, entity.name, op)
+		}
+		if err := o.budget.check(target, "MaxListItems", value.Len(), o.budget.MaxListItems); err != nil {
+			return nil, err
+		}
+		names := make([]string, value.Len())
+		for j := range names {
+			names[j] = name + "_" + strconv.Itoa(j)
+			if entity.isSensitive {
+				o.sensitiveParams[names[j]] = true
+			}
+			if params[names[j]], err = o.filterArg(entity, value.Index(j), op); err != nil {
+				return nil, err
+			}
+			names[j] = ":" + names[j]
+		}
+		clauses = append(clauses, entity.column()+" "+filterOps[op]+" ("+strings.Join(names, ", ")+")")
+	}
+	o.params = params
+	return clauses, nil
+}
+
+// filterField returns the field of the entity `v` compared by the filter field `self` and the operator comparing
+// them, a nil field if `self` follows no convention and should be ignored
+func filterField(v reflect.Value, target string, self reflect.StructField) (*field, string, error) {
+	column := self.Tag.Get("filter_col")
+	if column == "" {
+		column = self.Tag.Get("db")
+	}
+	tagged := column != "" || self.Tag.Get("filter_op") != ""
+	op := self.Tag.Get("filter_op")
+	name := self.Name
+	for _, implied := range filterSuffixes {
+		if trimmed := strings.TrimSuffix(name, implied.suffix); trimmed != name && trimmed != "" {
+			name = trimmed
+			if op == "" {
+				op = implied.op
+			}
+			break
+		}
+	}
+	if self.Type.Kind() == reflect.Slice && self.Type.Elem().Kind() != reflect.Uint8 {
+		name = strings.TrimSuffix(name, "s")
+		if op == "" {
+			op = "in"
+		}
+	}
+	if op == "" {
+		op = "eq"
+	}
+	if _, ok := filterOps[op]; !ok {
+		return nil, "", fmt.Errorf("pbsql: unknown filter_op %q on %s", op, self.Name)
+	}
+	names, err := normalizeMask(v.Type(), target, []string{column})
+	if column == "" {
+		// by convention the name of the filter field is the go name or column of the entity field
+		if names, err = normalizeMask(v.Type(), target, []string{name}); err != nil {
+			names, err = normalizeMask(v.Type(), target, []string{SnakeCase(name)})
+		}
+	}
+	if err != nil {
+		if tagged {
+			return nil, "", err
+		}
+		return nil, "", nil
+	}
+	entity, _ := v.Type().FieldByName(names[0])
+	field := parseReflection(v, entity.Index[0], target)
+	if field.name == "" || !field.isColumn {
+		return nil, "", fmt.Errorf("%w: filter field %s compares %s of %s", ErrUnmappedField, self.Name, names[0], target)
+	}
+	return field, op, nil
+}
+
+// filterArg returns the value bound for the filter value `v` compared with `entity` by `op` like filterArg, strings
+// sanitized and escaped for LIKE, see WithSanitizer and WithLikeEscape
+func (o *options) filterArg(entity *field, v reflect.Value, op string) (interface{}, error) {
+	arg, err := filterArg(entity.self, v)
+	if s, ok := arg.(string); ok && err == nil {
+		return o.sanitize(entity.name, s, op == "like")
+	}
+	return arg, err
+}
+
+// filterArg returns the value bound for the filter value `v` compared with the entity field `self`, encoded by the
+// Converter of the entity field if it has one
+func filterArg(self reflect.StructField, v reflect.Value) (interface{}, error) {
+	var value interface{}
+	switch {
+	case isTimestampMessage(v.Type()):
+		value = v.Interface().(*timestamppb.Timestamp).AsTime()
+	case isOptionalValue(v.Type()):
+		value = optionalArg(v)
+	default:
+		value = v.Interface()
+	}
+	c, err := converterOf(self)
+	if err != nil || c == nil {
+		return value, err
+	}
+	return c.Encode(value)
+}
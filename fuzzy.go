@@ -0,0 +1,32 @@
+package pbsql
+
+import "strconv"
+
+// WithFuzzySearch matches the phrase of BuildSearchQuery by similarity rather than LIKE, so that misspelled names are
+// still found:
+//
+//   - Postgres compares trigrams with the pg_trgm extension: `column % phrase`, true above the similarity threshold of
+//     the session (0.3 by default) and served by a trigram index, or `similarity(column, phrase) >= threshold` given a
+//     threshold above zero
+//   - MySQL and SQLite compare `SOUNDEX(column) = SOUNDEX(phrase)`, which SQLite only provides when built with
+//     SQLITE_SOUNDEX
+//
+// `%` wildcards around the phrase are left out. With WithSearchRank, Postgres ranks rows by their best similarity.
+func WithFuzzySearch(threshold float64) Option {
+	return func(o *options) {
+		o.fuzzy = true
+		o.fuzzyThreshold = threshold
+	}
+}
+
+// fuzzyMatch returns the predicate matching `column` to `value` by similarity, see WithFuzzySearch
+func (d Dialect) fuzzyMatch(column string, value string, threshold float64) string {
+	switch {
+	case d != Postgres:
+		return "SOUNDEX(" + column + ") = SOUNDEX(" + value + ")"
+	case threshold > 0:
+		return "similarity(" + column + ", " + value + ") >= " + strconv.FormatFloat(threshold, 'f', -1, 64)
+	default:
+		return column + " % " + value
+	}
+}
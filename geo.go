@@ -0,0 +1,181 @@
+package pbsql
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// roles of fields tagged `geo:"<role>:<point>"`
+const (
+	geoLat    = "lat"
+	geoLng    = "lng"
+	geoRadius = "radius"
+	geoBBox   = "bbox"
+)
+
+// earthRadius is the mean radius of the earth in meters, as used by the haversine formula
+const earthRadius = 6371000
+
+// geoPoint collects the fields tagged with the same point name
+type geoPoint struct {
+	name     string
+	lat, lng *field
+	// radius holds the maximum distance from the point in meters, zero if unset
+	radius float64
+	// bbox holds `min lat, min lng, max lat, max lng`, nil if unset
+	bbox []float64
+}
+
+// geoTag splits a `geo:"<role>:<point>"` tag, returning empty strings if the field isn't tagged
+func geoTag(self reflect.StructField) (role string, point string) {
+	tag := self.Tag.Get("geo")
+	if i := strings.IndexByte(tag, ':'); i > 0 {
+		return tag[:i], tag[i+1:]
+	}
+	return "", ""
+}
+
+// isGeoFilter reports whether a field is a radius or bounding box filter rather than a column
+func isGeoFilter(self reflect.StructField) bool {
+	role, _ := geoTag(self)
+	return role == geoRadius || role == geoBBox
+}
+
+// geoPoints returns every point of `v` which is filtered by radius or bounding box
+func geoPoints(v reflect.Value, target string) ([]*geoPoint, error) {
+	var points []*geoPoint
+	find := func(name string) *geoPoint {
+		for _, point := range points {
+			if point.name == name {
+				return point
+			}
+		}
+		point := &geoPoint{name: name}
+		points = append(points, point)
+		return point
+	}
+	for i := 0; i < v.NumField(); i++ {
+		field := parseReflection(v, i, target)
+		role, name := geoTag(field.self)
+		if role == "" || !field.value.CanInterface() {
+			continue
+		}
+		point := find(name)
+		switch role {
+		case geoLat:
+			point.lat = field
+		case geoLng:
+			point.lng = field
+		case geoRadius:
+			point.radius, _ = toFloat(field.value)
+		case geoBBox:
+			if field.value.Kind() != reflect.Slice || field.value.Len() == 0 {
+				continue
+			}
+			if field.value.Len() != 4 {
+				return nil, fmt.Errorf("pbsql: bounding box %s of %s must hold min lat, min lng, max lat, and max lng", field.self.Name, target)
+			}
+			point.bbox = make([]float64, 4)
+			for j := range point.bbox {
+				point.bbox[j], _ = toFloat(field.value.Index(j))
+			}
+		default:
+			return nil, fmt.Errorf("pbsql: unknown geo role %q on %s", role, field.self.Name)
+		}
+	}
+
+	filtered := points[:0]
+	for _, point := range points {
+		if point.radius <= 0 && point.bbox == nil {
+			continue
+		}
+		if point.lat == nil || point.lng == nil {
+			return nil, fmt.Errorf("pbsql: geo point %s of %s needs a lat and a lng field", point.name, target)
+		}
+		filtered = append(filtered, point)
+	}
+	return filtered, nil
+}
+
+// isGeoCenter reports whether `f` is the lat or lng of a point filtered by radius, whose value is the center of the
+// search rather than a predicate of its own
+func isGeoCenter(points []*geoPoint, f *field) bool {
+	for _, point := range points {
+		if point.radius > 0 && (point.lat.self.Name == f.self.Name || point.lng.self.Name == f.self.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeGeoPredicates writes the radius and bounding box predicates of every filtered point. Coordinates are written
+// as literals, which is safe since they are formatted from floats.
+func (qb *queryBuilder) writeGeoPredicates(points []*geoPoint) {
+	for _, point := range points {
+		lat := point.lat.table + "." + point.lat.name
+		lng := point.lng.table + "." + point.lng.name
+		if point.radius > 0 {
+			centerLat, _ := toFloat(point.lat.value)
+			centerLng, _ := toFloat(point.lng.value)
+			qb.writeGeoCondition(point, qb.dialect.geoDistance(lat, lng, centerLat, centerLng)+" <= "+formatFloat(point.radius))
+		}
+		if point.bbox != nil {
+			qb.writeGeoCondition(point, qb.dialect.geoWithin(lat, lng, point.bbox))
+		}
+	}
+}
+
+// writeGeoCondition writes a predicate on `point`, attributing it to the point rather than a single field
+func (qb *queryBuilder) writeGeoCondition(point *geoPoint, predicate string) {
+	qb.writeCondition(" AND " + predicate)
+	if qb.trace {
+		qb.clauses = append(qb.clauses, FieldClause{Field: point.name, Kind: ClauseWhere, Clause: predicate})
+	}
+}
+
+// geoDistance returns the expression for the distance in meters between a lat/lng column pair and a point
+func (d Dialect) geoDistance(lat, lng string, centerLat, centerLng float64) string {
+	if d == Postgres {
+		return fmt.Sprintf(
+			"ST_Distance(CAST(ST_SetSRID(ST_MakePoint(%s, %s), 4326) AS geography), CAST(ST_SetSRID(ST_MakePoint(%s, %s), 4326) AS geography))",
+			lng, lat, formatFloat(centerLng), formatFloat(centerLat),
+		)
+	}
+	return fmt.Sprintf(
+		"%d * 2 * ASIN(SQRT(POWER(SIN(RADIANS(%s - %s) / 2), 2) + COS(RADIANS(%s)) * COS(RADIANS(%s)) * POWER(SIN(RADIANS(%s - %s) / 2), 2)))",
+		earthRadius, lat, formatFloat(centerLat), formatFloat(centerLat), lat, lng, formatFloat(centerLng),
+	)
+}
+
+// geoWithin returns the predicate matching a lat/lng column pair inside a bounding box
+func (d Dialect) geoWithin(lat, lng string, bbox []float64) string {
+	if d == Postgres {
+		return fmt.Sprintf(
+			"ST_Within(ST_SetSRID(ST_MakePoint(%s, %s), 4326), ST_MakeEnvelope(%s, %s, %s, %s, 4326))",
+			lng, lat, formatFloat(bbox[1]), formatFloat(bbox[0]), formatFloat(bbox[3]), formatFloat(bbox[2]),
+		)
+	}
+	return fmt.Sprintf(
+		"%s BETWEEN %s AND %s AND %s BETWEEN %s AND %s",
+		lat, formatFloat(bbox[0]), formatFloat(bbox[2]), lng, formatFloat(bbox[1]), formatFloat(bbox[3]),
+	)
+}
+
+// toFloat returns the value of a numeric field as a float64
+func toFloat(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	}
+	return 0, false
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -1,22 +1,23 @@
 package pbsql
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
-const nullSelectField = "ifnull(%s.%s, %s) as %s, "
-const selectField = "%s.%s, "
-const selectFuncField = "ifnull(%s(%s.%s), %s) as %s, "
+const nullSelectField = "%s(%s.%s, %s) as %s"
+const selectFuncField = "%s(%s(%s.%s), %s) as %s"
+const exprSelectField = "%s as %s"
+const nullExprSelectField = "%s(%s, %s) as %s"
 const andPredicate = " AND %s.%s"
 const orPredicate = " OR %s.%s"
-const strComparison = " LIKE :%s"
-const notStrComparison = " NOT LIKE :%s"
 const valComparison = " = :%s"
 const notValComparison = " != :%s"
-const queryCore = "%sFROM %s%s%s"
 const isoDateFormat = "2006-01-02 15:04:05"
 var matchFirstCap = regexp.MustCompile("(.)([A-Z][a-z]+)")
 var matchAllCap   = regexp.MustCompile("([a-z0-9])([A-Z])")
@@ -34,11 +35,88 @@ type field struct {
 	selectFuncName string
 	dateTarget string
 	dateRange []string
-	selectFunc *selectFuncData
+	selectFunc selectFuncData
 	isMultiValue bool
+	isSensitive bool
+	isCreatedAt bool
+	isUpdatedAt bool
+	isReadonly bool
+	isWriteonly bool
+	isJSON bool
+	// isIndexed is set for fields tagged `indexed`, whose predicates are written before those of other fields
+	isIndexed bool
+	// isSearchable is set for fields tagged `searchable`, see BuildSearchQuery
+	isSearchable bool
+	// isConverted is set for fields tagged `convert`, `enum`, or `encrypt`, which are compared by equality even if they
+	// are strings
+	isConverted bool
+	// isEnum is set for fields tagged `enum`, whose zero value is unset
+	isEnum bool
+	// isCaseInsensitive is set for fields tagged `case_insensitive`, see WithCaseInsensitive
+	isCaseInsensitive bool
+	// array holds the mode of repeated fields tagged `array`, see arrayMode
+	array string
+	// isNegated is set for fields excluding the values of a column, see negatedColumn
+	isNegated bool
+	expr string
+	predicateGroup string
+	// isColumn is set for fields stored in a column of their own, i.e. tagged with `db` or named by the snake case
+	// fallback, as opposed to fields only used in predicates
+	isColumn bool
+	// insertDefault is the expression inserted while the field is unset, see the `insert_default` tag
+	insertDefault string
+	// hasPresence is set for fields of protobuf messages whose presence protoreflect tracks, see fieldPresence
+	hasPresence bool
+	// isPresent is set for such fields holding a value
+	isPresent bool
 	name string
 }
 
+// isSet reports whether the field holds a value worth writing
+func (f *field) isSet() bool {
+	if f.isJSON {
+		return !isEmptyJSON(f.value)
+	}
+	if f.array != "" {
+		return f.value.Len() > 0
+	}
+	return f.notDefault()
+}
+
+// notDefault reports whether the field holds a value other than the zero value of its type
+func (f *field) notDefault() bool {
+	if f.hasPresence {
+		return f.isPresent
+	}
+	if f.isEnum {
+		return !f.value.IsZero()
+	}
+	if isOptionalValue(f.self.Type) {
+		return !f.value.IsNil()
+	}
+	if isNullType(f.self.Type) {
+		return isNullSet(f.value)
+	}
+	if isBytes(f.self.Type) {
+		return f.value.Len() > 0
+	}
+	return notDefault(f.typeStr, f.value.Interface())
+}
+
+// isAutoTimestamp reports whether the field is managed by the builders rather than the caller
+func (f *field) isAutoTimestamp() bool {
+	return f.isCreatedAt || f.isUpdatedAt
+}
+
+// assignedBy reports whether an update with `fieldMask` assigns the field a value of the message, i.e. it is listed in
+// the mask or holds a value. Columns tagged `updated_at` are assigned the current time instead.
+func (f *field) assignedBy(fieldMask []string) bool {
+	if !f.value.CanInterface() || f.name == "" || f.isPrimaryKey || f.isCreatedAt || f.isReadonly || !f.permits(permUpdate) {
+		return false
+	}
+	return findInMask(fieldMask, f.self.Name) && !f.shouldIgnore || f.isSet()
+}
+
 type selectFuncData struct {
 	ok bool
 	name string
@@ -47,20 +125,27 @@ type selectFuncData struct {
 
 
 func parseReflection(val reflect.Value, i int, target string) *field {
-	self := val.Type().Field(i)
+	self := structField(val.Type(), i)
 	value := val.Field(i)
-	name := self.Tag.Get("db")
-	if name == "" {
+	name := columnOf(val.Type(), self)
+	array := arrayMode(self)
+	negated, isNegated := negatedColumn(val.Type(), self)
+	if isNegated {
+		name = negated
+	}
+	isColumn := name != "" && array != arrayIn && !isNegated
+	if name == "" && self.Tag.Get("db") != "-" {
 		name = self.Tag.Get("name")
 	}
 	foreignKey := self.Tag.Get("foreign_key")
 
 	selectFuncName := self.Tag.Get("select_func")
-	selectFunc := &selectFuncData{
+	selectFunc := selectFuncData{
 		ok: selectFuncName != "",
 		name: selectFuncName,
 		argName: self.Tag.Get("func_arg_name"),
 	}
+	hasPresence, isPresent := fieldPresence(val, self)
 
 
 	return &field{
@@ -68,24 +153,133 @@ func parseReflection(val reflect.Value, i int, target string) *field {
 		table: target,
 		self: self,
 		typeStr: value.Type().Name(),
-		isNullable: self.Tag.Get("nullable") == "y",
+		isNullable: self.Tag.Get("nullable") == "y" || isNullType(self.Type),
 		isPrimaryKey: self.Tag.Get("primary_key") != "",
 		shouldIgnore: self.Tag.Get("ignore") != "",
 		hasForeignKey: foreignKey != "",
 		isMultiValue: self.Tag.Get("multi_value") != "",
+		isSensitive: self.Tag.Get("sensitive") == "y",
+		isCreatedAt: self.Tag.Get("created_at") == "auto",
+		isUpdatedAt: self.Tag.Get("updated_at") == "auto",
+		isReadonly: self.Tag.Get("readonly") == "y" || self.Tag.Get("expr") != "" || array == arrayIn || isNegated,
+		isWriteonly: self.Tag.Get("writeonly") == "y" || array == arrayIn || isNegated || !permits(self, permRead),
+		expr: self.Tag.Get("expr"),
+		isJSON: isJSONColumn(self),
+		array: array,
+		isNegated: isNegated,
+		isConverted: hasConverter(self),
+		isEnum: self.Tag.Get("enum") != "",
+		isCaseInsensitive: self.Tag.Get("case_insensitive") == "y",
+		isIndexed: self.Tag.Get("indexed") == "y",
+		isSearchable: self.Tag.Get("searchable") == "y",
+		predicateGroup: self.Tag.Get("predicate_group"),
+		isColumn: isColumn,
+		insertDefault: strings.ReplaceAll(self.Tag.Get("insert_default"), ":", "::"),
+		hasPresence: hasPresence,
+		isPresent: isPresent,
 		selectFunc: selectFunc,
 		name: name,
 	}
 }
 
+// controlFields are read by name by the builders and never hold a column
+var controlFields = map[string]bool{"GroupBy": true, "OrderBy": true, "OrderDir": true, "DateRange": true, "DateTarget": true}
+
+var snakeCaseFallback atomic.Bool
+
+// SetSnakeCaseFallback derives the column name of fields without a `db` tag from their name, e.g. `GeoLat` is stored
+// in `geo_lat`, which saves tagging every field of large messages. Fields tagged `db:"-"` are always left out, as are
+// fields which aren't strings, numbers, bools, or bytes and fields the builders read by name such as `OrderBy`. It
+// applies to every Builder, and should be called once during initialization, before any queries are built.
+//
+// sqlx scans rows by `db` tag, so an Executor scanning into such messages needs a matching mapper, e.g.
+// `db.Mapper = reflectx.NewMapperFunc("db", pbsql.SnakeCase)`.
+func SetSnakeCaseFallback(enabled bool) {
+	snakeCaseFallback.Store(enabled)
+}
+
+// SnakeCase converts a go field name to the column name used by the snake case fallback, e.g. `PropertyID` becomes
+// `property_id`
+func SnakeCase(name string) string {
+	return toSnakeCase(name)
+}
+
+// columnName returns the column storing a struct field, or an empty string if it isn't stored in a column
+func columnName(self reflect.StructField) string {
+	name := self.Tag.Get("db")
+	if name == "-" {
+		return ""
+	}
+	if name != "" || !snakeCaseFallback.Load() || self.PkgPath != "" || controlFields[self.Name] || strings.HasPrefix(self.Name, "XXX_") || isGeoFilter(self) || self.Tag.Get("within") != "" {
+		return name
+	}
+	if self.Tag.Get("name") != "" || self.Tag.Get("foreign_key") != "" {
+		return ""
+	}
+	switch self.Type.Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return toSnakeCase(self.Name)
+	case reflect.Slice:
+		if self.Type.Elem().Kind() == reflect.Uint8 {
+			return toSnakeCase(self.Name)
+		}
+	case reflect.Ptr:
+		if isOptionalValue(self.Type) {
+			return toSnakeCase(self.Name)
+		}
+	case reflect.Struct:
+		if isNullType(self.Type) {
+			return toSnakeCase(self.Name)
+		}
+	}
+	return ""
+}
+
 /** Field Tags
 * __________________
 * Standard Group    |
-* db                | corresponding database property name 
+* db                | corresponding database property name, `-` to leave the field out
 * nullable          | y \ n if the field could be a null value
+* null_default      | value replacing null values of nullable fields rather than the zero value, e.g. -1 or 1970-01-01
 * primary_key       | y \ n if the field is the primary key of a table
 * ignore            | y \ n if the field should be ignored (edge case)
-* date_target       | default date field to use for date range searches
+* date_target       | default date field to use for date range searches, or the column bounded by a range pair
+*                   | such as DateRangeStart / DateRangeEnd or CreatedFrom / CreatedTo, see rangePairs
+* within            | `<column>[,<unit>]` on a number or duration field reading the rows whose column is within
+*                   | that long before now, e.g. `within:"created_at"` on WithinDays, see withinClause
+* sensitive         | y \ n if the field holds PII that must be redacted from query logs
+* created_at        | auto if the column is set to the current time on insert
+* updated_at        | auto if the column is set to the current time on insert and update
+* perm              | operations the field takes part in, any of c (create), r (read), and u (update), e.g. `cr`
+*                   | for insert-only columns and `r` for read-only ones, every operation if the tag is missing
+* readonly          | y \ n if the column is selected but never inserted or updated, e.g. computed columns
+* writeonly         | y \ n if the column is inserted and updated but never selected, e.g. password hashes
+* json_column       | y \ n if a map or message field is stored as JSON, reads filter by containment
+* indexed           | y \ n if the column is indexed, its predicate is written first in read and count queries
+* mask              | name of a registered mask, e.g. ssn \ last4 \ email \ redact, selected in place of the
+*                   | column by reads WithMasking, see RegisterMask and MaskPolicy
+* encrypt           | aes \ aes,deterministic on fields encrypted in their column, see SetKeyProvider
+* convert           | name of a registered Converter translating the field to and from its column, e.g. money or uuid
+* array             | column \ in for repeated fields on Postgres, stored in an array column or filtering a column by IN
+* expr              | SQL expression selected in place of the column, aliased to the db name, implies readonly
+* geo               | `lat:<point>` or `lng:<point>` on coordinate columns, `radius:<point>` (meters) or
+*                   | `bbox:<point>` (min lat, min lng, max lat, max lng) on fields filtering reads by location
+* predicate_group   | fields sharing a group are ORed together, e.g. `AND (name = ? OR email = ?)`
+* negate            | y \ n if the field excludes the values of its column, e.g. `NOT LIKE`, `!=`, or `NOT IN`, the
+*                   | field is never selected or written. Untagged fields named `<Field>Not` negate `<Field>`.
+* case_insensitive  | y \ n if LIKE predicates on the field ignore case, see WithCaseInsensitive
+* searchable        | y \ n if BuildSearchQuery matches the phrase against the field, by default every string field
+*                   | is matched unless one of the fields of the message is tagged searchable
+* search_weight     | weight of matches of the phrase in the field, 1 by default, see WithSearchRank
+* partition_by      | y \ n if the column partitions the rows of which WithFirstPerPartition reads the first
+* rank_order        | asc \ desc on columns ranking the rows of each partition, see WithFirstPerPartition
+* pk_gen            | uuid \ uuidv7 on string or bytes primary keys generated by creates while unset
+* insert_default    | SQL expression inserted while the field is unset, e.g. `uuid()`, `now()`, or `DEFAULT`
+* idempotency_key   | y \ n if the unique column tells a retried create apart from a new one, see CreateIdempotent
+* filter_col        | on filter messages, the entity field or column compared by the field, see WithFilter
+* filter_op         | on filter messages, eq \ ne \ gt \ gte \ lt \ lte \ like \ in \ not_in, see WithFilter
 * __________________|
 * Foreign Key Group |
 * foreign_key       | corresponding database property name on the foreign entity table
@@ -99,54 +293,309 @@ func parseReflection(val reflect.Value, i int, target string) *field {
 **/
 
 type queryBuilder struct {
-	Core strings.Builder
-	Joins strings.Builder
-	Fields strings.Builder
-	Predicate strings.Builder
-	Columns strings.Builder
-	Values strings.Builder
+	dialect Dialect
+	Core bytes.Buffer
+	Predicate bytes.Buffer
+
+	// selects, joins, and conditions hold the select list, the joins, and the AND predicates of a read, which are
+	// only joined into a statement once every field has been written, so skipped fields never leave a separator
+	// behind. They are exposed as a SelectQuery.
+	selects []string
+	joins []string
+	conditions []string
+	// columns and values hold the columns of an insert and the values written to them
+	columns []string
+	values []string
+	// assignments holds the `column = value` entries of the SET clause of an update
+	assignments []string
+
+	// groups holds the predicates of fields tagged `predicate_group`, in order of first appearance
+	groups []predicateGroup
+	// clauses records the clauses written for each field, see DebugReadQuery
+	clauses []FieldClause
+	// hoisted counts the conditions on indexed fields at the front of conditions, see hoistCondition
+	hoisted int
+	// trace enables recording clauses
+	trace bool
+	// caseInsensitive folds the case of every LIKE predicate, see WithCaseInsensitive
+	caseInsensitive bool
+	// escapeLike writes LIKE predicates with an ESCAPE clause, see WithLikeEscape
+	escapeLike bool
+	// masking selects the masked values of fields tagged `mask`, see WithMasking
+	masking bool
+	// nullDefaults replace null values of fields by name, see WithNullDefault
+	nullDefaults map[string]string
+	// likes records the fields matched by LIKE, whose values are sanitized by options.likeParams
+	likes []*field
+	// listItems is the largest number of values of a list predicate, see Budget
+	listItems int
+	// lifecycleField names the field holding the lifecycle column, which is left out of predicates, see Lifecycle
+	lifecycleField string
+	// openGroup is set while the next predicate is the first of a parenthesized OR group
+	openGroup bool
+}
+
+// maxPooledBuffer caps the capacity of buffers returned to the pool, so a single huge statement isn't held onto
+const maxPooledBuffer = 64 << 10
+
+var queryBuilders = sync.Pool{New: func() interface{} { return new(queryBuilder) }}
+
+// newQueryBuilder returns an empty queryBuilder from the pool, release it once its result has been read
+func newQueryBuilder(d Dialect) *queryBuilder {
+	qb := queryBuilders.Get().(*queryBuilder)
+	qb.dialect = d
+	return qb
 }
 
-func (qb *queryBuilder) writeSelectField(f *field) {
-	if f.isNullable {
-		fmt.Fprintf(&qb.Fields, nullSelectField, f.table, f.name, getDefault(f.typeStr, f.name), f.name)
+// release resets the builder and returns it to the pool. The recorded slices may be referenced by a SelectQuery,
+// so they are dropped rather than reused.
+func (qb *queryBuilder) release() {
+	if qb.Core.Cap() > maxPooledBuffer || qb.Predicate.Cap() > maxPooledBuffer {
+		return
+	}
+	qb.Core.Reset()
+	qb.Predicate.Reset()
+	qb.selects, qb.joins, qb.conditions, qb.groups, qb.clauses, qb.likes = nil, nil, nil, nil, nil, nil
+	qb.columns, qb.values, qb.assignments = qb.columns[:0], qb.values[:0], qb.assignments[:0]
+	qb.hoisted, qb.listItems, qb.trace, qb.caseInsensitive, qb.escapeLike, qb.openGroup = 0, 0, false, false, false, false
+	qb.masking, qb.nullDefaults = false, nil
+	qb.lifecycleField = ""
+	queryBuilders.Put(qb)
+}
+
+// openORGroup starts a parenthesized group of OR predicates, e.g. the phrase matches of a search
+func (qb *queryBuilder) openORGroup() {
+	qb.Predicate.WriteString(" AND (")
+	qb.openGroup = true
+}
+
+type predicateGroup struct {
+	name string
+	predicates []string
+}
+
+func (qb *queryBuilder) writeSelectField(f *field) error {
+	if f.isWriteonly {
+		return nil
+	}
+	if masked, ok := qb.maskedSelect(f); ok {
+		qb.writeSelect(f, masked)
+		return nil
+	}
+	if f.isJSON || f.array != "" {
+		qb.writeSelect(f, f.column())
+		return nil
+	}
+	if !f.coalesces() {
+		if f.expr != "" {
+			qb.writeSelect(f, fmt.Sprintf(exprSelectField, f.namedExpr(), f.name))
+		} else {
+			qb.writeSelect(f, f.column())
+		}
+		return nil
+	}
+	literal, err := qb.defaultLiteral(f)
+	if err != nil {
+		return err
+	}
+	if f.expr != "" {
+		qb.writeSelect(f, fmt.Sprintf(nullExprSelectField, qb.dialect.ifNull(), f.namedExpr(), literal, f.name))
 	} else {
-		fmt.Fprintf(&qb.Fields, selectField, f.table, f.name)
+		qb.writeSelect(f, fmt.Sprintf(nullSelectField, qb.dialect.ifNull(), f.table, f.name, literal, f.name))
+	}
+	return nil
+}
+
+// coalesces reports whether null values of the field are replaced by a default in the select list. Optional bools
+// and nullable wrappers such as sql.NullString scan NULL themselves.
+func (f *field) coalesces() bool {
+	return f.isNullable && !isOptionalValue(f.self.Type) && !isNullType(f.self.Type)
+}
+
+// column returns the qualified column of the field, e.g. `user.id`
+func (f *field) column() string {
+	return f.table + "." + f.name
+}
+
+// namedExpr returns the `expr` of a field with its colons escaped, so casts such as `::text` survive binding
+func (f *field) namedExpr() string {
+	return strings.ReplaceAll(f.expr, ":", "::")
+}
+
+// predicateTarget formats the left hand side of a predicate on `f`, which is the parenthesized expression of fields
+// tagged `expr` rather than their column
+func (f *field) predicateTarget(predicateStr string) string {
+	predicate := fmt.Sprintf(predicateStr, f.table, f.name)
+	if f.expr == "" {
+		return predicate
 	}
+	return strings.TrimSuffix(predicate, f.table+"."+f.name) + "(" + f.namedExpr() + ")"
+}
+
+// writeSelect appends a formatted select list entry for `f`
+func (qb *queryBuilder) writeSelect(f *field, entry string) {
+	qb.selects = append(qb.selects, entry)
+	qb.attribute(f, ClauseSelect, entry)
+}
+
+// writeValue appends a column and its value to an insert statement
+func (qb *queryBuilder) writeValue(column, value string) {
+	qb.columns = append(qb.columns, column)
+	qb.values = append(qb.values, value)
 }
 
-func (qb *queryBuilder) writeSelectFunc(f *field) {
-	fmt.Fprintf(&qb.Fields, selectFuncField, f.selectFunc.name, f.table, f.selectFunc.argName, getDefault(f.typeStr, f.name), f.name)
+// insertStatement renders the insert statement of the columns and values written to `target`
+func (qb *queryBuilder) insertStatement(target string) string {
+	return "INSERT INTO " + target + " (" + strings.Join(qb.columns, ", ") + ") VALUES (" + strings.Join(qb.values, ", ") + ")"
+}
+
+// writeAssignment appends `column = value` to the SET clause of an update statement
+func (qb *queryBuilder) writeAssignment(column, value string) {
+	qb.assignments = append(qb.assignments, column+" = "+value)
+}
+
+// writeCondition appends a formatted predicate, e.g. ` AND user.id = :id`, recording it as a condition of the
+// select query when it is joined with AND
+func (qb *queryBuilder) writeCondition(predicate string) {
+	if qb.openGroup {
+		predicate = strings.TrimPrefix(predicate, " OR ")
+		qb.openGroup = false
+	}
+	qb.Predicate.WriteString(predicate)
+	if strings.HasPrefix(predicate, " AND ") {
+		qb.conditions = append(qb.conditions, strings.TrimPrefix(predicate, " AND "))
+	}
+}
+
+// writeSelectList writes every selectable field of `v` permitted by the field mask to the select list
+func (qb *queryBuilder) writeSelectList(v reflect.Value, target string, o *options) error {
+	qb.masking, qb.nullDefaults = o.masking, o.nullDefaults
+	for i := 0; i < v.NumField(); i++ {
+		field := parseReflection(v, i, target)
+		if field.name == "" || !o.selects(field) {
+			continue
+		}
+		var err error
+		if field.selectFunc.ok {
+			err = qb.writeSelectFunc(field)
+		} else if !field.shouldIgnore {
+			err = qb.writeSelectField(field)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectList returns the select list
+func (qb *queryBuilder) selectList() string {
+	return strings.Join(qb.selects, ", ")
+}
+
+func (qb *queryBuilder) writeSelectFunc(f *field) error {
+	if f.isWriteonly {
+		return nil
+	}
+	literal, err := qb.defaultLiteral(f)
+	if err != nil {
+		return err
+	}
+	qb.writeSelect(f, fmt.Sprintf(selectFuncField, qb.dialect.ifNull(), f.selectFunc.name, f.table, f.selectFunc.argName, literal, f.name))
+	return nil
 }
 
 func (qb *queryBuilder) writePredicate(f *field, fieldMask []string, predicateStr string) {
-	if notDefault(f.typeStr, f.value.Interface()) || findInMask(fieldMask, f.self.Name) {
-		fmt.Fprintf(&qb.Predicate, predicateStr, f.table, f.name)
+	if qb.lifecycleField != "" && f.self.Name == qb.lifecycleField {
+		return
+	}
+	if f.isNegated {
+		qb.writeNotPredicate(f, fieldMask, predicateStr)
+		return
+	}
+	if f.isJSON {
+		if !isEmptyJSON(f.value) {
+			column := f.table + "." + f.name
+			predicate := strings.TrimSuffix(f.predicateTarget(predicateStr), column) + qb.dialect.jsonContains(column, f.name)
+			qb.writeGroupedCondition(f, predicate, predicateStr)
+		}
+		return
+	}
+	if f.array != "" {
+		qb.writeArrayPredicate(f, predicateStr, false)
+		return
+	}
+	if f.notDefault() || findInMask(fieldMask, f.self.Name) {
+		predicate := f.predicateTarget(predicateStr)
 		if f.isMultiValue && !f.value.IsZero() {
-			fmt.Fprintf(&qb.Predicate, " IN (%s)", f.value)
+			qb.countListItems(f)
+			predicate += fmt.Sprintf(" IN (%s)", f.value)
 		} else {
-		if f.typeStr == "string" {
-			fmt.Fprintf(&qb.Predicate, strComparison, f.name)
+		if f.typeStr == "string" && !f.isConverted {
+			predicate = qb.likePredicate(f, predicateStr, false)
 		} else {
-			fmt.Fprintf(&qb.Predicate,  valComparison, f.name)
+			predicate += fmt.Sprintf(valComparison, f.name)
 		}
 	}
+		qb.writeGroupedCondition(f, predicate, predicateStr)
 	}
 }
 
 func (qb *queryBuilder) writeNotPredicate(f *field, fieldMask []string, predicateStr string) {
-	if notDefault(f.typeStr, f.value.Interface()) || findInMask(fieldMask, f.self.Name) {
-		fmt.Fprintf(&qb.Predicate, predicateStr, f.table, f.name)
+	if qb.lifecycleField != "" && f.self.Name == qb.lifecycleField {
+		return
+	}
+	if f.array != "" {
+		qb.writeArrayPredicate(f, predicateStr, true)
+		return
+	}
+	if f.notDefault() || findInMask(fieldMask, f.self.Name) {
+		predicate := f.predicateTarget(predicateStr)
 		if f.isMultiValue {
-			fmt.Fprintf(&qb.Predicate, " NOT IN (%s)", f.value)
+			qb.countListItems(f)
+			predicate += fmt.Sprintf(" NOT IN (%s)", f.value)
 		} else {
-		if f.typeStr == "string" {
-			fmt.Fprintf(&qb.Predicate, notStrComparison, f.name)
+		if f.typeStr == "string" && !f.isConverted {
+			predicate = qb.likePredicate(f, predicateStr, true)
 		} else {
-			fmt.Fprintf(&qb.Predicate,  notValComparison, f.name)
+			predicate += fmt.Sprintf(notValComparison, f.param())
 		}
 	}
+		qb.writeGroupedCondition(f, predicate, predicateStr)
+	}
+}
+
+// writeGroupedCondition holds back the AND predicate of a field tagged `predicate_group` until writePredicateGroups
+func (qb *queryBuilder) writeGroupedCondition(f *field, predicate string, predicateStr string) {
+	qb.attribute(f, ClauseWhere, strings.TrimPrefix(strings.TrimPrefix(predicate, " AND "), " OR "))
+	if f.isIndexed && f.predicateGroup == "" && predicateStr == andPredicate {
+		qb.hoistCondition(predicate)
+		return
+	}
+	if f.predicateGroup == "" || predicateStr != andPredicate {
+		qb.writeCondition(predicate)
+		return
+	}
+	predicate = strings.TrimPrefix(predicate, " AND ")
+	for i := range qb.groups {
+		if qb.groups[i].name == f.predicateGroup {
+			qb.groups[i].predicates = append(qb.groups[i].predicates, predicate)
+			return
+		}
+	}
+	qb.groups = append(qb.groups, predicateGroup{name: f.predicateGroup, predicates: []string{predicate}})
+}
+
+// writePredicateGroups writes every predicate group held back by writeGroupedCondition as `AND (a OR b)`
+func (qb *queryBuilder) writePredicateGroups() {
+	for _, group := range qb.groups {
+		if len(group.predicates) == 1 {
+			qb.writeCondition(" AND " + group.predicates[0])
+		} else {
+			qb.writeCondition(" AND (" + strings.Join(group.predicates, " OR ") + ")")
+		}
 	}
+	qb.groups = nil
 }
 
 /*
@@ -159,67 +608,131 @@ func (qb *queryBuilder) writeAndPredicate(f *field, fieldMask []string) {
 }*/
 
 func (qb *queryBuilder) getReadResult(table string, v *reflect.Value) string {
-	fmt.Fprintf(&qb.Core, queryCore, qb.Fields.String(), table, qb.Joins.String(), qb.Predicate.String())
-	qb.handleGroupBy(v)
-	qb.handleOrder(v)
-	return strings.Replace(strings.Replace(qb.Core.String(), ", FROM", " FROM", 1), "( OR", "(", 1)
+	qb.Core.WriteString(qb.selectList())
+	qb.Core.WriteString(" FROM ")
+	qb.Core.WriteString(table)
+	for _, join := range qb.joins {
+		qb.Core.WriteString(" " + join)
+	}
+	qb.Core.Write(qb.Predicate.Bytes())
+	if groupBy := groupByOf(v); groupBy != "" {
+		qb.Core.WriteString(" group by ")
+		qb.Core.WriteString(groupBy)
+	}
+	if orderBy := orderByOf(v); orderBy != "" {
+		qb.Core.WriteString(" order by ")
+		qb.Core.WriteString(orderBy)
+	}
+	return qb.Core.String()
 }
 
 func (qb *queryBuilder) getUpdateResult() string {
-	qb.Core.WriteString(qb.Predicate.String())
-	return strings.Replace(qb.Core.String(), ", WHERE", " WHERE", 1)	
+	qb.Core.WriteString(strings.Join(qb.assignments, ", "))
+	if qb.Predicate.Len() > 0 {
+		qb.Core.WriteString(" ")
+		qb.Core.Write(qb.Predicate.Bytes())
+	}
+	return qb.Core.String()
 }
 
-func (qb *queryBuilder) handleGroupBy(v *reflect.Value) {
+// groupByOf returns the value of the message's `GroupBy` field, if any
+func groupByOf(v *reflect.Value) string {
 	groupBy := v.FieldByName("GroupBy")
 	if groupBy.CanAddr() && groupBy.String() != "" {
-		groupStr := fmt.Sprintf(" group by %s", groupBy.String())
-		fmt.Fprintf(&qb.Core, groupStr)
+		return groupBy.String()
 	}
+	return ""
 }
 
-func (qb *queryBuilder) handleOrder(v *reflect.Value) {
+// orderByOf returns the order by expression of the message's `OrderBy` and `OrderDir` fields, falling back to
+// those of the first related message
+func orderByOf(v *reflect.Value) string {
 	orderBy := v.FieldByName("OrderBy")
 	orderDir := v.FieldByName("OrderDir")
 	
 	if orderBy.CanAddr() && orderBy.String() != "" {
-		orderStr := fmt.Sprintf(" order by %s", orderBy.String())
+		orderStr := orderBy.String()
 		if orderDir.CanAddr() && orderDir.String() != "" {
 			orderStr = fmt.Sprintf("%s %s", orderStr, orderDir.String())
 		} else {
 			orderStr = fmt.Sprintf("%s asc", orderStr)
 		}
-		fmt.Fprint(&qb.Core, orderStr)
-	} else {
-		for i := 0; i < v.NumField(); i++ {
-			field := parseReflection(*v, i, "")
-			if field.hasForeignKey {
-				foreignKey := field.self.Tag.Get("foreign_key")
-				foreignTable := field.self.Tag.Get("foreign_table")
-				localName := field.self.Tag.Get("local_name")
-			
-				if localName == "" {
-					localName = foreignKey
-				}
-			
-				related := reflect.Indirect(field.value)
-				if related.CanAddr() && foreignKey != "" && foreignTable != "" {
-					orderBy := related.FieldByName("OrderBy")
-					orderDir := related.FieldByName("OrderDir")
-					if orderBy.CanAddr() && orderBy.String() != "" && orderBy.CanInterface() {
-						orderStr := fmt.Sprintf(" order by %s.%s", foreignTable, orderBy.String())
-						if orderDir.CanAddr() && orderDir.String() != "" {
-							orderStr = fmt.Sprintf("%s %s", orderStr, orderDir.String())
-						} else {
-							orderStr = fmt.Sprintf("%s asc", orderStr)
-						}
-						fmt.Fprint(&qb.Core, orderStr)
+		return orderStr
+	}
+	for i := 0; i < v.NumField(); i++ {
+		field := parseReflection(*v, i, "")
+		if field.hasForeignKey {
+			foreignKey := field.self.Tag.Get("foreign_key")
+			foreignTable := field.self.Tag.Get("foreign_table")
+
+			related := reflect.Indirect(field.value)
+			if related.Kind() == reflect.Struct && related.CanAddr() && foreignKey != "" && foreignTable != "" {
+				orderBy := related.FieldByName("OrderBy")
+				orderDir := related.FieldByName("OrderDir")
+				if orderBy.CanAddr() && orderBy.String() != "" && orderBy.CanInterface() {
+					orderStr := fmt.Sprintf("%s.%s", foreignTable, orderBy.String())
+					if orderDir.CanAddr() && orderDir.String() != "" {
+						orderStr = fmt.Sprintf("%s %s", orderStr, orderDir.String())
+					} else {
+						orderStr = fmt.Sprintf("%s asc", orderStr)
 					}
-					return
+					return orderStr
 				}
+				return ""
 			}
 		}
 	}
+	return ""
+}
+
+// primaryKeys returns every field of `v` tagged as `primary_key`, in declaration order
+func primaryKeys(v reflect.Value, target string) []*field {
+	var keys []*field
+	for i := 0; i < v.NumField(); i++ {
+		field := parseReflection(v, i, target)
+		if field.isPrimaryKey && field.name != "" {
+			keys = append(keys, field)
+		}
+	}
+	return keys
+}
+
+// setGeneratedKey stores a database generated id in the primary key of `source`, if it has a single unset integer key
+func setGeneratedKey(source interface{}, target string, id int64) {
+	keys := primaryKeys(reflect.ValueOf(source).Elem(), target)
+	if len(keys) != 1 || !keys[0].value.CanSet() {
+		return
+	}
+	key := keys[0].value
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if key.Int() == 0 {
+			key.SetInt(id)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if key.Uint() == 0 && id > 0 {
+			key.SetUint(uint64(id))
+		}
+	}
+}
+
+// keyPredicate returns a where clause matching every key column, or an empty string if there are none
+func keyPredicate(keys []*field) string {
+	var builder strings.Builder
+	for i, key := range keys {
+		if i == 0 {
+			builder.WriteString("WHERE ")
+		} else {
+			builder.WriteString(" AND ")
+		}
+		fmt.Fprintf(&builder, "%s.%s = :%s", key.table, key.name, key.name)
+	}
+	return builder.String()
+}
+
+// isBytes reports whether `t` is a []byte, the type of proto bytes fields stored in BLOB or bytea columns
+func isBytes(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
 }
 
 func isEmptySlice(v reflect.Value) bool {
@@ -245,14 +758,13 @@ func (qb *queryBuilder) handleDateRange(target string, t *reflect.Value) {
 
 				if dateTarget != "" {
 					for i := 0; i < dateRange.Len(); i = i + 2 {
-						fmt.Fprintf(
-							&qb.Predicate,
+						qb.writeCondition(fmt.Sprintf(
 							" AND %s.%s %s '%v'",
 							target,
 							dateTarget,
 							dateRange.Index(i),
 							dateRange.Index(i + 1),
-						)
+						))
 					}
 				}
 			} else {
@@ -263,14 +775,13 @@ func (qb *queryBuilder) handleDateRange(target string, t *reflect.Value) {
 						if len(dateTargetSlice) == 2 && i != 0 {
 							j = 1
 						}
-						fmt.Fprintf(
-							&qb.Predicate,
+						qb.writeCondition(fmt.Sprintf(
 							" AND %s.%s %s '%v'",
 							target,
 							dateTargetSlice[j],
 							dateRange.Index(i),
 							dateRange.Index(i + 1),
-						)
+						))
 					}
 				}
 			}
@@ -288,28 +799,36 @@ func (qb *queryBuilder) handleForeignKey(f *field) {
 	}
 
 	related := reflect.Indirect(f.value)
+	if related.Kind() != reflect.Struct {
+		return
+	}
 	if related.CanAddr() && foreignKey != "" && foreignTable != "" {
 		for j := 0; j < related.NumField(); j++ {
 			field := parseReflection(related, j, foreignTable)
 			
 			if field.name != "" && field.value.CanInterface() && notDefault(field.typeStr, field.value.Interface()) {
-				fmt.Fprintf(&qb.Predicate, " AND %s.%s", field.table, field.name)
+				predicate := fmt.Sprintf(" AND %s.%s", field.table, field.name)
 				if field.typeStr == "string" {
-					fmt.Fprintf(&qb.Predicate, " LIKE '%s'", field.value)
+					predicate = " AND " + qb.dialect.like(field.column(), fmt.Sprintf("'%s'", field.value), false, qb.foldsCase(field))
 				} else {
-					fmt.Fprintf(&qb.Predicate, " = %v", field.value)
+					predicate += fmt.Sprintf(" = %v", field.value)
+				}
+				qb.writeCondition(predicate)
+				if qb.trace {
+					qb.clauses = append(qb.clauses, FieldClause{Field: f.self.Name + "." + field.self.Name, Column: field.name, Kind: ClauseWhere, Clause: strings.TrimPrefix(predicate, " AND ")})
 				}
 			}
 		}
-		fmt.Fprintf(
-			&qb.Joins,
-			" LEFT JOIN %s on %s.%s = %s.%s",
+		join := fmt.Sprintf(
+			"LEFT JOIN %s on %s.%s = %s.%s",
 			foreignTable,
 			foreignTable,
 			foreignKey, 
 			f.table, 
 			localName,
 		)
+		qb.joins = append(qb.joins, join)
+		qb.attribute(f, ClauseJoin, join)
 	}
 	if  related.IsValid() {
 		qb.handleDateRange(foreignTable, &related)
@@ -359,6 +878,11 @@ func notDefault(typeName string, fieldVal interface{}) bool {
 	case "bool":
 		return fieldVal.(bool)
 	default:
+		// named numeric types, e.g. `type Celsius float32`, are told apart by their kind
+		v := reflect.ValueOf(fieldVal)
+		if isIntegerKind(v.Kind()) || v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64 {
+			return !v.IsZero()
+		}
 		return false
 	}
 }
@@ -0,0 +1,79 @@
+package pbsql
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// IndexHint names an index the planner should use for a read or count query, see WithIndexHint
+type IndexHint struct {
+	Index string
+	// Force emits FORCE INDEX rather than USE INDEX on MySQL, other dialects don't distinguish the two
+	Force bool
+}
+
+// WithIndexHint asks the planner to use `index` when reading from the target table: `USE INDEX (index)` on MySQL,
+// `INDEXED BY index` on SQLite, and a `/*+ IndexScan(table index) */` comment read by pg_hint_plan on Postgres.
+func WithIndexHint(index string) Option {
+	return func(o *options) {
+		o.indexHint = &IndexHint{Index: index}
+	}
+}
+
+// WithForceIndex behaves like WithIndexHint but emits `FORCE INDEX (index)` on MySQL
+func WithForceIndex(index string) Option {
+	return func(o *options) {
+		o.indexHint = &IndexHint{Index: index, Force: true}
+	}
+}
+
+var indexName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*$`)
+
+// validate rejects index names which aren't plain identifiers, since they are written into the statement as is
+func (h *IndexHint) validate() error {
+	if h != nil && !indexName.MatchString(h.Index) {
+		return fmt.Errorf("%w: %q is not a valid index name", ErrUnsafePredicate, h.Index)
+	}
+	return nil
+}
+
+// indexHint returns the comment written before a statement on `table` and the hint written after the table name
+func (d Dialect) indexHint(table string, h *IndexHint) (prefix, suffix string) {
+	if h == nil {
+		return "", ""
+	}
+	switch d {
+	case Postgres:
+		return fmt.Sprintf("/*+ IndexScan(%s %s) */ ", table, h.Index), ""
+	case SQLite:
+		return "", " INDEXED BY " + h.Index
+	default:
+		if h.Force {
+			return "", fmt.Sprintf(" FORCE INDEX (%s)", h.Index)
+		}
+		return "", fmt.Sprintf(" USE INDEX (%s)", h.Index)
+	}
+}
+
+// hoistCondition writes an AND predicate on an indexed column ahead of the predicates on other columns, so the
+// planner sees the selective ones first
+func (qb *queryBuilder) hoistCondition(predicate string) {
+	qb.Predicate.WriteString(predicate)
+	qb.conditions = append(qb.conditions, "")
+	copy(qb.conditions[qb.hoisted+1:], qb.conditions[qb.hoisted:])
+	qb.conditions[qb.hoisted] = predicate[len(" AND "):]
+	qb.hoisted++
+}
+
+// orderedPredicate rewrites the WHERE clause from the ordered conditions. Only builders whose predicates are all
+// ANDed use it, i.e. count queries.
+func (qb *queryBuilder) orderedPredicate(where string) {
+	if qb.hoisted == 0 {
+		return
+	}
+	qb.Predicate.Reset()
+	qb.Predicate.WriteString(where)
+	for _, condition := range qb.conditions {
+		qb.Predicate.WriteString(" AND " + condition)
+	}
+}
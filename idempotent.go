@@ -0,0 +1,126 @@
+package pbsql
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// idempotencyKeys returns the fields of `v` tagged `idempotency_key`, the unique columns telling a retried create
+// apart from a new one
+func idempotencyKeys(v reflect.Value, target string) []*field {
+	var keys []*field
+	for i := 0; i < v.NumField(); i++ {
+		field := parseReflection(v, i, target)
+		if field.name != "" && field.self.Tag.Get("idempotency_key") != "" {
+			keys = append(keys, field)
+		}
+	}
+	return keys
+}
+
+// BuildIdempotentCreateQuery builds an insert like BuildCreateQuery which does nothing when a row with the same
+// idempotency key already exists, e.g. for a client retrying a payment with the same request id:
+//
+//	RequestId string `db:"request_id" idempotency_key:"y"`
+//	// INSERT INTO payment (request_id, amount) VALUES (?, ?) ON CONFLICT (request_id) DO NOTHING
+//
+// The fields tagged `idempotency_key` must be set and make up a unique index of the table, ErrMissingIdempotencyKey
+// is returned otherwise. MySQL uses INSERT IGNORE, which also turns other errors of the row, such as truncated
+// values, into warnings.
+func BuildIdempotentCreateQuery(target string, source interface{}, opts ...Option) (string, []interface{}, error) {
+	o := newOptions(opts)
+	target = o.table(target, source)
+	qry, err := idempotentCreateQuery(target, source, o)
+	if err != nil {
+		return "", nil, err
+	}
+	return o.bind(qry, source)
+}
+
+// idempotentCreateQuery returns the named insert statement bound by BuildIdempotentCreateQuery
+func idempotentCreateQuery(target string, source interface{}, o *options) (string, error) {
+	keys, err := setIdempotencyKeys(reflect.ValueOf(source).Elem(), target)
+	if err != nil {
+		return "", err
+	}
+	qry, err := createQuery(target, source, o)
+	if err != nil {
+		return "", err
+	}
+	if o.dialect == MySQL {
+		return "INSERT IGNORE" + strings.TrimPrefix(qry, "INSERT"), nil
+	}
+	columns := make([]string, len(keys))
+	for i, key := range keys {
+		columns[i] = key.name
+	}
+	return qry + " ON CONFLICT (" + strings.Join(columns, ", ") + ") DO NOTHING", nil
+}
+
+// setIdempotencyKeys returns the idempotency keys of `v`, which must all be set
+func setIdempotencyKeys(v reflect.Value, target string) ([]*field, error) {
+	keys := idempotencyKeys(v, target)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: %s has no field tagged idempotency_key", ErrMissingIdempotencyKey, target)
+	}
+	for _, key := range keys {
+		if !key.isSet() {
+			return nil, fmt.Errorf("%w: %s of %s is unset", ErrMissingIdempotencyKey, key.self.Name, target)
+		}
+	}
+	return keys, nil
+}
+
+// idempotentReadQuery returns the named select statement reading back the row holding the idempotency key of
+// `source`, whether it was just created or already existed
+func idempotentReadQuery(target string, source interface{}, o *options) (string, error) {
+	v := reflect.ValueOf(source).Elem()
+	keys, err := setIdempotencyKeys(v, target)
+	if err != nil {
+		return "", err
+	}
+	return readByFieldsQuery(target, v, keys, o)
+}
+
+// CreateIdempotent inserts `source` with BuildIdempotentCreateQuery and reads the row holding its idempotency key
+// back into it, in a single transaction. It reports whether the row was created, or already existed in which case
+// `source` now holds the stored row rather than the values it was given, so a retried request returns the result of
+// the first one.
+func (e *Executor) CreateIdempotent(ctx context.Context, target string, source interface{}, opts ...Option) (created bool, err error) {
+	if err := beforeCreate(ctx, source); err != nil {
+		return false, err
+	}
+	e = e.route(target, source, OpCreate, opts...)
+	if opts, err = e.secure(ctx, target, OpCreate, source, opts); err != nil {
+		return false, err
+	}
+	o := e.options(opts)
+	target = o.table(target, source)
+	if err := e.validate(ctx, target, OpCreate, source, nil); err != nil {
+		return false, err
+	}
+	// the row is read back whatever its lifecycle
+	o.scope = All
+	view := o.readRelation(target, source)
+	err = e.inTx(ctx, func(tx *Executor) error {
+		res, err := tx.execBuilt(ctx, target, OpCreate, func() (string, interface{}, error) {
+			qry, err := idempotentCreateQuery(target, source, o)
+			return qry, o.bindSource(source), err
+		})
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		created = n > 0
+		return tx.getBuilt(ctx, target, source, func() (string, interface{}, error) {
+			qry, err := idempotentReadQuery(view, source, o)
+			return qry, o.bindSource(source), err
+		})
+	})
+	return created, err
+}
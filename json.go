@@ -0,0 +1,79 @@
+package pbsql
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// isJSONColumn reports whether a field is stored as JSON, i.e. tagged `json_column:"y"`. Hand written structs may
+// use `json:"y"` instead, which doesn't work for generated messages since protoc-gen-go already sets a json tag.
+func isJSONColumn(self reflect.StructField) bool {
+	return self.Tag.Get("json_column") == "y" || self.Tag.Get("json") == "y"
+}
+
+// isEmptyJSON reports whether a JSON field holds nothing worth writing or filtering by
+func isEmptyJSON(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+// encodeJSON marshals the value of a JSON field, using protojson for messages. Nil values are bound as NULL.
+func encodeJSON(v reflect.Value) (interface{}, error) {
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Map || v.Kind() == reflect.Slice || v.Kind() == reflect.Interface) && v.IsNil() {
+		return nil, nil
+	}
+	var b []byte
+	var err error
+	if msg, ok := v.Interface().(proto.Message); ok {
+		b, err = protojson.Marshal(msg)
+	} else {
+		b, err = json.Marshal(v.Interface())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pbsql: encoding json column: %w", err)
+	}
+	return string(b), nil
+}
+
+// decodeJSON unmarshals a JSON column into the field `v`, leaving it unset if the column is null
+func decodeJSON(data []byte, v reflect.Value) error {
+	if data == nil || string(data) == "null" {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		v.Set(reflect.New(v.Type().Elem()))
+	}
+	var err error
+	if msg, ok := v.Interface().(proto.Message); ok && v.Kind() == reflect.Ptr {
+		err = protojson.Unmarshal(data, msg)
+	} else {
+		err = json.Unmarshal(data, v.Addr().Interface())
+	}
+	if err != nil {
+		return fmt.Errorf("pbsql: decoding json column: %w", err)
+	}
+	return nil
+}
+
+// jsonContains returns the predicate matching rows whose JSON column contains the document bound to `param`. SQLite
+// has no containment operator, so the documents must be equal there.
+func (d Dialect) jsonContains(column, param string) string {
+	switch d {
+	case Postgres:
+		return fmt.Sprintf("%s @> CAST(:%s AS jsonb)", column, param)
+	case SQLite:
+		return fmt.Sprintf("json(%s) = json(:%s)", column, param)
+	default:
+		return fmt.Sprintf("JSON_CONTAINS(%s, :%s)", column, param)
+	}
+}
@@ -0,0 +1,115 @@
+package pbsql
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Values of the `pk_gen` tag
+const (
+	// keyUUID generates random version 4 uuids
+	keyUUID = "uuid"
+	// keyUUIDv7 generates version 7 uuids, which start with the time they were generated at so that consecutive keys
+	// are inserted next to each other in the index
+	keyUUIDv7 = "uuidv7"
+)
+
+// IDGenerator generates the keys of fields tagged `pk_gen`. Tests inject a deterministic one with WithIDGenerator or
+// Config.IDGenerator to assert the exact args of an insert.
+type IDGenerator interface {
+	// NewID returns the 16 bytes of a new uuid of `kind`, the value of the pk_gen tag: "uuid" or "uuidv7"
+	NewID(kind string) ([16]byte, error)
+}
+
+// IDGeneratorFunc adapts a func to an IDGenerator
+type IDGeneratorFunc func(kind string) ([16]byte, error)
+
+// NewID returns f(kind)
+func (f IDGeneratorFunc) NewID(kind string) ([16]byte, error) {
+	return f(kind)
+}
+
+// WithIDGenerator makes creates generate the keys tagged `pk_gen` with `gen` rather than random uuids
+func WithIDGenerator(gen IDGenerator) Option {
+	return func(o *options) {
+		o.ids = gen
+	}
+}
+
+// idGenerator returns the IDGenerator of the options, by default one generating random uuids whose version 7
+// timestamps are read from the clock of the options
+func (o *options) idGenerator() IDGenerator {
+	if o.ids != nil {
+		return o.ids
+	}
+	return uuidGenerator{clock: o.now}
+}
+
+// uuidGenerator generates random version 4 and version 7 uuids
+type uuidGenerator struct {
+	clock func() time.Time
+}
+
+func (g uuidGenerator) NewID(kind string) ([16]byte, error) {
+	var id [16]byte
+	switch kind {
+	case keyUUID:
+		if _, err := rand.Read(id[:]); err != nil {
+			return id, err
+		}
+		id[6] = id[6]&0x0f | 0x40
+	case keyUUIDv7:
+		var ms [8]byte
+		binary.BigEndian.PutUint64(ms[:], uint64(g.clock().UnixMilli()))
+		copy(id[:6], ms[2:])
+		if _, err := rand.Read(id[6:]); err != nil {
+			return id, err
+		}
+		id[6] = id[6]&0x0f | 0x70
+	default:
+		return id, fmt.Errorf("pbsql: unknown pk_gen %q", kind)
+	}
+	id[8] = id[8]&0x3f | 0x80
+	return id, nil
+}
+
+// generateKeys sets every unset primary key of `v` tagged `pk_gen` to a new uuid of `gen`, so the key is bound by the
+// insert and known to the caller without RETURNING or LastInsertId. Keys are strings holding the canonical form of
+// the uuid, or byte slices holding its 16 bytes.
+func generateKeys(v reflect.Value, target string, gen IDGenerator) error {
+	for _, key := range primaryKeys(v, target) {
+		kind := key.self.Tag.Get("pk_gen")
+		set := key.isSet()
+		if key.value.Kind() == reflect.Slice {
+			set = key.value.Len() > 0
+		}
+		if kind == "" || set {
+			continue
+		}
+		if !key.value.CanSet() {
+			return fmt.Errorf("pbsql: cannot generate the key %s of %s, it can't be set", key.self.Name, target)
+		}
+		if kind != keyUUID && kind != keyUUIDv7 {
+			return fmt.Errorf("pbsql: unknown pk_gen %q on %s of %s", kind, key.self.Name, target)
+		}
+		id, err := gen.NewID(kind)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case key.value.Kind() == reflect.String:
+			h := hex.EncodeToString(id[:])
+			key.value.SetString(h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:])
+		case key.value.Kind() == reflect.Slice && key.value.Type().Elem().Kind() == reflect.Uint8:
+			key.value.SetBytes(id[:])
+		default:
+			return fmt.Errorf("pbsql: cannot generate a uuid for %s of %s, it is a %s", key.self.Name, target, key.value.Type())
+		}
+	}
+	return nil
+}
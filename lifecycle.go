@@ -0,0 +1,109 @@
+package pbsql
+
+import (
+	"reflect"
+)
+
+// Lifecycle configures the column recording whether a row is active, e.g. `is_active`, for messages holding a field
+// named Field. Unlike a SoftDelete, which leaves the field an ordinary filter, the field of a lifecycle column is
+// left out of the predicates built from a message:
+//
+//   - reads, counts, searches, and Get only see active rows unless given WithScope
+//   - deletes mark rows inactive instead of removing them, superseding Config.SoftDelete
+//   - creates and updates write the field like any other, so a row is restored by updating it to Active
+//
+// Filtering by the field is ambiguous otherwise: setting IsActive to 1 to list active rows reads the same as a
+// message whose IsActive was never set.
+type Lifecycle struct {
+	Field  string
+	Column string
+	// Active and Inactive are the values of the column for active and deleted rows, nil for 1 and 0
+	Active   interface{}
+	Inactive interface{}
+}
+
+// Scope selects the rows of a read by their lifecycle column, see Lifecycle
+type Scope int
+
+// Scopes of reads, ActiveOnly is the default
+const (
+	ActiveOnly Scope = iota
+	InactiveOnly
+	All
+)
+
+// WithScope selects the rows read by their lifecycle column, e.g. WithScope(InactiveOnly) lists deleted rows. It
+// has no effect on messages without the field of the configured Lifecycle.
+func WithScope(s Scope) Option {
+	return func(o *options) {
+		o.scope = s
+	}
+}
+
+// lifecycleParam is the named param the value of the lifecycle column selected by a scope is bound to
+const lifecycleParam = "pbsql_lifecycle"
+
+// lifecycleField returns the name of the field of `t` holding the lifecycle column, empty if there is none
+func (o *options) lifecycleField(t reflect.Type) string {
+	l := o.lifecycle
+	if l.Field == "" || l.Column == "" {
+		return ""
+	}
+	if _, ok := t.FieldByName(l.Field); !ok {
+		return ""
+	}
+	return l.Field
+}
+
+// scopeClause returns the predicate restricting a read of `t` to the rows of the scope, e.g. `task.is_active =
+// :pbsql_lifecycle`, adding the value of the column to the params of `o`. It is empty for All, and for messages
+// without a lifecycle column.
+func (o *options) scopeClause(target string, t reflect.Type) string {
+	if o.scope == All || o.lifecycleField(t) == "" {
+		return ""
+	}
+	value := o.lifecycle.active()
+	if o.scope == InactiveOnly {
+		value = o.lifecycle.inactive()
+	}
+	if o.params == nil {
+		o.params = make(map[string]interface{})
+	}
+	o.params[lifecycleParam] = value
+	return target + "." + o.lifecycle.Column + " = :" + lifecycleParam
+}
+
+// withScope returns `where` followed by the scope clause of a read of `t`, if any
+func (o *options) withScope(where []string, target string, t reflect.Type) []string {
+	clause := o.scopeClause(target, t)
+	if clause == "" {
+		return where
+	}
+	return append(append([]string(nil), where...), clause)
+}
+
+// softDeletion returns the soft delete policy of deletes of `t`: the one registered for it with TableMetadata, that
+// of the lifecycle column if one is configured, or that of the config
+func (o *options) softDeletion(t reflect.Type) SoftDelete {
+	if s, ok := softDeleteOf(t); ok {
+		return s
+	}
+	if o.lifecycle.Field == "" || o.lifecycle.Column == "" {
+		return o.softDelete
+	}
+	return SoftDelete{Field: o.lifecycle.Field, Column: o.lifecycle.Column, Value: o.lifecycle.inactive()}
+}
+
+func (l Lifecycle) active() interface{} {
+	if l.Active == nil {
+		return 1
+	}
+	return l.Active
+}
+
+func (l Lifecycle) inactive() interface{} {
+	if l.Inactive == nil {
+		return 0
+	}
+	return l.Inactive
+}
@@ -0,0 +1,93 @@
+package pbsql
+
+import (
+	"strings"
+	"unicode"
+)
+
+// likeEscaper escapes the metacharacters of LIKE patterns with a backslash, the backslash itself included
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// EscapeLike returns `s` with the LIKE metacharacters `%` and `_` and the escape character `\` escaped by a
+// backslash, so that a LIKE predicate with `ESCAPE '\'` matches `s` literally
+func EscapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// WithLikeEscape matches the string fields of read, count, and search queries literally rather than as LIKE
+// patterns, for values taken from user input: `%` and `_` are escaped in the bound args and the predicates are
+// written with an ESCAPE clause, e.g. `user.name LIKE ? ESCAPE '\'`. MySQL escapes with a backslash by default and
+// gets no clause. The phrase of BuildSearchQuery is matched anywhere in the searched columns, as if surrounded by
+// `%`. The `like` operator of filter messages, see WithFilter, is escaped too.
+func WithLikeEscape() Option {
+	return func(o *options) {
+		o.escapeLike = true
+	}
+}
+
+// Sanitizer checks or rewrites the string `value` compared with `column` by a predicate before it is bound. An error
+// fails the build of the statement, e.g. to reject input a fuzzer produced rather than send it to the database.
+type Sanitizer func(column string, value string) (string, error)
+
+// WithSanitizer passes the values of string predicates through `s` before they are bound: the string fields of read,
+// count, and search queries, the phrase of BuildSearchQuery, whose column is empty, and the string values of filter
+// messages. Values are sanitized before they are escaped by WithLikeEscape.
+func WithSanitizer(s Sanitizer) Option {
+	return func(o *options) {
+		o.sanitizer = s
+	}
+}
+
+// SanitizeText is a Sanitizer removing what databases reject in text columns, or store but rarely mean: invalid
+// UTF-8 is replaced by U+FFFD and control characters other than tabs and line breaks are dropped, NUL included,
+// which Postgres refuses in text values
+func SanitizeText(_ string, value string) (string, error) {
+	value = strings.ToValidUTF8(value, "�")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r' {
+			return -1
+		}
+		return r
+	}, value), nil
+}
+
+// likeEscape returns the ESCAPE clause following LIKE predicates of values escaped by EscapeLike
+func (d Dialect) likeEscape() string {
+	if d == MySQL {
+		return ""
+	}
+	return ` ESCAPE '\'`
+}
+
+// sanitize returns `value` compared with `column` as it should be bound, sanitized and escaped if `like` is set
+func (o *options) sanitize(column string, value string, like bool) (string, error) {
+	if o.sanitizer != nil {
+		var err error
+		if value, err = o.sanitizer(column, value); err != nil {
+			return "", err
+		}
+	}
+	if like && o.escapeLike {
+		value = EscapeLike(value)
+	}
+	return value, nil
+}
+
+// likeParams adds the values of the LIKE predicates written by `qb` to the params of `o`, sanitized and escaped,
+// so that they are bound in place of the fields they were written for
+func (o *options) likeParams(qb *queryBuilder) error {
+	if o.sanitizer == nil && !o.escapeLike {
+		return nil
+	}
+	for _, f := range qb.likes {
+		value, err := o.sanitize(f.name, f.value.String(), true)
+		if err != nil {
+			return err
+		}
+		if o.params == nil {
+			o.params = make(map[string]interface{})
+		}
+		o.params[f.param()] = value
+	}
+	return nil
+}
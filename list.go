@@ -0,0 +1,168 @@
+package pbsql
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Page sizes of Executor.List, following AIP-158: a size of zero selects the default and larger sizes are capped
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 1000
+)
+
+// WithLimit restricts a read query to `limit` rows after skipping `offset`, zero leaves either out. Reads without an
+// order are ordered by primary key so that consecutive pages don't overlap.
+func WithLimit(limit int, offset int) Option {
+	return func(o *options) {
+		o.limit = limit
+		o.offset = offset
+	}
+}
+
+// Limits are guardrails on the number of rows returned by read queries, protecting the database from list calls
+// which accidentally read whole tables:
+//
+//	pbsql.SetConfig(pbsql.Config{
+//		Dialect: pbsql.Postgres,
+//		Limits:  pbsql.Limits{Default: 100, Max: 1000, Large: []string{"event", "audit.log"}},
+//	})
+//
+// They apply to the queries of BuildReadQueryWithOptions, BuildSelectQuery, Executor.Read, Executor.List, and
+// Executor.ListStream, not to reads of a single row by key.
+type Limits struct {
+	// Default is the LIMIT of reads which aren't given one with WithLimit, zero leaves them unlimited
+	Default int
+	// Max caps the LIMIT of every read, larger limits are lowered to it and reads without one are limited to it. Zero
+	// leaves the limit uncapped.
+	Max int
+	// Large lists the tables whose reads must be paginated: reads of them which aren't given a limit with WithLimit fail
+	// with ErrUnboundedRead rather than fall back to the Default. Tables listed without a schema match in any schema.
+	Large []string
+}
+
+// limit returns the LIMIT of a read of `target` given `limit` with WithLimit, zero for none
+func (l Limits) limit(target string, limit int) (int, error) {
+	if limit <= 0 {
+		_, table := splitTable(target)
+		for _, large := range l.Large {
+			if large == target || large == table {
+				return 0, fmt.Errorf("%w: %s must be read with a limit", ErrUnboundedRead, target)
+			}
+		}
+		limit = l.Default
+	}
+	if l.Max > 0 && (limit <= 0 || limit > l.Max) {
+		limit = l.Max
+	}
+	return limit, nil
+}
+
+// Page describes the page of rows read by Executor.List, matching the fields of an AIP-158 list response
+type Page struct {
+	// TotalCount is the number of rows matching the filter across every page
+	TotalCount int64
+	// NextPageToken reads the following page when passed back to List, empty on the last page
+	NextPageToken string
+}
+
+// List reads one page of the rows matching `source` into `dest` along with the total number of matching rows, for
+// list endpoints following AIP-158:
+//
+//	page, err := exec.List(ctx, "task", req.Filter, &res.Tasks, int(req.PageSize), req.PageToken)
+//	res.TotalSize, res.NextPageToken = int32(page.TotalCount), page.NextPageToken
+//
+// `pageToken` is empty for the first page, or the NextPageToken of the previous one. A token is only valid for the
+// filter it was issued for, ErrInvalidPageToken is returned for tokens which are malformed or were issued for a
+// different one.
+func (e *Executor) List(ctx context.Context, target string, source interface{}, dest interface{}, pageSize int, pageToken string, opts ...Option) (Page, error) {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	} else if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+	total, err := e.count(ctx, target, source, nil, opts)
+	if err != nil {
+		return Page{}, err
+	}
+
+	e = e.route(target, source, OpRead, opts...)
+	if opts, err = e.secure(ctx, target, OpRead, source, opts); err != nil {
+		return Page{}, err
+	}
+	o := e.options(opts)
+	target = o.table(target, source)
+	fingerprint, err := listFingerprint(target, source, o)
+	if err != nil {
+		return Page{}, err
+	}
+	offset, err := decodePageToken(pageToken, fingerprint)
+	if err != nil {
+		return Page{}, err
+	}
+	o.limit, o.offset = pageSize, offset
+	if err := e.read(ctx, target, source, dest, o); err != nil {
+		return Page{}, err
+	}
+	if err := e.preload(ctx, dest, o); err != nil {
+		return Page{}, err
+	}
+
+	page := Page{TotalCount: total}
+	if next := offset + reflect.Indirect(reflect.ValueOf(dest)).Len(); int64(next) < total {
+		page.NextPageToken = encodePageToken(next, fingerprint)
+	}
+	return page, nil
+}
+
+// listFingerprint identifies the filter of a list, so that page tokens can't be used with another one
+func listFingerprint(target string, source interface{}, o *options) (string, error) {
+	// the size of pages may change from one to the next, the fingerprint is that of an unlimited read
+	unlimited := *o
+	unlimited.limits = Limits{}
+	qry, err := readQuery(target, source, &unlimited)
+	if err != nil {
+		return "", err
+	}
+	_, args, err := SQLBinder.Bind(qry, o.bindSource(source), MySQL)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(qry))
+	for _, arg := range args {
+		fmt.Fprintf(h, "\x00%T:%v", arg, arg)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8]), nil
+}
+
+// encodePageToken returns an opaque token holding the offset of the next page and the fingerprint of the list
+func encodePageToken(offset int, fingerprint string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset) + ":" + fingerprint))
+}
+
+// decodePageToken returns the offset held by a page token, zero for the empty token of the first page
+func decodePageToken(token string, fingerprint string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, fmt.Errorf("%w: malformed token", ErrInvalidPageToken)
+	}
+	offset, issuedFor, ok := strings.Cut(string(raw), ":")
+	if !ok || issuedFor != fingerprint {
+		return 0, fmt.Errorf("%w: the token was issued for another filter", ErrInvalidPageToken)
+	}
+	n, err := strconv.Atoi(offset)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: malformed token", ErrInvalidPageToken)
+	}
+	return n, nil
+}
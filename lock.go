@@ -0,0 +1,77 @@
+package pbsql
+
+import (
+	"fmt"
+)
+
+// Lock selects the row lock taken by a read query, see WithLock. Modes are combined with `|`, e.g.
+// ForUpdate|SkipLocked.
+type Lock uint8
+
+// Lock modes
+const (
+	// ForUpdate locks the rows read against updates and other locks until the transaction ends
+	ForUpdate Lock = 1 << iota
+	// ForShare locks the rows read against updates, other transactions may still share the lock
+	ForShare
+	// SkipLocked leaves out rows locked by another transaction instead of waiting for them
+	SkipLocked
+	// NoWait fails the statement instead of waiting for rows locked by another transaction
+	NoWait
+)
+
+// WithLock locks the rows read by a read query until the end of the transaction it runs in, e.g. to claim a job of
+// a queue table without another worker picking it as well:
+//
+//	err := exec.InTx(ctx, func(tx *pbsql.Executor) error {
+//		jobs := []*pb.Job{}
+//		err := tx.Read(ctx, "job", &pb.Job{Status: "queued"}, &jobs,
+//			pbsql.WithLimit(1, 0), pbsql.WithLock(pbsql.ForUpdate|pbsql.SkipLocked))
+//		...
+//	})
+//
+// The clause is `FOR UPDATE` or `FOR SHARE` followed by `SKIP LOCKED` or `NOWAIT` on Postgres and MySQL 8, restricted
+// to the target table with `OF` when the query joins other tables. SQLite has no row locks, its writers are
+// serialized, so the clause is left out. Locked reads go to the primary and bypass the Executor's ResultCache.
+func WithLock(lock Lock) Option {
+	return func(o *options) {
+		o.lock = lock
+	}
+}
+
+// validate rejects modes that can't be combined
+func (l Lock) validate() error {
+	switch {
+	case l == 0:
+		return nil
+	case l&ForUpdate != 0 && l&ForShare != 0:
+		return fmt.Errorf("pbsql: lock can't be both FOR UPDATE and FOR SHARE")
+	case l&(ForUpdate|ForShare) == 0:
+		return fmt.Errorf("pbsql: SKIP LOCKED and NOWAIT require FOR UPDATE or FOR SHARE")
+	case l&SkipLocked != 0 && l&NoWait != 0:
+		return fmt.Errorf("pbsql: lock can't be both SKIP LOCKED and NOWAIT")
+	}
+	return nil
+}
+
+// lockClause returns the locking clause ending a read of `table`, empty if there is none
+func (d Dialect) lockClause(l Lock, table string, joined bool) string {
+	if l == 0 || d == SQLite {
+		return ""
+	}
+	clause := " FOR UPDATE"
+	if l&ForShare != 0 {
+		clause = " FOR SHARE"
+	}
+	// Postgres can't lock the nullable side of an outer join
+	if joined {
+		clause += " OF " + table
+	}
+	switch {
+	case l&SkipLocked != 0:
+		clause += " SKIP LOCKED"
+	case l&NoWait != 0:
+		clause += " NOWAIT"
+	}
+	return clause
+}
@@ -0,0 +1,79 @@
+package pbsql
+
+import (
+	"context"
+	"reflect"
+)
+
+// RedactedArg replaces the value of args bound from fields tagged `sensitive:"y"` in a LogEntry
+const RedactedArg = "[REDACTED]"
+
+// Logger can be plugged into an Executor with WithLogger to log every statement it runs
+type Logger interface {
+	LogQuery(ctx context.Context, entry LogEntry)
+}
+
+// LogEntry describes a statement run by an Executor
+type LogEntry struct {
+	QueryInfo
+	// Args holds the bound args in placeholder order, with sensitive values replaced by RedactedArg
+	Args []interface{}
+	Err  error
+}
+
+// LoggerFunc adapts an ordinary function to the Logger interface
+type LoggerFunc func(ctx context.Context, entry LogEntry)
+
+// LogQuery calls f(ctx, entry)
+func (f LoggerFunc) LogQuery(ctx context.Context, entry LogEntry) {
+	f(ctx, entry)
+}
+
+// redactArgs returns a copy of `args` with every arg bound to a sensitive field of `source` replaced by RedactedArg.
+// `named` is the named query the args were bound from, which maps each arg back to its param.
+func redactArgs(source interface{}, named string, args []interface{}) []interface{} {
+	sensitive := sensitiveParams(source)
+	redacted := make([]interface{}, len(args))
+	copy(redacted, args)
+	if len(sensitive) == 0 {
+		return redacted
+	}
+	for i, name := range ParamNames(named) {
+		if i < len(redacted) && sensitive[name] {
+			redacted[i] = RedactedArg
+		}
+	}
+	return redacted
+}
+
+// sensitiveParams returns the names of the params bound to sensitive fields of `source` or to the fields negating
+// them, under every name the binder resolves to them, and those of the filter values compared with sensitive fields
+func sensitiveParams(source interface{}) map[string]bool {
+	sensitive := make(map[string]bool)
+	if p, ok := source.(paramSource); ok {
+		for name := range p.sensitive {
+			sensitive[name] = true
+		}
+		source = p.source
+	}
+	v := reflect.Indirect(reflect.ValueOf(source))
+	if v.Kind() != reflect.Struct {
+		return sensitive
+	}
+	columns := make(map[string]bool)
+	for i := 0; i < v.NumField(); i++ {
+		if field := parseReflection(v, i, ""); field.isSensitive && field.name != "" {
+			columns[field.name] = true
+		}
+	}
+	if len(columns) == 0 {
+		return sensitive
+	}
+	for name, i := range fieldIndex(v.Type()) {
+		column, _ := negatedColumn(v.Type(), structField(v.Type(), i))
+		if parseReflection(v, i, "").isSensitive || columns[column] {
+			sensitive[name] = true
+		}
+	}
+	return sensitive
+}
@@ -0,0 +1,354 @@
+package pbsql
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// association describes a repeated field tagged `m2m:"junction,local_column,remote_column"`, whose messages are
+// stored in the table named by its `foreign_table` tag and linked to the owning message through a junction table
+// holding the primary key of each side, e.g.
+//
+//	Roles []*Role `m2m:"user_role,user_id,role_id" foreign_table:"role"`
+type association struct {
+	field    int
+	junction string
+	local    string
+	remote   string
+	table    string
+	// owner is the index of the primary key field of the owning message
+	owner int
+	// key is the index of the primary key field of the related message and column its column
+	key    int
+	column string
+	elem   reflect.Type
+}
+
+// associationOf returns the association stored in the field `name` of `t`, nil if the field isn't tagged `m2m`
+func associationOf(t reflect.Type, name string) (*association, error) {
+	self, ok := structFieldByName(t, name)
+	if !ok || self.Tag.Get("m2m") == "" {
+		return nil, nil
+	}
+	parts := strings.Split(self.Tag.Get("m2m"), ",")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("pbsql: m2m tag of %s must name the junction table, local column, and remote column", name)
+	}
+	a := &association{
+		field:    self.Index[0],
+		junction: strings.TrimSpace(parts[0]),
+		local:    strings.TrimSpace(parts[1]),
+		remote:   strings.TrimSpace(parts[2]),
+		table:    self.Tag.Get("foreign_table"),
+	}
+	if a.table == "" {
+		return nil, fmt.Errorf("pbsql: m2m field %s must name the related table with foreign_table", name)
+	}
+	if self.Type.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("pbsql: m2m field %s must be repeated", name)
+	}
+	elem := self.Type.Elem()
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("pbsql: m2m field %s must hold messages", name)
+	}
+	a.elem = elem
+
+	var err error
+	if a.owner, _, err = singleKey(t); err != nil {
+		return nil, err
+	}
+	if a.key, a.column, err = singleKey(elem); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// singleKey returns the index and column of the single primary key field of `t`
+func singleKey(t reflect.Type) (int, string, error) {
+	index, column := -1, ""
+	for i := 0; i < t.NumField(); i++ {
+		self := structField(t, i)
+		if self.Tag.Get("primary_key") == "" || columnOf(t, self) == "" {
+			continue
+		}
+		if index >= 0 {
+			return 0, "", fmt.Errorf("pbsql: m2m requires a single primary key, %s has several", t)
+		}
+		index, column = i, columnOf(t, self)
+	}
+	if index < 0 {
+		return 0, "", fmt.Errorf("%w: %s", ErrMissingPrimaryKey, t)
+	}
+	return index, column, nil
+}
+
+// keys returns the non zero primary keys of the related messages held by `v`, the value of the field
+func (a *association) keys(v reflect.Value) []interface{} {
+	var keys []interface{}
+	for i := 0; i < v.Len(); i++ {
+		elem := reflect.Indirect(v.Index(i))
+		if !elem.IsValid() || elem.Field(a.key).IsZero() {
+			continue
+		}
+		keys = append(keys, elem.Field(a.key).Interface())
+	}
+	return keys
+}
+
+// BuildAssociationQueries returns the statements reconciling the junction table of the m2m field `field` of
+// `source` with the related messages it holds, given the primary keys of the messages `current`ly associated with
+// it: a delete of the rows for messages no longer held and an insert of the rows for new ones. Either is left out if
+// it has nothing to do. Executor.SyncAssociation reads the current keys and runs the statements in a transaction.
+func BuildAssociationQueries(target string, source interface{}, field string, current []interface{}, opts ...Option) ([]Statement, error) {
+	o := newOptions(opts)
+	v := reflect.ValueOf(source).Elem()
+	a, err := associationOf(v.Type(), field)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil {
+		return nil, fmt.Errorf("pbsql: %s has no m2m field %s", v.Type(), field)
+	}
+	var statements []Statement
+	for _, step := range a.reconcile(v, current) {
+		qry, args, err := o.bind(step.query, step.params)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, Statement{Query: qry, Args: args})
+	}
+	return statements, nil
+}
+
+// associationStep is a named statement on a junction table along with its params
+type associationStep struct {
+	query  string
+	params map[string]interface{}
+}
+
+// reconcile returns the named statements turning the `current` associations of the message `v` into those of its field
+func (a *association) reconcile(v reflect.Value, current []interface{}) []associationStep {
+	owner := v.Field(a.owner).Interface()
+	desired := a.keys(v.Field(a.field))
+	held := make(map[string]bool, len(desired))
+	for _, key := range desired {
+		held[keyString(key)] = true
+	}
+	existing := make(map[string]bool, len(current))
+	var removed []interface{}
+	for _, key := range current {
+		existing[keyString(key)] = true
+		if !held[keyString(key)] {
+			removed = append(removed, key)
+		}
+	}
+
+	var steps []associationStep
+	if len(removed) > 0 {
+		params := map[string]interface{}{"owner": owner}
+		names := make([]string, len(removed))
+		for i, key := range removed {
+			names[i] = fmt.Sprintf(":remote_%d", i)
+			params[names[i][1:]] = key
+		}
+		steps = append(steps, associationStep{
+			query: fmt.Sprintf("DELETE FROM %s WHERE %s.%s = :owner AND %s.%s IN (%s)",
+				a.junction, a.junction, a.local, a.junction, a.remote, strings.Join(names, ", ")),
+			params: params,
+		})
+	}
+
+	params := map[string]interface{}{"owner": owner}
+	var values []string
+	for _, key := range desired {
+		if existing[keyString(key)] {
+			continue
+		}
+		existing[keyString(key)] = true
+		name := fmt.Sprintf("remote_%d", len(values))
+		params[name] = key
+		values = append(values, fmt.Sprintf("(:owner, :%s)", name))
+	}
+	if len(values) > 0 {
+		steps = append(steps, associationStep{
+			query:  fmt.Sprintf("INSERT INTO %s (%s, %s) VALUES %s", a.junction, a.local, a.remote, strings.Join(values, ", ")),
+			params: params,
+		})
+	}
+	return steps
+}
+
+// keyString returns a key in a form comparable across the integer types and text encodings drivers scan into
+func keyString(key interface{}) string {
+	if b, ok := key.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(key)
+}
+
+// SyncAssociation makes the junction table of the m2m field `field` of `source` hold exactly the related messages in
+// the field, see BuildAssociationQueries. The current associations are read and reconciled within a transaction. With
+// policies the owner row must match the predicates they require of updates, ErrNotFound is returned otherwise.
+func (e *Executor) SyncAssociation(ctx context.Context, target string, source interface{}, field string, opts ...Option) (err error) {
+	if opts, err = e.secure(ctx, target, OpUpdate, source, opts); err != nil {
+		return err
+	}
+	e = e.route(target, source, OpUpdate, opts...)
+	o := e.options(opts)
+	target = o.table(target, source)
+	v := reflect.ValueOf(source).Elem()
+	a, err := associationOf(v.Type(), field)
+	if err != nil {
+		return err
+	}
+	if a == nil {
+		return fmt.Errorf("pbsql: %s has no m2m field %s", v.Type(), field)
+	}
+	if v.Field(a.owner).IsZero() {
+		return fmt.Errorf("%w: cannot associate an unsaved %s", ErrMissingPrimaryKey, v.Type())
+	}
+	return e.inTx(ctx, func(tx *Executor) error {
+		if len(e.policies) > 0 {
+			// the junction rows are only synced for an owner the policies let the caller update
+			var visible bool
+			if err := tx.getBuilt(ctx, target, &visible, func() (string, interface{}, error) {
+				qry, err := existsQuery(target, source, nil, o)
+				return qry, o.bindSource(source), err
+			}); err != nil {
+				return err
+			}
+			if !visible {
+				return fmt.Errorf("%w: no row of %s matched the primary key", ErrNotFound, target)
+			}
+		}
+		pairs, err := tx.readJunction(ctx, a, []interface{}{v.Field(a.owner).Interface()}, o)
+		if err != nil {
+			return err
+		}
+		current := make([]interface{}, len(pairs))
+		for i, pair := range pairs {
+			current[i] = pair[1]
+		}
+		for _, step := range a.reconcile(v, current) {
+			step := step
+			op := OpCreate
+			if strings.HasPrefix(step.query, "DELETE") {
+				op = OpDelete
+			}
+			if _, err := tx.execBuilt(ctx, a.junction, op, func() (string, interface{}, error) {
+				return step.query, step.params, nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// readJunction returns the (local, remote) key pairs of the junction rows of the `owners`
+func (e *Executor) readJunction(ctx context.Context, a *association, owners []interface{}, o *options) (pairs [][2]interface{}, err error) {
+	ctx, run := e.start(ctx, a.junction, OpRead)
+	defer func() { run.finish(err) }()
+
+	if err = run.build(func() (string, interface{}, error) {
+		names, params := inParams(owners)
+		qry := fmt.Sprintf("SELECT %s.%s, %s.%s FROM %s WHERE %s.%s IN (%s)",
+			a.junction, a.local, a.junction, a.remote, a.junction, a.junction, a.local, names)
+		return qry, params, nil
+	}); err != nil {
+		return nil, err
+	}
+	rows, release, err := e.queryRows(ctx, a, run.info.Query, run.args)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer rows.Close()
+	for rows.Next() {
+		var pair [2]interface{}
+		if err = rows.Scan(&pair[0], &pair[1]); err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+	}
+	run.info.Rows = int64(len(pairs))
+	return pairs, rows.Err()
+}
+
+// preloadAssociation reads the related messages of `a` for every row through its junction table and appends them to
+// the rows' field
+func (e *Executor) preloadAssociation(ctx context.Context, rows reflect.Value, a *association, o *options) error {
+	var owners []interface{}
+	fields := make(map[string][]reflect.Value)
+	for i := 0; i < rows.Len(); i++ {
+		row := reflect.Indirect(rows.Index(i))
+		if !row.IsValid() || row.Field(a.owner).IsZero() {
+			continue
+		}
+		key := keyString(row.Field(a.owner).Interface())
+		if _, ok := fields[key]; !ok {
+			owners = append(owners, row.Field(a.owner).Interface())
+		}
+		fields[key] = append(fields[key], row.Field(a.field))
+	}
+
+	rel := &relation{table: a.table, column: a.column, elem: a.elem}
+	for start := 0; start < len(owners); start += preloadBatchSize {
+		end := start + preloadBatchSize
+		if end > len(owners) {
+			end = len(owners)
+		}
+		pairs, err := e.readJunction(ctx, a, owners[start:end], o)
+		if err != nil {
+			return err
+		}
+		if len(pairs) == 0 {
+			continue
+		}
+		var remotes []interface{}
+		holders := make(map[string][]string)
+		for _, pair := range pairs {
+			remote := keyString(pair[1])
+			if _, ok := holders[remote]; !ok {
+				remotes = append(remotes, pair[1])
+			}
+			holders[remote] = append(holders[remote], keyString(pair[0]))
+		}
+
+		related := reflect.New(reflect.SliceOf(reflect.PtrTo(a.elem)))
+		if err := e.readRelated(ctx, rel, remotes, related.Interface(), o); err != nil {
+			return err
+		}
+		related = related.Elem()
+		for i := 0; i < related.Len(); i++ {
+			child := related.Index(i)
+			for _, owner := range holders[keyString(child.Elem().Field(a.key).Interface())] {
+				for _, field := range fields[owner] {
+					if field.Type().Elem().Kind() == reflect.Ptr {
+						field.Set(reflect.Append(field, child))
+					} else {
+						field.Set(reflect.Append(field, child.Elem()))
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// inParams returns a list of named params for `values`, e.g. `:in_0, :in_1`, along with the map binding them
+func inParams(values []interface{}) (string, map[string]interface{}) {
+	params := make(map[string]interface{}, len(values))
+	names := make([]string, len(values))
+	for i, value := range values {
+		name := fmt.Sprintf("in_%d", i)
+		params[name] = value
+		names[i] = ":" + name
+	}
+	return strings.Join(names, ", "), params
+}
@@ -4,8 +4,6 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
-
-	"github.com/jmoiron/sqlx"
 )
 
 // BuildCountQuery_OLD is deprecated is a convenience wrapper for getting the result count of a query already generated by pbsql
@@ -17,29 +15,103 @@ func BuildCountQuery_OLD(selectQry string) string {
 // BuildCreateQuery accepts a target table name and a protobuf message and attempts to build a valid SQL insert statement for use
 // with sqlx.Named, ignoring any struct fields with default values. Fields must be tagged with `db:""` in order to be
 // included in the result string.
-func BuildCreateQuery(target string, source interface{}) (string, []interface{}, error) {
+//
+// Primary keys are assumed to be generated by the database and are left out, unless the message has a composite
+// primary key (e.g. a junction table) in which case every key column that is set is inserted.
+//
+// Fields tagged `created_at:"auto"` or `updated_at:"auto"` are always set to the current time.
+func BuildCreateQuery(target string, source interface{}, opts ...Option) (string, []interface{}, error) {
+	o := newOptions(opts)
+	target = o.table(target, source)
+	qry, err := createQuery(target, source, o)
+	if err != nil {
+		return "", nil, err
+	}
+	return o.bind(qry, source)
+}
+
+// createQuery returns the named insert statement bound by BuildCreateQuery
+func createQuery(target string, source interface{}, o *options) (string, error) {
+	if err := o.checkStrict(target, reflect.ValueOf(source).Elem()); err != nil {
+		return "", err
+	}
+	if err := generateKeys(reflect.ValueOf(source).Elem(), target, o.idGenerator()); err != nil {
+		return "", err
+	}
+	keys := primaryKeys(reflect.ValueOf(source).Elem(), target)
+	qry, _, err := insertQuery(target, source, len(keys) > 1, o)
+	return qry, err
+}
+
+// insertQuery returns a named insert statement along with the columns an upsert should overwrite
+func insertQuery(target string, source interface{}, includeKeys bool, o *options) (string, []string, error) {
 	t := reflect.ValueOf(source).Elem()
-	var qb queryBuilder
-	fmt.Fprintf(&qb.Columns, "INSERT INTO %s (", target)
-	qb.Values.WriteString("(")
+	setFields, err := o.setFieldsOf(t.Type(), target)
+	if err != nil {
+		return "", nil, err
+	}
+	qb := newQueryBuilder(o.dialect)
+	defer qb.release()
+	var columns []string
 
 	for i := 0; i < t.NumField(); i++ {
 		field := parseReflection(t, i, target)
-		if (field.value.CanInterface()) {
-			if notDefault(field.typeStr, field.value.Interface()) && field.name != "" && !field.isPrimaryKey {
-				if i != 0 {
-					qb.Columns.WriteString(", ")
-					qb.Values.WriteString(", ")
+		if field.isReadonly || !field.permits(permCreate) {
+			continue
+		}
+		if field.name != "" && field.isAutoTimestamp() {
+			qb.writeValue(o.dialect.assignable(target, field.name), o.nowExpr())
+			if field.isUpdatedAt && field.permits(permUpdate) {
+				columns = append(columns, field.name)
+			}
+		} else if (field.value.CanInterface()) {
+			// keys tagged `pk_gen` are set by generateKeys before the insert is built
+			generated := field.isPrimaryKey && field.self.Tag.Get("pk_gen") != ""
+			included := field.isSet() || generated || findInMask(setFields, field.self.Name) || o.zeroValues && field.isColumn && !field.isPrimaryKey
+			if field.name != "" && included && (includeKeys || !field.isPrimaryKey || generated) {
+				qb.writeValue(o.dialect.assignable(target, field.name), ":"+field.name)
+				if !field.isPrimaryKey && field.permits(permUpdate) {
+					columns = append(columns, field.name)
 				}
-				fmt.Fprintf(&qb.Columns, "%s.%s", target, field.name)
-				fmt.Fprintf(&qb.Values, ":%s", field.name)
+			} else if field.name != "" && !included && field.insertDefault != "" {
+				// keys are written too, the default generates them, and upserts don't overwrite a row with defaults
+				qb.writeValue(o.dialect.assignable(target, field.name), field.insertDefault)
 			}
 		}
 	}
-	qb.Values.WriteString(")")
-	fmt.Fprintf(&qb.Columns, ") VALUES %s", qb.Values.String())
-	result := strings.ReplaceAll(qb.Columns.String(), "(, ", "(")
-	return sqlx.Named(result, source)
+	return qb.insertStatement(target), columns, nil
+}
+
+// BuildUpsertQuery accepts a target table name and a protobuf message and attempts to build an insert statement
+// that updates the existing row instead when one with the same primary key already exists. Every primary key
+// column is part of the conflict target, so the key fields of `source` must be set.
+//
+// Uses `ON DUPLICATE KEY UPDATE` on MySQL and `ON CONFLICT (...) DO UPDATE` on Postgres and SQLite.
+func BuildUpsertQuery(target string, source interface{}, opts ...Option) (string, []interface{}, error) {
+	o := newOptions(opts)
+	target = o.table(target, source)
+	qry, err := upsertQuery(target, source, o)
+	if err != nil {
+		return "", nil, err
+	}
+	return o.bind(qry, source)
+}
+
+// upsertQuery returns the named upsert statement bound by BuildUpsertQuery
+func upsertQuery(target string, source interface{}, o *options) (string, error) {
+	keys := primaryKeys(reflect.ValueOf(source).Elem(), target)
+	if len(keys) == 0 {
+		return "", fmt.Errorf("%w: cannot upsert into %s", ErrMissingPrimaryKey, target)
+	}
+	keyNames := make([]string, len(keys))
+	for i, key := range keys {
+		keyNames[i] = key.name
+	}
+	qry, columns, err := insertQuery(target, source, true, o)
+	if err != nil {
+		return "", err
+	}
+	return qry + " " + o.dialect.upsertClause(keyNames, columns), nil
 }
 
 // BuildDeleteQuery accepts a target table name and a protobuf message and attempts to build a valid SQL
@@ -50,64 +122,209 @@ func BuildCreateQuery(target string, source interface{}) (string, []interface{},
 // This function returns a nullsafe query if nullable struct fields are properly tagged as `nullable:"y"`.
 //
 // If an IsActive field is detected (is_active), this func returns an update statement that sets is_active to 0,
-// otherwise it returns a delete statement
-func BuildDeleteQuery(target string, source interface{}) (string, []interface{}, error) {
+// otherwise it returns a delete statement. The field, column, and value are configured with Config.SoftDelete, and
+// WithHardDelete always deletes. Every primary key column is matched, and ErrMissingPrimaryKey is returned if there
+// is none.
+func BuildDeleteQuery(target string, source interface{}, opts ...Option) (string, []interface{}, error) {
+	o := newOptions(opts)
+	target = o.table(target, source)
+	qry, err := deleteQuery(target, source, o)
+	if err != nil {
+		return "", nil, err
+	}
+	return o.bind(qry, source)
+}
+
+// deleteQuery returns the named delete statement bound by BuildDeleteQuery
+func deleteQuery(target string, source interface{}, o *options) (string, error) {
 	reflectedValue := reflect.ValueOf(source).Elem()
 	var builder strings.Builder
 
-	if _, hasIsActive := reflectedValue.Type().FieldByName("IsActive"); hasIsActive {
-		fmt.Fprintf(&builder, "UPDATE %s SET %s.is_active = 0 WHERE ", target, target)
-	} else {
-		fmt.Fprintf(&builder, "DELETE FROM %s WHERE ", target)
+	keys := primaryKeys(reflectedValue, target)
+	if len(keys) == 0 {
+		return "", fmt.Errorf("%w: refusing to delete every row of %s", ErrMissingPrimaryKey, target)
 	}
 
-	for i := 0; i < reflectedValue.NumField(); i++ {
-		field := parseReflection(reflectedValue, i, target)
-		if field.isPrimaryKey {
-			fmt.Fprintf(&builder, "%s.%s = :%s", target, field.name, field.name)
-			break
-		}
+	builder.WriteString(deleteStatement(target, reflectedValue.Type(), o))
+	builder.WriteString(keyPredicate(keys))
+
+	where, err := o.whereClauses()
+	if err != nil {
+		return "", err
+	}
+	for _, clause := range where {
+		builder.WriteString(" AND " + clause)
+	}
+
+	return builder.String(), nil
+}
+
+
+// deleteStatement returns the start of a delete statement on `target` up to its WHERE clause, which is an update
+// setting the soft delete column if `t` has one. The soft delete value is added to the params of `o`, rather than
+// bound from the field, so that a filter on the field doesn't leave the row active.
+func deleteStatement(target string, t reflect.Type, o *options) string {
+	if o.hardDelete {
+		return fmt.Sprintf("DELETE FROM %s ", target)
+	}
+	softDelete := o.softDeletion(t)
+	column := softDelete.softDeleteColumn(t)
+	if column == "" {
+		return fmt.Sprintf("DELETE FROM %s ", target)
+	}
+	if o.params == nil {
+		o.params = make(map[string]interface{})
+	}
+	o.params[softDeleteParam] = softDelete.value()
+	return fmt.Sprintf("UPDATE %s SET %s = :%s ", target, o.dialect.assignable(target, column), softDeleteParam)
+}
+
+// BuildDeleteWhereQuery behaves like BuildDeleteQuery but matches rows on every field of `source` holding a value
+// rather than on the primary key, e.g. every user of a property. Fields given with WithFieldMask are matched even if
+// they hold their default value, so WithFieldMask("IsActive") matches rows where is_active = 0. Strings are compared
+// by equality rather than LIKE, so a stray `%` can't widen the delete.
+//
+// ErrMissingPredicate is returned if no field is set and no clause is given with WithWhere, since the statement
+// would delete every row.
+func BuildDeleteWhereQuery(target string, source interface{}, opts ...Option) (string, []interface{}, error) {
+	o := newOptions(opts)
+	target = o.table(target, source)
+	qry, err := deleteWhereQuery(target, source, o)
+	if err != nil {
+		return "", nil, err
 	}
+	return o.bind(qry, source)
+}
 
-	return sqlx.Named(builder.String(), source)
+// deleteWhereQuery returns the named delete statement bound by BuildDeleteWhereQuery
+func deleteWhereQuery(target string, source interface{}, o *options) (string, error) {
+	reflectedValue := reflect.ValueOf(source).Elem()
+	if err := o.checkStrict(target, reflectedValue); err != nil {
+		return "", err
+	}
+	predicate, err := deleteWherePredicate(target, reflectedValue, o)
+	if err != nil {
+		return "", err
+	}
+	return deleteStatement(target, reflectedValue.Type(), o) + predicate, nil
 }
 
+// deleteWherePredicate returns the WHERE clause of BuildDeleteWhereQuery
+func deleteWherePredicate(target string, v reflect.Value, o *options) (string, error) {
+	setFields, err := o.setFieldsOf(v.Type(), target)
+	if err != nil {
+		return "", err
+	}
+	qb := newQueryBuilder(o.dialect)
+	defer qb.release()
+	for i := 0; i < v.NumField(); i++ {
+		field := parseReflection(v, i, target)
+		if field.name == "" || !field.value.CanInterface() || field.isJSON || field.self.Name == o.lifecycleField(v.Type()) {
+			continue
+		}
+		if field.array != "" {
+			qb.writeArrayPredicate(field, andPredicate, field.isNegated)
+			continue
+		}
+		if !field.notDefault() && !findInMask(o.fieldMask, field.self.Name) && !findInMask(setFields, field.self.Name) {
+			continue
+		}
+		predicate := field.predicateTarget(andPredicate)
+		switch {
+		case field.isMultiValue && !field.value.IsZero() && field.isNegated:
+			predicate += fmt.Sprintf(" NOT IN (%s)", field.value)
+		case field.isMultiValue && !field.value.IsZero():
+			predicate += fmt.Sprintf(" IN (%s)", field.value)
+		case field.isNegated:
+			predicate += fmt.Sprintf(notValComparison, field.param())
+		default:
+			predicate += fmt.Sprintf(valComparison, field.name)
+		}
+		qb.writeGroupedCondition(field, predicate, andPredicate)
+	}
+	qb.writePredicateGroups()
+
+	where, err := o.whereClauses()
+	if err != nil {
+		return "", err
+	}
+	for _, clause := range where {
+		qb.writeCondition(" AND " + clause)
+	}
+	if len(qb.conditions) == 0 {
+		return "", fmt.Errorf("%w: refusing to delete every row of %s", ErrMissingPredicate, target)
+	}
+	return "WHERE " + strings.Join(qb.conditions, " AND "), nil
+}
 
 // BuildSearchQuery builds a search query
-func BuildSearchQuery(target string, source interface{}, searchPhrase string) (string, []interface{}, error) {
-	var qb queryBuilder
+func BuildSearchQuery(target string, source interface{}, searchPhrase string, opts ...Option) (string, []interface{}, error) {
+	o := newOptions(opts)
+	target = o.readRelation(o.table(target, source), source)
+	qb := newQueryBuilder(o.dialect)
+	defer qb.release()
+	qb.caseInsensitive, qb.escapeLike, qb.masking = o.caseInsensitive, o.escapeLike, o.masking
+	qb.nullDefaults = o.nullDefaults
 	qb.Core.WriteString("SELECT ")
 	qb.Predicate.WriteString(" WHERE true")
 	reflectedValue := reflect.ValueOf(source).Elem()
+	if err := o.checkStrict(target, reflectedValue); err != nil {
+		return "", nil, err
+	}
+	qb.lifecycleField = o.lifecycleField(reflectedValue.Type())
+	setFields, err := o.setFieldsOf(reflectedValue.Type(), target)
+	if err != nil {
+		return "", nil, err
+	}
 	fieldMask := make([]string, 0)
 	fields := make([]*field, 0)
 	n := reflectedValue.NumField()
 
 	for i := 0; i < n; i++ {
-		field := parseReflection(reflectedValue, i, target)
+		fields = append(fields, parseReflection(reflectedValue, i, target))
+	}
+	// fields tagged searchable restrict the phrase to themselves
+	restricted := false
+	for _, field := range fields {
+		restricted = restricted || field.isSearchable
+	}
+	for i := 0; i < n; i++ {
+		field := fields[i]
 			if field.selectFunc.ok {
 				field.shouldIgnore = true
 			}
-			fields = append(fields, field)
 			if field.name != "" && !field.shouldIgnore {
-				if field.typeStr == "string" && field.value.String() == "" {
-					fieldMask = append(fieldMask, field.self.Name)
+				if field.typeStr == "string" && !field.isConverted && field.value.String() == "" {
+					if !restricted || field.isSearchable {
+						fieldMask = append(fieldMask, field.self.Name)
+					}
 				} else if field.value.CanAddr() {
-					qb.writePredicate(field, fieldMask, andPredicate)
+					qb.writePredicate(field, setFields, andPredicate)
 				}
 			} else if field.selectFunc.ok {
-				qb.writeSelectFunc(field)
+				if err := qb.writeSelectFunc(field); err != nil {
+					return "", nil, err
+				}
 			}
 	}
 
-	qb.Predicate.WriteString(" AND (")
+	qb.writePredicateGroups()
+	qb.openORGroup()
+	var terms []*field
 	for i := 0; i < n; i++ {
 		field := fields[i]
 		if field.name != "" && !field.shouldIgnore {
-			qb.writeSelectField(field)
+			if err := qb.writeSelectField(field); err != nil {
+				return "", nil, err
+			}
 			if field.value.CanAddr() {
-				if field.typeStr == "string" && field.value.String() == "" {
-					qb.writePredicate(field, fieldMask, orPredicate)
+				if field.typeStr == "string" && !field.isConverted && field.value.String() == "" && (!restricted || field.isSearchable) {
+					if o.fuzzy {
+						qb.writeCondition(" OR " + o.dialect.fuzzyMatch(field.column(), ":"+field.param(), o.fuzzyThreshold))
+					} else {
+						qb.writePredicate(field, fieldMask, orPredicate)
+					}
+					terms = append(terms, field)
 				}
 			}
 		}
@@ -116,24 +333,121 @@ func BuildSearchQuery(target string, source interface{}, searchPhrase string) (s
 		}
 	}
 	qb.Predicate.WriteString(")")
-	/* here we choose to use the args returned from BuildReadQuery*/
-	qry, falseArgs, err := sqlx.Named(qb.getReadResult(target, &reflectedValue), source)
-	_, altArgs, _ := BuildReadQuery(target, source)
-	searchArgs := getSearchArgs(len(falseArgs) - len(altArgs), searchPhrase)
-	return qry, append(altArgs, searchArgs...), err
+	scope := o.scopeClause(target, reflectedValue.Type())
+	if scope != "" {
+		qb.writeCondition(" AND " + scope)
+	}
+	if err := o.likeParams(qb); err != nil {
+		return "", nil, err
+	}
+	if err := o.checkQuery(target, qb, len(fieldMask), 0); err != nil {
+		return "", nil, err
+	}
+	if searchPhrase, err = o.sanitize("", searchPhrase, false); err != nil {
+		return "", nil, err
+	}
+	rankPhrase := searchPhrase
+	if o.fuzzy {
+		searchPhrase = strings.Trim(searchPhrase, "%")
+	} else if o.escapeLike {
+		searchPhrase = "%" + EscapeLike(searchPhrase) + "%"
+	}
+	named := qb.getReadResult(target, &reflectedValue)
+	// the params of the rank are the last of the statement
+	ranked := 0
+	if o.searchRank && len(terms) > 0 {
+		order := qb.searchRank(terms, rankPhrase, searchPhrase, o) + " desc"
+		for _, key := range primaryKeys(reflectedValue, target) {
+			order += ", " + key.column() + " asc"
+		}
+		if orderByOf(&reflectedValue) != "" {
+			named += ", " + order
+		} else {
+			named += " order by " + order
+		}
+		ranked = strings.Count(order, ":"+searchRankParam)
+	}
+	/* here we choose to use the args returned from BuildReadQuery, which only lines up with positional args so the
+	search query is always bound with SQLBinder */
+	qry, falseArgs, err := SQLBinder.Bind(named, o.bindSource(source), o.dialect)
+	if err != nil {
+		return qry, nil, err
+	}
+	// the set fields filter the read as they do the search, except the empty strings matched against the phrase
+	var predicateMask []string
+	for _, name := range setFields {
+		if !findInMask(fieldMask, name) {
+			predicateMask = append(predicateMask, name)
+		}
+	}
+	_, altArgs, err := BuildReadQueryWithOptions(target, source, WithDialect(o.dialect), WithBinder(SQLBinder), func(alt *options) {
+		alt.lifecycle, alt.scope = o.lifecycle, All
+		alt.escapeLike, alt.sanitizer = o.escapeLike, o.sanitizer
+		alt.predicateMask = predicateMask
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	rankArgs := falseArgs[len(falseArgs)-ranked:]
+	falseArgs = falseArgs[:len(falseArgs)-ranked]
+	if scope == "" {
+		searchArgs := getSearchArgs(len(falseArgs) - len(altArgs), searchPhrase)
+		return qry, append(append(altArgs, searchArgs...), rankArgs...), nil
+	}
+	// the scope follows the phrase matches
+	searchArgs := getSearchArgs(len(falseArgs) - len(altArgs) - 1, searchPhrase)
+	return qry, append(append(append(altArgs, searchArgs...), falseArgs[len(falseArgs)-1]), rankArgs...), nil
 }
 
 // BuildCountQuery is a convenience wrapper for getting the result count of a query already generated by pbsql
 // value based, does not affect the initially supplied query string
 func BuildCountQuery(target string, source interface{}, fieldMask ...string) (string, []interface{}, error) {
+	o := newOptions(nil)
+	target = o.readRelation(o.table(target, source), source)
+	qry, err := countQuery(target, source, fieldMask, o)
+	if err != nil {
+		return "", nil, err
+	}
+	return o.bind(qry, source)
+}
+
+// BuildCountQueryWithOptions behaves like BuildCountQuery but accepts a list of options controlling the generated
+// statement, e.g. WithWhere
+func BuildCountQueryWithOptions(target string, source interface{}, opts ...Option) (string, []interface{}, error) {
+	o := newOptions(opts)
+	target = o.readRelation(o.table(target, source), source)
+	qry, err := countQuery(target, source, nil, o)
+	if err != nil {
+		return "", nil, err
+	}
+	return o.bind(qry, source)
+}
+
+// countQuery returns the named count statement bound by BuildCountQuery
+func countQuery(target string, source interface{}, fieldMask []string, o *options) (string, error) {
 	reflectedValue := reflect.ValueOf(source).Elem()
-	var qb queryBuilder
-	qb.Core.WriteString("SELECT COUNT(*) ")
+	if err := o.checkStrict(target, reflectedValue); err != nil {
+		return "", err
+	}
+	points, err := geoPoints(reflectedValue, target)
+	if err != nil {
+		return "", err
+	}
+	setFields, err := o.setFieldsOf(reflectedValue.Type(), target)
+	if err != nil {
+		return "", err
+	}
+	fieldMask = append(setFields, fieldMask...)
+	qb := newQueryBuilder(o.dialect)
+	defer qb.release()
+	qb.caseInsensitive, qb.escapeLike = o.caseInsensitive, o.escapeLike
+	qb.lifecycleField = o.lifecycleField(reflectedValue.Type())
+	qb.Core.WriteString("SELECT COUNT(*)")
 	qb.Predicate.WriteString(" WHERE TRUE")
 	for i := 0; i < reflectedValue.NumField(); i++ {
 		field := parseReflection(reflectedValue, i, target)
 		if field.value.CanInterface() {
-			if field.name != "" && field.value.CanAddr() {
+			if field.name != "" && field.value.CanAddr() && !isGeoCenter(points, field) {
 				qb.writePredicate(field, fieldMask, andPredicate)
 			}
 			if field.hasForeignKey {
@@ -141,52 +455,157 @@ func BuildCountQuery(target string, source interface{}, fieldMask ...string) (st
 			}
 		}
 	}
-	result := qb.getReadResult(target, &reflectedValue)
-	return sqlx.Named(result, source)
+	qb.writePredicateGroups()
+	qb.writeGeoPredicates(points)
+	if err := o.likeParams(qb); err != nil {
+		return "", err
+	}
+	where, err := o.whereClauses()
+	if err != nil {
+		return "", err
+	}
+	filter, err := o.filterClauses(target, reflectedValue)
+	if err != nil {
+		return "", err
+	}
+	ranges, err := o.rangeClauses(target, reflectedValue)
+	if err != nil {
+		return "", err
+	}
+	filter = append(ranges, filter...)
+	for _, clause := range o.withScope(append(filter, where...), target, reflectedValue.Type()) {
+		qb.writeCondition(" AND " + clause)
+	}
+	if err := o.indexHint.validate(); err != nil {
+		return "", err
+	}
+	if err := o.checkQuery(target, qb, 0, 0); err != nil {
+		return "", err
+	}
+	qb.orderedPredicate(" WHERE TRUE")
+	prefix, suffix := o.dialect.indexHint(target, o.indexHint)
+	return prefix + qb.getReadResult(target+suffix, &reflectedValue), nil
 }
 
 // BuildReadQuery accepts a target table name and a protobuf message and attempts to build a valid SQL select statement,
 // ignoring any struct fields with default values when writing predicates. Fields must be tagged with `db:""` in order to be
 // included in the result string.
 //
+// Fields named by go name in `fieldMask` are filtered on even when they hold their default value, as in
+// BuildCountQuery. To restrict the select list use BuildReadQueryWithOptions with WithFieldMask.
+//
 // Returns a SQL statement as a string, a slice of args to interpolate, and an error
 func BuildReadQuery(target string, source interface{}, fieldMask ...string) (string, []interface{}, error) {
+	return BuildReadQueryWithOptions(target, source, withPredicateMask(fieldMask))
+}
+
+// withPredicateMask filters reads by the fields of `fieldMask` even when they hold their default value
+func withPredicateMask(fieldMask []string) Option {
+	return func(o *options) {
+		o.predicateMask = append(o.predicateMask, fieldMask...)
+	}
+}
+
+// BuildReadQueryWithOptions behaves like BuildReadQuery but accepts a list of options controlling the generated statement.
+func BuildReadQueryWithOptions(target string, source interface{}, opts ...Option) (string, []interface{}, error) {
+	o := newOptions(opts)
+	target = o.readRelation(o.table(target, source), source)
+	qry, err := readQuery(target, source, o)
+	if err != nil {
+		return "", nil, err
+	}
+	return o.bind(qry, source)
+}
+
+// readQuery returns the named select statement bound by BuildReadQueryWithOptions
+func readQuery(target string, source interface{}, o *options) (string, error) {
+	q, err := selectQuery(target, source, o)
+	if err != nil {
+		return "", err
+	}
+	return q.Named(), nil
+}
+
+// BuildReadByPKQuery accepts a target table name and a protobuf message and builds a select statement for the single
+// row matching the message's primary key. Unlike BuildReadQuery every other field is ignored when writing the
+// predicate, which makes this the right builder for GetByID style endpoints. Every primary key column is matched,
+// and ErrMissingPrimaryKey is returned if there is none.
+//
+// The select list can be restricted with WithFieldMask.
+func BuildReadByPKQuery(target string, source interface{}, opts ...Option) (string, []interface{}, error) {
+	o := newOptions(opts)
+	target = o.readRelation(o.table(target, source), source)
+	qry, err := readByKeyQuery(target, source, o)
+	if err != nil {
+		return "", nil, err
+	}
+	return o.bind(qry, source)
+}
+
+// readByKeyQuery returns the named select statement bound by BuildReadByPKQuery
+func readByKeyQuery(target string, source interface{}, o *options) (string, error) {
 	reflectedValue := reflect.ValueOf(source).Elem()
-	var qb queryBuilder
-	qb.Core.WriteString("SELECT ")
-	qb.Predicate.WriteString(" WHERE true")
-	
-	for i := 0; i < reflectedValue.NumField(); i++ {
-		field := parseReflection(reflectedValue, i, target)
-		if field.name != "" {
-			if !field.shouldIgnore && !field.selectFunc.ok {
-				qb.writeSelectField(field)
-				if field.value.CanAddr() {
-					qb.writePredicate(field, fieldMask, andPredicate)
-				}
-			} else if field.selectFunc.ok {
-				qb.writeSelectFunc(field)
-			} else if field.isMultiValue && field.value.CanAddr(){
-				qb.writePredicate(field, fieldMask, andPredicate)
-			}
-		}
-		if field.hasForeignKey {
-			qb.handleForeignKey(field)
-		}
+	keys := primaryKeys(reflectedValue, target)
+	if len(keys) == 0 {
+		return "", fmt.Errorf("%w: cannot read %s by primary key", ErrMissingPrimaryKey, target)
 	}
-	qb.handleDateRange(target, &reflectedValue)
-	result := qb.getReadResult(target, &reflectedValue)
-	return sqlx.Named(result, source)
+	return readByFieldsQuery(target, reflectedValue, keys, o)
+}
+
+// existsByKeyQuery returns the named statement selecting 1 for the row matching the primary key of `source`,
+// whatever its lifecycle
+func existsByKeyQuery(target string, source interface{}, o *options) (string, error) {
+	keys := primaryKeys(reflect.ValueOf(source).Elem(), target)
+	if len(keys) == 0 {
+		return "", fmt.Errorf("%w: cannot look %s up by primary key", ErrMissingPrimaryKey, target)
+	}
+	where, err := o.whereClauses()
+	if err != nil {
+		return "", err
+	}
+	qry := "SELECT 1 FROM " + target + " " + keyPredicate(keys)
+	for _, clause := range where {
+		qry += " AND " + clause
+	}
+	return qry, nil
+}
+
+// readByFieldsQuery returns the named select statement for the row of `target` matching the values of `keys`
+func readByFieldsQuery(target string, reflectedValue reflect.Value, keys []*field, o *options) (string, error) {
+	qb := newQueryBuilder(o.dialect)
+	defer qb.release()
+	if err := qb.writeSelectList(reflectedValue, target, o); err != nil {
+		return "", err
+	}
+	if len(qb.selects) == 0 {
+		return "", fmt.Errorf("field mask %v does not match any selectable field of %s", o.fieldMask, target)
+	}
+	where, err := o.whereClauses()
+	if err != nil {
+		return "", err
+	}
+	if err := o.lock.validate(); err != nil {
+		return "", err
+	}
+	qry := fmt.Sprintf("SELECT %s FROM %s %s", qb.selectList(), target, keyPredicate(keys))
+	for _, clause := range o.withScope(where, target, reflectedValue.Type()) {
+		qry += " AND " + clause
+	}
+	return qry + o.dialect.lockClause(o.lock, target, false), nil
 }
 
 // BuildReadQueryWithNotList accepts a target table name and a protobuf message and attempts to build a valid SQL select statement,
 // ignoring any struct fields with default values when writing predicates. Fields must be tagged with `db:""` in order to be
-// included in the result string.
+// included in the result string. Fields listed in `notList` are negated, and fields in `fieldMask` are
+// filtered on even when they hold their default value.
 //
 // Returns a SQL statement as a string, a slice of args to interpolate, and an error
 func BuildReadQueryWithNotList(target string, source interface{}, notList []string, fieldMask ...string) (string, []interface{}, error) {
+	o := newOptions(nil)
+	target = o.readRelation(o.table(target, source), source)
 	reflectedValue := reflect.ValueOf(source).Elem()
-	var qb queryBuilder
+	qb := newQueryBuilder(o.dialect)
+	defer qb.release()
 	qb.Core.WriteString("SELECT ")
 	qb.Predicate.WriteString(" WHERE true")
 	
@@ -194,7 +613,9 @@ func BuildReadQueryWithNotList(target string, source interface{}, notList []stri
 		field := parseReflection(reflectedValue, i, target)
 		if field.name != "" {
 			if !field.shouldIgnore && !field.selectFunc.ok {
-				qb.writeSelectField(field)
+				if err := qb.writeSelectField(field); err != nil {
+					return "", nil, err
+				}
 				if field.value.CanAddr() {
 					if findInMask(notList, field.self.Name) {
 						qb.writeNotPredicate(field, notList, andPredicate)
@@ -203,7 +624,9 @@ func BuildReadQueryWithNotList(target string, source interface{}, notList []stri
 					}
 				}
 			} else if field.selectFunc.ok {
-				qb.writeSelectFunc(field)
+				if err := qb.writeSelectFunc(field); err != nil {
+					return "", nil, err
+				}
 			}else if field.isMultiValue && field.value.CanAddr(){
 				if findInMask(notList, field.self.Name) {
 					qb.writeNotPredicate(field, notList, andPredicate)
@@ -216,38 +639,93 @@ func BuildReadQueryWithNotList(target string, source interface{}, notList []stri
 			qb.handleForeignKey(field)
 		}
 	}
+	qb.writePredicateGroups()
 	qb.handleDateRange(target, &reflectedValue)
 	result := qb.getReadResult(target, &reflectedValue)
-	return sqlx.Named(result, source)
+	return o.bind(result, source)
 }
 // BuildUpdateQuery accepts a target table name `target`, a struct `source`, and a list of struct fields `fieldMask`
 // and attempts to build a valid sql update statement for use with sqlx.Named, ignoring any struct fields not present
-// in `fieldMask`. Struct fields must also be tagged with `db:""`, and the primary key should be tagged as
-// `primary_key` otherwise this function will return an invalid query
-func BuildUpdateQuery(target string, source interface{}, fieldMask []string) (string, []interface{}, error) {
+// in `fieldMask`. Struct fields must also be tagged with `db:""`, and the primary key must be tagged as `primary_key`.
+//
+// Fields tagged `updated_at:"auto"` are always set to the current time, fields tagged `created_at:"auto"` are never
+// updated.
+//
+// Entries of `fieldMask` may be struct field names (`FirstName`), the paths of a gRPC FieldMask (`first_name`), or
+// column names. ErrUnknownField is returned for entries naming no field.
+//
+// Returns ErrEmptyUpdate if no column would be set, and ErrMissingPrimaryKey if the statement would have no
+// WHERE clause, unless the AllowFullTableUpdate option is given.
+func BuildUpdateQuery(target string, source interface{}, fieldMask []string, opts ...Option) (string, []interface{}, error) {
+	o := newOptions(opts)
+	target = o.table(target, source)
+	qry, err := updateQuery(target, source, fieldMask, o)
+	if err != nil {
+		return "", nil, err
+	}
+	return o.bind(qry, source)
+}
+
+// updateQuery returns the named update statement bound by BuildUpdateQuery
+func updateQuery(target string, source interface{}, fieldMask []string, o *options) (string, error) {
 	reflectedValue := reflect.ValueOf(source).Elem()
-	var qb queryBuilder
-	fmt.Fprintf(&qb.Core, "UPDATE %s SET ", target)
+	fieldMask, err := normalizeMask(reflectedValue.Type(), target, fieldMask)
+	if err != nil {
+		return "", err
+	}
+	if err := o.checkStrict(target, reflectedValue); err != nil {
+		return "", err
+	}
+	if err := o.checkPermitted(target, reflectedValue, fieldMask, permUpdate); err != nil {
+		return "", err
+	}
+	qb := newQueryBuilder(o.dialect)
+	defer qb.release()
+	qb.Core.WriteString("UPDATE " + target + " SET ")
+	hasSet := false
+	qb.Predicate.WriteString(keyPredicate(primaryKeys(reflectedValue, target)))
 
 	for i := 0; i < reflectedValue.NumField(); i++ {
 		field := parseReflection(reflectedValue, i, target)
 
 		if field.value.CanInterface() && field.name != "" {
-			if field.isPrimaryKey {
-				fmt.Fprintf(&qb.Predicate, "WHERE %s.%s = :%s", target, field.name, field.name)
-			} else if findInMask(fieldMask, field.self.Name) && !field.shouldIgnore || field.value.CanInterface() && notDefault(field.typeStr, field.value.Interface()) {
-				fmt.Fprintf(&qb.Core, "%s.%s = :%s, ", target, field.name, field.name)
+			if field.isPrimaryKey || field.isCreatedAt || field.isReadonly {
+				continue
+			} else if field.isUpdatedAt && field.permits(permUpdate) {
+				qb.writeAssignment(o.dialect.assignable(target, field.name), o.nowExpr())
+			} else if field.assignedBy(fieldMask) {
+				qb.writeAssignment(o.dialect.assignable(target, field.name), ":"+field.name)
+				hasSet = true
 			}
 		}
 	}
 
-	return sqlx.Named(qb.getUpdateResult(), source)
+	if !hasSet {
+		return "", fmt.Errorf("%w: %s with field mask %v", ErrEmptyUpdate, target, fieldMask)
+	}
+	if qb.Predicate.Len() == 0 && !o.allowFullTableUpdate {
+		return "", fmt.Errorf("%w: refusing to update every row of %s", ErrMissingPrimaryKey, target)
+	}
+	where, err := o.whereClauses()
+	if err != nil {
+		return "", err
+	}
+	for _, clause := range where {
+		if qb.Predicate.Len() == 0 {
+			qb.Predicate.WriteString("WHERE " + clause)
+		} else {
+			qb.Predicate.WriteString(" AND " + clause)
+		}
+	}
+	return qb.getUpdateResult(), nil
 }
 
-// BuildRelatedReadQuery can be used to quickly build queries for many to one relationships
+// BuildRelatedReadQuery can be used to quickly build queries for many to one relationships, it returns an empty
+// string if a `null_default` of the related message is invalid
 // This method is still experimental
 func BuildRelatedReadQuery(source interface{}, foreignKey string, foreignValue interface{}) string {
-	var qb queryBuilder
+	qb := newQueryBuilder(CurrentConfig().Dialect)
+	defer qb.release()
 	reflectedValue := reflect.ValueOf(source).Elem()
 
 	for i := 0; i < reflectedValue.NumField(); i++ {
@@ -258,18 +736,18 @@ func BuildRelatedReadQuery(source interface{}, foreignKey string, foreignValue i
 
 		if foreignKeyTag == foreignKey && foreignTable != "" && localName != "" {
 			related := reflect.Indirect(field.value)
-			fmt.Fprintf(&qb.Core, "SELECT ")
+			qb.Core.WriteString("SELECT ")
 			if related.CanAddr() {
 				for j := 0; j < related.NumField(); j++ {
 					f := parseReflection(related, j, foreignTable)
-					if f.name != "" && f.value.CanInterface() {
-						qb.writeSelectField(f)
+					if f.name != "" && f.value.CanInterface() && qb.writeSelectField(f) != nil {
+						return ""
 					}
 				}
+				qb.Core.WriteString(qb.selectList())
 				fmt.Fprintf(
 					&qb.Core,
-					"%sFROM %s where %s.%s = %v",
-					qb.Fields.String(),
+					" FROM %s where %s.%s = %v",
 					foreignTable,
 					foreignTable,
 					foreignKey,
@@ -279,5 +757,5 @@ func BuildRelatedReadQuery(source interface{}, foreignKey string, foreignValue i
 		}
 	}
 	
-	return strings.Replace(qb.Core.String(), ", FROM", " FROM", 1)
+	return qb.Core.String()
 }
\ No newline at end of file
@@ -1,9 +1,28 @@
 package pbsql
 
 import (
+	"context"
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 type TestStruct struct {
@@ -21,6 +40,33 @@ type TestStruct struct {
 
 var target TestStruct
 
+type AuditedStruct struct {
+	ID        int32  `db:"id" primary_key:"y"`
+	Name      string `db:"name"`
+	CreatedAt string `db:"created_at" created_at:"auto"`
+	UpdatedAt string `db:"updated_at" updated_at:"auto"`
+}
+
+type UserRole struct {
+	UserID int32 `db:"user_id" primary_key:"y"`
+	RoleID int32 `db:"role_id" primary_key:"y"`
+	Level  int32 `db:"level"`
+}
+
+type Account struct {
+	ID           int32  `db:"id" primary_key:"y"`
+	Email        string `db:"email"`
+	PasswordHash string `db:"password_hash" writeonly:"y"`
+	DisplayName  string `db:"display_name" readonly:"y"`
+}
+
+type ContactFilter struct {
+	ID       int32  `db:"id" primary_key:"y"`
+	Name     string `db:"name" predicate_group:"contact"`
+	Email    string `db:"email" predicate_group:"contact"`
+	IsActive int32  `db:"is_active"`
+}
+
 var expectedCreateQry = "INSERT INTO test_table (test_table.date, test_table.geolocation_lat, test_table.geolocation_lng) VALUES (?, ?, ?)"
 
 var expectedReadQry = "SELECT test_table.id, ifnull(test_table.name, '') as name, ifnull(test_table.date, '') as date, ifnull(test_table.geolocation_lat, 0.0) as geolocation_lat, ifnull(test_table.geolocation_lng, 0.0) as geolocation_lng, test_table.is_active FROM test_table WHERE true AND test_table.id = ? AND test_table.date LIKE ? AND test_table.geolocation_lat = ? AND test_table.geolocation_lng = ? order by id asc"
@@ -73,6 +119,184 @@ func TestBuildRead(t *testing.T) {
 	}
 }
 
+func TestBuildReadFieldMask(t *testing.T) {
+	expected := "SELECT ifnull(test_table.name, '') as name, ifnull(test_table.geolocation_lat, 0.0) as geolocation_lat FROM test_table WHERE true AND test_table.id = ? AND test_table.date LIKE ? AND test_table.geolocation_lat = ? AND test_table.geolocation_lng = ? order by id asc"
+	qry, _, err := BuildReadQueryWithOptions("test_table", &target, WithFieldMask("Name", "geolocation_lat"))
+	if err != nil {
+		t.Fatal("BuildReadQueryWithOptions failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	// the variadic mask of BuildReadQuery filters on zero values as in BuildCountQuery, the select list is unchanged
+	filter := ContactFilter{Name: "someone"}
+	expected = "SELECT contact.id, contact.name, contact.email, contact.is_active FROM contact WHERE true AND contact.is_active = ? AND contact.name LIKE ?"
+	qry, args, err := BuildReadQuery("contact", &filter, "IsActive")
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+	if len(args) != 2 || args[0] != int32(0) {
+		t.Fatal("unexpected args", args)
+	}
+	expected = "SELECT COUNT(*) FROM contact WHERE TRUE AND contact.is_active = ? AND contact.name LIKE ?"
+	if qry, _, err = BuildCountQuery("contact", &filter, "IsActive"); err != nil || qry != expected {
+		t.Fatal("BuildCountQuery does not match the predicate mask of BuildReadQuery", qry, err)
+	}
+
+	if _, _, err := BuildReadQueryWithOptions("test_table", &target, WithFieldMask("Missing")); err == nil {
+		t.Fatal("expected an error for a field mask matching no columns")
+	}
+}
+
+func TestPredicateGroups(t *testing.T) {
+	filter := ContactFilter{Name: "someone", Email: "someone@example.com", IsActive: 1}
+	expected := "SELECT contact.id, contact.name, contact.email, contact.is_active FROM contact WHERE true AND contact.is_active = ? AND (contact.name LIKE ? OR contact.email LIKE ?)"
+	qry, args, err := BuildReadQuery("contact", &filter)
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+	if len(args) != 3 || args[0] != int32(1) || args[1] != "someone" {
+		t.Fatal("unexpected args", args)
+	}
+
+	filter.Email = ""
+	expected = "SELECT COUNT(*) FROM contact WHERE TRUE AND contact.is_active = ? AND contact.name LIKE ?"
+	qry, _, err = BuildCountQuery("contact", &filter)
+	if err != nil {
+		t.Fatal("BuildCountQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestWithWhere(t *testing.T) {
+	filter := ContactFilter{IsActive: 1}
+	where := WithWhere("contact.tags && :tags", map[string]interface{}{"tags": "{a,b}"})
+
+	expected := "SELECT contact.id, contact.name, contact.email, contact.is_active FROM contact WHERE true AND contact.is_active = $1 AND contact.tags && $2"
+	qry, args, err := BuildReadQueryWithOptions("contact", &filter, where, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildReadQueryWithOptions failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+	if len(args) != 2 || args[1] != "{a,b}" {
+		t.Fatal("unexpected args", args)
+	}
+
+	expected = "SELECT COUNT(*) FROM contact WHERE TRUE AND contact.is_active = ? AND contact.tags && ?"
+	qry, _, err = BuildCountQueryWithOptions("contact", &filter, where)
+	if err != nil {
+		t.Fatal("BuildCountQueryWithOptions failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	filter.ID = 3
+	expected = "UPDATE contact SET contact.is_active = ? WHERE contact.id = ? AND contact.tags && ?"
+	qry, args, err = BuildDeleteQuery("contact", &filter, where)
+	if err != nil {
+		t.Fatal("BuildDeleteQuery failed", err)
+	}
+	if qry != expected || len(args) != 3 || args[0] != 0 {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	for _, clause := range []string{"true; DROP TABLE contact", "contact.id = 1 -- ", "contact.id = 1) OR (true", "name = 'x", "id = 1 # "} {
+		if _, _, err := BuildReadQueryWithOptions("contact", &filter, WithWhere(clause, nil)); !errors.Is(err, ErrUnsafePredicate) {
+			t.Fatal("expected ErrUnsafePredicate for", clause, "got", err)
+		}
+	}
+	if _, _, err := BuildReadQueryWithOptions("contact", &filter, WithWhere("name = 'a;b'", nil)); err != nil {
+		t.Fatal("quoted terminators should be allowed", err)
+	}
+}
+
+func TestBuildSelectQuery(t *testing.T) {
+	q, err := BuildSelectQuery("test_table", &target, WithFieldMask("ID"))
+	if err != nil {
+		t.Fatal("BuildSelectQuery failed", err)
+	}
+	q.Where = append(q.Where, "test_table.name <> :excluded")
+	q.Params = map[string]interface{}{"excluded": "nobody"}
+	q.OrderBy = "name desc"
+	q.Limit = 10
+
+	expected := "SELECT test_table.id FROM test_table WHERE true AND test_table.id = ? AND test_table.date LIKE ? AND test_table.geolocation_lat = ? AND test_table.geolocation_lng = ? AND test_table.name <> ? order by name desc LIMIT 10"
+	qry, args, err := q.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+	if len(args) != 5 || args[0] != target.ID || args[4] != "nobody" {
+		t.Fatal("unexpected args", args)
+	}
+}
+
+func TestBuildReadByPK(t *testing.T) {
+	expected := "SELECT test_table.id, coalesce(test_table.name, '') as name FROM test_table WHERE test_table.id = $1"
+	qry, args, err := BuildReadByPKQuery("test_table", &target, WithFieldMask("ID", "Name"), WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildReadByPKQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+	if len(args) != 1 || args[0] != target.ID {
+		t.Fatal("expected only the primary key to be bound, got", args)
+	}
+}
+
+func TestBinders(t *testing.T) {
+	expected, expectedArgs, err := BuildCreateQuery("test_table", &target, WithBinder(SQLXBinder))
+	if err != nil {
+		t.Fatal(err)
+	}
+	qry, args, err := BuildCreateQuery("test_table", &target, WithBinder(SQLBinder))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Fatal("binders disagree on args", args, expectedArgs)
+	}
+
+	qry, args, err = SQLBinder.Bind("SELECT '00::00' FROM t WHERE id = :id AND name = :name", map[string]interface{}{"id": 1, "name": "x"}, Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qry != "SELECT '00:00' FROM t WHERE id = $1 AND name = $2" || !reflect.DeepEqual(args, []interface{}{1, "x"}) {
+		t.Fatal("unexpected bind result", qry, args)
+	}
+	if _, _, err := SQLBinder.Bind("SELECT * FROM t WHERE id = :missing", &target, MySQL); err == nil {
+		t.Fatal("expected an error for an unknown param")
+	}
+}
+
 func TestBuildSearch(t *testing.T) {
 	qry, _, err := BuildSearchQuery("transaction", &testTxn, "search")
 	log.Print(qry)
@@ -103,6 +327,69 @@ func TestBuildUpdate(t *testing.T) {
 	*/
 }
 
+func TestBuildUpdatePostgres(t *testing.T) {
+	expected := "UPDATE test_table SET date = $1, geolocation_lat = $2, geolocation_lng = $3 WHERE test_table.id = $4"
+	qry, args, err := BuildUpdateQuery("test_table", &target, nil, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildUpdateQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+	if len(args) != 4 {
+		t.Fatal("expected 4 args, got", len(args))
+	}
+}
+
+func TestBuildUpdateValidation(t *testing.T) {
+	empty := TestStruct{ID: 1}
+	if _, _, err := BuildUpdateQuery("test_table", &empty, nil); !errors.Is(err, ErrEmptyUpdate) {
+		t.Fatal("expected ErrEmptyUpdate, got", err)
+	}
+
+	type noKey struct {
+		Name string `db:"name"`
+	}
+	source := noKey{Name: "name"}
+	if _, _, err := BuildUpdateQuery("test_table", &source, nil); !errors.Is(err, ErrMissingPrimaryKey) {
+		t.Fatal("expected ErrMissingPrimaryKey, got", err)
+	}
+	qry, _, err := BuildUpdateQuery("test_table", &source, nil, AllowFullTableUpdate())
+	if err != nil {
+		t.Fatal("BuildUpdateQuery failed", err)
+	}
+	if qry != "UPDATE test_table SET test_table.name = ?" {
+		t.Fatal("unexpected full table update", qry)
+	}
+}
+
+func TestBuildUpdateFieldMask(t *testing.T) {
+	type profile struct {
+		ID          int32  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty" db:"id" primary_key:"y"`
+		DisplayName string `protobuf:"bytes,2,opt,name=display_name,json=displayName,proto3" json:"display_name,omitempty" db:"name"`
+		Bio         string `protobuf:"bytes,3,opt,name=bio,proto3" json:"bio,omitempty" db:"bio"`
+	}
+
+	source := profile{ID: 1}
+	expected := "UPDATE profile SET name = $1, bio = $2 WHERE profile.id = $3"
+	for _, mask := range [][]string{{"DisplayName", "Bio"}, {"display_name", "bio"}, {"displayName", "bio"}, {"name", "Bio"}} {
+		qry, args, err := BuildUpdateQuery("profile", &source, mask, WithDialect(Postgres))
+		if err != nil {
+			t.Fatal("BuildUpdateQuery failed", mask, err)
+		}
+		if qry != expected || len(args) != 3 {
+			t.Log("Got:", qry, args)
+			t.Fatal("Expected:", expected)
+		}
+	}
+
+	_, _, err := BuildUpdateQuery("profile", &source, []string{"bio", "avatar", "email"})
+	if !errors.Is(err, ErrUnknownField) || !strings.Contains(err.Error(), "avatar, email") {
+		t.Fatal("expected ErrUnknownField listing the unknown entries, got", err)
+	}
+}
+
 func TestBuildDelete(t *testing.T) {
 	qry, _, err := BuildDeleteQuery("test_table", &target)
 	if err != nil {
@@ -114,3 +401,2394 @@ func TestBuildDelete(t *testing.T) {
 		t.Fatal("Expected:", expectedDeleteQry)
 	}
 }
+
+func TestBuildDeleteWhere(t *testing.T) {
+	qry, _, err := BuildDeleteWhereQuery("user_role", &UserRole{RoleID: 2}, WithFieldMask("Level"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "DELETE FROM user_role WHERE user_role.role_id = ? AND user_role.level = ?"; qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	qry, args, err := BuildDeleteWhereQuery("test_table", &TestStruct{PropertyID: 7, Name: "a%"}, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "UPDATE test_table SET is_active = $1 WHERE test_table.name = $2 AND test_table.property_id = $3"; qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+	if !reflect.DeepEqual(args, []interface{}{0, "a%", int32(7)}) {
+		t.Fatal("unexpected args", args)
+	}
+
+	if _, _, err := BuildDeleteWhereQuery("user_role", &UserRole{}); !errors.Is(err, ErrMissingPredicate) {
+		t.Fatal("expected ErrMissingPredicate, got", err)
+	}
+}
+
+func TestSoftDeleteValue(t *testing.T) {
+	source := TestStruct{ID: 3, IsActive: 1}
+	_, args, err := BuildDeleteQuery("test_table", &source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args, []interface{}{0, int32(3)}) {
+		t.Fatal("expected the deactivated value to be bound rather than the field, got", args)
+	}
+
+	cfg := DefaultConfig()
+	cfg.SoftDelete.Value = -1
+	if _, args, err = BuildDeleteQuery("test_table", &source, WithConfig(cfg)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args, []interface{}{-1, int32(3)}) {
+		t.Fatal("expected the configured value to be bound, got", args)
+	}
+
+	qry, _, err := BuildDeleteQuery("test_table", &source, WithHardDelete())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "DELETE FROM test_table WHERE test_table.id = ?"; qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+type testProperty struct {
+	PropertyID int32 `db:"property_id" primary_key:"y"`
+}
+
+type testNote struct {
+	ID     int32 `db:"id" primary_key:"y"`
+	TestID int32 `db:"test_id" foreign_key:"id" foreign_table:"test_table"`
+}
+
+func TestBuildCascadeDelete(t *testing.T) {
+	dependents := []Dependent{{Table: "test_note", Message: testNote{}}, {Table: "test_table", Message: &target}}
+	statements, err := BuildCascadeDeleteQueries("properties", &testProperty{PropertyID: 4}, dependents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := "SELECT properties.property_id FROM properties WHERE properties.property_id = ?"
+	expected := []string{
+		"DELETE FROM test_note WHERE test_note.test_id IN (SELECT test_table.id FROM test_table WHERE test_table.property_id IN (" + properties + "))",
+		"UPDATE test_table SET test_table.is_active = ? WHERE test_table.property_id IN (" + properties + ")",
+		"DELETE FROM properties WHERE properties.property_id = ?",
+	}
+	if len(statements) != len(expected) {
+		t.Fatal("unexpected statements", statements)
+	}
+	for i, qry := range expected {
+		if statements[i].Query != qry {
+			t.Log("Got:", statements[i].Query)
+			t.Fatal("Expected:", qry)
+		}
+	}
+	if !reflect.DeepEqual(statements[1].Args, []interface{}{0, int32(4)}) {
+		t.Fatal("unexpected args", statements[1].Args)
+	}
+
+	statements, err = BuildCascadeDeleteQueries("properties", &testProperty{PropertyID: 4}, dependents, WithHardDelete())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(statements[1].Query, "DELETE FROM test_table WHERE") {
+		t.Fatal("expected WithHardDelete to apply to dependents, got", statements[1].Query)
+	}
+}
+
+type testRole struct {
+	ID   int32  `db:"id" primary_key:"y"`
+	Name string `db:"name"`
+}
+
+type testMember struct {
+	ID    int32       `db:"id" primary_key:"y"`
+	Roles []*testRole `m2m:"member_role,member_id,role_id" foreign_table:"role"`
+}
+
+func TestBuildAssociationQueries(t *testing.T) {
+	member := testMember{ID: 1, Roles: []*testRole{{ID: 2}, {ID: 3}}}
+	statements, err := BuildAssociationQueries("member", &member, "Roles", []interface{}{int64(3), int64(4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Statement{
+		{Query: "DELETE FROM member_role WHERE member_role.member_id = ? AND member_role.role_id IN (?)", Args: []interface{}{int32(1), int64(4)}},
+		{Query: "INSERT INTO member_role (member_id, role_id) VALUES (?, ?)", Args: []interface{}{int32(1), int32(2)}},
+	}
+	if !reflect.DeepEqual(statements, expected) {
+		t.Fatal("unexpected statements", statements)
+	}
+
+	statements, err = BuildAssociationQueries("member", &member, "Roles", []interface{}{int64(2), int64(3)})
+	if err != nil || len(statements) != 0 {
+		t.Fatal("expected nothing to reconcile", statements, err)
+	}
+}
+
+func TestCompositePrimaryKey(t *testing.T) {
+	source := UserRole{UserID: 1, RoleID: 2, Level: 3}
+	expected := map[string]string{
+		"create": "INSERT INTO user_role (user_role.user_id, user_role.role_id, user_role.level) VALUES (?, ?, ?)",
+		"update": "UPDATE user_role SET user_role.level = ? WHERE user_role.user_id = ? AND user_role.role_id = ?",
+		"delete": "DELETE FROM user_role WHERE user_role.user_id = ? AND user_role.role_id = ?",
+		"upsert": "INSERT INTO user_role (user_id, role_id, level) VALUES ($1, $2, $3) ON CONFLICT (user_id, role_id) DO UPDATE SET level = EXCLUDED.level",
+	}
+	got := make(map[string]string)
+	var err error
+	if got["create"], _, err = BuildCreateQuery("user_role", &source); err != nil {
+		t.Fatal(err)
+	}
+	if got["update"], _, err = BuildUpdateQuery("user_role", &source, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got["delete"], _, err = BuildDeleteQuery("user_role", &source); err != nil {
+		t.Fatal(err)
+	}
+	if got["upsert"], _, err = BuildUpsertQuery("user_role", &source, WithDialect(Postgres)); err != nil {
+		t.Fatal(err)
+	}
+	for op, qry := range expected {
+		if got[op] != qry {
+			t.Log("Got:", got[op])
+			t.Fatal("Expected:", qry)
+		}
+	}
+}
+
+func TestBuilderConfig(t *testing.T) {
+	source := UserRole{UserID: 1, RoleID: 2, Level: 3}
+	mysql := NewBuilder(DefaultConfig())
+	postgres := NewBuilder(Config{Dialect: Postgres, SoftDelete: SoftDelete{Field: "Level", Column: "level"}})
+	expected := map[*Builder]string{
+		mysql:    "DELETE FROM user_role WHERE user_role.user_id = ? AND user_role.role_id = ?",
+		postgres: "UPDATE user_role SET level = $1 WHERE user_role.user_id = $2 AND user_role.role_id = $3",
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*len(expected))
+	for b, want := range expected {
+		wg.Add(2)
+		go func(b *Builder, want string) {
+			defer wg.Done()
+			qry, _, err := b.BuildDeleteQuery("user_role", &source)
+			if err == nil && qry != want {
+				err = fmt.Errorf("got %q, expected %q", qry, want)
+			}
+			errs <- err
+		}(b, want)
+		go func(b *Builder) {
+			defer wg.Done()
+			_, _, err := b.BuildReadQuery("user_role", &source)
+			errs <- err
+		}(b)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	SetConfig(Config{Dialect: Postgres})
+	defer SetConfig(DefaultConfig())
+	qry, _, err := BuildDeleteQuery("test_table", &target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "DELETE FROM test_table WHERE test_table.id = $1"; qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestBuildCreateTable(t *testing.T) {
+	expected := "CREATE TABLE test_table (id INT NOT NULL AUTO_INCREMENT, name VARCHAR(255), date VARCHAR(255), geolocation_lat DOUBLE, geolocation_lng DOUBLE, is_active INT NOT NULL, property_id INT NOT NULL, PRIMARY KEY (id), FOREIGN KEY (property_id) REFERENCES properties (property_id))"
+	qry, err := BuildCreateTableQuery("test_table", &target)
+	if err != nil {
+		t.Fatal("BuildCreateTableQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "CREATE TABLE audited (id SERIAL, name TEXT NOT NULL, created_at TIMESTAMP NOT NULL, updated_at TIMESTAMP NOT NULL, PRIMARY KEY (id))"
+	qry, err = BuildCreateTableQuery("audited", &AuditedStruct{}, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildCreateTableQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "CREATE TABLE user_role (user_id INTEGER NOT NULL, role_id INTEGER NOT NULL, level INTEGER NOT NULL, PRIMARY KEY (user_id, role_id))"
+	qry, err = BuildCreateTableQuery("user_role", &UserRole{}, WithDialect(SQLite))
+	if err != nil {
+		t.Fatal("BuildCreateTableQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestBuildMigration(t *testing.T) {
+	snapshot, err := SchemaSnapshot("audited", &AuditedStruct{}, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("SchemaSnapshot failed", err)
+	}
+	type audited struct {
+		ID        int32   `db:"id" primary_key:"y"`
+		Name      string  `db:"name" nullable:"y"`
+		Score     float64 `db:"score"`
+		CreatedAt string  `db:"created_at" created_at:"auto"`
+	}
+	expected := []string{
+		"ALTER TABLE audited ALTER COLUMN name DROP NOT NULL",
+		"ALTER TABLE audited ADD COLUMN score DOUBLE PRECISION NOT NULL",
+		"ALTER TABLE audited DROP COLUMN updated_at",
+	}
+	queries, err := BuildMigrationQueries("audited", &audited{}, snapshot, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildMigrationQueries failed", err)
+	}
+	if !reflect.DeepEqual(queries, expected) {
+		t.Log("Got:", queries)
+		t.Fatal("Expected:", expected)
+	}
+
+	live := []ColumnInfo{{Name: "id", DataType: "int"}, {Name: "name", DataType: "int"}}
+	expected = []string{
+		"ALTER TABLE audited MODIFY COLUMN name VARCHAR(255) NOT NULL",
+		"ALTER TABLE audited ADD COLUMN created_at TIMESTAMP NOT NULL",
+		"ALTER TABLE audited ADD COLUMN updated_at TIMESTAMP NOT NULL",
+	}
+	queries, err = BuildMigrationQueries("audited", &AuditedStruct{}, live)
+	if err != nil {
+		t.Fatal("BuildMigrationQueries failed", err)
+	}
+	if !reflect.DeepEqual(queries, expected) {
+		t.Log("Got:", queries)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestSnakeCaseFallback(t *testing.T) {
+	type snake struct {
+		ID          int32 `db:"id" primary_key:"y"`
+		DisplayName string
+		GeoLat      float64
+		Secret      string `db:"-"`
+		Related     *TestStruct
+		OrderBy     string
+	}
+	source := snake{ID: 1, DisplayName: "someone", Secret: "hidden", OrderBy: "display_name"}
+
+	expected := "SELECT snake.id FROM snake WHERE true AND snake.id = ? order by display_name asc"
+	if qry, _, err := BuildReadQuery("snake", &source); err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	SetSnakeCaseFallback(true)
+	defer SetSnakeCaseFallback(false)
+	expected = "SELECT snake.id, snake.display_name, snake.geo_lat FROM snake WHERE true AND snake.id = ? AND snake.display_name LIKE ? order by display_name asc"
+	qry, args, err := BuildReadQuery("snake", &source)
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+	if len(args) != 2 || args[1] != "someone" {
+		t.Fatal("unexpected args", args)
+	}
+}
+
+func TestReadonlyWriteonly(t *testing.T) {
+	account := Account{ID: 1, Email: "someone@example.com", PasswordHash: "hash", DisplayName: "someone"}
+
+	expected := "INSERT INTO account (account.email, account.password_hash) VALUES (?, ?)"
+	qry, _, err := BuildCreateQuery("account", &account)
+	if err != nil {
+		t.Fatal("BuildCreateQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "UPDATE account SET account.email = ?, account.password_hash = ? WHERE account.id = ?"
+	qry, _, err = BuildUpdateQuery("account", &account, []string{"DisplayName"})
+	if err != nil {
+		t.Fatal("BuildUpdateQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT account.id, account.email, account.display_name FROM account WHERE account.id = ?"
+	qry, _, err = BuildReadByPKQuery("account", &account)
+	if err != nil {
+		t.Fatal("BuildReadByPKQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestExprColumns(t *testing.T) {
+	type person struct {
+		ID        int32  `db:"id" primary_key:"y"`
+		FirstName string `db:"first_name"`
+		LastName  string `db:"last_name"`
+		FullName  string `db:"full_name" expr:"CONCAT(first_name, ' ', last_name)"`
+		Label     string `db:"label" expr:"id::text" nullable:"y"`
+	}
+	source := person{FirstName: "some", LastName: "one", FullName: "some%"}
+
+	expected := "SELECT person.id, person.first_name, person.last_name, CONCAT(first_name, ' ', last_name) as full_name, coalesce(id::text, '') as label FROM person WHERE true AND person.first_name LIKE $1 AND person.last_name LIKE $2 AND (CONCAT(first_name, ' ', last_name)) LIKE $3"
+	qry, args, err := BuildReadQueryWithOptions("person", &source, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildReadQueryWithOptions failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+	if len(args) != 3 || args[2] != "some%" {
+		t.Fatal("unexpected args", args)
+	}
+
+	expected = "INSERT INTO person (person.first_name, person.last_name) VALUES (?, ?)"
+	qry, _, err = BuildCreateQuery("person", &source)
+	if err != nil {
+		t.Fatal("BuildCreateQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestGeoPredicates(t *testing.T) {
+	type place struct {
+		ID     int32     `db:"id" primary_key:"y"`
+		Lat    float64   `db:"lat" geo:"lat:location"`
+		Lng    float64   `db:"lng" geo:"lng:location"`
+		Radius float64   `geo:"radius:location"`
+		BBox   []float64 `geo:"bbox:location"`
+	}
+
+	source := place{Lat: 40.5, Lng: -73.25, Radius: 1500}
+	expected := "SELECT place.id, place.lat, place.lng FROM place WHERE true AND 6371000 * 2 * ASIN(SQRT(POWER(SIN(RADIANS(place.lat - 40.5) / 2), 2) + COS(RADIANS(40.5)) * COS(RADIANS(place.lat)) * POWER(SIN(RADIANS(place.lng - -73.25) / 2), 2))) <= 1500"
+	qry, args, err := BuildReadQuery("place", &source)
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+	if len(args) != 0 {
+		t.Fatal("the center must not be matched exactly", args)
+	}
+
+	source = place{BBox: []float64{40, -74, 41, -73}}
+	expected = "SELECT COUNT(*) FROM place WHERE TRUE AND ST_Within(ST_SetSRID(ST_MakePoint(place.lng, place.lat), 4326), ST_MakeEnvelope(-74, 40, -73, 41, 4326))"
+	qry, _, err = BuildCountQueryWithOptions("place", &source, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildCountQueryWithOptions failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	source.BBox = []float64{40, -74}
+	if _, _, err := BuildReadQuery("place", &source); err == nil {
+		t.Fatal("expected an error for an incomplete bounding box")
+	}
+}
+
+func TestJSONColumns(t *testing.T) {
+	type document struct {
+		ID       int32             `db:"id" primary_key:"y"`
+		Metadata map[string]string `db:"metadata" json_column:"y"`
+	}
+
+	source := document{Metadata: map[string]string{"owner": "someone"}}
+	expected := "INSERT INTO document (document.metadata) VALUES (?)"
+	qry, args, err := BuildCreateQuery("document", &source)
+	if err != nil {
+		t.Fatal("BuildCreateQuery failed", err)
+	}
+	if qry != expected || len(args) != 1 || args[0] != `{"owner":"someone"}` {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT document.id, document.metadata FROM document WHERE true AND document.metadata @> CAST($1 AS jsonb)"
+	qry, args, err = BuildReadQueryWithOptions("document", &source, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildReadQueryWithOptions failed", err)
+	}
+	if qry != expected || len(args) != 1 || args[0] != `{"owner":"someone"}` {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT document.id, document.metadata FROM document WHERE true AND JSON_CONTAINS(document.metadata, ?)"
+	qry, _, err = BuildReadQuery("document", &source)
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestArrayColumns(t *testing.T) {
+	type article struct {
+		ID        int32    `db:"id" primary_key:"y"`
+		Tags      []string `db:"tags" array:"column"`
+		AuthorIds []int64  `db:"author_id" array:"in"`
+	}
+
+	source := article{Tags: []string{"go", "sql"}}
+	expected := "INSERT INTO article (tags) VALUES ($1)"
+	qry, args, err := BuildCreateQuery("article", &source, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildCreateQuery failed", err)
+	}
+	if qry != expected || len(args) != 1 || !reflect.DeepEqual(args[0], pq.Array(source.Tags)) {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	source.AuthorIds = []int64{1, 2}
+	expected = "SELECT article.id, article.tags FROM article WHERE true AND article.tags && $1 AND article.author_id = ANY($2)"
+	qry, args, err = BuildReadQueryWithOptions("article", &source, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildReadQueryWithOptions failed", err)
+	}
+	if qry != expected || len(args) != 2 || !reflect.DeepEqual(args[1], pq.Array(source.AuthorIds)) {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT article.id, article.tags FROM article WHERE true AND NOT (article.tags && ?) AND article.author_id <> ALL(?)"
+	qry, _, err = BuildReadQueryWithNotList("article", &source, []string{"Tags", "AuthorIds"})
+	if err != nil {
+		t.Fatal("BuildReadQueryWithNotList failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "CREATE TABLE article (id SERIAL, tags TEXT[] NOT NULL, PRIMARY KEY (id))"
+	qry, err = BuildCreateTableQuery("article", &source, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildCreateTableQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestConverters(t *testing.T) {
+	type invoice struct {
+		ID       int32  `db:"id" primary_key:"y"`
+		Ref      string `db:"ref" convert:"uuid"`
+		Total    string `db:"total" convert:"money"`
+		Currency string `db:"currency"`
+	}
+
+	source := invoice{Ref: "6ba7b810-9dad-11d1-80b4-00c04fd430c8", Total: "-12.3"}
+	expected := "INSERT INTO invoice (invoice.ref, invoice.total) VALUES (?, ?)"
+	qry, args, err := BuildCreateQuery("invoice", &source)
+	if err != nil {
+		t.Fatal("BuildCreateQuery failed", err)
+	}
+	ref := []byte{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+	if qry != expected || len(args) != 2 || !reflect.DeepEqual(args[0], ref) || args[1] != int64(-1230) {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT invoice.id, invoice.ref, invoice.total, invoice.currency FROM invoice WHERE true AND invoice.ref = ? AND invoice.total = ?"
+	qry, _, err = BuildReadQuery("invoice", &source)
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	source.Total = "12.345"
+	if _, _, err := BuildCreateQuery("invoice", &source); err == nil {
+		t.Fatal("expected an error for a fraction of a cent")
+	}
+
+	type unknown struct {
+		Code string `db:"code" convert:"unknown"`
+	}
+	if _, _, err := BuildCreateQuery("unknown", &unknown{Code: "x"}); err == nil {
+		t.Fatal("expected an error for an unregistered converter")
+	}
+}
+
+type testStatus int32
+
+func TestStringEnums(t *testing.T) {
+	RegisterEnum(testStatus(0), map[int32]string{1: "OPEN", 2: "CLOSED"})
+	type ticket struct {
+		ID       int32        `db:"id" primary_key:"y"`
+		Status   testStatus   `db:"status" enum:"string"`
+		Statuses []testStatus `db:"status" array:"in" enum:"string"`
+	}
+
+	source := ticket{Status: 2}
+	expected := "INSERT INTO ticket (ticket.status) VALUES (?)"
+	qry, args, err := BuildCreateQuery("ticket", &source)
+	if err != nil {
+		t.Fatal("BuildCreateQuery failed", err)
+	}
+	if qry != expected || len(args) != 1 || args[0] != "CLOSED" {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	filter := ticket{Statuses: []testStatus{1, 2}}
+	expected = "SELECT ticket.id, ticket.status FROM ticket WHERE true AND ticket.status = ANY($1)"
+	qry, args, err = BuildReadQueryWithOptions("ticket", &filter, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected || len(args) != 1 || !reflect.DeepEqual(args[0], pq.Array([]string{"OPEN", "CLOSED"})) {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	c, err := converterOf(reflect.TypeOf(ticket{}).Field(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := c.Decode([]byte("OPEN")); err != nil || decoded != testStatus(1) {
+		t.Fatal("expected OPEN to decode to 1, got", decoded, err)
+	}
+	if _, err := c.Decode("PENDING"); err == nil {
+		t.Fatal("expected an error for an unknown enum string")
+	}
+	if _, _, err := BuildCreateQuery("ticket", &ticket{Status: 3}); err == nil {
+		t.Fatal("expected an error for an enum value without a string")
+	}
+}
+
+func TestBools(t *testing.T) {
+	type flag struct {
+		ID       int32                 `db:"id" primary_key:"y"`
+		Enabled  bool                  `db:"enabled" nullable:"y"`
+		Archived *bool                 `db:"archived"`
+		Public   *wrapperspb.BoolValue `db:"public"`
+	}
+
+	expected := "SELECT flag.id, coalesce(flag.enabled, FALSE) as enabled, flag.archived, flag.public FROM flag WHERE true AND flag.archived = $1 AND flag.public = $2"
+	archived := false
+	qry, args, err := BuildReadQueryWithOptions("flag", &flag{Archived: &archived, Public: wrapperspb.Bool(false)}, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected || len(args) != 2 || args[0] != false || args[1] != false {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT flag.id, ifnull(flag.enabled, 0) as enabled, flag.archived, flag.public FROM flag WHERE true"
+	qry, args, err = BuildReadQueryWithOptions("flag", &flag{})
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected || len(args) != 0 {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestPresence(t *testing.T) {
+	type counter struct {
+		ID    int32                   `db:"id" primary_key:"y"`
+		Limit *int64                  `db:"limit_count"`
+		Label *wrapperspb.StringValue `db:"label"`
+	}
+
+	expected := "SELECT counter.id, counter.limit_count, counter.label FROM counter WHERE true AND counter.limit_count = $1 AND counter.label = $2"
+	qry, args, err := BuildReadQueryWithOptions("counter", &counter{Limit: new(int64), Label: wrapperspb.String("")}, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected || len(args) != 2 || args[0] != int64(0) || args[1] != "" {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	// fields of protobuf messages are set when protoreflect reports them present, even if they hold their zero value
+	if err := RegisterColumnAliases(&descriptorpb.FieldDescriptorProto{}, map[string]string{"name": "name", "number": "number", "type_name": "type_name"}); err != nil {
+		t.Fatal(err)
+	}
+	defer RegisterColumnAliases(&descriptorpb.FieldDescriptorProto{}, nil)
+	expected = "SELECT field.name, field.number, field.type_name FROM field WHERE true AND field.number = $1"
+	qry, args, err = BuildReadQueryWithOptions("field", &descriptorpb.FieldDescriptorProto{Number: proto.Int32(0)}, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected || len(args) != 1 || args[0] != int32(0) {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestSetFields(t *testing.T) {
+	type invoice struct {
+		ID     int32   `db:"id" primary_key:"y"`
+		Amount float64 `db:"amount" protobuf:"fixed64,2,opt,name=amount,proto3"`
+		Status int32   `db:"status"`
+	}
+
+	expected := "SELECT invoice.id, invoice.amount, invoice.status FROM invoice WHERE true AND invoice.amount = ?"
+	qry, args, err := BuildReadQueryWithOptions("invoice", &invoice{}, WithSetFields("amount"))
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected || len(args) != 1 || args[0] != float64(0) {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "DELETE FROM invoice WHERE invoice.amount = ? AND invoice.status = ?"
+	qry, _, err = BuildDeleteWhereQuery("invoice", &invoice{Status: 2}, WithSetFields("Amount"))
+	if err != nil {
+		t.Fatal("BuildDeleteWhereQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	if _, _, err := BuildReadQueryWithOptions("invoice", &invoice{}, WithSetFields("total")); !errors.Is(err, ErrUnknownField) {
+		t.Fatal("expected ErrUnknownField, got", err)
+	}
+
+	type task struct {
+		ID     int32  `db:"id" primary_key:"y"`
+		Status int32  `db:"status"`
+		Title  string `db:"title"`
+		Notes  string `db:"notes"`
+	}
+	expected = "SELECT task.id, task.status, task.title, task.notes FROM task WHERE true AND task.status = ? AND (task.title LIKE ? OR task.notes LIKE ?)"
+	qry, args, err = BuildSearchQuery("task", &task{}, "foo", WithSetFields("Status", "Title"))
+	if err != nil {
+		t.Fatal("BuildSearchQuery failed", err)
+	}
+	if qry != expected || len(args) != 3 || args[0] != int32(0) || args[1] != "foo" || args[2] != "foo" {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestCreateZeroValues(t *testing.T) {
+	type stock struct {
+		ID       int32  `db:"id" primary_key:"y"`
+		SKU      string `db:"sku"`
+		Quantity int32  `db:"quantity"`
+		Note     string `db:"note"`
+	}
+
+	expected := "INSERT INTO stock (stock.sku, stock.quantity) VALUES (?, ?)"
+	qry, args, err := BuildCreateQuery("stock", &stock{SKU: "A-1"}, WithSetFields("quantity"))
+	if err != nil {
+		t.Fatal("BuildCreateQuery failed", err)
+	}
+	if qry != expected || len(args) != 2 || args[1] != int32(0) {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "INSERT INTO stock (sku, quantity, note) VALUES ($1, $2, $3)"
+	qry, _, err = BuildCreateQuery("stock", &stock{SKU: "A-1"}, WithZeroValues(), WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildCreateQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	if _, _, err := BuildCreateQuery("stock", &stock{}, WithSetFields("count")); !errors.Is(err, ErrUnknownField) {
+		t.Fatal("expected ErrUnknownField, got", err)
+	}
+}
+
+func TestSkippedColumns(t *testing.T) {
+	// the first and last fields are left out of every statement, which must not leave a separator behind
+	type note struct {
+		Internal string `db:"internal" writeonly:"y" readonly:"y"`
+		ID       int32  `db:"id" primary_key:"y"`
+		Title    string `db:"title"`
+		Body     string `db:"body"`
+		Computed string `db:"computed" readonly:"y" writeonly:"y"`
+	}
+
+	qry, _, err := BuildCreateQuery("note", &note{Body: "b", Computed: "c"})
+	if expected := "INSERT INTO note (note.body) VALUES (?)"; err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+	qry, _, err = BuildUpdateQuery("note", &note{ID: 1, Title: "t", Internal: "i"}, nil)
+	if expected := "UPDATE note SET note.title = ? WHERE note.id = ?"; err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+	qry, _, err = BuildReadQueryWithOptions("note", &note{}, WithFieldMask("title", "body"))
+	if expected := "SELECT note.title, note.body FROM note WHERE true"; err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestInsertDefaults(t *testing.T) {
+	type session struct {
+		ID     string `db:"id" primary_key:"y" insert_default:"gen_random_uuid()"`
+		UserID int32  `db:"user_id"`
+		Scope  string `db:"scope" insert_default:"'read'::text"`
+		Note   string `db:"note"`
+	}
+
+	expected := "INSERT INTO session (id, user_id, scope) VALUES (gen_random_uuid(), $1, 'read'::text)"
+	qry, args, err := BuildCreateQuery("session", &session{UserID: 4}, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildCreateQuery failed", err)
+	}
+	if qry != expected || len(args) != 1 || args[0] != int32(4) {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "INSERT INTO session (id, user_id, scope) VALUES (gen_random_uuid(), $1, $2)"
+	qry, args, err = BuildCreateQuery("session", &session{UserID: 4, Scope: "write"}, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildCreateQuery failed", err)
+	}
+	if qry != expected || len(args) != 2 || args[1] != "write" {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestGeneratedKeys(t *testing.T) {
+	type order struct {
+		ID     string `db:"id" primary_key:"y" pk_gen:"uuid"`
+		Amount int64  `db:"amount"`
+	}
+	type event struct {
+		ID   []byte `db:"id" primary_key:"y" pk_gen:"uuidv7"`
+		Kind string `db:"kind"`
+	}
+
+	source := &order{Amount: 12}
+	expected := "INSERT INTO order (order.id, order.amount) VALUES (?, ?)"
+	qry, args, err := BuildCreateQuery("order", source)
+	if err != nil {
+		t.Fatal("BuildCreateQuery failed", err)
+	}
+	if qry != expected || len(args) != 2 || args[0] != source.ID {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(source.ID) {
+		t.Fatal("expected a version 4 uuid, got", source.ID)
+	}
+	id := source.ID
+	if _, _, err := BuildCreateQuery("order", source); err != nil || source.ID != id {
+		t.Fatal("expected a set key to be kept, got", source.ID, err)
+	}
+
+	ev := &event{Kind: "signup"}
+	before := time.Now().UnixMilli()
+	if _, _, err := BuildCreateQuery("event", ev, WithDialect(Postgres)); err != nil {
+		t.Fatal("BuildCreateQuery failed", err)
+	}
+	if len(ev.ID) != 16 || ev.ID[6]>>4 != 7 {
+		t.Fatal("expected a version 7 uuid, got", ev.ID)
+	}
+	if ms := int64(ev.ID[0])<<40 | int64(ev.ID[1])<<32 | int64(ev.ID[2])<<24 | int64(ev.ID[3])<<16 | int64(ev.ID[4])<<8 | int64(ev.ID[5]); ms < before {
+		t.Fatal("expected the uuid to start with the current time, got", ms)
+	}
+
+	type bad struct {
+		ID int32 `db:"id" primary_key:"y" pk_gen:"uuid"`
+	}
+	if _, _, err := BuildCreateQuery("bad", &bad{}); err == nil {
+		t.Fatal("expected an error generating a uuid for an integer key")
+	}
+}
+
+func TestBulkUpdate(t *testing.T) {
+	type task struct {
+		ID         int32  `db:"id" primary_key:"y"`
+		PropertyID int32  `db:"property_id"`
+		Status     string `db:"status"`
+		Priority   int32  `db:"priority"`
+	}
+
+	expected := "UPDATE task SET status = $1, priority = $2 WHERE task.property_id = $3 AND task.status = $4"
+	qry, args, err := BuildBulkUpdateQuery("task", &task{PropertyID: 7, Status: "open"}, &task{Status: "closed"}, []string{"priority"}, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildBulkUpdateQuery failed", err)
+	}
+	if qry != expected || len(args) != 4 || args[0] != "closed" || args[1] != int32(0) || args[2] != int32(7) || args[3] != "open" {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	if _, _, err := BuildBulkUpdateQuery("task", &task{}, &task{Status: "closed"}, nil); !errors.Is(err, ErrMissingPredicate) {
+		t.Fatal("expected ErrMissingPredicate, got", err)
+	}
+	if _, _, err := BuildBulkUpdateQuery("task", &task{PropertyID: 7}, &task{ID: 3}, nil); !errors.Is(err, ErrEmptyUpdate) {
+		t.Fatal("expected ErrEmptyUpdate, got", err)
+	}
+}
+
+func TestDeleteMany(t *testing.T) {
+	type task struct {
+		ID       int32  `db:"id" primary_key:"y"`
+		Title    string `db:"title"`
+		IsActive bool   `db:"is_active"`
+	}
+
+	stmts, err := BuildDeleteManyQuery("task", &task{}, []interface{}{4, 8, 15}, WithHardDelete(), WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildDeleteManyQuery failed", err)
+	}
+	expected := "DELETE FROM task WHERE task.id IN ($1, $2, $3)"
+	if len(stmts) != 1 || stmts[0].Query != expected || len(stmts[0].Args) != 3 || stmts[0].Args[2] != 15 {
+		t.Log("Got:", stmts)
+		t.Fatal("Expected:", expected)
+	}
+
+	ids := make([]interface{}, 1201)
+	for i := range ids {
+		ids[i] = i
+	}
+	stmts, err = BuildDeleteManyQuery("task", &task{}, ids)
+	if err != nil {
+		t.Fatal("BuildDeleteManyQuery failed", err)
+	}
+	if len(stmts) != 3 || len(stmts[2].Args) != 202 || stmts[2].Args[0] != 0 || stmts[2].Args[1] != 1000 {
+		t.Fatal("expected the ids to be split into 3 soft deletes, got", len(stmts))
+	}
+	if !strings.HasPrefix(stmts[0].Query, "UPDATE task SET task.is_active = ? WHERE task.id IN (?, ?") {
+		t.Fatal("expected a soft delete, got", stmts[0].Query[:80])
+	}
+
+	if stmts, err := BuildDeleteManyQuery("task", &task{}, nil); err != nil || len(stmts) != 0 {
+		t.Fatal("expected no statement for no ids, got", stmts, err)
+	}
+	if _, err := BuildDeleteManyQuery("user_role", &UserRole{}, ids); !errors.Is(err, ErrMissingPrimaryKey) {
+		t.Fatal("expected ErrMissingPrimaryKey, got", err)
+	}
+}
+
+func TestIdempotentCreate(t *testing.T) {
+	type payment struct {
+		ID        int64  `db:"id" primary_key:"y"`
+		RequestID string `db:"request_id" idempotency_key:"y"`
+		Amount    int64  `db:"amount"`
+	}
+
+	expected := "INSERT IGNORE INTO payment (payment.request_id, payment.amount) VALUES (?, ?)"
+	qry, args, err := BuildIdempotentCreateQuery("payment", &payment{RequestID: "req-1", Amount: 5})
+	if err != nil || qry != expected || len(args) != 2 {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "INSERT INTO payment (request_id, amount) VALUES ($1, $2) ON CONFLICT (request_id) DO NOTHING"
+	qry, _, err = BuildIdempotentCreateQuery("payment", &payment{RequestID: "req-1", Amount: 5}, WithDialect(Postgres))
+	if err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	if _, _, err := BuildIdempotentCreateQuery("payment", &payment{Amount: 5}); !errors.Is(err, ErrMissingIdempotencyKey) {
+		t.Fatal("expected ErrMissingIdempotencyKey for an unset key, got", err)
+	}
+	if _, _, err := BuildIdempotentCreateQuery("contact", &ContactFilter{Name: "a"}); !errors.Is(err, ErrMissingIdempotencyKey) {
+		t.Fatal("expected ErrMissingIdempotencyKey for a message without key, got", err)
+	}
+}
+
+func TestExistsQuery(t *testing.T) {
+	expected := "SELECT EXISTS(SELECT 1 FROM contact WHERE contact.email = ? AND contact.is_active = ?)"
+	qry, args, err := BuildExistsQuery("contact", &ContactFilter{Email: "a@b.c"}, "email", "IsActive")
+	if err != nil || qry != expected || !reflect.DeepEqual(args, []interface{}{"a@b.c", int32(0)}) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT EXISTS(SELECT 1 FROM contact WHERE contact.id = ?)"
+	qry, _, err = BuildExistsQuery("contact", &ContactFilter{ID: 4})
+	if err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	if _, _, err := BuildExistsQuery("contact", &ContactFilter{}, "phone"); !errors.Is(err, ErrUnknownField) {
+		t.Fatal("expected ErrUnknownField, got", err)
+	}
+}
+
+func TestFilterMessage(t *testing.T) {
+	type invoice struct {
+		ID     int64     `db:"id" primary_key:"y"`
+		Date   time.Time `db:"issued_on"`
+		Amount int64     `db:"amount"`
+		Status string    `db:"state"`
+	}
+	type invoiceFilter struct {
+		DateFrom  *timestamppb.Timestamp
+		DateTo    *timestamppb.Timestamp
+		AmountMin int64
+		Ids       []int64
+		Status    string `filter_col:"state" filter_op:"ne"`
+		PageToken string
+	}
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	filter := &invoiceFilter{DateFrom: timestamppb.New(from), AmountMin: 100, Ids: []int64{3, 5}, Status: "void", PageToken: "x"}
+
+	expected := "SELECT invoice.id, invoice.issued_on, invoice.amount, invoice.state FROM invoice WHERE true AND invoice.issued_on >= ? AND invoice.amount >= ? AND invoice.id IN (?, ?) AND invoice.state != ?"
+	qry, args, err := BuildFilterQuery("invoice", &invoice{}, filter)
+	if err != nil || qry != expected || !reflect.DeepEqual(args, []interface{}{from, int64(100), int64(3), int64(5), "void"}) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT COUNT(*) FROM invoice WHERE TRUE AND invoice.amount >= ?"
+	qry, _, err = BuildCountQueryWithOptions("invoice", &invoice{}, WithFilter(&invoiceFilter{AmountMin: 100}))
+	if err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	type badFilter struct {
+		Total int64 `filter_col:"total" filter_op:"gte"`
+	}
+	if _, _, err := BuildFilterQuery("invoice", &invoice{}, &badFilter{Total: 1}); !errors.Is(err, ErrUnknownField) {
+		t.Fatal("expected ErrUnknownField for a tagged field naming no column, got", err)
+	}
+}
+
+func TestRangePairs(t *testing.T) {
+	type shift struct {
+		ID             int64                  `db:"id" primary_key:"y"`
+		Date           time.Time              `db:"work_date"`
+		Hours          int32                  `db:"hours"`
+		DateRangeStart *timestamppb.Timestamp
+		DateRangeEnd   *timestamppb.Timestamp
+		HoursFrom      int32
+		HoursTo        int32
+		PaidFrom       string `date_target:"paid_on"`
+		PaidTo         string
+	}
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	source := &shift{DateRangeStart: timestamppb.New(start), DateRangeEnd: timestamppb.New(end), HoursFrom: 4, PaidTo: "2024-04-01"}
+
+	expected := "SELECT shift.id, shift.work_date, shift.hours FROM shift WHERE true AND shift.work_date >= ? AND shift.work_date <= ? AND shift.hours >= ? AND shift.paid_on <= ?"
+	qry, args, err := BuildReadQueryWithOptions("shift", source, WithStrict())
+	if err != nil || qry != expected || !reflect.DeepEqual(args, []interface{}{start, end, int32(4), "2024-04-01"}) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT COUNT(*) FROM shift WHERE TRUE AND shift.hours >= ?"
+	qry, _, err = BuildCountQuery("shift", &shift{HoursFrom: 4})
+	if err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestRelativeTimeFilters(t *testing.T) {
+	type order struct {
+		ID         int64                `db:"id" primary_key:"y"`
+		Total      int64                `db:"total"`
+		WithinDays int32                `within:"created_at"`
+		Within     *durationpb.Duration `within:"paid_at"`
+		Recent     uint32               `within:"shipped_at,h"`
+	}
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	clock := WithClock(ClockFunc(func() time.Time { return now }))
+
+	expected := "SELECT order.id, order.total FROM order WHERE true AND order.created_at >= ? AND order.paid_at >= ? AND order.shipped_at >= ?"
+	source := &order{WithinDays: 7, Within: durationpb.New(90 * time.Minute), Recent: 2}
+	qry, args, err := BuildReadQueryWithOptions("order", source, clock, WithStrict())
+	expectedArgs := []interface{}{now.AddDate(0, 0, -7), now.Add(-90 * time.Minute), now.Add(-2 * time.Hour)}
+	if err != nil || qry != expected || !reflect.DeepEqual(args, expectedArgs) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("Expected:", expected, expectedArgs)
+	}
+
+	type noUnit struct {
+		ID     int64 `db:"id" primary_key:"y"`
+		Window int32 `within:"created_at"`
+	}
+	if _, _, err := BuildCountQuery("t", &noUnit{Window: 3}); err == nil {
+		t.Fatal("expected an error for a window without unit")
+	}
+}
+
+func TestDeterministicBuilds(t *testing.T) {
+	type order struct {
+		ID        string `db:"id" primary_key:"y" pk_gen:"uuid"`
+		Amount    int64  `db:"amount"`
+		CreatedAt string `db:"created_at" created_at:"auto"`
+		UpdatedAt string `db:"updated_at" updated_at:"auto"`
+	}
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	ids := IDGeneratorFunc(func(kind string) ([16]byte, error) {
+		return [16]byte{15: 1}, nil
+	})
+	cfg := Config{Dialect: Postgres, Clock: ClockFunc(func() time.Time { return now }), IDGenerator: ids}
+
+	source := &order{Amount: 12}
+	expected := "INSERT INTO order (id, amount, created_at, updated_at) VALUES ($1, $2, $3, $4)"
+	qry, args, err := BuildCreateQuery("order", source, WithConfig(cfg))
+	expectedArgs := []interface{}{"00000000-0000-0000-0000-000000000001", int64(12), now, now}
+	if err != nil || qry != expected || !reflect.DeepEqual(args, expectedArgs) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("Expected:", expected, expectedArgs)
+	}
+
+	expected = "UPDATE order SET amount = $1, updated_at = $2 WHERE order.id = $3"
+	qry, args, err = BuildUpdateQuery("order", source, nil, WithConfig(cfg))
+	expectedArgs = []interface{}{int64(12), now, source.ID}
+	if err != nil || qry != expected || !reflect.DeepEqual(args, expectedArgs) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("Expected:", expected, expectedArgs)
+	}
+
+	// without a clock the database tells the time
+	expected = "UPDATE order SET amount = $1, updated_at = NOW() WHERE order.id = $2"
+	if qry, _, err = BuildUpdateQuery("order", source, nil, WithDialect(Postgres)); err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestLikeEscape(t *testing.T) {
+	type product struct {
+		ID   int64  `db:"id" primary_key:"y"`
+		Name string `db:"name"`
+	}
+
+	source := &product{Name: `50%_off\`}
+	expected := `SELECT product.id, product.name FROM product WHERE true AND product.name LIKE $1 ESCAPE '\'`
+	qry, args, err := BuildReadQueryWithOptions("product", source, WithDialect(Postgres), WithLikeEscape())
+	if err != nil || qry != expected || !reflect.DeepEqual(args, []interface{}{`50\%\_off\\`}) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("Expected:", expected)
+	}
+	if source.Name != `50%_off\` {
+		t.Fatal("expected the message to be left untouched, got", source.Name)
+	}
+
+	// MySQL escapes with a backslash by default
+	expected = "SELECT COUNT(*) FROM product WHERE TRUE AND product.name LIKE ?"
+	qry, args, err = BuildCountQueryWithOptions("product", source, WithLikeEscape())
+	if err != nil || qry != expected || !reflect.DeepEqual(args, []interface{}{`50\%\_off\\`}) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = `SELECT product.id, product.name FROM product WHERE true AND (product.name LIKE ? ESCAPE '\')`
+	qry, args, err = BuildSearchQuery("product", &product{}, "a_b", WithDialect(SQLite), WithLikeEscape())
+	if err != nil || qry != expected || !reflect.DeepEqual(args, []interface{}{`%a\_b%`}) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	type productFilter struct {
+		Name string `filter_op:"like"`
+	}
+	expected = `SELECT product.id, product.name FROM product WHERE true AND product.name LIKE $1 ESCAPE '\'`
+	qry, args, err = BuildFilterQuery("product", &product{}, &productFilter{Name: "x%\x00"}, WithDialect(Postgres), WithLikeEscape(), WithSanitizer(SanitizeText))
+	if err != nil || qry != expected || !reflect.DeepEqual(args, []interface{}{`x\%`}) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	reject := errors.New("rejected")
+	sanitizer := WithSanitizer(func(column, value string) (string, error) {
+		return "", fmt.Errorf("%s: %w", column, reject)
+	})
+	if _, _, err := BuildReadQueryWithOptions("product", source, sanitizer); !errors.Is(err, reject) {
+		t.Fatal("expected the sanitizer to reject the value, got", err)
+	}
+	if _, _, err := BuildSearchQuery("product", &product{}, "a", sanitizer); !errors.Is(err, reject) {
+		t.Fatal("expected the sanitizer to reject the phrase, got", err)
+	}
+}
+
+func TestReadLimits(t *testing.T) {
+	type event struct {
+		ID   int64  `db:"id" primary_key:"y"`
+		Kind string `db:"kind"`
+	}
+	cfg := WithConfig(Config{Dialect: Postgres, Limits: Limits{Default: 100, Max: 500, Large: []string{"event"}}})
+
+	expected := "SELECT task.id, task.kind FROM task WHERE true order by task.id asc LIMIT 100"
+	qry, _, err := BuildReadQueryWithOptions("task", &event{}, cfg)
+	if err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT task.id, task.kind FROM task WHERE true order by task.id asc LIMIT 500"
+	qry, _, err = BuildReadQueryWithOptions("task", &event{}, cfg, WithLimit(10000, 0))
+	if err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	for _, target := range []string{"audit.event", "event"} {
+		if _, _, err := BuildReadQueryWithOptions(target, &event{}, cfg); !errors.Is(err, ErrUnboundedRead) {
+			t.Fatal("expected an unbounded read of", target, "to fail, got", err)
+		}
+	}
+	expected = "SELECT audit.event.id, audit.event.kind FROM audit.event WHERE true order by audit.event.id asc LIMIT 20 OFFSET 40"
+	qry, _, err = BuildReadQueryWithOptions("audit.event", &event{}, cfg, WithLimit(20, 40))
+	if err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestQueryBudget(t *testing.T) {
+	type task struct {
+		ID      int64   `db:"id" primary_key:"y"`
+		Title   string  `db:"title"`
+		Notes   string  `db:"notes"`
+		Status  int32   `db:"status"`
+		Owners  []int64 `db:"owner_id" array:"in"`
+		Project *task   `foreign_table:"project" foreign_key:"id" local_name:"project_id"`
+	}
+	type taskFilter struct {
+		Ids []int64
+	}
+	budget := WithBudget(Budget{MaxListItems: 2, MaxJoins: 1, MaxSearchTerms: 1, MaxPredicates: 2})
+
+	cases := []struct {
+		limit string
+		build func() error
+	}{
+		{"MaxListItems", func() error {
+			_, _, err := BuildFilterQuery("task", &task{}, &taskFilter{Ids: []int64{1, 2, 3}}, budget)
+			return err
+		}},
+		{"MaxListItems", func() error {
+			_, _, err := BuildCountQueryWithOptions("task", &task{Owners: []int64{1, 2, 3}}, budget, WithDialect(Postgres))
+			return err
+		}},
+		{"MaxJoins", func() error {
+			_, _, err := BuildReadQueryWithOptions("task", &task{}, budget, WithPreload("Project", "Project"))
+			return err
+		}},
+		{"MaxSearchTerms", func() error {
+			_, _, err := BuildSearchQuery("task", &task{}, "report", budget)
+			return err
+		}},
+		{"MaxPredicates", func() error {
+			_, _, err := BuildReadQueryWithOptions("task", &task{Title: "a", Status: 2}, budget, WithWhere("task.id > 3", nil))
+			return err
+		}},
+	}
+	for _, c := range cases {
+		var over *BudgetError
+		err := c.build()
+		if !errors.As(err, &over) || over.Limit != c.limit || !errors.Is(err, ErrOverBudget) {
+			t.Fatal("expected", c.limit, "to be exceeded, got", err)
+		}
+	}
+
+	if _, _, err := BuildReadQueryWithOptions("task", &task{Title: "a", Owners: []int64{1, 2}}, budget, WithDialect(Postgres)); err != nil {
+		t.Fatal("expected a query within budget to build, got", err)
+	}
+}
+
+func TestSearchRank(t *testing.T) {
+	type article struct {
+		ID     int64  `db:"id" primary_key:"y"`
+		Title  string `db:"title" search_weight:"4"`
+		Body   string `db:"body"`
+		Status int32  `db:"status"`
+	}
+
+	cases := []struct {
+		dialect  Dialect
+		expected string
+		rank     string
+	}{
+		{Postgres, "SELECT article.id, article.title, article.body, article.status FROM article WHERE true AND article.status = $1 AND (article.title LIKE $2 OR article.body LIKE $3) order by ts_rank(setweight(to_tsvector(coalesce(article.title, '')), 'A') || to_tsvector(coalesce(article.body, '')), plainto_tsquery($4)) desc, article.id asc", "go"},
+		{MySQL, "SELECT article.id, article.title, article.body, article.status FROM article WHERE true AND article.status = ? AND (article.title LIKE ? OR article.body LIKE ?) order by MATCH (article.title, article.body) AGAINST (?) desc, article.id asc", "go"},
+		{SQLite, "SELECT article.id, article.title, article.body, article.status FROM article WHERE true AND article.status = ? AND (article.title LIKE ? OR article.body LIKE ?) order by (CASE WHEN article.title LIKE ? THEN 4 ELSE 0 END + CASE WHEN article.body LIKE ? THEN 1 ELSE 0 END) desc, article.id asc", "%go%"},
+	}
+	for _, c := range cases {
+		qry, args, err := BuildSearchQuery("article", &article{Status: 2}, "%go%", WithDialect(c.dialect), WithSearchRank())
+		expectedArgs := []interface{}{int32(2), "%go%", "%go%", c.rank}
+		if c.dialect == SQLite {
+			expectedArgs = append(expectedArgs, c.rank)
+		}
+		if err != nil || qry != c.expected || !reflect.DeepEqual(args, expectedArgs) {
+			t.Log("Got:", qry, args, err)
+			t.Fatal("Expected:", c.expected, expectedArgs)
+		}
+	}
+}
+
+func TestFuzzySearch(t *testing.T) {
+	type customer struct {
+		ID    int64  `db:"id" primary_key:"y"`
+		Name  string `db:"name"`
+		Email string `db:"email" search_weight:"2"`
+	}
+
+	cases := []struct {
+		dialect  Dialect
+		opts     []Option
+		expected string
+	}{
+		{Postgres, []Option{WithFuzzySearch(0)}, "SELECT customer.id, customer.name, customer.email FROM customer WHERE true AND (customer.name % $1 OR customer.email % $2)"},
+		{Postgres, []Option{WithFuzzySearch(0.4), WithSearchRank()}, "SELECT customer.id, customer.name, customer.email FROM customer WHERE true AND (similarity(customer.name, $1) >= 0.4 OR similarity(customer.email, $2) >= 0.4) order by GREATEST(similarity(customer.name, $3), similarity(customer.email, $4) * 2) desc, customer.id asc"},
+		{MySQL, []Option{WithFuzzySearch(0)}, "SELECT customer.id, customer.name, customer.email FROM customer WHERE true AND (SOUNDEX(customer.name) = SOUNDEX(?) OR SOUNDEX(customer.email) = SOUNDEX(?))"},
+	}
+	for _, c := range cases {
+		qry, args, err := BuildSearchQuery("customer", &customer{}, "%jonh%", append(c.opts, WithDialect(c.dialect))...)
+		if err != nil || qry != c.expected || len(args) == 0 {
+			t.Log("Got:", qry, args, err)
+			t.Fatal("Expected:", c.expected)
+		}
+		for _, arg := range args {
+			if arg != "jonh" {
+				t.Fatal("expected the phrase to be bound without wildcards, got", args)
+			}
+		}
+	}
+}
+
+func TestFacetQuery(t *testing.T) {
+	type task struct {
+		ID        int64  `db:"id" primary_key:"y"`
+		ProjectID int64  `db:"project_id"`
+		Status    string `db:"status"`
+		Priority  int32  `db:"priority"`
+	}
+
+	statements, err := BuildFacetQuery("task", &task{ProjectID: 7}, "status", "priority")
+	expected := []string{
+		"SELECT task.status AS value, COUNT(*) AS count FROM task WHERE TRUE AND task.project_id = ? GROUP BY task.status ORDER BY COUNT(*) DESC, task.status",
+		"SELECT task.priority AS value, COUNT(*) AS count FROM task WHERE TRUE AND task.project_id = ? GROUP BY task.priority ORDER BY COUNT(*) DESC, task.priority",
+	}
+	if err != nil || len(statements) != 2 {
+		t.Fatal("BuildFacetQuery failed", statements, err)
+	}
+	for i, statement := range statements {
+		if statement.Query != expected[i] || !reflect.DeepEqual(statement.Args, []interface{}{int64(7)}) {
+			t.Log("Got:", statement.Query, statement.Args)
+			t.Fatal("Expected:", expected[i])
+		}
+	}
+
+	if _, err := BuildFacetQuery("task", &task{}, "owner"); !errors.Is(err, ErrUnknownField) {
+		t.Fatal("expected an unknown facet to fail, got", err)
+	}
+}
+
+func TestFirstPerPartition(t *testing.T) {
+	type invoice struct {
+		ID         int64  `db:"id" primary_key:"y"`
+		CustomerID int64  `db:"customer_id" partition_by:"y"`
+		Status     string `db:"status"`
+		IssuedAt   int64  `db:"issued_at" rank_order:"desc"`
+	}
+
+	qry, args, err := BuildReadQueryWithOptions("invoice", &invoice{Status: "open"}, WithFirstPerPartition(), WithLimit(10, 0))
+	expected := "SELECT invoice.id, invoice.customer_id, invoice.status, invoice.issued_at FROM (SELECT invoice.id, invoice.customer_id, invoice.status, invoice.issued_at, ROW_NUMBER() OVER (PARTITION BY invoice.customer_id ORDER BY invoice.issued_at desc) AS pbsql_rn FROM invoice WHERE true AND invoice.status LIKE ?) AS invoice WHERE pbsql_rn = 1 order by invoice.id asc LIMIT 10"
+	if err != nil || qry != expected || !reflect.DeepEqual(args, []interface{}{"open"}) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	type payment struct {
+		ID         int64 `db:"id" primary_key:"y"`
+		CustomerID int64 `db:"customer_id" partition_by:"y"`
+	}
+	qry, _, err = BuildReadQueryWithOptions("billing.payment", &payment{}, WithFirstPerPartition())
+	expected = "SELECT payment.id, payment.customer_id FROM (SELECT billing.payment.id, billing.payment.customer_id, ROW_NUMBER() OVER (PARTITION BY billing.payment.customer_id ORDER BY billing.payment.id desc) AS pbsql_rn FROM billing.payment WHERE true) AS payment WHERE pbsql_rn = 1"
+	if err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	if _, _, err := BuildReadQueryWithOptions("invoice", &invoice{}, WithFirstPerPartition(), WithLock(ForUpdate)); err == nil {
+		t.Fatal("expected a ranked read to refuse row locks")
+	}
+	type customer struct {
+		ID int64 `db:"id" primary_key:"y"`
+	}
+	if _, _, err := BuildReadQueryWithOptions("customer", &customer{}, WithFirstPerPartition()); err == nil {
+		t.Fatal("expected a ranked read without partition_by to fail")
+	}
+}
+
+func TestUnionQuery(t *testing.T) {
+	type order struct {
+		ID         int64  `db:"id" primary_key:"y"`
+		CustomerID int64  `db:"customer_id"`
+		Source     string `db:"source_table"`
+	}
+
+	qry, args, err := BuildUnionQuery([]string{"orders", "orders_archive"}, &order{CustomerID: 7}, WithLimit(20, 40))
+	expected := "SELECT * FROM (SELECT orders.id, orders.customer_id, 'orders' AS source_table FROM orders WHERE true AND orders.customer_id = ? UNION ALL SELECT orders_archive.id, orders_archive.customer_id, 'orders_archive' AS source_table FROM orders_archive WHERE true AND orders_archive.customer_id = ?) AS pbsql_union order by id asc, source_table asc LIMIT 20 OFFSET 40"
+	if err != nil || qry != expected || !reflect.DeepEqual(args, []interface{}{int64(7), int64(7)}) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	if _, _, err := BuildUnionQuery(nil, &order{}); err == nil {
+		t.Fatal("expected a union of no tables to fail")
+	}
+	if _, _, err := BuildUnionQuery([]string{"orders"}, &order{}, WithLock(ForUpdate)); err == nil {
+		t.Fatal("expected a union read to refuse row locks")
+	}
+}
+
+func TestAsOf(t *testing.T) {
+	type task struct {
+		ID   int64  `db:"id" primary_key:"y"`
+		Name string `db:"name"`
+	}
+	at := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	qry, args, err := BuildReadQueryWithOptions("task", &task{ID: 3}, WithAsOf(at))
+	expected := "SELECT task.id, task.name FROM task FOR SYSTEM_TIME AS OF ? WHERE true AND task.id = ?"
+	if err != nil || qry != expected || !reflect.DeepEqual(args, []interface{}{at, int64(3)}) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	for _, opts := range [][]Option{{WithDialect(Postgres), WithAsOf(at)}, {WithHistoryAsOf(at), WithDialect(Postgres)}} {
+		qry, args, err = BuildReadQueryWithOptions("task", &task{ID: 3}, opts...)
+		expected = "SELECT task_history.id, task_history.name FROM task_history WHERE true AND task_history.id = $1 AND task_history.valid_from <= $2 AND (task_history.valid_to IS NULL OR task_history.valid_to > $3)"
+		if err != nil || qry != expected || !reflect.DeepEqual(args, []interface{}{int64(3), at, at}) {
+			t.Log("Got:", qry, args, err)
+			t.Fatal("Expected:", expected)
+		}
+	}
+}
+
+func TestEncryptedFields(t *testing.T) {
+	type patient struct {
+		ID    int64  `db:"id" primary_key:"y"`
+		Ssn   string `db:"ssn" encrypt:"aes"`
+		Email string `db:"email" encrypt:"aes,deterministic"`
+	}
+	SetKeyProvider(StaticKey("0123456789abcdef0123456789abcdef"))
+	defer SetKeyProvider(nil)
+
+	qry, args, err := BuildCreateQuery("patient", &patient{Ssn: "123-45-6789", Email: "a@b.c"})
+	if err != nil || qry != "INSERT INTO patient (patient.ssn, patient.email) VALUES (?, ?)" || len(args) != 2 {
+		t.Fatal("unexpected create", qry, args, err)
+	}
+	self, _ := reflect.TypeOf(patient{}).FieldByName("Ssn")
+	c, err := converterOf(self)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args[0] == "123-45-6789" {
+		t.Fatal("expected the ssn to be encrypted")
+	}
+	if decrypted, err := c.Decode(args[0]); err != nil || string(decrypted.([]byte)) != "123-45-6789" {
+		t.Fatal("expected the ssn to decrypt, got", decrypted, err)
+	}
+	if again, _ := c.Encode("123-45-6789"); again == args[0] {
+		t.Fatal("expected random nonces for non deterministic fields")
+	}
+
+	qry, readArgs, err := BuildReadQueryWithOptions("patient", &patient{Email: "a@b.c"})
+	if err != nil || qry != "SELECT patient.id, patient.ssn, patient.email FROM patient WHERE true AND patient.email = ?" || readArgs[0] != args[1] {
+		t.Fatal("expected deterministic fields to be filtered by equality", qry, readArgs, err)
+	}
+
+	SetKeyProvider(nil)
+	if _, _, err := BuildCreateQuery("patient", &patient{Ssn: "123-45-6789"}); err == nil {
+		t.Fatal("expected encryption without a key provider to fail")
+	}
+}
+
+func TestMasking(t *testing.T) {
+	type employee struct {
+		ID    int64  `db:"id" primary_key:"y"`
+		Ssn   string `db:"ssn" mask:"ssn"`
+		Email string `db:"email" mask:"email"`
+		Notes string `db:"notes" mask:"unknown"`
+	}
+
+	cases := []struct {
+		dialect  Dialect
+		expected string
+	}{
+		{MySQL, "SELECT employee.id, COALESCE(CONCAT('***-**-', RIGHT(employee.ssn, 4)), '') as ssn, COALESCE(CONCAT(LEFT(employee.email, 1), '***', SUBSTRING(employee.email, LOCATE('@', employee.email))), '') as email, COALESCE('***', '') as notes FROM employee WHERE true AND employee.ssn LIKE ?"},
+		{SQLite, "SELECT employee.id, COALESCE('***-**-' || substr(employee.ssn, -4), '') as ssn, COALESCE(substr(employee.email, 1, 1) || '***' || substr(employee.email, instr(employee.email, '@')), '') as email, COALESCE('***', '') as notes FROM employee WHERE true AND employee.ssn LIKE ?"},
+	}
+	for _, c := range cases {
+		qry, _, err := BuildReadQueryWithOptions("employee", &employee{Ssn: "123-45-6789"}, WithMasking(), WithDialect(c.dialect))
+		if err != nil || qry != c.expected {
+			t.Log("Got:", qry, err)
+			t.Fatal("Expected:", c.expected)
+		}
+	}
+
+	policy := MaskPolicy("hr")
+	for _, claims := range []Claims{{Roles: []string{"hr"}}, {Roles: []string{"engineer"}}} {
+		opts, err := policy(context.Background(), PolicyRequest{Claims: claims, HasClaims: true, Operation: OpRead})
+		if err != nil || (len(opts) == 0) != claims.HasRole("hr") {
+			t.Fatal("unexpected masking of", claims.Roles, opts, err)
+		}
+	}
+}
+
+func TestFieldPermissions(t *testing.T) {
+	type account struct {
+		ID        int64  `db:"id" primary_key:"y"`
+		Name      string `db:"name"`
+		CreatedBy string `db:"created_by" perm:"cr"`
+		Balance   int64  `db:"balance" perm:"r"`
+		Secret    string `db:"secret" perm:"cu"`
+	}
+	source := &account{ID: 1, Name: "a", CreatedBy: "u", Balance: 10, Secret: "s"}
+
+	qry, args, err := BuildCreateQuery("account", source)
+	if err != nil || qry != "INSERT INTO account (account.name, account.created_by, account.secret) VALUES (?, ?, ?)" || len(args) != 3 {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("expected balance to be left out of inserts")
+	}
+	qry, args, err = BuildUpdateQuery("account", source, []string{"name", "created_by", "balance", "secret"})
+	if err != nil || qry != "UPDATE account SET account.name = ?, account.secret = ? WHERE account.id = ?" || len(args) != 3 {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("expected created_by and balance to be left out of updates")
+	}
+	qry, _, err = BuildReadQueryWithOptions("account", &account{})
+	if err != nil || qry != "SELECT account.id, account.name, account.created_by, account.balance FROM account WHERE true" {
+		t.Log("Got:", qry, err)
+		t.Fatal("expected secret to be left out of reads")
+	}
+
+	if _, _, err := BuildUpdateQuery("account", source, []string{"balance"}, WithStrict()); !errors.Is(err, ErrForbiddenField) {
+		t.Fatal("expected strict updates of read-only fields to fail, got", err)
+	}
+	if _, _, err := BuildReadQueryWithOptions("account", &account{}, WithFieldMask("secret"), WithStrict()); !errors.Is(err, ErrForbiddenField) {
+		t.Fatal("expected strict reads of write-only fields to fail, got", err)
+	}
+	if _, _, err := BuildUpdateQuery("account", source, []string{"name", "secret"}, WithStrict()); err != nil {
+		t.Fatal("expected strict updates of permitted fields to succeed, got", err)
+	}
+}
+
+func TestCreatePrimaryKeyPositions(t *testing.T) {
+	type keyFirst struct {
+		ID   int64  `db:"id" primary_key:"y"`
+		Name string `db:"name"`
+		Note string `db:"note"`
+	}
+	type keyMiddle struct {
+		Name string `db:"name"`
+		ID   int64  `db:"id" primary_key:"y"`
+		Note string `db:"note"`
+	}
+	type keyLast struct {
+		Name string `db:"name"`
+		Note string `db:"note"`
+		ID   int64  `db:"id" primary_key:"y"`
+	}
+
+	cases := []struct {
+		source   interface{}
+		expected string
+	}{
+		{&keyFirst{ID: 1, Name: "a", Note: "b"}, "INSERT INTO t (t.name, t.note) VALUES (?, ?)"},
+		{&keyMiddle{ID: 1, Name: "a", Note: "b"}, "INSERT INTO t (t.name, t.note) VALUES (?, ?)"},
+		{&keyLast{ID: 1, Name: "a", Note: "b"}, "INSERT INTO t (t.name, t.note) VALUES (?, ?)"},
+		{&keyFirst{ID: 1, Note: "b"}, "INSERT INTO t (t.note) VALUES (?)"},
+		{&keyMiddle{ID: 1, Name: "a"}, "INSERT INTO t (t.name) VALUES (?)"},
+		{&keyLast{ID: 1, Note: "b"}, "INSERT INTO t (t.note) VALUES (?)"},
+	}
+	for _, c := range cases {
+		qry, args, err := BuildCreateQuery("t", c.source)
+		if err != nil || qry != c.expected || len(args) != strings.Count(qry, "?") {
+			t.Log("Got:", qry, args, err)
+			t.Fatal("Expected:", c.expected)
+		}
+	}
+}
+
+func TestWideIntegerFields(t *testing.T) {
+	type counter struct {
+		ID    int64  `db:"id" primary_key:"y"`
+		Hits  int64  `db:"hits"`
+		Bytes uint64 `db:"bytes"`
+	}
+
+	qry, args, err := BuildReadQueryWithOptions("counter", &counter{})
+	if err != nil || qry != "SELECT counter.id, counter.hits, counter.bytes FROM counter WHERE true" || len(args) != 0 {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("expected zero int64 and uint64 fields to be left out of predicates")
+	}
+	qry, args, err = BuildReadQueryWithOptions("counter", &counter{Hits: -1, Bytes: 1 << 40})
+	if err != nil || qry != "SELECT counter.id, counter.hits, counter.bytes FROM counter WHERE true AND counter.hits = ? AND counter.bytes = ?" || args[0] != int64(-1) || args[1] != uint64(1<<40) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("expected set int64 and uint64 fields to be compared")
+	}
+	qry, args, err = BuildCreateQuery("counter", &counter{Bytes: 2})
+	if err != nil || qry != "INSERT INTO counter (counter.bytes) VALUES (?)" || args[0] != uint64(2) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("expected zero int64 fields to be left out of inserts")
+	}
+	qry, args, err = BuildUpdateQuery("counter", &counter{ID: 1, Hits: 3}, nil)
+	if err != nil || qry != "UPDATE counter SET counter.hits = ? WHERE counter.id = ?" || args[0] != int64(3) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("expected zero uint64 fields to be left out of updates")
+	}
+	for _, typeName := range []string{"int64", "uint64"} {
+		if d := getDefault(typeName, "hits"); d != "0" {
+			t.Fatal("unexpected default of", typeName, d)
+		}
+	}
+}
+
+func TestFloatFields(t *testing.T) {
+	type celsius float32
+	type reading struct {
+		ID          int64   `db:"id" primary_key:"y"`
+		Value       float32 `db:"value"`
+		Temperature celsius `db:"temperature"`
+	}
+
+	qry, args, err := BuildReadQueryWithOptions("reading", &reading{})
+	if err != nil || qry != "SELECT reading.id, reading.value, reading.temperature FROM reading WHERE true" || len(args) != 0 {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("expected zero float32 fields to be left out of predicates")
+	}
+	qry, args, err = BuildReadQueryWithOptions("reading", &reading{Value: 0.5, Temperature: -3})
+	if err != nil || qry != "SELECT reading.id, reading.value, reading.temperature FROM reading WHERE true AND reading.value = ? AND reading.temperature = ?" || args[0] != float32(0.5) || args[1] != celsius(-3) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("expected set float32 fields to be compared")
+	}
+	if notDefault("celsius", celsius(0)) || !notDefault("celsius", celsius(1.5)) {
+		t.Fatal("expected named numeric types to be told apart by their kind")
+	}
+
+	type cents int64
+	type invoice struct {
+		ID       int64   `db:"id" primary_key:"y"`
+		Total    cents   `db:"total" nullable:"y"`
+		Discount celsius `db:"discount" nullable:"y"`
+	}
+	qry, args, err = BuildReadQueryWithOptions("invoice", &invoice{Total: 250})
+	expected := "SELECT invoice.id, ifnull(invoice.total, 0) as total, ifnull(invoice.discount, 0.0) as discount FROM invoice WHERE true AND invoice.total = ?"
+	if err != nil || qry != expected || args[0] != cents(250) {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestBytesFields(t *testing.T) {
+	type file struct {
+		ID   int64  `db:"id" primary_key:"y"`
+		Name string `db:"name"`
+		Data []byte `db:"data"`
+	}
+
+	qry, args, err := BuildCreateQuery("file", &file{Name: "a", Data: []byte("xy")})
+	if err != nil || qry != "INSERT INTO file (file.name, file.data) VALUES (?, ?)" || string(args[1].([]byte)) != "xy" {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("expected bytes to be inserted")
+	}
+	qry, args, err = BuildUpdateQuery("file", &file{ID: 1, Data: []byte("xy")}, nil)
+	if err != nil || qry != "UPDATE file SET file.data = ? WHERE file.id = ?" || string(args[0].([]byte)) != "xy" {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("expected bytes to be updated")
+	}
+	qry, args, err = BuildReadQueryWithOptions("file", &file{Name: "a", Data: []byte("xy")})
+	if err != nil || qry != "SELECT file.id, file.name, file.data FROM file WHERE true AND file.name LIKE ? AND file.data = ?" || len(args) != 2 {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("expected bytes to be compared by equality rather than LIKE")
+	}
+	qry, args, err = BuildReadQueryWithOptions("file", &file{Data: []byte{}})
+	if err != nil || qry != "SELECT file.id, file.name, file.data FROM file WHERE true" || len(args) != 0 {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("expected empty bytes to be unset")
+	}
+
+	type attachment struct {
+		ID   int64  `db:"id" primary_key:"y"`
+		Data []byte `db:"data" nullable:"y"`
+	}
+	cases := []struct {
+		dialect  Dialect
+		expected string
+	}{
+		{MySQL, "SELECT attachment.id, ifnull(attachment.data, X'') as data FROM attachment WHERE true"},
+		{Postgres, "SELECT attachment.id, coalesce(attachment.data, decode('', 'hex')) as data FROM attachment WHERE true"},
+	}
+	for _, c := range cases {
+		qry, _, err := BuildReadQueryWithOptions("attachment", &attachment{}, WithDialect(c.dialect))
+		if err != nil || qry != c.expected {
+			t.Log("Got:", qry, err)
+			t.Fatal("Expected:", c.expected)
+		}
+	}
+	qry, args, err = BuildUpdateQuery("attachment", &attachment{ID: 1}, []string{"data"})
+	if err != nil || qry != "UPDATE attachment SET attachment.data = ? WHERE attachment.id = ?" || len(args) != 2 {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("expected nullable bytes to be updated")
+	}
+	qry, args, err = BuildCountQueryWithOptions("attachment", &attachment{Data: []byte("xy")})
+	if err != nil || qry != "SELECT COUNT(*) FROM attachment WHERE TRUE AND attachment.data = ?" || len(args) != 1 {
+		t.Log("Got:", qry, args, err)
+		t.Fatal("expected nullable bytes to be counted")
+	}
+}
+
+func TestNullDefaults(t *testing.T) {
+	type shipment struct {
+		ID        int64  `db:"id" primary_key:"y"`
+		Code      int32  `db:"code" nullable:"y" null_default:"-1"`
+		ShippedOn string `db:"shipped_on" nullable:"y" null_default:"1970-01-01"`
+		Note      string `db:"note" nullable:"y"`
+	}
+
+	qry, _, err := BuildReadQueryWithOptions("shipment", &shipment{})
+	expected := "SELECT shipment.id, ifnull(shipment.code, -1) as code, ifnull(shipment.shipped_on, '1970-01-01') as shipped_on, ifnull(shipment.note, '') as note FROM shipment WHERE true"
+	if err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+	qry, _, err = BuildReadQueryWithOptions("shipment", &shipment{}, WithNullDefault("Code", "0"), WithNullDefault("note", "n/a"))
+	expected = "SELECT shipment.id, ifnull(shipment.code, 0) as code, ifnull(shipment.shipped_on, '1970-01-01') as shipped_on, ifnull(shipment.note, 'n/a') as note FROM shipment WHERE true"
+	if err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+	qry, _, err = BuildReadQueryWithOptions("shipment", &shipment{}, WithNullDefault("ShippedOn", "2020-01-01 00:00:00"))
+	expected = "SELECT shipment.id, ifnull(shipment.code, -1) as code, ifnull(shipment.shipped_on, '2020-01-01 00:00:00') as shipped_on, ifnull(shipment.note, '') as note FROM shipment WHERE true"
+	if err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+	if _, _, err := BuildReadQueryWithOptions("shipment", &shipment{}, WithNullDefault("code", "none")); err == nil || !strings.Contains(err.Error(), "is not a number") {
+		t.Fatal("expected a null default which isn't a number to fail, got", err)
+	}
+	type flag struct {
+		ID      int64 `db:"id" primary_key:"y"`
+		Enabled bool  `db:"enabled" nullable:"y" null_default:"maybe"`
+	}
+	if _, _, err := BuildSearchQuery("flag", &flag{}, "x"); err == nil || !strings.Contains(err.Error(), "is not a boolean") {
+		t.Fatal("expected a null default which isn't a boolean to fail, got", err)
+	}
+}
+
+func TestNegatedPredicates(t *testing.T) {
+	type task struct {
+		ID        int32  `db:"id" primary_key:"y"`
+		Title     string `db:"title"`
+		TitleNot  string
+		Status    int32   `db:"status"`
+		StatusNot int32   `db:"status" negate:"y"`
+		Skip      []int32 `db:"id" array:"in" negate:"y"`
+	}
+
+	source := task{Title: "%report%", TitleNot: "%draft%", StatusNot: 3, Skip: []int32{4, 5}}
+	expected := "SELECT task.id, task.title, task.status FROM task WHERE true AND task.title LIKE $1 AND task.title NOT LIKE $2 AND task.status != $3 AND task.id <> ALL($4)"
+	qry, args, err := BuildReadQueryWithOptions("task", &source, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected || len(args) != 4 || args[1] != "%draft%" || args[2] != int32(3) {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT COUNT(*) FROM task WHERE TRUE AND task.status != ?"
+	qry, args, err = BuildCountQuery("task", &task{StatusNot: 3})
+	if err != nil {
+		t.Fatal("BuildCountQuery failed", err)
+	}
+	if qry != expected || len(args) != 1 || args[0] != int32(3) {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "DELETE FROM task WHERE task.title = ? AND task.status != ?"
+	qry, args, err = BuildDeleteWhereQuery("task", &task{Title: "old", StatusNot: 1})
+	if err != nil {
+		t.Fatal("BuildDeleteWhereQuery failed", err)
+	}
+	if qry != expected || len(args) != 2 || args[0] != "old" || args[1] != int32(1) {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "INSERT INTO task (task.title, task.status) VALUES (?, ?)"
+	qry, args, err = BuildCreateQuery("task", &task{Title: "a", Status: 2, StatusNot: 3})
+	if err != nil {
+		t.Fatal("BuildCreateQuery failed", err)
+	}
+	if qry != expected || len(args) != 2 || args[1] != int32(2) {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	type contact struct {
+		ID    int32  `db:"id" primary_key:"y"`
+		Name  string `db:"name"`
+		Email string `db:"email" case_insensitive:"y"`
+	}
+
+	source := contact{Name: "ann%", Email: "ann@example.com"}
+	expected := "SELECT contact.id, contact.name, contact.email FROM contact WHERE true AND contact.name LIKE ? AND LOWER(contact.email) LIKE LOWER(?)"
+	qry, _, err := BuildReadQueryWithOptions("contact", &source)
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT COUNT(*) FROM contact WHERE TRUE AND contact.name ILIKE $1 AND contact.email ILIKE $2"
+	qry, _, err = BuildCountQueryWithOptions("contact", &source, WithDialect(Postgres), WithCaseInsensitive())
+	if err != nil {
+		t.Fatal("BuildCountQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT contact.id, contact.name, contact.email FROM contact WHERE true AND contact.id = ? AND (LOWER(contact.name) LIKE LOWER(?) OR LOWER(contact.email) LIKE LOWER(?))"
+	qry, args, err := BuildSearchQuery("contact", &contact{ID: 1}, "%ann%", WithCaseInsensitive())
+	if err != nil {
+		t.Fatal("BuildSearchQuery failed", err)
+	}
+	if qry != expected || len(args) != 3 || args[1] != "%ann%" {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestNullTypes(t *testing.T) {
+	type account struct {
+		ID       int32          `db:"id" primary_key:"y"`
+		Nickname sql.NullString `db:"nickname"`
+		Credit   sql.NullInt64  `db:"credit"`
+		ClosedAt sql.NullTime   `db:"closed_at"`
+	}
+
+	source := account{Nickname: sql.NullString{String: "ann", Valid: true}}
+	expected := "SELECT account.id, account.nickname, account.credit, account.closed_at FROM account WHERE true AND account.nickname = ?"
+	qry, args, err := BuildReadQueryWithOptions("account", &source)
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected || len(args) != 1 || args[0] != "ann" {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	source.Credit = sql.NullInt64{Int64: 0, Valid: true}
+	expected = "INSERT INTO account (nickname, credit) VALUES ($1, $2)"
+	qry, args, err = BuildCreateQuery("account", &source, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildCreateQuery failed", err)
+	}
+	if qry != expected || len(args) != 2 || args[1] != int64(0) {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "CREATE TABLE account (id SERIAL, nickname TEXT, credit BIGINT, closed_at TIMESTAMP, PRIMARY KEY (id))"
+	qry, err = BuildCreateTableQuery("account", &account{}, WithDialect(Postgres))
+	if err != nil {
+		t.Fatal("BuildCreateTableQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	type task struct {
+		ID       int32  `db:"id" primary_key:"y"`
+		Title    string `db:"title"`
+		Assignee string `dB:"assignee"`
+		Notes    string `nullable:"y"`
+		OrderBy  string
+		Hidden   string `db:"-"`
+	}
+
+	source := task{Title: "a", Assignee: "ann", OrderBy: "title", Hidden: "x"}
+	if _, _, err := BuildReadQueryWithOptions("task", &source); err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	_, _, err := BuildReadQueryWithOptions("task", &source, WithStrict())
+	if !errors.Is(err, ErrUnmappedField) || !strings.Contains(err.Error(), "Assignee is set, Notes is tagged nullable") {
+		t.Fatal("expected ErrUnmappedField, got", err)
+	}
+	if _, _, err := BuildDeleteWhereQuery("task", &source, WithConfig(Config{Strict: true})); !errors.Is(err, ErrUnmappedField) {
+		t.Fatal("expected ErrUnmappedField from a strict config, got", err)
+	}
+
+	type note struct {
+		ID    int32  `db:"id" primary_key:"y"`
+		Title string `db:"title"`
+	}
+	if _, _, err := BuildReadQueryWithOptions("note", &note{}, WithStrict(), WithFieldMask("id", "Titel")); !errors.Is(err, ErrUnknownField) {
+		t.Fatal("expected ErrUnknownField, got", err)
+	}
+	if _, _, err := BuildCreateQuery("note", &note{Title: "a"}, WithStrict()); err != nil {
+		t.Fatal("BuildCreateQuery failed", err)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	b := NewBuilder(Config{Dialect: Postgres, Lifecycle: Lifecycle{Field: "IsActive", Column: "is_active"}})
+	filter := ContactFilter{Name: "someone", IsActive: 1}
+
+	expected := "SELECT contact.id, contact.name, contact.email, contact.is_active FROM contact WHERE true AND contact.name LIKE $1 AND contact.is_active = $2"
+	qry, args, err := b.BuildReadQuery("contact", &filter)
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected || len(args) != 2 || args[1] != 1 {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT COUNT(*) FROM contact WHERE TRUE AND contact.name LIKE $1 AND contact.is_active = $2"
+	qry, args, err = BuildCountQueryWithOptions("contact", &filter, WithConfig(b.Config()), WithScope(InactiveOnly))
+	if err != nil {
+		t.Fatal("BuildCountQuery failed", err)
+	}
+	if qry != expected || len(args) != 2 || args[1] != 0 {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT contact.id, contact.name, contact.email, contact.is_active FROM contact WHERE true AND contact.name LIKE $1"
+	if qry, _, err = b.BuildReadQuery("contact", &filter, WithScope(All)); err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT contact.id, contact.name, contact.email, contact.is_active FROM contact WHERE true AND contact.id = $1 AND (contact.name LIKE $2 OR contact.email LIKE $3) AND contact.is_active = $4"
+	qry, args, err = b.BuildSearchQuery("contact", &ContactFilter{ID: 2, IsActive: 1}, "%one%")
+	if err != nil {
+		t.Fatal("BuildSearchQuery failed", err)
+	}
+	if qry != expected || len(args) != 4 || args[0] != int32(2) || args[2] != "%one%" || args[3] != 1 {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "UPDATE contact SET is_active = $1 WHERE contact.id = $2"
+	qry, args, err = b.BuildDeleteQuery("contact", &ContactFilter{ID: 2})
+	if err != nil {
+		t.Fatal("BuildDeleteQuery failed", err)
+	}
+	if qry != expected || len(args) != 2 || args[0] != 0 {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestLocks(t *testing.T) {
+	source := ContactFilter{Email: "a@example.com"}
+	expected := "SELECT contact.id, contact.name, contact.email, contact.is_active FROM contact WHERE true AND contact.email LIKE $1 order by contact.id asc LIMIT 1 FOR UPDATE SKIP LOCKED"
+	qry, _, err := BuildReadQueryWithOptions("contact", &source, WithDialect(Postgres), WithLimit(1, 0), WithLock(ForUpdate|SkipLocked))
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT contact.id, contact.name, contact.email, contact.is_active FROM contact WHERE contact.id = ? FOR SHARE NOWAIT"
+	qry, _, err = BuildReadByPKQuery("contact", &ContactFilter{ID: 1}, WithLock(ForShare|NoWait))
+	if err != nil {
+		t.Fatal("BuildReadByPKQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT contact.id, contact.name, contact.email, contact.is_active FROM contact WHERE contact.id = ?"
+	if qry, _, err = BuildReadByPKQuery("contact", &ContactFilter{ID: 1}, WithDialect(SQLite), WithLock(ForUpdate)); err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	for _, lock := range []Lock{ForUpdate | ForShare, SkipLocked, ForUpdate | SkipLocked | NoWait} {
+		if _, _, err := BuildReadQueryWithOptions("contact", &source, WithLock(lock)); err == nil {
+			t.Fatal("expected an error for lock", lock)
+		}
+	}
+}
+
+func TestClaim(t *testing.T) {
+	type job struct {
+		ID        int32  `db:"id" primary_key:"y"`
+		Queue     string `db:"queue"`
+		Status    string `db:"status"`
+		ClaimedBy string `db:"claimed_by"`
+	}
+
+	claim := Claim{StatusColumn: "status", Pending: "pending", Claimed: "running", WorkerColumn: "claimed_by", Worker: "worker-1",
+		ClaimedAtColumn: "claimed_at", OrderBy: "job.id desc", Limit: 2}
+	expected := "UPDATE job SET status = $1, claimed_by = $2, claimed_at = NOW() WHERE (job.id) IN (SELECT job.id FROM job WHERE job.status = $3 AND job.queue = $4 ORDER BY job.id desc LIMIT 2 FOR UPDATE SKIP LOCKED) RETURNING job.id, job.queue, job.status, job.claimed_by"
+	qry, args, err := BuildClaimQuery("job", &job{}, claim, WithDialect(Postgres), WithWhere("job.queue = :queue", map[string]interface{}{"queue": "mail"}))
+	if err != nil {
+		t.Fatal("BuildClaimQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+	if fmt.Sprint(args) != "[running worker-1 pending mail]" {
+		t.Fatal("unexpected args", args)
+	}
+
+	expected = "UPDATE job SET job.status = ?, job.claimed_by = ? WHERE job.status = ? ORDER BY job.id asc LIMIT 1"
+	claim = Claim{StatusColumn: "status", Pending: "pending", Claimed: "running", WorkerColumn: "claimed_by", Worker: "worker-1"}
+	if qry, _, err = BuildClaimQuery("job", &job{}, claim); err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT job.id, job.queue, job.status, job.claimed_by FROM job WHERE job.status = ? AND job.claimed_by = ?"
+	if qry, args, err = BuildClaimedQuery("job", &job{}, claim); err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+	if fmt.Sprint(args) != "[running worker-1]" {
+		t.Fatal("unexpected args", args)
+	}
+
+	if _, _, err := BuildClaimQuery("job", &job{}, Claim{StatusColumn: "status"}); err == nil {
+		t.Fatal("expected an error for a MySQL claim without a worker column")
+	}
+	claim.OrderBy = "id; drop table job"
+	if _, _, err := BuildClaimQuery("job", &job{}, claim); !errors.Is(err, ErrUnsafePredicate) {
+		t.Fatal("expected ErrUnsafePredicate, got", err)
+	}
+}
+
+func TestTableResolver(t *testing.T) {
+	type event struct {
+		ID         int32     `db:"id" primary_key:"y"`
+		Kind       string    `db:"kind"`
+		OccurredAt time.Time `db:"occurred_at"`
+	}
+
+	monthly := WithTableResolver(TimePartitions("OccurredAt", "_2006_01"))
+	source := event{Kind: "login", OccurredAt: time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)}
+	expected := "SELECT events_2024_05.id, events_2024_05.kind, events_2024_05.occurred_at FROM events_2024_05 WHERE true AND events_2024_05.kind LIKE ?"
+	qry, _, err := BuildReadQueryWithOptions("events", &source, monthly)
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "INSERT INTO events_2024_05 (events_2024_05.kind) VALUES (?)"
+	if qry, _, err = BuildCreateQuery("events", &source, monthly); err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT events.id, events.kind, events.occurred_at FROM events WHERE true AND events.kind LIKE ?"
+	if qry, _, err = BuildReadQueryWithOptions("events", &event{Kind: "login"}, monthly); err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	sharded := NewBuilder(Config{Dialect: Postgres, TableResolver: func(base string, msg interface{}) string {
+		return fmt.Sprintf("%s_%d", base, msg.(*event).ID%4)
+	}})
+	expected = "DELETE FROM events_3 WHERE events_3.id = $1"
+	if qry, _, err = sharded.BuildDeleteQuery("events", &event{ID: 7}); err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestReadView(t *testing.T) {
+	type task struct {
+		ID           int32  `db:"id" primary_key:"y"`
+		Title        string `db:"title"`
+		AssigneeName string `db:"assignee_name" readonly:"y"`
+	}
+	RegisterReadView(&task{}, "task_detail_view")
+	t.Cleanup(func() { RegisterReadView(&task{}, "") })
+
+	expected := "SELECT task_detail_view.id, task_detail_view.title, task_detail_view.assignee_name FROM task_detail_view WHERE true AND task_detail_view.title LIKE ?"
+	qry, _, err := BuildReadQueryWithOptions("task", &task{Title: "docs"})
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "SELECT COUNT(*) FROM task_detail_view WHERE TRUE AND task_detail_view.title LIKE ?"
+	if qry, _, err = BuildCountQuery("task", &task{Title: "docs"}); err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "UPDATE task SET task.title = ? WHERE task.id = ?"
+	if qry, _, err = BuildUpdateQuery("task", &task{ID: 1, Title: "docs"}, []string{"title"}); err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "DELETE FROM task WHERE task.id = ?"
+	if qry, _, err = BuildDeleteQuery("task", &task{ID: 1}); err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestColumnAliases(t *testing.T) {
+	type task struct {
+		ID        int32  `db:"id" primary_key:"y"`
+		Title     string `db:"title"`
+		CreatedAt string `db:"created_at"`
+		Notes     string
+	}
+	if err := RegisterColumnAliases(&task{}, map[string]string{"ID": "tsk_id", "created_at": "tsk_dt_crtd", "Notes": "tsk_nts"}); err != nil {
+		t.Fatal("RegisterColumnAliases failed", err)
+	}
+	t.Cleanup(func() { RegisterColumnAliases(&task{}, nil) })
+
+	expected := "SELECT task.tsk_id, task.title, task.tsk_dt_crtd, task.tsk_nts FROM task WHERE true AND task.tsk_dt_crtd LIKE ?"
+	qry, args, err := BuildReadQueryWithOptions("task", &task{CreatedAt: "2024"})
+	if err != nil {
+		t.Fatal("BuildReadQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+	if fmt.Sprint(args) != "[2024]" {
+		t.Fatal("unexpected args", args)
+	}
+
+	expected = "UPDATE task SET task.tsk_nts = ? WHERE task.tsk_id = ?"
+	if qry, args, err = BuildUpdateQuery("task", &task{ID: 3, Notes: "late"}, []string{"tsk_nts"}); err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+	if fmt.Sprint(args) != "[late 3]" {
+		t.Fatal("unexpected args", args)
+	}
+
+	if err := RegisterColumnAliases(&task{}, map[string]string{"Missing": "x"}); !errors.Is(err, ErrUnknownField) {
+		t.Fatal("expected ErrUnknownField, got", err)
+	}
+
+	type legacy struct {
+		Name string
+	}
+	mapping := strings.NewReader(`{"legacy": {"Name": "lgcy_nm"}}`)
+	if err := LoadColumnAliases(mapping, &legacy{}); err != nil {
+		t.Fatal("LoadColumnAliases failed", err)
+	}
+	t.Cleanup(func() { RegisterColumnAliases(&legacy{}, nil) })
+	expected = "SELECT legacy.lgcy_nm FROM legacy WHERE true AND legacy.lgcy_nm LIKE ?"
+	if qry, _, err = BuildReadQueryWithOptions("legacy", &legacy{Name: "a"}); err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+	if err := LoadColumnAliases(strings.NewReader(`{"other": {}}`), &legacy{}); err == nil {
+		t.Fatal("expected an error for an unknown type")
+	}
+}
+
+func TestTableMetadata(t *testing.T) {
+	type ticket struct {
+		Id      int32  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+		Title   string `protobuf:"bytes,2,opt,name=title,proto3" json:"title,omitempty"`
+		Body    string `protobuf:"bytes,3,opt,name=body,proto3" json:"body,omitempty"`
+		Removed int32  `protobuf:"varint,4,opt,name=removed,proto3" json:"removed,omitempty"`
+	}
+	mapping := strings.NewReader(`[{
+		"message": "ticket",
+		"table": "support_ticket",
+		"primary_key": ["id"],
+		"columns": {"id": "id", "title": "title", "body": "body", "removed": "is_removed"},
+		"soft_delete": {"Field": "Removed", "Column": "is_removed", "Value": 1},
+		"searchable": ["title"],
+		"tags": {"body": "nullable:\"y\""}
+	}]`)
+	if err := LoadTableMetadata(mapping, &ticket{}); err != nil {
+		t.Fatal("LoadTableMetadata failed", err)
+	}
+	t.Cleanup(func() {
+		RegisterTableMetadata(&ticket{}, TableMetadata{})
+	})
+	table := TableName(&ticket{})
+	if table != "support_ticket" {
+		t.Fatal("unexpected table", table)
+	}
+
+	expected := "SELECT support_ticket.id, support_ticket.title, ifnull(support_ticket.body, '') as body, support_ticket.is_removed FROM support_ticket WHERE support_ticket.id = ?"
+	qry, _, err := BuildReadByPKQuery(table, &ticket{Id: 2})
+	if err != nil {
+		t.Fatal("BuildReadByPKQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "UPDATE support_ticket SET support_ticket.is_removed = ? WHERE support_ticket.id = ?"
+	qry, args, err := BuildDeleteQuery(table, &ticket{Id: 2})
+	if err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+	if fmt.Sprint(args) != "[1 2]" {
+		t.Fatal("unexpected args", args)
+	}
+
+	qry, _, err = BuildSearchQuery(table, &ticket{}, "printer")
+	if err != nil {
+		t.Fatal("BuildSearchQuery failed", err)
+	}
+	if !strings.Contains(qry, "support_ticket.title LIKE ?") || strings.Contains(qry, "body LIKE") {
+		t.Fatal("expected the search to be restricted to the title, got", qry)
+	}
+
+	err = RegisterTableMetadata(&ticket{}, TableMetadata{PrimaryKey: []string{"ticket_id"}})
+	if !errors.Is(err, ErrUnknownField) {
+		t.Fatal("expected ErrUnknownField, got", err)
+	}
+}
+
+func TestProtoOptions(t *testing.T) {
+	type property struct {
+		Id  int32   `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+		Lat float64 `protobuf:"fixed64,2,opt,name=lat,proto3" json:"lat,omitempty"`
+	}
+	option := func(number protowire.Number, value string) []byte {
+		if value == "" {
+			return protowire.AppendVarint(protowire.AppendTag(nil, number, protowire.VarintType), 1)
+		}
+		return protowire.AppendString(protowire.AppendTag(nil, number, protowire.BytesType), value)
+	}
+	fieldOptions := func(raw ...[]byte) *descriptorpb.FieldOptions {
+		opts := &descriptorpb.FieldOptions{}
+		opts.ProtoReflect().SetUnknown(bytes.Join(raw, nil))
+		return opts
+	}
+	messageOptions := &descriptorpb.MessageOptions{}
+	messageOptions.ProtoReflect().SetUnknown(option(tableOption, "property"))
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("property.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name:    proto.String("Property"),
+			Options: messageOptions,
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:    proto.String("id"),
+				Number:  proto.Int32(1),
+				Type:    descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+				Options: fieldOptions(option(columnOption, "id"), option(primaryKeyOption, "")),
+			}, {
+				Name:    proto.String("lat"),
+				Number:  proto.Int32(2),
+				Type:    descriptorpb.FieldDescriptorProto_TYPE_DOUBLE.Enum(),
+				Options: fieldOptions(option(columnOption, "geolocation_lat"), option(tagsOption, `indexed:"y"`)),
+			}},
+		}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registered := annotationsOf(reflect.TypeOf(property{}), fd.Messages().Get(0))
+	if registered == nil || registered.table != "property" {
+		t.Fatal("expected the table option to be read, got", registered)
+	}
+	annotationsMu.Lock()
+	annotations[reflect.TypeOf(property{})] = registered
+	annotationsMu.Unlock()
+	t.Cleanup(func() {
+		annotationsMu.Lock()
+		delete(annotations, reflect.TypeOf(property{}))
+		annotationsMu.Unlock()
+	})
+
+	if table := TableName(&property{}); table != "property" {
+		t.Fatal("unexpected table", table)
+	}
+	expected := "SELECT property.id, property.geolocation_lat FROM property WHERE property.id = ?"
+	qry, _, err := BuildReadByPKQuery("property", &property{Id: 1})
+	if err != nil {
+		t.Fatal("BuildReadByPKQuery failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+	expected = "SELECT property.id, property.geolocation_lat FROM property WHERE true AND property.geolocation_lat = ?"
+	if qry, _, err = BuildReadQueryWithOptions("property", &property{Lat: 1.5}); err != nil || qry != expected {
+		t.Log("Got:", qry, err)
+		t.Fatal("Expected:", expected)
+	}
+}
+
+func TestIndexHints(t *testing.T) {
+	type order struct {
+		ID         int32  `db:"id" primary_key:"y"`
+		Status     string `db:"status"`
+		CustomerID int32  `db:"customer_id" indexed:"y"`
+	}
+
+	source := order{Status: "open", CustomerID: 7}
+	expected := "SELECT order.id, order.status, order.customer_id FROM order USE INDEX (idx_customer) WHERE true AND order.customer_id = ? AND order.status LIKE ?"
+	qry, args, err := BuildReadQueryWithOptions("order", &source, WithIndexHint("idx_customer"))
+	if err != nil {
+		t.Fatal("BuildReadQueryWithOptions failed", err)
+	}
+	if qry != expected || len(args) != 2 || args[0] != int32(7) {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expected)
+	}
+
+	expected = "/*+ IndexScan(order idx_customer) */ SELECT COUNT(*) FROM order WHERE TRUE AND order.customer_id = $1 AND order.status LIKE $2"
+	qry, _, err = BuildCountQueryWithOptions("order", &source, WithDialect(Postgres), WithForceIndex("idx_customer"))
+	if err != nil {
+		t.Fatal("BuildCountQueryWithOptions failed", err)
+	}
+	if qry != expected {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expected)
+	}
+
+	if _, _, err := BuildReadQueryWithOptions("order", &source, WithIndexHint("idx) WHERE false")); !errors.Is(err, ErrUnsafePredicate) {
+		t.Fatal("expected ErrUnsafePredicate for an invalid index name, got", err)
+	}
+}
+
+func TestDebugReadQuery(t *testing.T) {
+	source := ContactFilter{ID: 3, Name: "o'brien"}
+	debug, err := DebugReadQuery("contact", &source)
+	if err != nil {
+		t.Fatal("DebugReadQuery failed", err)
+	}
+	expected := "SELECT contact.id, contact.name, contact.email, contact.is_active FROM contact WHERE true AND contact.id = 3 AND contact.name LIKE 'o''brien'"
+	if debug.SQL != expected {
+		t.Log("Got:", debug.SQL)
+		t.Fatal("Expected:", expected)
+	}
+	where := []FieldClause{
+		{Field: "ID", Column: "id", Kind: ClauseWhere, Clause: "contact.id = :id"},
+		{Field: "Name", Column: "name", Kind: ClauseWhere, Clause: "contact.name LIKE :name"},
+	}
+	var got []FieldClause
+	for _, clause := range debug.Clauses {
+		if clause.Kind == ClauseWhere {
+			got = append(got, clause)
+		}
+	}
+	if !reflect.DeepEqual(got, where) {
+		t.Fatal("unexpected clauses", debug.Clauses)
+	}
+
+	interpolated, err := Interpolate("SELECT ? FROM t WHERE a = $2 AND b = $1 AND c = '$1'", []interface{}{`a\b`, []byte{0xca, 0xfe}}, Postgres)
+	if err != nil {
+		t.Fatal("Interpolate failed", err)
+	}
+	expected = `SELECT ? FROM t WHERE a = '\xcafe' AND b = 'a\b' AND c = '$1'`
+	if interpolated != expected {
+		t.Log("Got:", interpolated)
+		t.Fatal("Expected:", expected)
+	}
+	if _, err := Interpolate("SELECT ?", nil, MySQL); err == nil {
+		t.Fatal("expected an error for a missing arg")
+	}
+}
+
+func TestAutoTimestamps(t *testing.T) {
+	source := AuditedStruct{ID: 1, Name: "name", CreatedAt: "2019-01-01"}
+	expectedCreate := "INSERT INTO audited (audited.name, audited.created_at, audited.updated_at) VALUES (?, NOW(), NOW())"
+	qry, args, err := BuildCreateQuery("audited", &source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qry != expectedCreate || len(args) != 1 {
+		t.Log("Got:", qry, args)
+		t.Fatal("Expected:", expectedCreate)
+	}
+
+	expectedUpdate := "UPDATE audited SET name = ?, updated_at = CURRENT_TIMESTAMP WHERE audited.id = ?"
+	qry, _, err = BuildUpdateQuery("audited", &source, nil, WithDialect(SQLite))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qry != expectedUpdate {
+		t.Log("Got:", qry)
+		t.Fatal("Expected:", expectedUpdate)
+	}
+
+	unchanged := AuditedStruct{ID: 1}
+	if _, _, err := BuildUpdateQuery("audited", &unchanged, nil); !errors.Is(err, ErrEmptyUpdate) {
+		t.Fatal("expected ErrEmptyUpdate, got", err)
+	}
+}
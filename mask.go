@@ -0,0 +1,62 @@
+package pbsql
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// maskNames returns every name a field mask entry may refer to a field by: its go name, the name and json name of
+// its protobuf field, its json name, and its column
+func maskNames(self reflect.StructField) []string {
+	names := []string{self.Name}
+	for _, option := range strings.Split(self.Tag.Get("protobuf"), ",") {
+		if name := strings.TrimPrefix(option, "name="); name != option {
+			names = append(names, name)
+		} else if name := strings.TrimPrefix(option, "json="); name != option {
+			names = append(names, name)
+		}
+	}
+	if name := strings.Split(self.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+		names = append(names, name)
+	}
+	if name := columnName(self); name != "" {
+		names = append(names, name)
+	}
+	return names
+}
+
+// normalizeMask returns the go names of the fields of `t` listed in `mask`, whose entries may be go names such as
+// `FirstName`, the proto paths of a gRPC FieldMask such as `first_name`, or columns. An error lists the entries which
+// don't name any field.
+func normalizeMask(t reflect.Type, target string, mask []string) ([]string, error) {
+	if len(mask) == 0 {
+		return mask, nil
+	}
+	byName := make(map[string]string, t.NumField())
+	for i := t.NumField() - 1; i >= 0; i-- {
+		self := structField(t, i)
+		if self.PkgPath != "" {
+			continue
+		}
+		for _, name := range maskNames(self) {
+			byName[name] = self.Name
+		}
+		if column := columnOf(t, self); column != "" {
+			byName[column] = self.Name
+		}
+	}
+	normalized := make([]string, 0, len(mask))
+	var unknown []string
+	for _, entry := range mask {
+		if name, ok := byName[entry]; ok {
+			normalized = append(normalized, name)
+		} else {
+			unknown = append(unknown, entry)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("%w: %s has no field %s", ErrUnknownField, target, strings.Join(unknown, ", "))
+	}
+	return normalized, nil
+}
@@ -0,0 +1,99 @@
+package pbsql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MaskFunc returns the SQL expression selecting the masked value of `column`, e.g.
+// `CONCAT('***-**-', RIGHT(user.ssn, 4))`, see RegisterMask
+type MaskFunc func(d Dialect, column string) string
+
+var (
+	masksMu sync.RWMutex
+	masks   = map[string]MaskFunc{
+		"ssn":    func(d Dialect, column string) string { return d.concat("'***-**-'", d.right(column, 4)) },
+		"last4":  func(d Dialect, column string) string { return d.concat("'****'", d.right(column, 4)) },
+		"email":  maskEmail,
+		"redact": func(d Dialect, column string) string { return "'***'" },
+	}
+)
+
+// RegisterMask makes `fn` available to fields tagged `mask:"<name>"`, replacing any mask registered under the same
+// name. The built in masks are:
+//
+//   - ssn: the last 4 characters after `***-**-`
+//   - last4: the last 4 characters after `****`, e.g. for card or phone numbers
+//   - email: the first character and the domain, e.g. `a***@b.c`
+//   - redact: `***`
+func RegisterMask(name string, fn MaskFunc) {
+	masksMu.Lock()
+	defer masksMu.Unlock()
+	masks[name] = fn
+}
+
+// WithMasking selects the masked value of the fields tagged `mask:"<name>"` rather than their column, e.g.
+// `CONCAT('***-**-', RIGHT(user.ssn, 4))` for `mask:"ssn"`, so that restricted callers never receive the actual
+// values. Executors apply it with MaskPolicy. Predicates still compare the columns themselves.
+func WithMasking() Option {
+	return func(o *options) {
+		o.masking = true
+	}
+}
+
+// MaskPolicy masks the fields tagged `mask` in the reads of callers holding none of `unmaskedRoles`, including
+// callers without claims, see WithMasking
+func MaskPolicy(unmaskedRoles ...string) Policy {
+	return func(ctx context.Context, req PolicyRequest) ([]Option, error) {
+		if req.Operation != OpRead || (req.HasClaims && req.Claims.HasRole(unmaskedRoles...)) {
+			return nil, nil
+		}
+		return []Option{WithMasking()}, nil
+	}
+}
+
+// maskedSelect returns the select list entry of the masked value of `f`, reporting false if `f` isn't masked. Fields
+// tagged with a mask which isn't registered are redacted, so that a typo never reveals their values.
+func (qb *queryBuilder) maskedSelect(f *field) (string, bool) {
+	name := f.self.Tag.Get("mask")
+	if !qb.masking || name == "" {
+		return "", false
+	}
+	masksMu.RLock()
+	fn, ok := masks[name]
+	masksMu.RUnlock()
+	if !ok {
+		fn = masks["redact"]
+	}
+	return "COALESCE(" + fn(qb.dialect, f.column()) + ", '') as " + f.name, true
+}
+
+// maskEmail keeps the first character and the domain of an email address
+func maskEmail(d Dialect, column string) string {
+	switch d {
+	case Postgres:
+		return d.concat("LEFT("+column+", 1)", "'***'", "SUBSTRING("+column+" FROM POSITION('@' IN "+column+"))")
+	case SQLite:
+		return d.concat("substr("+column+", 1, 1)", "'***'", "substr("+column+", instr("+column+", '@'))")
+	default:
+		return d.concat("LEFT("+column+", 1)", "'***'", "SUBSTRING("+column+", LOCATE('@', "+column+"))")
+	}
+}
+
+// concat returns the concatenation of `exprs`
+func (d Dialect) concat(exprs ...string) string {
+	if d == MySQL {
+		return "CONCAT(" + strings.Join(exprs, ", ") + ")"
+	}
+	return strings.Join(exprs, " || ")
+}
+
+// right returns the last `n` characters of `expr`
+func (d Dialect) right(expr string, n int) string {
+	if d == SQLite {
+		return fmt.Sprintf("substr(%s, -%d)", expr, n)
+	}
+	return fmt.Sprintf("RIGHT(%s, %d)", expr, n)
+}
@@ -0,0 +1,85 @@
+package pbsql
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MaterializedView is a materialized view computed from tables written through an Executor, see
+// WithMaterializedViews
+type MaterializedView struct {
+	Name string
+	// Tables lists the tables the view is computed from, a write to any of them refreshes the view
+	Tables []string
+	// Concurrently refreshes the view without locking out its readers, which requires a unique index on the view
+	Concurrently bool
+	// Debounce delays the refresh by that long after the first write, merging the refreshes requested meanwhile into
+	// one. Zero refreshes the view after every write, before the write returns.
+	Debounce time.Duration
+}
+
+// WithMaterializedViews refreshes each of `views` with REFRESH MATERIALIZED VIEW after the writes of the tables it is
+// computed from, once their transaction commits if they run in one, keeping reporting views current. Materialized
+// views are a Postgres feature. Refreshes are reported to the tracer and logger of the Executor as OpRefresh. Their
+// errors are only reported there, since the write they follow has succeeded. Debounced refreshes run after the write
+// returns, with a context of their own.
+func WithMaterializedViews(views ...MaterializedView) ExecutorOption {
+	return func(e *Executor) {
+		if e.matViews == nil {
+			e.matViews = make(map[string][]*matView)
+		}
+		for _, view := range views {
+			mv := &matView{MaterializedView: view}
+			for _, table := range view.Tables {
+				e.matViews[table] = append(e.matViews[table], mv)
+			}
+		}
+	}
+}
+
+// matView holds the refresh state of a materialized view registered with an Executor
+type matView struct {
+	MaterializedView
+	mu sync.Mutex
+	// scheduled reports whether a debounced refresh is waiting to run
+	scheduled bool
+}
+
+// statement returns the statement refreshing the view
+func (mv *matView) statement() string {
+	if mv.Concurrently {
+		return "REFRESH MATERIALIZED VIEW CONCURRENTLY " + mv.Name
+	}
+	return "REFRESH MATERIALIZED VIEW " + mv.Name
+}
+
+// refreshViews refreshes the materialized views computed from `table` after a write to it, or schedules the refresh of
+// those which are debounced
+func (e *Executor) refreshViews(ctx context.Context, table string) {
+	for _, mv := range e.matViews[table] {
+		if mv.Debounce <= 0 {
+			e.refreshView(ctx, mv)
+			continue
+		}
+		mv.mu.Lock()
+		if !mv.scheduled {
+			mv.scheduled = true
+			mv := mv
+			time.AfterFunc(mv.Debounce, func() {
+				mv.mu.Lock()
+				mv.scheduled = false
+				mv.mu.Unlock()
+				e.refreshView(context.Background(), mv)
+			})
+		}
+		mv.mu.Unlock()
+	}
+}
+
+// refreshView runs the statement refreshing `mv`
+func (e *Executor) refreshView(ctx context.Context, mv *matView) {
+	e.silent().execBuilt(ctx, mv.Name, OpRefresh, func() (string, interface{}, error) {
+		return mv.statement(), map[string]interface{}{}, nil
+	})
+}
@@ -0,0 +1,212 @@
+package pbsql
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TableMetadata describes how a message type is stored, for messages whose struct tags can't be changed, such as
+// generated protobuf messages. Fields are named by any name a field mask entry may use: the go name, the protobuf
+// name, or their column. Everything a TableMetadata sets takes precedence over the tags of the message.
+type TableMetadata struct {
+	// Message names the type by its protobuf full name or go type name, it is only read by LoadTableMetadata
+	Message string `json:"message" yaml:"message"`
+	// Table is the table the message is stored in, see TableName
+	Table string `json:"table" yaml:"table"`
+	// PrimaryKey lists the fields of the primary key, replacing any fields tagged `primary_key`
+	PrimaryKey []string `json:"primary_key" yaml:"primary_key"`
+	// Columns maps fields to their columns, see RegisterColumnAliases
+	Columns map[string]string `json:"columns" yaml:"columns"`
+	// SoftDelete replaces the soft delete policy of the config for the message
+	SoftDelete *SoftDelete `json:"soft_delete" yaml:"soft_delete"`
+	// Searchable lists the fields BuildSearchQuery matches the phrase against, as if tagged `searchable:"y"`
+	Searchable []string `json:"searchable" yaml:"searchable"`
+	// Relations maps fields holding related messages to the tables they are read from
+	Relations map[string]Relation `json:"relations" yaml:"relations"`
+	// Tags maps fields to any other tags they should have, e.g. `nullable:"y" case_insensitive:"y"`
+	Tags map[string]string `json:"tags" yaml:"tags"`
+}
+
+// Relation describes a field holding related messages like the `foreign_table`, `foreign_key`, `local_name`, and
+// `m2m` tags, see WithPreload and BuildAssociationQueries
+type Relation struct {
+	Table      string `json:"table" yaml:"table"`
+	ForeignKey string `json:"foreign_key" yaml:"foreign_key"`
+	LocalName  string `json:"local_name" yaml:"local_name"`
+	M2M        string `json:"m2m" yaml:"m2m"`
+}
+
+// registeredType holds the metadata registered for a message type
+type registeredType struct {
+	table      string
+	softDelete *SoftDelete
+	// tags are prepended to the tags of fields by go field name, so that they take precedence
+	tags map[string]string
+	// columns are the column aliases declared with proto options, registered aliases are held by RegisterColumnAliases
+	columns map[string]string
+}
+
+var (
+	metadataMu sync.RWMutex
+	metadata   = make(map[reflect.Type]*registeredType)
+)
+
+// RegisterTableMetadata stores messages of the type of `msg` as described by `md`, usually during initialization.
+// An error lists the names which don't match any field, and nothing is registered then. Registering the same type
+// again replaces its metadata.
+func RegisterTableMetadata(msg interface{}, md TableMetadata) error {
+	t := reflect.TypeOf(msg)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("pbsql: cannot register the metadata of %s, it isn't a struct", t)
+	}
+	tags := make(map[string][]string)
+	var unknown []string
+	tag := func(name string, key, value string) {
+		field, ok := namedField(t, name)
+		if !ok {
+			unknown = append(unknown, name)
+			return
+		}
+		if value != "" {
+			tags[field] = append(tags[field], key+":"+strconv.Quote(value))
+		}
+	}
+	if len(md.PrimaryKey) > 0 {
+		for i := 0; i < t.NumField(); i++ {
+			tags[t.Field(i).Name] = []string{`primary_key:""`}
+		}
+		for _, name := range md.PrimaryKey {
+			if field, ok := namedField(t, name); ok {
+				tags[field] = nil
+			}
+			tag(name, "primary_key", "y")
+		}
+	}
+	for _, name := range md.Searchable {
+		tag(name, "searchable", "y")
+	}
+	for name, rel := range md.Relations {
+		tag(name, "foreign_table", rel.Table)
+		tag(name, "foreign_key", rel.ForeignKey)
+		tag(name, "local_name", rel.LocalName)
+		tag(name, "m2m", rel.M2M)
+	}
+	for name, extra := range md.Tags {
+		if field, ok := namedField(t, name); ok {
+			tags[field] = append(tags[field], extra)
+		} else {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("%w: %s has no field %s", ErrUnknownField, t, strings.Join(unknown, ", "))
+	}
+	if err := RegisterColumnAliases(msg, md.Columns); err != nil {
+		return err
+	}
+
+	registered := &registeredType{table: md.Table, softDelete: md.SoftDelete, tags: make(map[string]string, len(tags))}
+	for field, fieldTags := range tags {
+		if len(fieldTags) > 0 {
+			registered.tags[field] = strings.Join(fieldTags, " ")
+		}
+	}
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	metadata[t] = registered
+	return nil
+}
+
+// LoadTableMetadata registers the metadata of a JSON list of TableMetadata read from `r`, each naming its message
+// type, which must be among `msgs`:
+//
+//	[{"message": "tasks.v1.Task", "table": "task", "primary_key": ["id"], "searchable": ["title"]}]
+//
+// Files kept in YAML can be decoded into a []TableMetadata, whose fields carry `yaml` tags, and registered the same
+// way with RegisterTableMetadata.
+func LoadTableMetadata(r io.Reader, msgs ...interface{}) error {
+	var mds []TableMetadata
+	if err := json.NewDecoder(r).Decode(&mds); err != nil {
+		return fmt.Errorf("pbsql: cannot decode table metadata: %w", err)
+	}
+	byType := messagesByName(msgs)
+	for _, md := range mds {
+		msg, ok := byType[md.Message]
+		if !ok {
+			return fmt.Errorf("pbsql: table metadata of %q doesn't match any of the given messages", md.Message)
+		}
+		if err := RegisterTableMetadata(msg, md); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TableName returns the table registered for the type of `msg` with RegisterTableMetadata or declared with the
+// `(pbsql.table)` option, empty if there is none
+func TableName(msg interface{}) string {
+	if registered := metadataOf(reflect.TypeOf(msg)); registered != nil {
+		return registered.table
+	}
+	return ""
+}
+
+// metadataOf returns the metadata registered for `t` or the type it points to, or declared by its proto options, nil
+// if there is none
+func metadataOf(t reflect.Type) *registeredType {
+	if t == nil {
+		return nil
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	metadataMu.RLock()
+	registered, ok := metadata[t]
+	metadataMu.RUnlock()
+	if ok {
+		return registered
+	}
+	return annotatedMetadata(t)
+}
+
+// structField returns the `i`th field of the struct type `t` with the tags registered for it, see TableMetadata
+func structField(t reflect.Type, i int) reflect.StructField {
+	return withRegisteredTags(t, t.Field(i))
+}
+
+// structFieldByName behaves like reflect.Type.FieldByName, adding the tags registered for the field
+func structFieldByName(t reflect.Type, name string) (reflect.StructField, bool) {
+	self, ok := t.FieldByName(name)
+	if !ok || len(self.Index) != 1 {
+		return self, ok
+	}
+	return withRegisteredTags(t, self), true
+}
+
+// withRegisteredTags prepends the tags registered for the field `self` of `t` to its own, so they take precedence
+func withRegisteredTags(t reflect.Type, self reflect.StructField) reflect.StructField {
+	if registered := metadataOf(t); registered != nil {
+		if tags, ok := registered.tags[self.Name]; ok {
+			self.Tag = reflect.StructTag(tags + " " + string(self.Tag))
+		}
+	}
+	return self
+}
+
+// softDeleteOf returns the soft delete policy registered for `t`, if any
+func softDeleteOf(t reflect.Type) (SoftDelete, bool) {
+	if registered := metadataOf(t); registered != nil && registered.softDelete != nil {
+		return *registered.softDelete, true
+	}
+	return SoftDelete{}, false
+}
@@ -0,0 +1,123 @@
+package pbsql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// SchemaSnapshot returns the columns BuildCreateTableQuery would create for `source`. A snapshot can be stored
+// alongside a message version and later passed to BuildMigrationQueries to migrate to a newer version of it.
+func SchemaSnapshot(target string, source interface{}, opts ...Option) ([]ColumnInfo, error) {
+	columns, _, err := tableDefinition(target, source, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	snapshot := make([]ColumnInfo, len(columns))
+	for i, column := range columns {
+		snapshot[i] = column.ColumnInfo
+	}
+	return snapshot, nil
+}
+
+// BuildMigrationQueries compares the table BuildCreateTableQuery would create for `source` with the `current` columns
+// of `target`, as returned by TableColumns or SchemaSnapshot, and returns the statements migrating the table:
+// ADD COLUMN for new fields, DROP COLUMN for columns without a field, and MODIFY or ALTER COLUMN for columns whose type
+// or nullability changed. If `current` is empty the table is created instead.
+//
+// Types are compared by family (integer, text, time, ...) since databases report them in their own words, so
+// widening an INT to a BIGINT is not detected. SQLite can't alter columns, an error is returned if that is required.
+//
+// Dropped columns lose their data, always review the statements before applying them.
+func BuildMigrationQueries(target string, source interface{}, current []ColumnInfo, opts ...Option) ([]string, error) {
+	o := newOptions(opts)
+	if len(current) == 0 {
+		qry, err := BuildCreateTableQuery(target, source, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return []string{qry}, nil
+	}
+	target = o.table(target, source)
+	columns, _, err := tableDefinition(target, source, o)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]ColumnInfo, len(current))
+	for _, column := range current {
+		existing[strings.ToLower(column.Name)] = column
+	}
+	wanted := make(map[string]bool, len(columns))
+
+	var queries []string
+	for _, column := range columns {
+		wanted[strings.ToLower(column.Name)] = true
+		old, ok := existing[strings.ToLower(column.Name)]
+		if !ok {
+			queries = append(queries, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", target, column.definition))
+			continue
+		}
+		retyped := !o.dialect.sameFamily(columnFamily(old.DataType), columnFamily(column.DataType))
+		if !retyped && old.Nullable == column.Nullable {
+			continue
+		}
+		altered, err := o.dialect.alterColumn(target, column, retyped, old.Nullable != column.Nullable)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, altered...)
+	}
+	for _, column := range current {
+		if !wanted[strings.ToLower(column.Name)] {
+			queries = append(queries, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", target, column.Name))
+		}
+	}
+	return queries, nil
+}
+
+// PlanMigration reads the columns of `target` from the database and returns the statements bringing the table in
+// line with `source`, see BuildMigrationQueries
+func PlanMigration(ctx context.Context, db *sqlx.DB, target string, source interface{}) ([]string, error) {
+	columns, err := TableColumns(ctx, db, target)
+	if err != nil {
+		return nil, err
+	}
+	return BuildMigrationQueries(target, source, columns, WithDialect(DialectFromDriver(db.DriverName())))
+}
+
+// sameFamily reports whether two column type families are interchangeable, MySQL reports BOOLEAN columns as tinyint
+func (d Dialect) sameFamily(a, b string) bool {
+	if a == b || a == familyOther || b == familyOther {
+		return true
+	}
+	if d == MySQL {
+		return (a == familyBool || a == familyInteger) && (b == familyBool || b == familyInteger)
+	}
+	return false
+}
+
+// alterColumn returns the statements changing the type and/or nullability of an existing column
+func (d Dialect) alterColumn(target string, column columnDefinition, retyped, renulled bool) ([]string, error) {
+	switch d {
+	case Postgres:
+		var queries []string
+		if retyped {
+			queries = append(queries, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s::%s", target, column.Name, column.DataType, column.Name, column.DataType))
+		}
+		if renulled {
+			if column.Nullable {
+				queries = append(queries, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL", target, column.Name))
+			} else {
+				queries = append(queries, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", target, column.Name))
+			}
+		}
+		return queries, nil
+	case SQLite:
+		return nil, fmt.Errorf("pbsql: sqlite cannot alter column %s.%s, the table must be rebuilt", target, column.Name)
+	default:
+		return []string{fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s", target, column.definition)}, nil
+	}
+}
@@ -0,0 +1,41 @@
+package pbsql
+
+import (
+	"reflect"
+	"strings"
+)
+
+// negationSuffix names the companion fields excluding the values of another field, e.g. `NameNot` for `Name`
+const negationSuffix = "Not"
+
+// negatedColumn returns the column a field of `t` excludes values from, reporting false if it doesn't negate one.
+// Fields tagged `negate:"y"` exclude the column of their `db` or `name` tag, and fields named after another field
+// with the `Not` suffix and no `db` tag exclude its column, e.g. `NameNot` writes `AND user.name NOT LIKE :namenot`.
+// Companions must have the type of the field they are named after.
+func negatedColumn(t reflect.Type, self reflect.StructField) (string, bool) {
+	if self.Tag.Get("negate") == "y" {
+		if name := self.Tag.Get("db"); name != "" && name != "-" {
+			return name, true
+		}
+		name := self.Tag.Get("name")
+		return name, name != ""
+	}
+	if _, tagged := self.Tag.Lookup("db"); tagged || self.PkgPath != "" || !strings.HasSuffix(self.Name, negationSuffix) {
+		return "", false
+	}
+	base, ok := structFieldByName(t, strings.TrimSuffix(self.Name, negationSuffix))
+	if !ok || base.Type != self.Type || base.Tag.Get("negate") == "y" || arrayMode(base) == arrayIn {
+		return "", false
+	}
+	name := columnOf(t, base)
+	return name, name != ""
+}
+
+// param returns the name of the param bound for the field. Negating fields share their column with another field,
+// so like IN filters they are bound by their lower cased field name instead.
+func (f *field) param() string {
+	if f.isNegated || f.array == arrayIn {
+		return strings.ToLower(f.self.Name)
+	}
+	return f.name
+}
@@ -0,0 +1,38 @@
+package pbsql
+
+import (
+	"database/sql/driver"
+	"reflect"
+)
+
+var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+// isNullType reports whether `t` is a nullable wrapper such as sql.NullString, sql.Null[T], or null.String of
+// gopkg.in/guregu/null.v4: a struct implementing driver.Valuer, which is unset while its value is NULL. Such fields
+// are always nullable, scan NULL as invalid rather than a default, and are compared by equality.
+func isNullType(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t.Implements(valuerType) && t.NumField() > 0
+}
+
+// nullValue returns the value held by a nullable wrapper, nil if it is invalid
+func nullValue(v reflect.Value) (interface{}, error) {
+	if !v.CanInterface() {
+		return nil, nil
+	}
+	return v.Interface().(driver.Valuer).Value()
+}
+
+// isNullSet reports whether a nullable wrapper holds a value
+func isNullSet(v reflect.Value) bool {
+	value, err := nullValue(v)
+	return err == nil && value != nil
+}
+
+// nullValueType returns the type of the value held by a nullable wrapper, e.g. string for sql.NullString. The
+// wrappers of database/sql hold it in their first field, which those of guregu/null embed.
+func nullValueType(t reflect.Type) reflect.Type {
+	for isNullType(t) {
+		t = t.Field(0).Type
+	}
+	return t
+}
@@ -0,0 +1,60 @@
+package pbsql
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// WithNullDefault replaces null values of `field`, a go field name or column tagged `nullable`, by `value` rather than
+// by its `null_default` tag or the zero value of its type, for a single statement, e.g. WithNullDefault("code", "-1")
+func WithNullDefault(field, value string) Option {
+	return func(o *options) {
+		defaults := make(map[string]string, len(o.nullDefaults)+1)
+		for name, value := range o.nullDefaults {
+			defaults[name] = value
+		}
+		defaults[field] = value
+		o.nullDefaults = defaults
+	}
+}
+
+// nullDefault returns the value replacing null values of `f` given by WithNullDefault or the `null_default` tag
+func (qb *queryBuilder) nullDefault(f *field) (string, bool) {
+	if value, ok := qb.nullDefaults[f.self.Name]; ok {
+		return value, true
+	}
+	if value, ok := qb.nullDefaults[f.name]; ok {
+		return value, true
+	}
+	return f.self.Tag.Lookup("null_default")
+}
+
+// nullDefaultLiteral renders the null default `value` of `f` as a literal of its type: numbers are written as they
+// are, booleans as the literals of the dialect, and anything else as a quoted string, e.g. '1970-01-01', whose colons
+// are escaped so they aren't bound as params. Values which aren't literals of the type are an error.
+func (qb *queryBuilder) nullDefaultLiteral(f *field, value string) (string, error) {
+	kind := f.value.Kind()
+	switch {
+	case kind == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("pbsql: null default %q of %s is not a boolean", value, f.self.Name)
+		}
+		if !b {
+			return qb.dialect.falseLiteral(), nil
+		}
+		if qb.dialect == Postgres {
+			return "TRUE", nil
+		}
+		return "1", nil
+	case isIntegerKind(kind) || kind == reflect.Float32 || kind == reflect.Float64:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "", fmt.Errorf("pbsql: null default %q of %s is not a number", value, f.self.Name)
+		}
+		return value, nil
+	}
+	quoted := "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	return strings.ReplaceAll(quoted, ":", "::"), nil
+}
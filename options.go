@@ -0,0 +1,188 @@
+package pbsql
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Option configures optional behaviour of the query builders. Options are applied in order, so
+// later options override earlier ones where they conflict.
+type Option func(*options)
+
+type options struct {
+	fieldMask []string
+	dialect   Dialect
+	binder    Binder
+	where     []string
+	params    map[string]interface{}
+	indexHint *IndexHint
+	// sensitiveParams are the params of filter values compared with sensitive fields, redacted from logs
+	sensitiveParams map[string]bool
+	// softDelete is the soft delete policy of deletes, see Config
+	softDelete SoftDelete
+	hardDelete bool
+	// primary sends executor reads to the primary, see WithPrimary
+	primary bool
+	// noCache bypasses the executor's ResultCache, see WithoutCache
+	noCache bool
+	// preload lists the relations read after the rows of Executor.Read, see WithPreload
+	preload []string
+	// limit and offset restrict read queries, see WithLimit
+	limit  int
+	offset int
+	// trace records the clauses written for each field, see DebugReadQuery
+	trace bool
+	// caseInsensitive folds the case of LIKE predicates, see WithCaseInsensitive
+	caseInsensitive bool
+	// searchRank orders searches by relevance, see WithSearchRank
+	searchRank bool
+	// fuzzy matches the phrase of searches by similarity above fuzzyThreshold, see WithFuzzySearch
+	fuzzy          bool
+	fuzzyThreshold float64
+	// asOf is the time point-in-time reads see the rows at, read from the history table if asOfHistory, see WithAsOf
+	asOf        time.Time
+	asOfHistory bool
+	// firstPerPartition reads the first row of each partition, see WithFirstPerPartition
+	firstPerPartition bool
+	// masking selects the masked values of fields tagged `mask`, see WithMasking
+	masking bool
+	// nullDefaults replace null values of fields by name, see WithNullDefault
+	nullDefaults map[string]string
+	// escapeLike matches string predicates literally, see WithLikeEscape
+	escapeLike bool
+	// sanitizer checks the values of string predicates, see WithSanitizer
+	sanitizer Sanitizer
+	// strict reports fields the builders would ignore, see WithStrict
+	strict bool
+	// lifecycle is the lifecycle column of the config, scope selects the rows read by it, see Lifecycle
+	lifecycle Lifecycle
+	scope     Scope
+	// lock is the row lock taken by reads, see WithLock
+	lock Lock
+	// tableResolver resolves the target table of a statement, see WithTableResolver
+	tableResolver TableResolver
+	// setFields lists fields filtering reads and written by inserts even when they hold their zero value, see
+	// WithSetFields
+	setFields []string
+	// predicateMask lists go names of fields filtering reads even when zero, given as the variadic mask of
+	// BuildReadQuery. Unlike setFields its entries aren't checked.
+	predicateMask []string
+	// zeroValues writes every column of an insert, see WithZeroValues
+	zeroValues bool
+	// filter is the filter message predicates are written from, see WithFilter
+	filter interface{}
+	// clock tells the current time, see WithClock
+	clock Clock
+	// ids generates the keys tagged pk_gen, see WithIDGenerator
+	ids IDGenerator
+	// limits are the guardrails on the rows of read queries, see Limits
+	limits Limits
+	// budget caps the complexity of read queries, see Budget
+	budget Budget
+
+	allowFullTableUpdate bool
+}
+
+func newOptions(opts []Option) *options {
+	cfg := CurrentConfig()
+	o := &options{dialect: cfg.Dialect, binder: cfg.Binder, softDelete: cfg.SoftDelete, strict: cfg.Strict, lifecycle: cfg.Lifecycle,
+		tableResolver: cfg.TableResolver, clock: cfg.Clock, ids: cfg.IDGenerator, limits: cfg.Limits,
+		budget: cfg.Budget}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(o)
+		}
+	}
+	return o
+}
+
+// WithFieldMask restricts the select list of a read query to the given fields. Entries may be either
+// go struct field names (`GeoLat`) or database column names (`geolocation_lat`), which allows a gRPC
+// read_mask to be passed straight through. An empty mask selects every tagged column.
+func WithFieldMask(fields ...string) Option {
+	return func(o *options) {
+		o.fieldMask = append(o.fieldMask, fields...)
+	}
+}
+
+// AllowFullTableUpdate permits BuildUpdateQuery to generate a statement without a WHERE clause when the source has
+// no primary key, updating every row of the table. This is rarely what you want.
+func AllowFullTableUpdate() Option {
+	return func(o *options) {
+		o.allowFullTableUpdate = true
+	}
+}
+
+// WithWhere appends a custom predicate to the WHERE clause of a read, count, update, or delete statement, e.g.
+// `WithWhere("user.tags && :tags", map[string]interface{}{"tags": tags})`. Named params are bound from `args`
+// before the fields of the source message, so values never need to be interpolated into the clause. Clauses which
+// could end the statement or comment out the rest of it are rejected with ErrUnsafePredicate.
+func WithWhere(clause string, args map[string]interface{}) Option {
+	return func(o *options) {
+		o.where = append(o.where, clause)
+		if len(args) > 0 && o.params == nil {
+			o.params = make(map[string]interface{}, len(args))
+		}
+		for name, value := range args {
+			o.params[name] = value
+		}
+	}
+}
+
+// whereClauses returns the clauses given with WithWhere, validating each of them
+func (o *options) whereClauses() ([]string, error) {
+	for _, clause := range o.where {
+		if err := validatePredicate(clause, o.dialect); err != nil {
+			return nil, err
+		}
+	}
+	return o.where, nil
+}
+
+// validatePredicate rejects a statement terminator or comment outside of a quoted string, as well as unbalanced
+// quotes and parentheses which would let the clause escape into the rest of the statement. `#` only starts a comment
+// on MySQL, elsewhere it is an operator.
+func validatePredicate(clause string, d Dialect) error {
+	if strings.TrimSpace(clause) == "" {
+		return fmt.Errorf("%w: empty clause", ErrUnsafePredicate)
+	}
+	var quote byte
+	depth := 0
+	for i := 0; i < len(clause); i++ {
+		c := clause[i]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == ';':
+			return fmt.Errorf("%w: %q contains a statement terminator", ErrUnsafePredicate, clause)
+		case c == '-' && i+1 < len(clause) && clause[i+1] == '-', c == '/' && i+1 < len(clause) && clause[i+1] == '*', c == '#' && d == MySQL:
+			return fmt.Errorf("%w: %q contains a comment", ErrUnsafePredicate, clause)
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("%w: %q has unbalanced parentheses", ErrUnsafePredicate, clause)
+			}
+		}
+	}
+	if quote != 0 || depth != 0 {
+		return fmt.Errorf("%w: %q has an unterminated quote or parenthesis", ErrUnsafePredicate, clause)
+	}
+	return nil
+}
+
+// selects reports whether a field belongs in the select list under the configured field mask
+func (o *options) selects(f *field) bool {
+	if len(o.fieldMask) == 0 {
+		return true
+	}
+	return findInMask(o.fieldMask, f.self.Name) || findInMask(o.fieldMask, f.name)
+}
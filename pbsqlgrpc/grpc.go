@@ -0,0 +1,253 @@
+// Package pbsqlgrpc serves protobuf messages stored with pbsql over gRPC: a generic CRUD service implementing the
+// handlers of an entity on a pbsql.Executor, and interceptors handing the caller's claims to its policies
+package pbsqlgrpc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/rmilejcz/pbsql"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Service implements the create, get, list, update, and delete handlers of the messages stored in one table, so the
+// handlers of a generated gRPC service are reduced to a call each:
+//
+//	tasks := pbsqlgrpc.NewService(exec, "task")
+//
+//	func (s *TaskServer) GetTask(ctx context.Context, req *pb.Task) (*pb.Task, error) {
+//		return req, s.tasks.Get(ctx, req)
+//	}
+//
+//	func (s *TaskServer) ListTasks(ctx context.Context, req *pb.Task) (*pb.ListTasksResponse, error) {
+//		res := &pb.ListTasksResponse{}
+//		return res, s.tasks.List(ctx, req, &res.Tasks)
+//	}
+//
+// Every method fills the message it is given, and returns errors as gRPC statuses, see Status.
+type Service struct {
+	exec  *pbsql.Executor
+	table string
+	opts  []pbsql.Option
+}
+
+// NewService returns a Service storing messages in `table` with `exec`. `opts` are given to every statement.
+func NewService(exec *pbsql.Executor, table string, opts ...pbsql.Option) *Service {
+	return &Service{exec: exec, table: table, opts: opts}
+}
+
+// Create inserts `msg` and reads the stored row back into it, including generated keys and defaults
+func (s *Service) Create(ctx context.Context, msg interface{}) error {
+	return Status(s.exec.CreateAndRead(ctx, s.table, msg, s.opts...))
+}
+
+// Get reads the row matching the primary key of `msg` into it, NotFound if there is none
+func (s *Service) Get(ctx context.Context, msg interface{}) error {
+	return Status(s.exec.Get(ctx, s.table, msg, s.opts...))
+}
+
+// List reads the rows matching `filter` into `dest`, a pointer to a slice of messages
+func (s *Service) List(ctx context.Context, filter interface{}, dest interface{}) error {
+	return Status(s.exec.Read(ctx, s.table, filter, dest, s.opts...))
+}
+
+// ListPage reads one page of the rows matching `filter` into `dest`, see pbsql.Executor.List
+func (s *Service) ListPage(ctx context.Context, filter interface{}, dest interface{}, pageSize int, pageToken string) (pbsql.Page, error) {
+	page, err := s.exec.List(ctx, s.table, filter, dest, pageSize, pageToken, s.opts...)
+	return page, Status(err)
+}
+
+// Update sets the columns of the row matching the primary key of `msg` to its fields, restricted to `fieldMask` if
+// given, and reads the updated row back into it. NotFound is returned if no row matched.
+func (s *Service) Update(ctx context.Context, msg interface{}, fieldMask []string) error {
+	if _, err := s.exec.Update(ctx, s.table, msg, fieldMask, s.opts...); err != nil {
+		return Status(err)
+	}
+	return Status(s.exec.Get(ctx, s.table, msg, append(append([]pbsql.Option(nil), s.opts...), pbsql.WithPrimary())...))
+}
+
+// Delete deletes the row matching the primary key of `msg`, NotFound if no row matched
+func (s *Service) Delete(ctx context.Context, msg interface{}) error {
+	_, err := s.exec.Delete(ctx, s.table, msg, s.opts...)
+	return Status(err)
+}
+
+// LogInternal logs the errors Status returns as Internal, whose messages are withheld from clients since they may
+// hold statements and column names of the driver. It logs with the standard logger unless replaced.
+var LogInternal = func(err error) {
+	log.Printf("pbsqlgrpc: internal error: %v", err)
+}
+
+// Status returns `err` as a gRPC status error: pbsql.ErrNotFound and sql.ErrNoRows are NotFound, errors caused by the
+// request such as pbsql.ErrMissingPrimaryKey are InvalidArgument, pbsql.ErrMissingClaims is Unauthenticated,
+// pbsql.ErrForbiddenField and pbsql.ErrPolicyDenied are PermissionDenied, statements over budget are ResourceExhausted, unbounded reads are
+// FailedPrecondition, errors which already carry a status are returned as is, and anything else is Internal with a
+// generic message, the error itself being logged with LogInternal. A nil error stays nil.
+//
+// Messages rejected by validation are InvalidArgument with a BadRequest detail listing the violations, constraint
+// violations of the database are mapped by ConstraintStatus, and deadlocks and serialization failures are Aborted,
+// telling clients to retry.
+func Status(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if constraint, ok := pbsql.AsConstraintError(err); ok {
+		return ConstraintStatus(constraint)
+	}
+	var invalid *pbsql.ValidationError
+	if errors.As(err, &invalid) {
+		return validationStatus(invalid)
+	}
+	switch {
+	case pbsql.IsTransientError(err):
+		return status.Error(codes.Aborted, "pbsql: the transaction conflicted with another one, retry it")
+	case errors.Is(err, pbsql.ErrNotFound), errors.Is(err, sql.ErrNoRows):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, pbsql.ErrMissingClaims):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, pbsql.ErrMissingPrimaryKey), errors.Is(err, pbsql.ErrMissingPredicate),
+		errors.Is(err, pbsql.ErrEmptyUpdate), errors.Is(err, pbsql.ErrUnsafePredicate),
+		errors.Is(err, pbsql.ErrInvalidPageToken), errors.Is(err, pbsql.ErrUnknownField),
+		errors.Is(err, pbsql.ErrUnmappedField), errors.Is(err, pbsql.ErrInvalidMessage),
+		errors.Is(err, pbsql.ErrMissingIdempotencyKey):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, pbsql.ErrForbiddenField), errors.Is(err, pbsql.ErrPolicyDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, pbsql.ErrOverBudget):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, pbsql.ErrUnboundedRead):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	LogInternal(err)
+	return status.Error(codes.Internal, "pbsql: internal error")
+}
+
+// validationStatus returns a message rejected by validation as an InvalidArgument status error, with a BadRequest
+// detail listing the violations of its fields
+func validationStatus(invalid *pbsql.ValidationError) error {
+	st := status.New(codes.InvalidArgument, invalid.Error())
+	req := &errdetails.BadRequest{}
+	for _, violation := range invalid.Violations {
+		req.FieldViolations = append(req.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       violation.Field,
+			Description: violation.Reason,
+		})
+	}
+	if detailed, err := st.WithDetails(req); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// ConstraintStatus returns a constraint violation as a gRPC status error: unique violations are AlreadyExists, not
+// null violations InvalidArgument, and foreign key and check violations FailedPrecondition. The message only names
+// the constraint and column, never the values of the row, and an ErrorInfo detail of domain "pbsql" carries them as
+// metadata with a reason such as UNIQUE_VIOLATION, so clients can point at the offending field.
+func ConstraintStatus(constraint *pbsql.ConstraintError) error {
+	code := codes.FailedPrecondition
+	switch constraint.Kind {
+	case pbsql.UniqueViolation:
+		code = codes.AlreadyExists
+	case pbsql.NotNullViolation:
+		code = codes.InvalidArgument
+	}
+	st := status.New(code, constraint.Error())
+	info := &errdetails.ErrorInfo{
+		Reason:   strings.ToUpper(strings.ReplaceAll(constraint.Kind.String(), " ", "_")) + "_VIOLATION",
+		Domain:   "pbsql",
+		Metadata: make(map[string]string),
+	}
+	if constraint.Column != "" {
+		info.Metadata["column"] = constraint.Column
+	}
+	if constraint.Constraint != "" {
+		info.Metadata["constraint"] = constraint.Constraint
+	}
+	if detailed, err := st.WithDetails(info); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// ClaimsFunc reads the claims of the caller from the incoming metadata of an RPC, reporting false if there are none
+type ClaimsFunc func(md metadata.MD) (pbsql.Claims, bool)
+
+// MetadataClaims reads the user id, tenant, and roles of the caller from the metadata keys given. Empty keys are
+// skipped, and callers without a user id have no claims.
+//
+// The metadata is sent by the client, so the claims are only as trustworthy as the headers: use it only behind an
+// authenticating proxy which strips these keys from every request and sets them from the verified identity of the
+// caller. Otherwise any client can set them to pass the owner and tenant checks of the policies, and the claims must
+// rather be read by a ClaimsFunc of your own which verifies a token, such as the bearer token of the authorization
+// metadata.
+func MetadataClaims(userKey string, tenantKey string, rolesKey string) ClaimsFunc {
+	return func(md metadata.MD) (pbsql.Claims, bool) {
+		var claims pbsql.Claims
+		if values := md.Get(userKey); userKey != "" && len(values) > 0 {
+			claims.UserID = values[0]
+		}
+		if claims.UserID == "" {
+			return pbsql.Claims{}, false
+		}
+		if values := md.Get(tenantKey); tenantKey != "" && len(values) > 0 {
+			claims.Tenant = values[0]
+		}
+		if rolesKey != "" {
+			claims.Roles = md.Get(rolesKey)
+		}
+		return claims, true
+	}
+}
+
+// contextWithClaims stores the claims `extract` finds in the incoming metadata of `ctx` with pbsql.ContextWithClaims
+func contextWithClaims(ctx context.Context, extract ClaimsFunc) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	claims, ok := extract(md)
+	if !ok {
+		return ctx
+	}
+	return pbsql.ContextWithClaims(ctx, claims)
+}
+
+// UnaryServerInterceptor stores the claims of the caller in the context of every unary RPC, where the policies of a
+// pbsql.Executor find them, see pbsql.WithPolicy
+func UnaryServerInterceptor(extract ClaimsFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(contextWithClaims(ctx, extract), req)
+	}
+}
+
+// StreamServerInterceptor stores the claims of the caller in the context of every streaming RPC, like
+// UnaryServerInterceptor
+func StreamServerInterceptor(extract ClaimsFunc) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &claimsStream{ServerStream: stream, ctx: contextWithClaims(stream.Context(), extract)})
+	}
+}
+
+// claimsStream overrides the context of a stream
+type claimsStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *claimsStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,177 @@
+package pbsqlgrpc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/rmilejcz/pbsql"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatus(t *testing.T) {
+	if Status(nil) != nil {
+		t.Fatal("expected a nil error to stay nil")
+	}
+	for err, expected := range map[error]codes.Code{
+		pbsql.ErrNotFound: codes.NotFound,
+		sql.ErrNoRows:     codes.NotFound,
+		fmt.Errorf("get task: %w", pbsql.ErrNotFound):        codes.NotFound,
+		pbsql.ErrMissingClaims:                               codes.Unauthenticated,
+		pbsql.ErrMissingPrimaryKey:                           codes.InvalidArgument,
+		pbsql.ErrMissingPredicate:                            codes.InvalidArgument,
+		pbsql.ErrEmptyUpdate:                                 codes.InvalidArgument,
+		pbsql.ErrUnsafePredicate:                             codes.InvalidArgument,
+		pbsql.ErrInvalidPageToken:                            codes.InvalidArgument,
+		pbsql.ErrUnknownField:                                codes.InvalidArgument,
+		pbsql.ErrUnmappedField:                               codes.InvalidArgument,
+		pbsql.ErrInvalidMessage:                              codes.InvalidArgument,
+		pbsql.ErrMissingIdempotencyKey:                       codes.InvalidArgument,
+		pbsql.ErrForbiddenField:                              codes.PermissionDenied,
+		fmt.Errorf("update task: %w", pbsql.ErrPolicyDenied): codes.PermissionDenied,
+		status.Error(codes.Unavailable, "down"):              codes.Unavailable,
+		sqlStateError("40001"):                               codes.Aborted,
+		fmt.Errorf("update: %w", sqlStateError("40P01")):     codes.Aborted,
+		pbsql.ErrOverBudget:                                  codes.ResourceExhausted,
+		pbsql.ErrUnboundedRead:                               codes.FailedPrecondition,
+		context.Canceled:                                     codes.Canceled,
+		fmt.Errorf("read: %w", context.DeadlineExceeded):     codes.DeadlineExceeded,
+	} {
+		st, ok := status.FromError(Status(err))
+		if !ok || st.Code() != expected {
+			t.Fatalf("expected %v to be %v, got %v", err, expected, st.Code())
+		}
+	}
+}
+
+// sqlStateError is a driver error reporting its SQLSTATE, as those of lib/pq and pgx do
+type sqlStateError string
+
+func (e sqlStateError) Error() string {
+	return "SQLSTATE " + string(e)
+}
+
+func (e sqlStateError) SQLState() string {
+	return string(e)
+}
+
+func TestStatusDetails(t *testing.T) {
+	invalid := &pbsql.ValidationError{Table: "task", Violations: []pbsql.FieldViolation{{Field: "title", Reason: "required"}}}
+	st, _ := status.FromError(Status(fmt.Errorf("create: %w", invalid)))
+	if st.Code() != codes.InvalidArgument || len(st.Details()) != 1 {
+		t.Fatal("expected InvalidArgument with a BadRequest detail, got", st.Code(), st.Details())
+	}
+	req, ok := st.Details()[0].(*errdetails.BadRequest)
+	if !ok || len(req.FieldViolations) != 1 || req.FieldViolations[0].Field != "title" || req.FieldViolations[0].Description != "required" {
+		t.Fatal("unexpected BadRequest detail", st.Details()[0])
+	}
+
+	for constraint, expected := range map[*pbsql.ConstraintError]codes.Code{
+		{Kind: pbsql.UniqueViolation, Constraint: "user_email_key", Column: "email"}: codes.AlreadyExists,
+		{Kind: pbsql.NotNullViolation, Column: "name"}:                               codes.InvalidArgument,
+		{Kind: pbsql.ForeignKeyViolation, Constraint: "fk_task_user"}:                codes.FailedPrecondition,
+	} {
+		st, _ := status.FromError(Status(fmt.Errorf("create: %w", constraint)))
+		if st.Code() != expected || len(st.Details()) != 1 {
+			t.Fatalf("expected %v with an ErrorInfo detail, got %v %v", expected, st.Code(), st.Details())
+		}
+		info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+		if !ok || info.Domain != "pbsql" || info.Metadata["column"] != constraint.Column || info.Metadata["constraint"] != constraint.Constraint {
+			t.Fatal("unexpected ErrorInfo detail", st.Details()[0])
+		}
+	}
+	st, _ = status.FromError(ConstraintStatus(&pbsql.ConstraintError{Kind: pbsql.UniqueViolation, Column: "email"}))
+	if info := st.Details()[0].(*errdetails.ErrorInfo); info.Reason != "UNIQUE_VIOLATION" {
+		t.Fatal("expected reason UNIQUE_VIOLATION, got", info.Reason)
+	}
+}
+
+func TestStatusInternal(t *testing.T) {
+	var logged []error
+	defer func(log func(error)) { LogInternal = log }(LogInternal)
+	LogInternal = func(err error) { logged = append(logged, err) }
+
+	err := errors.New(`pq: relation "secret_table" does not exist`)
+	st, _ := status.FromError(Status(err))
+	if st.Code() != codes.Internal || st.Message() != "pbsql: internal error" {
+		t.Fatal("expected Internal with a generic message, got", st.Code(), st.Message())
+	}
+	if len(logged) != 1 || logged[0] != err {
+		t.Fatal("expected the error to be logged, got", logged)
+	}
+
+	logged = nil
+	Status(pbsql.ErrNotFound)
+	if len(logged) != 0 {
+		t.Fatal("expected only internal errors to be logged, got", logged)
+	}
+}
+
+func TestMetadataClaims(t *testing.T) {
+	extract := MetadataClaims("x-user-id", "x-tenant", "x-roles")
+	claims, ok := extract(metadata.Pairs("x-user-id", "7", "x-tenant", "acme", "x-roles", "admin", "x-roles", "support"))
+	expected := pbsql.Claims{UserID: "7", Tenant: "acme", Roles: []string{"admin", "support"}}
+	if !ok || !reflect.DeepEqual(claims, expected) {
+		t.Log("Got:", claims, ok)
+		t.Fatal("Expected:", expected)
+	}
+
+	for _, md := range []metadata.MD{metadata.Pairs("x-tenant", "acme", "x-roles", "admin"), metadata.Pairs("x-user-id", "")} {
+		if claims, ok := extract(md); ok || !reflect.DeepEqual(claims, pbsql.Claims{}) {
+			t.Fatal("expected a caller without a user id to have no claims, got", claims)
+		}
+	}
+	if _, ok := MetadataClaims("", "x-tenant", "")(metadata.Pairs("", "7")); ok {
+		t.Fatal("expected an empty user key to give no claims")
+	}
+}
+
+func TestServerInterceptors(t *testing.T) {
+	extract := MetadataClaims("x-user-id", "x-tenant", "")
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-user-id", "7", "x-tenant", "acme"))
+	expected := pbsql.Claims{UserID: "7", Tenant: "acme"}
+
+	var got pbsql.Claims
+	_, err := UnaryServerInterceptor(extract)(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		got, _ = pbsql.ClaimsFromContext(ctx)
+		return nil, nil
+	})
+	if err != nil || !reflect.DeepEqual(got, expected) {
+		t.Log("Got:", got, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	got = pbsql.Claims{}
+	err = StreamServerInterceptor(extract)(nil, &contextStream{ctx: ctx}, &grpc.StreamServerInfo{}, func(_ interface{}, stream grpc.ServerStream) error {
+		got, _ = pbsql.ClaimsFromContext(stream.Context())
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(got, expected) {
+		t.Log("Got:", got, err)
+		t.Fatal("Expected:", expected)
+	}
+
+	_, _ = UnaryServerInterceptor(extract)(context.Background(), nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		if _, ok := pbsql.ClaimsFromContext(ctx); ok {
+			t.Fatal("expected no claims without incoming metadata")
+		}
+		return nil, nil
+	})
+}
+
+// contextStream is a server stream with only a context
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,58 @@
+// Package pbsqlotel reports queries run by a pbsql.Executor as OpenTelemetry spans
+package pbsqlotel
+
+import (
+	"context"
+
+	"github.com/rmilejcz/pbsql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/rmilejcz/pbsql"
+
+// Tracer implements pbsql.Tracer on top of an OpenTelemetry trace.Tracer
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer returns a Tracer creating spans with the given provider, or the global provider if `tp` is nil
+func NewTracer(tp trace.TracerProvider) *Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return &Tracer{tracer: tp.Tracer(instrumentationName)}
+}
+
+// StartQuery starts a client span named after the operation, e.g. `pbsql.read`
+func (t *Tracer) StartQuery(ctx context.Context, table string, op pbsql.Operation) (context.Context, pbsql.QuerySpan) {
+	ctx, span := t.tracer.Start(
+		ctx,
+		"pbsql."+string(op),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.sql.table", table),
+			attribute.String("db.operation", string(op)),
+		),
+	)
+	return ctx, querySpan{span}
+}
+
+type querySpan struct {
+	span trace.Span
+}
+
+func (s querySpan) End(info pbsql.QueryInfo, err error) {
+	s.span.SetAttributes(
+		attribute.String("db.statement", info.Query),
+		attribute.Int64("pbsql.rows", info.Rows),
+		attribute.Int64("pbsql.build_duration_us", info.BuildDuration.Microseconds()),
+	)
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
@@ -0,0 +1,27 @@
+package pbsqlpgx
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rmilejcz/pbsql"
+)
+
+// Copier is a pgx connection, pool, or transaction able to run the COPY protocol
+type Copier interface {
+	CopyFrom(ctx context.Context, table pgx.Identifier, columns []string, rows pgx.CopyFromSource) (int64, error)
+}
+
+// CopyFrom loads the messages of `source`, a slice or channel of messages, into `target` with the COPY protocol and
+// returns the number of rows loaded, see pbsql.NewCopySource for the columns loaded. It is the pgx counterpart of
+// pbsql.Executor.CopyFrom, which streams COPY through lib/pq:
+//
+//	n, err := pbsqlpgx.CopyFrom(ctx, pool, "task", tasks)
+func CopyFrom(ctx context.Context, conn Copier, target string, source interface{}) (int64, error) {
+	src, err := pbsql.NewCopySource(target, source)
+	if err != nil {
+		return 0, err
+	}
+	return conn.CopyFrom(ctx, pgx.Identifier(strings.Split(target, ".")), src.Columns, src)
+}
@@ -0,0 +1,52 @@
+// Package pbsqlpgx binds queries generated by pbsql for the native pgx interface
+package pbsqlpgx
+
+import (
+	"github.com/jackc/pgx/v5"
+	"github.com/rmilejcz/pbsql"
+)
+
+// Binder rewrites `:param` to pgx's `@param` syntax and returns a single pgx.NamedArgs holding the value of every
+// param, e.g.
+//
+//	qry, args, err := pbsql.BuildReadQueryWithOptions("user", &user, pbsql.WithBinder(pbsqlpgx.Binder))
+//	rows, err := conn.Query(ctx, qry, args...)
+var Binder pbsql.Binder = pbsql.BinderFunc(bind)
+
+func bind(named string, source interface{}, d pbsql.Dialect) (string, []interface{}, error) {
+	qry, names := compile(named)
+	values, err := pbsql.ArgsOf(names, source)
+	if err != nil {
+		return "", nil, err
+	}
+	args := make(pgx.NamedArgs, len(names))
+	for i, name := range names {
+		args[name] = values[i]
+	}
+	return qry, []interface{}{args}, nil
+}
+
+// compile swaps the `:` prefix of each param for `@` and returns the param names
+func compile(named string) (string, []string) {
+	names := pbsql.ParamNames(named)
+	out := make([]byte, 0, len(named))
+	for i := 0; i < len(named); i++ {
+		c := named[i]
+		if c == ':' && i+1 < len(named) {
+			if named[i+1] == ':' {
+				out = append(out, ':')
+				i++
+				continue
+			}
+			if isBindRune(named[i+1]) {
+				c = '@'
+			}
+		}
+		out = append(out, c)
+	}
+	return string(out), names
+}
+
+func isBindRune(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.'
+}
@@ -0,0 +1,238 @@
+// Package pbsqltest locks in the SQL pbsql generates for the messages of a service with golden files, so that
+// upgrading pbsql or changing the tags of a message never changes a statement unnoticed
+package pbsqltest
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rmilejcz/pbsql"
+	"google.golang.org/protobuf/proto"
+)
+
+// update rewrites the golden files with the rendered queries instead of comparing them
+var update = flag.Bool("pbsqltest.update", false, "rewrite the pbsql golden files with the rendered queries")
+
+// Dir is the directory golden files are read from and written to, relative to the package under test
+var Dir = filepath.Join("testdata", "pbsql")
+
+// Now is the time the fixed clock of the rendered queries reads, see pbsql.WithClock
+var Now = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// operation renders one query of a message
+type operation struct {
+	name  string
+	build func(target string, msg interface{}, opts ...pbsql.Option) (string, []interface{}, error)
+}
+
+// operations are the queries rendered for every message, in the order they appear in golden files
+var operations = []operation{
+	{"create", pbsql.BuildCreateQuery},
+	{"upsert", pbsql.BuildUpsertQuery},
+	{"read", pbsql.BuildReadQueryWithOptions},
+	{"read_by_pk", pbsql.BuildReadByPKQuery},
+	{"count", pbsql.BuildCountQueryWithOptions},
+	{"update", func(target string, msg interface{}, opts ...pbsql.Option) (string, []interface{}, error) {
+		return pbsql.BuildUpdateQuery(target, msg, nil, opts...)
+	}},
+	{"delete", pbsql.BuildDeleteQuery},
+}
+
+// entity is a message registered with Register
+type entity struct {
+	target string
+	msg    interface{}
+	opts   []pbsql.Option
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []entity
+)
+
+// Register adds `msg`, a pointer to a message holding sample values, to the messages whose queries Run compares
+// against golden files, usually from the init of a test file. `target` is the table it is stored in, empty for the
+// table registered with pbsql.RegisterTableMetadata or declared with the `(pbsql.table)` option.
+func Register(target string, msg interface{}, opts ...pbsql.Option) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, entity{target: target, msg: msg, opts: opts})
+}
+
+// Run compares the queries of every message registered with Register against their golden files, in a subtest per
+// table
+func Run(t *testing.T) {
+	t.Helper()
+	registryMu.Lock()
+	entities := append([]entity(nil), registry...)
+	registryMu.Unlock()
+	for _, e := range entities {
+		e := e
+		t.Run(tableOf(e.target, e.msg), func(t *testing.T) {
+			Golden(t, e.target, e.msg, e.opts...)
+		})
+	}
+}
+
+// Golden renders the create, upsert, read, read by key, count, update, and delete queries of `msg` into `target` with
+// `opts`, and compares them with the golden file of the table in Dir, reporting the lines which changed. Running the
+// tests with -pbsqltest.update writes the rendered queries to the golden file instead, which is then reviewed and
+// committed like any other change:
+//
+//	func TestQueries(t *testing.T) {
+//		pbsqltest.Golden(t, "task", &pb.Task{Id: 1, Title: "report", Status: pb.Status_OPEN})
+//	}
+//
+// `msg` holds the sample values of the rendered statements and is left untouched. The queries are built with a clock
+// reading Now and an id generator counting from 1, whatever the clock and id generator of `opts`, so that timestamps
+// and generated keys are stable. Queries a message doesn't support, such as an update without any set field, render
+// their error, which is locked in as well.
+func Golden(t testing.TB, target string, msg interface{}, opts ...pbsql.Option) {
+	t.Helper()
+	path := filepath.Join(Dir, tableOf(target, msg)+".golden")
+	rendered, err := Render(target, msg, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, rendered, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	golden, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("pbsqltest: no golden file %s, run the tests with -pbsqltest.update to create it", path)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(golden, rendered) {
+		t.Errorf("pbsqltest: queries of %s differ from %s, run the tests with -pbsqltest.update to accept them:\n%s",
+			tableOf(target, msg), path, Diff(string(golden), string(rendered)))
+	}
+}
+
+// Render returns the golden file of `msg` stored in `target`, see Golden. Each query is a section headed by the name
+// of the operation, holding the statement and its args one per line, or the error building it.
+func Render(target string, msg interface{}, opts ...pbsql.Option) ([]byte, error) {
+	v := reflect.ValueOf(msg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("pbsqltest: cannot render the queries of %T, expected a pointer to a message", msg)
+	}
+	target = tableOf(target, msg)
+	var out bytes.Buffer
+	for i, op := range operations {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		fmt.Fprintf(&out, "-- %s --\n", op.name)
+		qry, args, err := op.build(target, clone(msg), deterministic(opts)...)
+		if err != nil {
+			fmt.Fprintf(&out, "error: %v\n", err)
+			continue
+		}
+		out.WriteString(qry + "\n")
+		for j, arg := range args {
+			fmt.Fprintf(&out, "  [%d] %s\n", j, formatArg(arg))
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// deterministic returns `opts` followed by a clock reading Now and an id generator counting from 1
+func deterministic(opts []pbsql.Option) []pbsql.Option {
+	var n byte
+	ids := pbsql.IDGeneratorFunc(func(string) ([16]byte, error) {
+		n++
+		return [16]byte{15: n}, nil
+	})
+	clock := pbsql.ClockFunc(func() time.Time { return Now })
+	return append(append([]pbsql.Option(nil), opts...), pbsql.WithClock(clock), pbsql.WithIDGenerator(ids))
+}
+
+// tableOf returns `target`, or the table registered for `msg` if it is empty
+func tableOf(target string, msg interface{}) string {
+	if target == "" {
+		return pbsql.TableName(msg)
+	}
+	return target
+}
+
+// clone returns a copy of the message `msg`, so that keys generated by a query don't leak into the next one
+func clone(msg interface{}) interface{} {
+	if m, ok := msg.(proto.Message); ok {
+		return proto.Clone(m)
+	}
+	v := reflect.New(reflect.TypeOf(msg).Elem())
+	v.Elem().Set(reflect.ValueOf(msg).Elem())
+	return v.Interface()
+}
+
+// formatArg renders an arg with its type, quoting strings and listing the entries of maps such as pgx.NamedArgs in
+// order
+func formatArg(arg interface{}) string {
+	v := reflect.ValueOf(arg)
+	if v.Kind() == reflect.String {
+		return fmt.Sprintf("%T %q", arg, arg)
+	}
+	if v.Kind() != reflect.Map {
+		return fmt.Sprintf("%T %v", arg, arg)
+	}
+	entries := make([]string, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		entries = append(entries, fmt.Sprintf("%v: %s", key, formatArg(v.MapIndex(key).Interface())))
+	}
+	sort.Strings(entries)
+	return fmt.Sprintf("%T{%s}", arg, strings.Join(entries, ", "))
+}
+
+// Diff returns the lines of `want` and `got`, those only in `want` prefixed with - and those only in `got` prefixed
+// with +
+func Diff(want, got string) string {
+	a, b := strings.Split(want, "\n"), strings.Split(got, "\n")
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			out.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,52 @@
+package pbsql
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Operations of the `perm` tag
+const (
+	permCreate = 'c'
+	permRead   = 'r'
+	permUpdate = 'u'
+)
+
+var permNames = map[byte]string{permCreate: "created", permRead: "read", permUpdate: "updated"}
+
+// permits reports whether the `perm` tag of the field lets it take part in `op`, one of c, r, and u. Fields without
+// the tag take part in every operation, e.g. `perm:"cr"` on created_by is inserted and selected but never updated, and
+// `perm:"r"` on balance is only selected.
+func (f *field) permits(op byte) bool {
+	return permits(f.self, op)
+}
+
+// permits reports whether the `perm` tag of `self` lets it take part in `op`
+func permits(self reflect.StructField, op byte) bool {
+	perm, ok := self.Tag.Lookup("perm")
+	return !ok || strings.IndexByte(perm, op) >= 0
+}
+
+// checkPermitted returns ErrForbiddenField in strict mode for the entries of `mask` naming fields of `v` whose `perm`
+// tag excludes `op`
+func (o *options) checkPermitted(target string, v reflect.Value, mask []string, op byte) error {
+	if !o.strict || len(mask) == 0 {
+		return nil
+	}
+	names, err := normalizeMask(v.Type(), target, mask)
+	if err != nil {
+		return err
+	}
+	var forbidden []string
+	for _, name := range names {
+		self, _ := v.Type().FieldByName(name)
+		if !parseReflection(v, self.Index[0], target).permits(op) {
+			forbidden = append(forbidden, name)
+		}
+	}
+	if len(forbidden) > 0 {
+		return fmt.Errorf("%w: %s of %s can't be %s", ErrForbiddenField, strings.Join(forbidden, ", "), target, permNames[op])
+	}
+	return nil
+}
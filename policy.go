@@ -0,0 +1,251 @@
+package pbsql
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Claims describe the caller statements are run on behalf of, usually taken from the credentials of an RPC
+type Claims struct {
+	UserID string
+	Tenant string
+	Roles  []string
+}
+
+// HasRole reports whether the claims hold any of `roles`
+func (c Claims) HasRole(roles ...string) bool {
+	for _, held := range c.Roles {
+		for _, role := range roles {
+			if held == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type claimsKey struct{}
+
+// ContextWithClaims returns a copy of ctx carrying `claims`, e.g. set by a gRPC interceptor from the incoming metadata
+func ContextWithClaims(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims stored by ContextWithClaims, reporting false if there are none. It is the
+// default ClaimsExtractor.
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(Claims)
+	return claims, ok
+}
+
+// ClaimsExtractor reads the claims of the caller from the context of a statement, reporting false if there are none
+type ClaimsExtractor func(ctx context.Context) (Claims, bool)
+
+// PolicyRequest describes a statement about to be built by an Executor
+type PolicyRequest struct {
+	Claims Claims
+	// HasClaims is false if the extractor found no claims in the context
+	HasClaims bool
+	Table     string
+	Operation Operation
+	// Source is the message the statement is built from, or the slice or channel of messages loaded by CopyFrom
+	Source interface{}
+	// FieldMask lists the fields an update assigns besides those holding a value in Source
+	FieldMask []string
+}
+
+// Policy returns the options a statement must be built with given the claims of the caller, usually predicates given
+// with WithWhere restricting the rows it may see or change. An error denies the statement.
+type Policy func(ctx context.Context, req PolicyRequest) ([]Option, error)
+
+// WithPolicy applies `policies` to every statement run by the Executor, in order, after the options given by the
+// caller. Claims are read from the context with ClaimsFromContext unless WithClaimsExtractor is given.
+//
+// Predicates returned by a policy restrict Read, ListStream, Get, Count, Update, Delete, DeleteWhere, and
+// DeleteCascade, along with the rows of dependents it deletes. Create and Upsert statements have no WHERE clause,
+// their policies can only deny them or check the values of their message.
+func WithPolicy(policies ...Policy) ExecutorOption {
+	return func(e *Executor) {
+		e.policies = append(e.policies, policies...)
+	}
+}
+
+// WithClaimsExtractor reads the claims handed to policies with `extract`, e.g. straight from gRPC metadata:
+//
+//	pbsql.WithClaimsExtractor(func(ctx context.Context) (pbsql.Claims, bool) {
+//		md, ok := metadata.FromIncomingContext(ctx)
+//		if !ok || len(md.Get("x-user-id")) == 0 {
+//			return pbsql.Claims{}, false
+//		}
+//		return pbsql.Claims{UserID: md.Get("x-user-id")[0], Roles: md.Get("x-roles")}, true
+//	})
+func WithClaimsExtractor(extract ClaimsExtractor) ExecutorOption {
+	return func(e *Executor) {
+		e.claims = extract
+	}
+}
+
+// Params bound for the claims in the predicates of the built in policies
+const (
+	ClaimsUserIDParam = "ctx_user_id"
+	ClaimsTenantParam = "ctx_tenant"
+)
+
+// OwnerPolicy restricts reads, counts, updates, and deletes of messages holding a field stored in `column` to the
+// rows whose column holds the user id of the caller, e.g. `AND task.owner_user_id = :ctx_user_id`. Creates and
+// upserts set the field to the user id where it is unset, and are denied with ErrPolicyDenied if it holds another
+// one, as are updates assigning the column anything but the user id, so rows can't be handed to other users. Loads of
+// a channel by CopyFrom are denied since their rows can't be checked. Callers holding any of `adminRoles` are
+// unrestricted. Statements on other messages are left as is, and statements without claims are denied with
+// ErrMissingClaims.
+func OwnerPolicy(column string, adminRoles ...string) Policy {
+	return claimPolicy(column, ClaimsUserIDParam, adminRoles, func(c Claims) string { return c.UserID })
+}
+
+// TenantPolicy restricts reads, counts, updates, and deletes of messages holding a field stored in `column` to the
+// rows whose column holds the tenant of the caller, e.g. `AND task.tenant_id = :ctx_tenant`, like OwnerPolicy.
+func TenantPolicy(column string, adminRoles ...string) Policy {
+	return claimPolicy(column, ClaimsTenantParam, adminRoles, func(c Claims) string { return c.Tenant })
+}
+
+// claimPolicy matches `column` against the claim returned by `claim`, bound as `param`
+func claimPolicy(column string, param string, adminRoles []string, claim func(Claims) string) Policy {
+	return func(ctx context.Context, req PolicyRequest) ([]Option, error) {
+		t := rowType(reflect.TypeOf(req.Source))
+		if t == nil || t.Kind() != reflect.Struct {
+			return nil, nil
+		}
+		index, ok := columnIndex(t, column)
+		if !ok {
+			return nil, nil
+		}
+		if !req.HasClaims || claim(req.Claims) == "" {
+			return nil, fmt.Errorf("%w: %s of %s requires a %s", ErrMissingClaims, req.Operation, req.Table, param)
+		}
+		if req.Claims.HasRole(adminRoles...) {
+			return nil, nil
+		}
+		switch req.Operation {
+		case OpCreate, OpUpsert:
+			return nil, claimRows(req, column, index, claim(req.Claims))
+		case OpUpdate:
+			if err := checkClaimed(req, t, column, index, claim(req.Claims)); err != nil {
+				return nil, err
+			}
+		}
+		clause := fmt.Sprintf("%s.%s = :%s", req.Table, column, param)
+		return []Option{WithWhere(clause, map[string]interface{}{param: claim(req.Claims)})}, nil
+	}
+}
+
+// rowType returns the message type of `t`, the type of a message or of a slice or channel of them
+func rowType(t reflect.Type) reflect.Type {
+	t = messageType(t)
+	if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Chan) {
+		t = messageType(t.Elem())
+	}
+	return t
+}
+
+// claimRows sets the field at `index` of the messages of the request to `value` where it is unset, and denies them if
+// it holds another value
+func claimRows(req PolicyRequest, column string, index int, value string) error {
+	v := reflect.Indirect(reflect.ValueOf(req.Source))
+	switch v.Kind() {
+	case reflect.Struct:
+		return claimRow(req, column, v.Field(index), value)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if row := reflect.Indirect(v.Index(i)); row.IsValid() {
+				if err := claimRow(req, column, row.Field(index), value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: the %s of the rows loaded into %s can't be checked", ErrPolicyDenied, column, req.Table)
+}
+
+// claimRow sets the field `f` stored in `column` to `value` if it is unset, and denies the request if it holds another
+// value
+func claimRow(req PolicyRequest, column string, f reflect.Value, value string) error {
+	if !f.IsZero() {
+		if fmt.Sprint(reflect.Indirect(f).Interface()) != value {
+			return fmt.Errorf("%w: %s of %s can't hold another caller's value", ErrPolicyDenied, column, req.Table)
+		}
+		return nil
+	}
+	if !f.CanSet() {
+		return fmt.Errorf("%w: %s of %s is unset", ErrPolicyDenied, column, req.Table)
+	}
+	switch {
+	case f.Kind() == reflect.String:
+		f.SetString(value)
+		return nil
+	case f.CanInt():
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && !f.OverflowInt(n) {
+			f.SetInt(n)
+			return nil
+		}
+	case f.CanUint():
+		if n, err := strconv.ParseUint(value, 10, 64); err == nil && !f.OverflowUint(n) {
+			f.SetUint(n)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s of %s can't be set to the caller's %q", ErrPolicyDenied, column, req.Table, value)
+}
+
+// checkClaimed denies an update assigning the field at `index` of the message type `t` anything but `value`, either
+// a value it holds or the zero value of a field listed in the field mask
+func checkClaimed(req PolicyRequest, t reflect.Type, column string, index int, value string) error {
+	v := reflect.Indirect(reflect.ValueOf(req.Source))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	if f := v.Field(index); !f.IsZero() {
+		if fmt.Sprint(reflect.Indirect(f).Interface()) != value {
+			return fmt.Errorf("%w: %s of %s can't be assigned another caller's value", ErrPolicyDenied, column, req.Table)
+		}
+		return nil
+	}
+	names, _ := normalizeMask(t, req.Table, req.FieldMask)
+	if findInMask(names, t.Field(index).Name) {
+		return fmt.Errorf("%w: %s of %s can't be cleared", ErrPolicyDenied, column, req.Table)
+	}
+	return nil
+}
+
+// secure appends the options the executor's policies require for an `op` statement on `table` to `opts`
+func (e *Executor) secure(ctx context.Context, table string, op Operation, source interface{}, opts []Option) ([]Option, error) {
+	return e.securePolicy(ctx, PolicyRequest{Table: table, Operation: op, Source: source}, opts)
+}
+
+// secureUpdate secures an update of `source` like secure, handing its field mask to the policies
+func (e *Executor) secureUpdate(ctx context.Context, table string, source interface{}, fieldMask []string, opts []Option) ([]Option, error) {
+	return e.securePolicy(ctx, PolicyRequest{Table: table, Operation: OpUpdate, Source: source, FieldMask: fieldMask}, opts)
+}
+
+// securePolicy appends the options the executor's policies require for the statement of `req` to `opts`
+func (e *Executor) securePolicy(ctx context.Context, req PolicyRequest, opts []Option) ([]Option, error) {
+	if len(e.policies) == 0 {
+		return opts, nil
+	}
+	extract := e.claims
+	if extract == nil {
+		extract = ClaimsFromContext
+	}
+	req.Claims, req.HasClaims = extract(ctx)
+	secured := append([]Option(nil), opts...)
+	for _, policy := range e.policies {
+		required, err := policy(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		secured = append(secured, required...)
+	}
+	return secured, nil
+}
@@ -0,0 +1,198 @@
+package pbsql
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// preloadBatchSize caps the number of values matched by a single preload query
+const preloadBatchSize = 500
+
+// WithPreload makes Executor.Read fill the given relation fields of every row it reads. A relation is a field holding
+// a message, or a repeated field of messages, tagged with `foreign_table`, `foreign_key`, and optionally
+// `local_name` like the fields read with a join:
+//
+//	Property  *Property  `foreign_table:"property" foreign_key:"id" local_name:"property_id"`
+//	Addresses []*Address `foreign_table:"address" foreign_key:"user_id" local_name:"id"`
+//
+// Rows of the related table whose `foreign_key` column matches the `local_name` column (which defaults to
+// `foreign_key`) of a row are read with one `WHERE foreign_key IN (...)` query per relation, rather than one query per
+// row, and stitched into the rows. Both columns must be stored in fields of their messages. Fields tagged `m2m` are
+// read through their junction table, see BuildAssociationQueries. Builders ignore it.
+func WithPreload(fields ...string) Option {
+	return func(o *options) {
+		o.preload = append(o.preload, fields...)
+	}
+}
+
+// relation describes a field read by WithPreload
+type relation struct {
+	field int
+	// local is the index of the field holding the local column
+	local int
+	// remote is the index of the field of the related message holding the foreign key
+	remote int
+	table  string
+	column string
+	many   bool
+	// elem is the type of the related message
+	elem reflect.Type
+}
+
+// relationOf returns the relation stored in the field `name` of `t`
+func relationOf(t reflect.Type, name string) (*relation, error) {
+	self, ok := structFieldByName(t, name)
+	if !ok || len(self.Index) != 1 {
+		return nil, fmt.Errorf("pbsql: cannot preload %s, %s has no such field", name, t)
+	}
+	rel := &relation{
+		field:  self.Index[0],
+		table:  self.Tag.Get("foreign_table"),
+		column: self.Tag.Get("foreign_key"),
+	}
+	if rel.table == "" || rel.column == "" {
+		return nil, fmt.Errorf("pbsql: cannot preload %s, it isn't tagged with foreign_table and foreign_key", name)
+	}
+	localName := self.Tag.Get("local_name")
+	if localName == "" {
+		localName = rel.column
+	}
+
+	elem := self.Type
+	if elem.Kind() == reflect.Slice {
+		rel.many = true
+		elem = elem.Elem()
+	}
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct || (!rel.many && self.Type.Kind() != reflect.Ptr) {
+		return nil, fmt.Errorf("pbsql: cannot preload %s, expected a message pointer or a repeated message", name)
+	}
+	rel.elem = elem
+
+	var found bool
+	if rel.local, found = columnIndex(t, localName); !found {
+		return nil, fmt.Errorf("pbsql: cannot preload %s, %s has no field stored in %s", name, t, localName)
+	}
+	if rel.remote, found = columnIndex(elem, rel.column); !found {
+		return nil, fmt.Errorf("pbsql: cannot preload %s, %s has no field stored in %s", name, elem, rel.column)
+	}
+	return rel, nil
+}
+
+// columnIndex returns the index of the field of `t` stored in `column`
+func columnIndex(t reflect.Type, column string) (int, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if columnOf(t, structField(t, i)) == column {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// preload reads every relation given WithPreload for the rows of `dest` and stitches them in
+func (e *Executor) preload(ctx context.Context, dest interface{}, o *options) error {
+	if len(o.preload) == 0 {
+		return nil
+	}
+	rows := reflect.Indirect(reflect.ValueOf(dest))
+	if rows.Len() == 0 {
+		return nil
+	}
+	base := rows.Type().Elem()
+	if base.Kind() == reflect.Ptr {
+		base = base.Elem()
+	}
+	for _, name := range o.preload {
+		a, err := associationOf(base, name)
+		if err != nil {
+			return err
+		}
+		if a != nil {
+			if err := e.preloadAssociation(ctx, rows, a, o); err != nil {
+				return err
+			}
+			continue
+		}
+		rel, err := relationOf(base, name)
+		if err != nil {
+			return err
+		}
+		if err := e.preloadRelation(ctx, rows, rel, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preloadRelation reads the related rows of `rel` in batches and assigns them to the rows holding their key
+func (e *Executor) preloadRelation(ctx context.Context, rows reflect.Value, rel *relation, o *options) error {
+	var keys []interface{}
+	parents := make(map[string][]reflect.Value)
+	for i := 0; i < rows.Len(); i++ {
+		row := reflect.Indirect(rows.Index(i))
+		if !row.IsValid() {
+			continue
+		}
+		local := row.Field(rel.local)
+		if local.IsZero() {
+			continue
+		}
+		key := keyString(local.Interface())
+		if _, ok := parents[key]; !ok {
+			keys = append(keys, local.Interface())
+		}
+		parents[key] = append(parents[key], row.Field(rel.field))
+	}
+
+	for start := 0; start < len(keys); start += preloadBatchSize {
+		end := start + preloadBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		related := reflect.New(reflect.SliceOf(reflect.PtrTo(rel.elem)))
+		if err := e.readRelated(ctx, rel, keys[start:end], related.Interface(), o); err != nil {
+			return err
+		}
+		related = related.Elem()
+		for i := 0; i < related.Len(); i++ {
+			child := related.Index(i)
+			for _, field := range parents[keyString(child.Elem().Field(rel.remote).Interface())] {
+				switch {
+				case !rel.many:
+					field.Set(child)
+				case field.Type().Elem().Kind() == reflect.Ptr:
+					field.Set(reflect.Append(field, child))
+				default:
+					field.Set(reflect.Append(field, child.Elem()))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// readRelated selects the rows of the related table whose foreign key is one of `keys` into `dest`
+func (e *Executor) readRelated(ctx context.Context, rel *relation, keys []interface{}, dest interface{}, o *options) (err error) {
+	ctx, run := e.start(ctx, rel.table, OpRead)
+	defer func() { run.finish(err) }()
+
+	if err = run.build(func() (string, interface{}, error) {
+		qb := newQueryBuilder(o.dialect)
+		defer qb.release()
+		if err := qb.writeSelectList(reflect.New(rel.elem).Elem(), rel.table, &options{dialect: o.dialect}); err != nil {
+			return "", nil, err
+		}
+		names, params := inParams(keys)
+		qry := fmt.Sprintf("SELECT %s FROM %s WHERE %s.%s IN (%s)", qb.selectList(), rel.table, rel.table, rel.column, names)
+		return qry, params, nil
+	}); err != nil {
+		return err
+	}
+	if err = e.selectRows(ctx, reflect.New(rel.elem).Interface(), dest, run.info.Query, run.args); err == nil {
+		run.info.Rows = int64(reflect.Indirect(reflect.ValueOf(dest)).Len())
+	}
+	return err
+}
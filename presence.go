@@ -0,0 +1,140 @@
+package pbsql
+
+import (
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var protoMessageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+
+// wrapperNames are the full names of the well-known wrapper messages, which hold an optional scalar in field 1
+var wrapperNames = map[protoreflect.FullName]bool{
+	"google.protobuf.BoolValue":   true,
+	"google.protobuf.BytesValue":  true,
+	"google.protobuf.DoubleValue": true,
+	"google.protobuf.FloatValue":  true,
+	"google.protobuf.Int32Value":  true,
+	"google.protobuf.Int64Value":  true,
+	"google.protobuf.StringValue": true,
+	"google.protobuf.UInt32Value": true,
+	"google.protobuf.UInt64Value": true,
+}
+
+// isWrapperType reports whether `t` is a pointer to a well-known wrapper such as *wrapperspb.Int64Value
+func isWrapperType(t reflect.Type) bool {
+	if t.Kind() != reflect.Ptr || !t.Implements(protoMessageType) {
+		return false
+	}
+	msg := reflect.Zero(t).Interface().(proto.Message)
+	return wrapperNames[msg.ProtoReflect().Descriptor().FullName()]
+}
+
+// isOptionalValue reports whether `t` holds a scalar which may be absent: a pointer to a string, number, or bool, as
+// generated for proto3 `optional` fields, or a well-known wrapper. Like optional bools, such fields are set whenever
+// they aren't nil, bind and scan NULL as nil, and are compared by equality.
+func isOptionalValue(t reflect.Type) bool {
+	if t.Kind() != reflect.Ptr {
+		return false
+	}
+	switch t.Elem().Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return isWrapperType(t)
+}
+
+// optionalValueType returns the type of the scalar held by an optional value, e.g. int64 for *wrapperspb.Int64Value
+func optionalValueType(t reflect.Type) reflect.Type {
+	if isWrapperType(t) {
+		if value, ok := t.Elem().FieldByName("Value"); ok {
+			return value.Type
+		}
+	}
+	return t.Elem()
+}
+
+// optionalArg returns the value bound for an optional value field, nil if it isn't set
+func optionalArg(v reflect.Value) interface{} {
+	if v.IsNil() {
+		return nil
+	}
+	if msg, ok := v.Interface().(proto.Message); ok {
+		m := msg.ProtoReflect()
+		return m.Get(m.Descriptor().Fields().ByNumber(1)).Interface()
+	}
+	return v.Elem().Interface()
+}
+
+// scanOptional returns the target a column is scanned into for the optional value field `v`, and the decoder setting
+// `v` from it once the row is scanned
+func scanOptional(v reflect.Value) (interface{}, func() error) {
+	t := v.Type()
+	if !isWrapperType(t) {
+		// database/sql allocates the pointer for values and sets it to nil for NULL
+		return v.Addr().Interface(), nil
+	}
+	value := reflect.New(reflect.PtrTo(optionalValueType(t)))
+	return value.Interface(), func() error {
+		if value.Elem().IsNil() {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		wrapper := reflect.New(t.Elem())
+		m := wrapper.Interface().(proto.Message).ProtoReflect()
+		m.Set(m.Descriptor().Fields().ByNumber(1), protoreflect.ValueOf(value.Elem().Elem().Interface()))
+		v.Set(wrapper)
+		return nil
+	}
+}
+
+// fieldPresence reports whether the field `self` of the struct `val` is a field of a protobuf message whose presence
+// protoreflect tracks, and if so whether it is set. Scalars and enums are present when they aren't zero, `optional`
+// scalars and wrappers when they aren't nil, which spares the builders from guessing from the go type of the field.
+// Plain structs, messages passed by value, and fields holding other messages, lists, or maps aren't tracked.
+func fieldPresence(val reflect.Value, self reflect.StructField) (tracked, present bool) {
+	if !val.CanAddr() || self.PkgPath != "" {
+		return false, false
+	}
+	msg, ok := val.Addr().Interface().(proto.Message)
+	if !ok {
+		return false, false
+	}
+	m := msg.ProtoReflect()
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name(protoFieldName(self)))
+	if fd == nil || fd.IsList() || fd.IsMap() {
+		return false, false
+	}
+	if fd.Message() != nil && !wrapperNames[fd.Message().FullName()] {
+		return false, false
+	}
+	return true, m.Has(fd)
+}
+
+// WithSetFields lists fields which filter reads, counts, searches, and DeleteWhere even when they hold their zero
+// value, e.g. `WithSetFields("amount")` to read the rows whose amount is 0. Without it a zero plain proto3 scalar
+// can't be told apart from unset and is left out of the predicate. Creates and upserts insert the listed fields
+// rather than leaving their columns to the table defaults. Entries name fields like WithFieldMask, and an entry which
+// doesn't name any field fails with ErrUnknownField. Strings still filter by LIKE, which an empty pattern
+// only matches against empty columns.
+func WithSetFields(fields ...string) Option {
+	return func(o *options) {
+		o.setFields = append(o.setFields, fields...)
+	}
+}
+
+// setFieldsOf returns the go names of the fields of `t` given with WithSetFields
+func (o *options) setFieldsOf(t reflect.Type, target string) ([]string, error) {
+	return normalizeMask(t, target, o.setFields)
+}
+
+// WithZeroValues makes creates and upserts insert every column of the message, zero values included, so that no
+// column is left to its table default. An unset primary key is still left to the database to generate.
+func WithZeroValues() Option {
+	return func(o *options) {
+		o.zeroValues = true
+	}
+}
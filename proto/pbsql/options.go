@@ -0,0 +1,79 @@
+// Package pbsqlpb registers pbsql/options.proto, the options annotating messages stored with pbsql, so that
+// protobuf packages generated from files importing it link. The options are read by pbsql from the descriptors of
+// messages whether or not this package is imported; use the extension types to read them yourself, e.g.
+//
+//	column := proto.GetExtension(field.Options(), pbsqlpb.E_Column)
+package pbsqlpb
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// File_pbsql_options_proto is the descriptor of pbsql/options.proto
+var File_pbsql_options_proto protoreflect.FileDescriptor
+
+// Extensions of pbsql/options.proto, see the file for their meaning
+var (
+	E_Table      protoreflect.ExtensionType
+	E_Column     protoreflect.ExtensionType
+	E_PrimaryKey protoreflect.ExtensionType
+	E_Nullable   protoreflect.ExtensionType
+	E_Readonly   protoreflect.ExtensionType
+	E_Searchable protoreflect.ExtensionType
+	E_Tags       protoreflect.ExtensionType
+)
+
+func init() {
+	extension := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type, extendee string) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     kind.Enum(),
+			Extendee: proto.String(extendee),
+		}
+	}
+	const (
+		message = ".google.protobuf.MessageOptions"
+		field   = ".google.protobuf.FieldOptions"
+		str     = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		boolean = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+	)
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("pbsql/options.proto"),
+		Package:    proto.String("pbsql"),
+		Dependency: []string{"google/protobuf/descriptor.proto"},
+		Syntax:     proto.String("proto3"),
+		Options:    &descriptorpb.FileOptions{GoPackage: proto.String("github.com/rmilejcz/pbsql/proto/pbsql;pbsqlpb")},
+		Extension: []*descriptorpb.FieldDescriptorProto{
+			extension("table", 50710, str, message),
+			extension("column", 50710, str, field),
+			extension("primary_key", 50711, boolean, field),
+			extension("nullable", 50712, boolean, field),
+			extension("readonly", 50713, boolean, field),
+			extension("searchable", 50714, boolean, field),
+			extension("tags", 50715, str, field),
+		},
+	}
+	file, err := protodesc.NewFile(fd, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(file); err != nil {
+		panic(err)
+	}
+	File_pbsql_options_proto = file
+
+	types := []*protoreflect.ExtensionType{&E_Table, &E_Column, &E_PrimaryKey, &E_Nullable, &E_Readonly, &E_Searchable, &E_Tags}
+	for i, xt := range types {
+		*xt = dynamicpb.NewExtensionType(file.Extensions().Get(i))
+		if err := protoregistry.GlobalTypes.RegisterExtension(*xt); err != nil {
+			panic(err)
+		}
+	}
+}
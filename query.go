@@ -0,0 +1,243 @@
+package pbsql
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// SelectQuery is the intermediate representation of a read query. BuildSelectQuery returns one so that a clause the
+// builders don't support can be added before the statement is rendered with Build, rather than by editing the
+// generated SQL. Every field may be modified.
+type SelectQuery struct {
+	// Columns holds the select list, e.g. `user.id` or `ifnull(user.name, '') as name`
+	Columns []string
+	Table   string
+	// IndexHint names the index the planner should use, nil to leave it to the planner
+	IndexHint *IndexHint
+	// Joins holds complete join clauses, e.g. `LEFT JOIN role on role.id = user.role_id`
+	Joins []string
+	// Where holds the predicates of the query, which are joined with AND. They may refer to fields of the source
+	// message or to Params as `:name`.
+	Where []string
+	// GroupBy and OrderBy hold the expressions following `group by` and `order by`, e.g. `name asc`
+	GroupBy string
+	OrderBy string
+	// Limit and Offset are left out of the statement while zero
+	Limit  int
+	Offset int
+	// Lock is the row lock taken by the query, see WithLock
+	Lock Lock
+	// Params holds values for named params used by custom predicates, in addition to the fields of the source
+	Params map[string]interface{}
+	// Period follows the table, e.g. ` FOR SYSTEM_TIME AS OF :pbsql_as_of`, see WithAsOf
+	Period string
+	// PartitionBy and RankOrder rank the rows of each partition, of which only the first is read, see
+	// WithFirstPerPartition. The query isn't ranked while PartitionBy is empty.
+	PartitionBy []string
+	RankOrder   string
+
+	source  interface{}
+	opts    *options
+	clauses []FieldClause
+}
+
+// BuildSelectQuery builds the read query BuildReadQueryWithOptions would, but returns it unrendered
+func BuildSelectQuery(target string, source interface{}, opts ...Option) (*SelectQuery, error) {
+	o := newOptions(opts)
+	return selectQuery(o.readRelation(o.table(target, source), source), source, o)
+}
+
+// selectQuery collects the select list and predicates of a read query for `source`
+func selectQuery(target string, source interface{}, o *options) (*SelectQuery, error) {
+	reflectedValue := reflect.ValueOf(source).Elem()
+	if err := o.checkStrict(target, reflectedValue); err != nil {
+		return nil, err
+	}
+	if err := o.checkPermitted(target, reflectedValue, o.fieldMask, permRead); err != nil {
+		return nil, err
+	}
+	target = o.asOfTable(target)
+	qb := newQueryBuilder(o.dialect)
+	defer qb.release()
+	qb.trace = o.trace
+	qb.caseInsensitive, qb.escapeLike, qb.masking = o.caseInsensitive, o.escapeLike, o.masking
+	qb.nullDefaults = o.nullDefaults
+	qb.lifecycleField = o.lifecycleField(reflectedValue.Type())
+	points, err := geoPoints(reflectedValue, target)
+	if err != nil {
+		return nil, err
+	}
+	setFields, err := o.setFieldsOf(reflectedValue.Type(), target)
+	if err != nil {
+		return nil, err
+	}
+	setFields = append(setFields, o.predicateMask...)
+
+	for i := 0; i < reflectedValue.NumField(); i++ {
+		field := parseReflection(reflectedValue, i, target)
+		if field.name != "" {
+			if !field.shouldIgnore && !field.selectFunc.ok {
+				if o.selects(field) {
+					if err := qb.writeSelectField(field); err != nil {
+						return nil, err
+					}
+				}
+				if field.value.CanAddr() && !isGeoCenter(points, field) {
+					qb.writePredicate(field, setFields, andPredicate)
+				}
+			} else if field.selectFunc.ok {
+				if o.selects(field) {
+					if err := qb.writeSelectFunc(field); err != nil {
+						return nil, err
+					}
+				}
+			} else if field.isMultiValue && field.value.CanAddr() {
+				qb.writePredicate(field, setFields, andPredicate)
+			}
+		}
+		if field.hasForeignKey {
+			qb.handleForeignKey(field)
+		}
+	}
+	if len(qb.selects) == 0 {
+		return nil, fmt.Errorf("field mask %v does not match any selectable field of %s", o.fieldMask, target)
+	}
+	qb.writePredicateGroups()
+	qb.writeGeoPredicates(points)
+	qb.handleDateRange(target, &reflectedValue)
+	if err := o.likeParams(qb); err != nil {
+		return nil, err
+	}
+	where, err := o.whereClauses()
+	if err != nil {
+		return nil, err
+	}
+	filter, err := o.filterClauses(target, reflectedValue)
+	if err != nil {
+		return nil, err
+	}
+	ranges, err := o.rangeClauses(target, reflectedValue)
+	if err != nil {
+		return nil, err
+	}
+	filter = append(ranges, filter...)
+	where = o.withScope(append(filter, where...), target, reflectedValue.Type())
+	period, asOf := o.asOfClauses(target)
+	where = append(where, asOf...)
+	if err := o.indexHint.validate(); err != nil {
+		return nil, err
+	}
+	if err := o.lock.validate(); err != nil {
+		return nil, err
+	}
+	if err := o.checkQuery(target, qb, 0, len(where)); err != nil {
+		return nil, err
+	}
+	params := make(map[string]interface{}, len(o.params))
+	for name, value := range o.params {
+		params[name] = value
+	}
+	limit, err := o.limits.limit(target, o.limit)
+	if err != nil {
+		return nil, err
+	}
+	var partition []string
+	var rankOrder string
+	if o.firstPerPartition {
+		if o.lock != 0 {
+			return nil, fmt.Errorf("pbsql: ranked read of %s can't take a row lock", target)
+		}
+		if partition, rankOrder, err = partitionWindow(reflectedValue, target); err != nil {
+			return nil, err
+		}
+	}
+	orderBy := orderByOf(&reflectedValue)
+	if orderBy == "" && (limit > 0 || o.offset > 0) {
+		var keys []string
+		for _, key := range primaryKeys(reflectedValue, target) {
+			keys = append(keys, key.column()+" asc")
+		}
+		orderBy = strings.Join(keys, ", ")
+	}
+
+	return &SelectQuery{
+		Columns:     qb.selects,
+		Table:       target,
+		IndexHint:   o.indexHint,
+		Joins:       qb.joins,
+		Where:       append(qb.conditions, where...),
+		GroupBy:     groupByOf(&reflectedValue),
+		OrderBy:     orderBy,
+		Limit:       limit,
+		Offset:      o.offset,
+		Lock:        o.lock,
+		Params:      params,
+		Period:      period,
+		PartitionBy: partition,
+		RankOrder:   rankOrder,
+		source:      source,
+		opts:        o,
+		clauses:     qb.clauses,
+	}, nil
+}
+
+// Named renders the query with `:name` params, before it is bound
+func (q *SelectQuery) Named() string {
+	var builder strings.Builder
+	prefix, suffix := q.opts.dialect.indexHint(q.Table, q.IndexHint)
+	columns := q.Columns
+	if len(q.PartitionBy) > 0 {
+		_, alias := splitTable(q.Table)
+		builder.WriteString("SELECT " + strings.Join(rankedColumns(alias, columns), ", ") + " FROM (")
+		columns = append(columns[:len(columns):len(columns)], "ROW_NUMBER() OVER (PARTITION BY "+
+			strings.Join(q.PartitionBy, ", ")+" ORDER BY "+q.RankOrder+") AS "+rankedAlias)
+	}
+	builder.WriteString(prefix + "SELECT ")
+	builder.WriteString(strings.Join(columns, ", "))
+	builder.WriteString(" FROM ")
+	builder.WriteString(q.Table + q.Period + suffix)
+	for _, join := range q.Joins {
+		builder.WriteString(" " + join)
+	}
+	builder.WriteString(" WHERE true")
+	for _, predicate := range q.Where {
+		builder.WriteString(" AND " + predicate)
+	}
+	if q.GroupBy != "" {
+		builder.WriteString(" group by " + q.GroupBy)
+	}
+	if len(q.PartitionBy) > 0 {
+		_, alias := splitTable(q.Table)
+		builder.WriteString(") AS " + alias + " WHERE " + rankedAlias + " = 1")
+	}
+	if q.OrderBy != "" {
+		builder.WriteString(" order by " + q.OrderBy)
+	}
+	if q.Limit > 0 {
+		builder.WriteString(" LIMIT " + strconv.Itoa(q.Limit))
+	}
+	if q.Offset > 0 {
+		builder.WriteString(" OFFSET " + strconv.Itoa(q.Offset))
+	}
+	builder.WriteString(q.opts.dialect.lockClause(q.Lock, q.Table, len(q.Joins) > 0))
+	return builder.String()
+}
+
+// Build renders and binds the query, returning a SQL statement, a slice of args to interpolate, and an error
+func (q *SelectQuery) Build() (string, []interface{}, error) {
+	if len(q.Columns) == 0 {
+		return "", nil, fmt.Errorf("select query on %s has no columns", q.Table)
+	}
+	if err := q.IndexHint.validate(); err != nil {
+		return "", nil, err
+	}
+	if err := q.Lock.validate(); err != nil {
+		return "", nil, err
+	}
+	if q.Lock != 0 && len(q.PartitionBy) > 0 {
+		return "", nil, fmt.Errorf("pbsql: ranked read of %s can't take a row lock", q.Table)
+	}
+	return q.opts.binder.Bind(q.Named(), withParams(q.source, q.Params), q.opts.dialect)
+}
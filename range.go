@@ -0,0 +1,198 @@
+package pbsql
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// rangeParam prefixes the params of the bounds of range predicates, followed by the lower cased name of the bound
+const rangeParam = "pbsql_range_"
+
+// rangeSuffixes are the suffixes of the lower and upper bound fields of a range
+var rangeSuffixes = [][2]string{{"Start", "End"}, {"From", "To"}}
+
+// rangePair is a pair of fields bounding the values of a column, e.g. DateRangeStart and DateRangeEnd
+type rangePair struct {
+	lower, upper int
+	column       string
+}
+
+// rangePairsCache caches the range pairs of message types
+var rangePairsCache sync.Map
+
+// rangePairs returns the range pairs of the struct type `t`: fields without a `db` tag named `<Prefix>Start` and
+// `<Prefix>End`, or `<Prefix>From` and `<Prefix>To`. The column they bound is given by the `date_target` tag of
+// either field, and is otherwise the column of the field named after the prefix without its Range suffix, e.g. the
+// column of Date for DateRangeStart, or the snake case of that name if there is no such field.
+func rangePairs(t reflect.Type) []rangePair {
+	if cached, ok := rangePairsCache.Load(t); ok {
+		return cached.([]rangePair)
+	}
+	var pairs []rangePair
+	for i := 0; i < t.NumField(); i++ {
+		lower := t.Field(i)
+		if lower.PkgPath != "" || lower.Tag.Get("db") != "" {
+			continue
+		}
+		for _, suffixes := range rangeSuffixes {
+			prefix := strings.TrimSuffix(lower.Name, suffixes[0])
+			if prefix == lower.Name || prefix == "" {
+				continue
+			}
+			upper, ok := t.FieldByName(prefix + suffixes[1])
+			if !ok || len(upper.Index) != 1 || upper.Tag.Get("db") != "" {
+				continue
+			}
+			column := lower.Tag.Get("date_target")
+			if column == "" {
+				column = upper.Tag.Get("date_target")
+			}
+			if column == "" {
+				column = rangeColumn(t, strings.TrimSuffix(prefix, "Range"))
+			}
+			pairs = append(pairs, rangePair{lower: i, upper: upper.Index[0], column: column})
+		}
+	}
+	rangePairsCache.Store(t, pairs)
+	return pairs
+}
+
+// rangeColumn returns the column of the field of `t` named `name`, or the snake case of `name` if there is none
+func rangeColumn(t reflect.Type, name string) string {
+	if self, ok := t.FieldByName(name); ok && len(self.Index) == 1 {
+		if column, ok := columnAliases(t)[name]; ok {
+			return column
+		}
+		if column := columnName(self); column != "" {
+			return column
+		}
+	}
+	return SnakeCase(name)
+}
+
+// isRangeBound reports whether `self` is a bound of a range pair of `t`, which is never a column itself
+func isRangeBound(t reflect.Type, self reflect.StructField) bool {
+	if self.Tag.Get("db") != "" || len(self.Index) != 1 {
+		return false
+	}
+	for _, pair := range rangePairs(t) {
+		if pair.lower == self.Index[0] || pair.upper == self.Index[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeClauses returns the predicates of the range pairs and relative windows of `v` holding a value, e.g.
+// `task.date >= ?` and `task.date <= ?`, and adds the bounds to the params of `o`. A pair with a single bound set is
+// open on the other side.
+func (o *options) rangeClauses(target string, v reflect.Value) ([]string, error) {
+	pairs := rangePairs(v.Type())
+	if len(pairs) == 0 && !hasWithin(v.Type()) {
+		return nil, nil
+	}
+	params := make(map[string]interface{}, len(o.params)+2*len(pairs))
+	for name, value := range o.params {
+		params[name] = value
+	}
+	var clauses []string
+	for i := 0; i < v.NumField(); i++ {
+		self := v.Type().Field(i)
+		if self.Tag.Get("within") == "" || !v.Field(i).CanInterface() || v.Field(i).IsZero() {
+			continue
+		}
+		clause, since, err := o.withinClause(target, self, v.Field(i))
+		if err != nil {
+			return nil, err
+		}
+		params[rangeParam+strings.ToLower(self.Name)] = since
+		clauses = append(clauses, clause)
+	}
+	for _, pair := range pairs {
+		for _, bound := range []struct {
+			index int
+			op    string
+		}{{pair.lower, ">="}, {pair.upper, "<="}} {
+			value := v.Field(bound.index)
+			if !value.CanInterface() || value.IsZero() {
+				continue
+			}
+			self := v.Type().Field(bound.index)
+			name := rangeParam + strings.ToLower(self.Name)
+			arg, err := filterArg(self, value)
+			if err != nil {
+				return nil, err
+			}
+			params[name] = arg
+			clauses = append(clauses, target+"."+pair.column+" "+bound.op+" :"+name)
+		}
+	}
+	o.params = params
+	return clauses, nil
+}
+
+// withinUnits are the units of relative windows implied by the suffix of the field name
+var withinUnits = []struct {
+	suffix string
+	unit   time.Duration
+}{
+	{"Minutes", time.Minute},
+	{"Hours", time.Hour},
+	{"Days", 24 * time.Hour},
+	{"Weeks", 7 * 24 * time.Hour},
+}
+
+// hasWithin reports whether a field of the struct type `t` is tagged `within`
+func hasWithin(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("within") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// withinClause returns the predicate of the relative window `self`, a field tagged `within:"<column>"` holding a
+// number of units or a *durationpb.Duration, along with the absolute time the window starts at. For instance a
+// WithinDays field tagged `within:"created_at"` reads the rows created in the last WithinDays days. The unit is given after the column,
+// `within:"created_at,h"` for hours, or implied by the Minutes, Hours, Days, or Weeks suffix of the field name. The
+// start of the window is computed when the statement is built from the clock of `o`, see WithClock.
+func (o *options) withinClause(target string, self reflect.StructField, v reflect.Value) (string, time.Time, error) {
+	column, unitName, _ := strings.Cut(self.Tag.Get("within"), ",")
+	var window time.Duration
+	switch {
+	case self.Type == reflect.TypeOf((*durationpb.Duration)(nil)):
+		window = v.Interface().(*durationpb.Duration).AsDuration()
+	case v.CanInt() || v.CanUint():
+		unit := time.Duration(0)
+		if unitName != "" {
+			parsed, err := time.ParseDuration("1" + unitName)
+			if err != nil {
+				return "", time.Time{}, fmt.Errorf("pbsql: unknown unit %q of %s", unitName, self.Name)
+			}
+			unit = parsed
+		}
+		for _, implied := range withinUnits {
+			if unit == 0 && strings.HasSuffix(self.Name, implied.suffix) {
+				unit = implied.unit
+			}
+		}
+		if unit == 0 {
+			return "", time.Time{}, fmt.Errorf("pbsql: %s tagged within has no unit, tag it `within:\"%s,h\"` or name it after one", self.Name, column)
+		}
+		if v.CanUint() {
+			window = time.Duration(v.Uint()) * unit
+		} else {
+			window = time.Duration(v.Int()) * unit
+		}
+	default:
+		return "", time.Time{}, fmt.Errorf("pbsql: %s tagged within must be a number or a duration, got %s", self.Name, self.Type)
+	}
+	since := o.now().Add(-window)
+	return target + "." + column + " >= :" + rangeParam + strings.ToLower(self.Name), since, nil
+}
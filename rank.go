@@ -0,0 +1,84 @@
+package pbsql
+
+import (
+	"strconv"
+	"strings"
+)
+
+// searchRankParam is the param the phrase ranked by the order of a search is bound to
+const searchRankParam = "pbsql_search_rank"
+
+// WithSearchRank orders the rows of BuildSearchQuery by relevance to the phrase, most relevant first, then by primary
+// key so that rows ranked equally keep a stable order:
+//
+//   - Postgres ranks with `ts_rank` of the searched columns against `plainto_tsquery` of the phrase
+//   - MySQL ranks with `MATCH (columns) AGAINST (phrase)`, which needs a FULLTEXT index on exactly those columns
+//   - SQLite sums a CASE per column the phrase matches, by LIKE or WithFuzzySearch
+//
+// A field tagged `search_weight:"<n>"` weighs its matches n times, 1 by default: on Postgres its lexemes are weighted
+// A for 4 and above, B for 3, C for 2, and D otherwise, or its similarity multiplied by n with WithFuzzySearch, MySQL
+// ignores weights. An order set in the OrderBy field of
+// the message comes first, ranking only breaks its ties. `%` wildcards around the phrase are left out of the ranking.
+func WithSearchRank() Option {
+	return func(o *options) {
+		o.searchRank = true
+	}
+}
+
+// searchWeight returns the weight of matches of the phrase of a search in `f`, see WithSearchRank
+func (f *field) searchWeight() int {
+	weight, err := strconv.Atoi(f.self.Tag.Get("search_weight"))
+	if err != nil || weight < 1 {
+		return 1
+	}
+	return weight
+}
+
+// searchRank returns the expression ranking the matches of the phrase of a search in the columns of `terms`, and adds
+// the phrase it is bound to, `like` as bound by the predicates of the search or `phrase` for full text ranking, to the
+// params of `o`
+func (qb *queryBuilder) searchRank(terms []*field, phrase, like string, o *options) string {
+	if o.params == nil {
+		o.params = make(map[string]interface{})
+	}
+	o.params[searchRankParam] = strings.Trim(phrase, "%")
+	columns := make([]string, len(terms))
+	for i, term := range terms {
+		columns[i] = term.column()
+	}
+	switch {
+	case qb.dialect == Postgres && o.fuzzy:
+		similarities := make([]string, len(terms))
+		for i, term := range terms {
+			similarities[i] = "similarity(" + columns[i] + ", :" + searchRankParam + ")"
+			if weight := term.searchWeight(); weight > 1 {
+				similarities[i] += " * " + strconv.Itoa(weight)
+			}
+		}
+		return "GREATEST(" + strings.Join(similarities, ", ") + ")"
+	case qb.dialect == Postgres:
+		vectors := make([]string, len(terms))
+		for i, term := range terms {
+			vectors[i] = "to_tsvector(coalesce(" + columns[i] + ", ''))"
+			if weight := term.searchWeight(); weight > 1 {
+				vectors[i] = "setweight(" + vectors[i] + ", '" + string("DCBA"[min(weight, 4)-1]) + "')"
+			}
+		}
+		return "ts_rank(" + strings.Join(vectors, " || ") + ", plainto_tsquery(:" + searchRankParam + "))"
+	case qb.dialect == MySQL:
+		return "MATCH (" + strings.Join(columns, ", ") + ") AGAINST (:" + searchRankParam + ")"
+	default:
+		o.params[searchRankParam] = like
+		cases := make([]string, len(terms))
+		for i, term := range terms {
+			match := qb.dialect.like(columns[i], ":"+searchRankParam, false, qb.foldsCase(term))
+			if o.fuzzy {
+				match = qb.dialect.fuzzyMatch(columns[i], ":"+searchRankParam, o.fuzzyThreshold)
+			} else if qb.escapeLike {
+				match += qb.dialect.likeEscape()
+			}
+			cases[i] = "CASE WHEN " + match + " THEN " + strconv.Itoa(term.searchWeight()) + " ELSE 0 END"
+		}
+		return "(" + strings.Join(cases, " + ") + ")"
+	}
+}
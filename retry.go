@@ -0,0 +1,114 @@
+package pbsql
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"reflect"
+	"time"
+)
+
+// RetryPolicy retries the statements and transactions of an Executor which fail with a transient error, see
+// WithRetry
+type RetryPolicy struct {
+	// MaxAttempts caps the number of times a statement or transaction is run, including the first, 3 if zero
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubled for every retry after it, 10ms if zero
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between two attempts, 1s if zero
+	MaxDelay time.Duration
+	// IsTransient reports whether a failed attempt may succeed when run again, IsTransientError if nil
+	IsTransient func(err error) bool
+	// OnRetry, if set, is called before every retry with the number of the attempt which failed, its error, and the
+	// delay before the next one, e.g. to count retries in metrics
+	OnRetry func(ctx context.Context, attempt int, err error, delay time.Duration)
+}
+
+// WithRetry makes the Executor run again the statements and InTx transactions which fail with a transient error,
+// such as a deadlock or a serialization failure, up to `policy.MaxAttempts` times with an exponential backoff.
+// Statements run within a transaction are never retried on their own, since the failure aborts the transaction: the
+// InTx closure running them is run again from the start in a new transaction, so it must not have side effects
+// other than its statements. Operations which open a transaction of their own, such as audited writes, Create on
+// Postgres, or CopyFrom, aren't retried.
+func WithRetry(policy RetryPolicy) ExecutorOption {
+	return func(e *Executor) {
+		e.retryPolicy = &policy
+	}
+}
+
+// Codes of the transient errors recognized by IsTransientError
+var (
+	// transientStates are the SQLSTATE classes of serialization failures and deadlocks, as reported by Postgres
+	transientStates = map[string]bool{"40001": true, "40P01": true}
+	// transientNumbers are the MySQL error numbers of deadlocks and lock wait timeouts
+	transientNumbers = map[uint64]bool{1213: true, 1205: true}
+)
+
+// IsTransientError reports whether `err` is a deadlock or a serialization failure, which usually succeeds when the
+// statement or transaction is run again: Postgres errors with SQLSTATE 40001 or 40P01, as returned by lib/pq and pgx,
+// and MySQL errors 1213 and 1205, as returned by go-sql-driver/mysql. The errors are recognized by their SQLState
+// method or Number field, so the drivers don't need to be linked.
+func IsTransientError(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if state, ok := err.(interface{ SQLState() string }); ok && transientStates[state.SQLState()] {
+			return true
+		}
+		v := reflect.ValueOf(err)
+		if v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			continue
+		}
+		if number := v.FieldByName("Number"); number.IsValid() && number.CanUint() && transientNumbers[number.Uint()] {
+			return true
+		}
+	}
+	return false
+}
+
+// run calls `fn` until it succeeds, fails with an error which isn't transient, or has been called MaxAttempts times
+func (p *RetryPolicy) run(ctx context.Context, fn func() error) error {
+	attempts, delay, maxDelay := p.MaxAttempts, p.BaseDelay, p.MaxDelay
+	if attempts <= 0 {
+		attempts = 3
+	}
+	if delay <= 0 {
+		delay = 10 * time.Millisecond
+	}
+	if maxDelay <= 0 {
+		maxDelay = time.Second
+	}
+	isTransient := p.IsTransient
+	if isTransient == nil {
+		isTransient = IsTransientError
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= attempts || !isTransient(err) {
+			return err
+		}
+		// full jitter spreads the retries of transactions which deadlocked on each other
+		wait := time.Duration(rand.Int63n(int64(min(delay, maxDelay))) + 1)
+		if p.OnRetry != nil {
+			p.OnRetry(ctx, attempt, err, wait)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		// clamped before doubling so that many attempts can't overflow the delay
+		delay = min(delay, maxDelay) * 2
+	}
+}
+
+// retry runs `fn` with the retry policy of the Executor, once if it has none or is bound to a transaction
+func (e *Executor) retry(ctx context.Context, fn func() error) error {
+	if e.retryPolicy == nil || e.tx != nil {
+		return fn()
+	}
+	return e.retryPolicy.run(ctx, fn)
+}
@@ -0,0 +1,216 @@
+package pbsql
+
+import (
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Router maps tables and message types to the databases holding them, see WithRouter. Each route has a primary
+// which receives writes and optionally a set of replicas which receive reads.
+//
+// A Router must be fully configured before it is handed to an Executor, after which it is safe for concurrent use.
+type Router struct {
+	tables   map[string]*route
+	messages map[reflect.Type]*route
+	// replicas of the executor's own database, used for tables without a route
+	replicas *route
+	balancer Balancer
+	// staleness is how long reads of a route go to its primary after a write, see MaxStaleness
+	staleness time.Duration
+}
+
+// Balancer picks the replica a read is sent to
+type Balancer int
+
+// Supported balancers, RoundRobin is the default
+const (
+	// RoundRobin sends reads to each replica in turn
+	RoundRobin Balancer = iota
+	// LeastLatency sends reads to the replica with the lowest moving average query duration. Replicas which haven't
+	// served a read yet are tried first.
+	LeastLatency
+)
+
+// route holds the handles of one database
+type route struct {
+	primary  *sqlx.DB
+	replicas []*replica
+	next     uint64
+	// lastWrite is the time of the last write routed to the primary in unix nanoseconds
+	lastWrite int64
+}
+
+// replica is a read replica along with its observed latency
+type replica struct {
+	db *sqlx.DB
+	// latency is the moving average duration of reads in nanoseconds, zero until the first one
+	latency int64
+}
+
+// observe folds the duration of a read into the moving average
+func (r *replica) observe(d time.Duration) {
+	old := atomic.LoadInt64(&r.latency)
+	if old == 0 {
+		atomic.StoreInt64(&r.latency, int64(d))
+		return
+	}
+	atomic.StoreInt64(&r.latency, old+(int64(d)-old)/8)
+}
+
+func newRoute(primary *sqlx.DB, replicas []*sqlx.DB) *route {
+	rt := &route{primary: primary}
+	for _, db := range replicas {
+		rt.replicas = append(rt.replicas, &replica{db: db})
+	}
+	return rt
+}
+
+// NewRouter returns an empty Router, which sends every statement to the executor's database
+func NewRouter() *Router {
+	return &Router{tables: make(map[string]*route), messages: make(map[reflect.Type]*route)}
+}
+
+// Table routes statements on `table` to `primary`, and reads to `replicas` if any are given
+func (r *Router) Table(table string, primary *sqlx.DB, replicas ...*sqlx.DB) *Router {
+	r.tables[table] = newRoute(primary, replicas)
+	return r
+}
+
+// Message routes statements built from messages of the same type as `msg` to `primary`, and reads to `replicas` if
+// any are given. Message routes take precedence over table routes.
+func (r *Router) Message(msg interface{}, primary *sqlx.DB, replicas ...*sqlx.DB) *Router {
+	r.messages[messageType(reflect.TypeOf(msg))] = newRoute(primary, replicas)
+	return r
+}
+
+// Replicas sends reads on tables without a route to `replicas` rather than the executor's database
+func (r *Router) Replicas(replicas ...*sqlx.DB) *Router {
+	r.replicas = newRoute(nil, replicas)
+	return r
+}
+
+// Balance picks replicas with `b` rather than round-robin
+func (r *Router) Balance(b Balancer) *Router {
+	r.balancer = b
+	return r
+}
+
+// MaxStaleness sends reads of a route to its primary for `d` after the executor writes to it, so callers read their
+// own writes while replicas catch up. It should be set to the replication lag the replicas are expected to stay
+// within.
+func (r *Router) MaxStaleness(d time.Duration) *Router {
+	r.staleness = d
+	return r
+}
+
+// WithRouter sends statements to the databases configured on `r` by table or message type. Reads, i.e. Read,
+// ListStream, Get, and Count, go to a replica of the database if it has any, picked by the router's Balancer, unless
+// they are given WithPrimary or MaxStaleness applies. Writes go to the primary. Statements within a transaction
+// always run on the database the transaction was started on.
+func WithRouter(r *Router) ExecutorOption {
+	return func(e *Executor) {
+		e.router = r
+	}
+}
+
+// WithReplicas sends reads of every table to `replicas`, picked with `b`, and everything else to the executor's
+// database. It is shorthand for WithRouter(NewRouter().Balance(b).Replicas(replicas...)) and replaces any router.
+func WithReplicas(b Balancer, replicas ...*sqlx.DB) ExecutorOption {
+	return WithRouter(NewRouter().Balance(b).Replicas(replicas...))
+}
+
+// WithPrimary sends a read to the primary even if its database has replicas, e.g. to read a row straight after
+// writing it. Builders ignore it.
+func WithPrimary() Option {
+	return func(o *options) {
+		o.primary = true
+	}
+}
+
+// lookup returns the route of statements on `table` built from `source`, nil if they go to the executor's database
+func (r *Router) lookup(table string, source interface{}) *route {
+	if source != nil {
+		if rt, ok := r.messages[messageType(reflect.TypeOf(source))]; ok {
+			return rt
+		}
+	}
+	return r.tables[table]
+}
+
+// pick returns the replica a read is sent to, nil if it goes to the primary
+func (r *Router) pick(rt *route) *replica {
+	if len(rt.replicas) == 0 {
+		return nil
+	}
+	if r.staleness > 0 && time.Since(time.Unix(0, atomic.LoadInt64(&rt.lastWrite))) < r.staleness {
+		return nil
+	}
+	if r.balancer == LeastLatency {
+		var best *replica
+		var lowest int64
+		for _, candidate := range rt.replicas {
+			latency := atomic.LoadInt64(&candidate.latency)
+			if latency == 0 {
+				return candidate
+			}
+			if best == nil || latency < lowest {
+				best, lowest = candidate, latency
+			}
+		}
+		return best
+	}
+	n := atomic.AddUint64(&rt.next, 1)
+	return rt.replicas[(n-1)%uint64(len(rt.replicas))]
+}
+
+// route returns the executor running an `op` statement on `table` built from `source`, which is `e` itself unless
+// it is routed to another database
+func (e *Executor) route(table string, source interface{}, op Operation, opts ...Option) *Executor {
+	if e.router == nil || e.tx != nil {
+		return e
+	}
+	rt := e.router.lookup(table, source)
+	if rt == nil {
+		rt = e.router.replicas
+	}
+	if rt == nil {
+		return e
+	}
+
+	db := rt.primary
+	var picked *replica
+	o := newOptions(opts)
+	switch {
+	case op != OpRead && op != OpCount:
+		if e.router.staleness > 0 {
+			atomic.StoreInt64(&rt.lastWrite, time.Now().UnixNano())
+		}
+	case !o.primary && o.lock == 0:
+		if picked = e.router.pick(rt); picked != nil {
+			db = picked.db
+		}
+		atomic.StoreInt64(&rt.lastWrite, time.Now().UnixNano())
+	}
+	if db == nil {
+		db = e.DB
+	}
+	if db == e.DB && picked == nil {
+		return e
+	}
+	routed := *e
+	routed.DB = db
+	routed.dialect = DialectFromDriver(db.DriverName())
+	routed.replica = picked
+	return &routed
+}
+
+// messageType returns the struct type of a message or a pointer to one
+func messageType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,274 @@
+package pbsql
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// RowScanner is implemented by *sql.Rows and *sqlx.Rows
+type RowScanner interface {
+	Columns() ([]string, error)
+	Scan(dest ...interface{}) error
+}
+
+// ScanRow scans the current row into the struct `dest` points to, matching columns to fields by column name the same
+// way the builders do, decoding fields tagged `json_column`, `array`, and `convert`. Columns without a matching field
+// are discarded.
+//
+// Aliased columns such as `ifnull(user.age, 0) as age` match by their alias. A column named `prefix.column` is
+// scanned into the field stored in `column` of the nested message held by the field named `prefix` by its
+// `foreign_table` or `db` tag or its name, which is allocated if nil. Integer columns scan into enum fields and
+// timestamps into *timestamppb.Timestamp fields, a NULL leaving the field nil.
+//
+// Use it in place of sqlx's StructScan for messages with such fields, the Executor does so automatically.
+func ScanRow(rows RowScanner, dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("pbsql: cannot scan into %T, expected a pointer to a struct", dest)
+	}
+	v = v.Elem()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	index := fieldIndex(v.Type())
+	targets := make([]interface{}, len(columns))
+	var decoders []func() error
+	for i, column := range columns {
+		field, self, ok := scanField(v, index, column)
+		if !ok {
+			targets[i] = new(interface{})
+			continue
+		}
+		c, err := converterOf(self)
+		if err != nil {
+			return err
+		}
+		if c != nil {
+			src := new(interface{})
+			targets[i] = src
+			decoders = append(decoders, func() error { return decodeInto(c, *src, field) })
+			continue
+		}
+		if isJSONColumn(self) {
+			raw := new([]byte)
+			targets[i] = raw
+			decoders = append(decoders, func() error { return decodeJSON(*raw, field) })
+			continue
+		}
+		if arrayMode(self) != "" {
+			targets[i] = pq.Array(field.Addr().Interface())
+			continue
+		}
+		if isOptionalBool(self.Type) {
+			src := new(interface{})
+			targets[i] = src
+			decoders = append(decoders, func() error { return decodeOptionalBool(*src, field) })
+			continue
+		}
+		if isWrapperType(self.Type) {
+			target, decode := scanOptional(field)
+			targets[i] = target
+			decoders = append(decoders, decode)
+			continue
+		}
+		if isTimestampMessage(self.Type) {
+			src := new(interface{})
+			targets[i] = src
+			decoders = append(decoders, func() error { return decodeTimestamp(*src, field) })
+			continue
+		}
+		targets[i] = field.Addr().Interface()
+	}
+	if err := rows.Scan(targets...); err != nil {
+		return err
+	}
+	for _, decode := range decoders {
+		if err := decode(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanField returns the field of `v` a column is scanned into, following `prefix.column` names into nested messages
+func scanField(v reflect.Value, index map[string]int, column string) (reflect.Value, reflect.StructField, bool) {
+	if j, ok := index[column]; ok {
+		return v.Field(j), structField(v.Type(), j), true
+	}
+	dot := strings.Index(column, ".")
+	if dot < 0 {
+		return reflect.Value{}, reflect.StructField{}, false
+	}
+	prefix, rest := column[:dot], column[dot+1:]
+	for j := 0; j < v.NumField(); j++ {
+		self := structField(v.Type(), j)
+		if self.PkgPath != "" || messageType(self.Type).Kind() != reflect.Struct || isTimestampType(self.Type) {
+			continue
+		}
+		if prefix != self.Tag.Get("foreign_table") && prefix != self.Tag.Get("db") &&
+			prefix != strings.ToLower(self.Name) && prefix != toSnakeCase(self.Name) {
+			continue
+		}
+		nested := v.Field(j)
+		if nested.Kind() == reflect.Ptr {
+			if nested.IsNil() {
+				nested.Set(reflect.New(nested.Type().Elem()))
+			}
+			nested = nested.Elem()
+		}
+		return scanField(nested, fieldIndex(nested.Type()), rest)
+	}
+	return reflect.Value{}, reflect.StructField{}, false
+}
+
+// timestampLayouts are the layouts timestamps scanned as text are parsed with
+var timestampLayouts = []string{"2006-01-02 15:04:05.999999999", time.RFC3339Nano, "2006-01-02"}
+
+// isTimestampMessage reports whether `t` is a *timestamppb.Timestamp, which database/sql can't scan into
+func isTimestampMessage(t reflect.Type) bool {
+	return t.Kind() == reflect.Ptr && t != reflect.PtrTo(timeType) && isTimestampType(t)
+}
+
+// decodeTimestamp sets the *timestamppb.Timestamp `v` from a scanned time, or text if the driver doesn't parse times
+func decodeTimestamp(src interface{}, v reflect.Value) error {
+	var t time.Time
+	switch src := src.(type) {
+	case nil:
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	case time.Time:
+		t = src
+	case []byte, string:
+		text := fmt.Sprint(src)
+		if b, ok := src.([]byte); ok {
+			text = string(b)
+		}
+		var err error
+		for _, layout := range timestampLayouts {
+			if t, err = time.Parse(layout, text); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("pbsql: cannot parse %q as a timestamp", text)
+		}
+	default:
+		return fmt.Errorf("pbsql: cannot scan %T into a timestamp", src)
+	}
+	v.Set(reflect.ValueOf(timestamppb.New(t)))
+	return nil
+}
+
+// hasCustomColumns reports whether values of `t`, or the elements of a slice of them, hold JSON, array, converted,
+// timestamp, or wrapped bool columns, negating fields, or aliased columns, which sqlx can't bind or scan as is
+func hasCustomColumns(t reflect.Type) bool {
+	if t == nil {
+		return false
+	}
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	if columnAliases(t) != nil {
+		return true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := structField(t, i)
+		if isJSONColumn(f) || arrayMode(f) != "" || hasConverter(f) || (columnName(f) != "" && (isTimestampMessage(f.Type) || isWrapperType(f.Type))) {
+			return true
+		}
+		if _, negated := negatedColumn(t, f); negated {
+			return true
+		}
+	}
+	return false
+}
+
+// scanStruct scans the current row into `dest`, falling back to ScanRow where sqlx can't decode a field
+func scanStruct(rows *sqlx.Rows, dest interface{}) error {
+	if hasCustomColumns(reflect.TypeOf(dest)) {
+		return ScanRow(rows, dest)
+	}
+	return rows.StructScan(dest)
+}
+
+// Rows is implemented by *sql.Rows and *sqlx.Rows
+type Rows interface {
+	RowScanner
+	Next() bool
+	Err() error
+}
+
+// ScanAll scans every remaining row into `dest`, a pointer to a slice of messages or message pointers such as
+// *[]*pb.Task, with ScanRow, appending them to the slice. `sizeHint` is the number of rows expected, usually the
+// result of the count query paired with the read, and is used to allocate the slice once up front; zero or a wrong
+// guess only costs reallocations. It doesn't close the rows.
+//
+//	tasks := make([]*pb.Task, 0)
+//	err := pbsql.ScanAll(rows, &tasks, int(total))
+func ScanAll(rows Rows, dest interface{}, sizeHint int) error {
+	slice := reflect.ValueOf(dest)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("pbsql: cannot scan into %T, expected a pointer to a slice", dest)
+	}
+	slice = slice.Elem()
+	elemType := slice.Type().Elem()
+	base := elemType
+	if base.Kind() == reflect.Ptr {
+		base = base.Elem()
+	}
+	if base.Kind() != reflect.Struct {
+		return fmt.Errorf("pbsql: cannot scan into %T, expected a slice of structs", dest)
+	}
+	if sizeHint > slice.Cap()-slice.Len() {
+		grown := reflect.MakeSlice(slice.Type(), slice.Len(), slice.Len()+sizeHint)
+		reflect.Copy(grown, slice)
+		slice.Set(grown)
+	}
+	return ScanEach(rows, func() interface{} { return reflect.New(base).Interface() }, func(item interface{}) error {
+		if elemType.Kind() == reflect.Ptr {
+			slice.Set(reflect.Append(slice, reflect.ValueOf(item)))
+		} else {
+			slice.Set(reflect.Append(slice, reflect.ValueOf(item).Elem()))
+		}
+		return nil
+	})
+}
+
+// ScanEach scans every remaining row with ScanRow into a message returned by `newMsg`, which must be a struct pointer,
+// and passes it to `fn`, stopping at the first error. It lets callers allocate messages themselves, e.g. from a pool
+// or an arena, rather than have ScanAll grow a slice. It doesn't close the rows.
+func ScanEach(rows Rows, newMsg func() interface{}, fn func(msg interface{}) error) error {
+	for rows.Next() {
+		msg := newMsg()
+		if err := ScanRow(rows, msg); err != nil {
+			return err
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// scanOne scans the first row into `dest`, returning sql.ErrNoRows if there is none
+func scanOne(rows *sqlx.Rows, dest interface{}) error {
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	return ScanRow(rows, dest)
+}
@@ -0,0 +1,206 @@
+package pbsql
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DriftKind classifies a mismatch between a message and the table it is stored in
+type DriftKind string
+
+// Kinds of drift reported by CheckSchema
+const (
+	// DriftMissingTable is reported when the table doesn't exist
+	DriftMissingTable DriftKind = "missing_table"
+	// DriftMissingColumn is reported for a `db` tagged field without a matching column
+	DriftMissingColumn DriftKind = "missing_column"
+	// DriftTypeMismatch is reported when a column can't be scanned into or written from its field
+	DriftTypeMismatch DriftKind = "type_mismatch"
+	// DriftNullable is reported for a nullable column whose field isn't tagged `nullable`, reading a null value
+	// into such a field fails
+	DriftNullable DriftKind = "nullable"
+)
+
+// Drift describes a single mismatch found by CheckSchema
+type Drift struct {
+	Kind   DriftKind
+	Table  string
+	Column string
+	// Field is the name of the go struct field, empty for DriftMissingTable
+	Field string
+	// Detail is a human readable explanation, e.g. the conflicting types
+	Detail string
+}
+
+func (d Drift) String() string {
+	if d.Column == "" {
+		return fmt.Sprintf("%s: %s", d.Table, d.Detail)
+	}
+	return fmt.Sprintf("%s.%s (%s): %s", d.Table, d.Column, d.Field, d.Detail)
+}
+
+// ColumnInfo describes a column as reported by the database
+type ColumnInfo struct {
+	Name     string
+	DataType string
+	Nullable bool
+}
+
+// CheckSchema compares the `db` tagged fields of `source` with the columns of `target` in the database and returns
+// every mismatch found: columns missing from the table, types which are incompatible with the field, and nullable
+// columns lacking the `nullable` tag. An empty result means the message and table agree. Columns of the table
+// without a matching field are not reported, nor are fields tagged `readonly` which may be backed by a view. The types
+// of fields tagged `convert` aren't compared since their converter decides what is stored.
+//
+// Columns are read from information_schema on MySQL and Postgres, restricted to the current schema, and from
+// pragma_table_info on SQLite. This is intended to be run at service start up or in integration tests.
+func CheckSchema(ctx context.Context, db *sqlx.DB, target string, source interface{}) ([]Drift, error) {
+	columns, err := TableColumns(ctx, db, target)
+	if err != nil {
+		return nil, err
+	}
+	return compareSchema(target, source, columns), nil
+}
+
+// TableColumns returns the columns of `target` as reported by the database, see CheckSchema
+func TableColumns(ctx context.Context, db *sqlx.DB, target string) ([]ColumnInfo, error) {
+	var qry string
+	switch DialectFromDriver(db.DriverName()) {
+	case Postgres:
+		qry = "SELECT column_name, data_type, is_nullable FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 ORDER BY ordinal_position"
+	case SQLite:
+		qry = "SELECT name AS column_name, type AS data_type, CASE WHEN \"notnull\" = 0 AND pk = 0 THEN 'YES' ELSE 'NO' END AS is_nullable FROM pragma_table_info(?) ORDER BY cid"
+	default:
+		qry = "SELECT column_name, data_type, is_nullable FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? ORDER BY ordinal_position"
+	}
+	var rows []struct {
+		Name       string `db:"column_name"`
+		DataType   string `db:"data_type"`
+		IsNullable string `db:"is_nullable"`
+	}
+	if err := sqlx.SelectContext(ctx, db, &rows, qry, target); err != nil {
+		return nil, fmt.Errorf("pbsql: reading columns of %s: %w", target, err)
+	}
+	columns := make([]ColumnInfo, len(rows))
+	for i, row := range rows {
+		columns[i] = ColumnInfo{Name: row.Name, DataType: row.DataType, Nullable: strings.EqualFold(row.IsNullable, "YES")}
+	}
+	return columns, nil
+}
+
+// compareSchema reports every mismatch between the fields of `source` and `columns`
+func compareSchema(target string, source interface{}, columns []ColumnInfo) []Drift {
+	if len(columns) == 0 {
+		return []Drift{{Kind: DriftMissingTable, Table: target, Detail: "table does not exist"}}
+	}
+	byName := make(map[string]ColumnInfo, len(columns))
+	for _, column := range columns {
+		byName[strings.ToLower(column.Name)] = column
+	}
+
+	var drifts []Drift
+	v := reflect.Indirect(reflect.ValueOf(source))
+	for i := 0; i < v.NumField(); i++ {
+		field := parseReflection(v, i, target)
+		if !field.isColumn || field.shouldIgnore || field.selectFunc.ok || field.isMultiValue || field.isReadonly {
+			continue
+		}
+		drift := Drift{Table: target, Column: field.name, Field: field.self.Name}
+		column, ok := byName[strings.ToLower(field.name)]
+		if !ok {
+			drift.Kind = DriftMissingColumn
+			drift.Detail = "column does not exist"
+			drifts = append(drifts, drift)
+			continue
+		}
+		family := columnFamily(column.DataType)
+		if !hasConverter(field.self) && !compatible(field.self.Type, family) {
+			drift.Kind = DriftTypeMismatch
+			drift.Detail = fmt.Sprintf("%s field is incompatible with %s column", field.self.Type, column.DataType)
+			drifts = append(drifts, drift)
+		}
+		if column.Nullable && !field.isNullable {
+			drift.Kind = DriftNullable
+			drift.Detail = "column is nullable but the field is not tagged nullable"
+			drifts = append(drifts, drift)
+		}
+	}
+	return drifts
+}
+
+// type families of database columns, as far as scanning into go values is concerned
+const (
+	familyInteger = "integer"
+	familyFloat   = "float"
+	familyDecimal = "decimal"
+	familyBool    = "bool"
+	familyText    = "text"
+	familyTime    = "time"
+	familyBinary  = "binary"
+	familyOther   = "other"
+)
+
+// columnFamily classifies a data type as reported by any of the supported databases, e.g. `character varying`,
+// `bigint`, or `VARCHAR(255)`
+func columnFamily(dataType string) string {
+	t := strings.ToLower(dataType)
+	if i := strings.IndexByte(t, '('); i >= 0 {
+		t = t[:i]
+	}
+	t = strings.TrimSpace(t)
+	switch {
+	case t == "bool" || t == "boolean" || t == "bit":
+		return familyBool
+	case strings.Contains(t, "int") || strings.Contains(t, "serial"):
+		return familyInteger
+	case t == "decimal" || t == "numeric" || t == "money":
+		return familyDecimal
+	case strings.Contains(t, "float") || strings.Contains(t, "double") || t == "real":
+		return familyFloat
+	case strings.Contains(t, "char") || strings.Contains(t, "text") || strings.Contains(t, "clob") ||
+		t == "enum" || t == "set" || t == "uuid" || t == "json" || t == "jsonb":
+		return familyText
+	case strings.Contains(t, "time") || t == "date" || t == "year" || t == "interval":
+		return familyTime
+	case strings.Contains(t, "blob") || strings.Contains(t, "binary") || t == "bytea":
+		return familyBinary
+	}
+	return familyOther
+}
+
+// compatible reports whether values of a column family can be read into and written from a field of type `t`
+func compatible(t reflect.Type, family string) bool {
+	if family == familyOther {
+		return true
+	}
+	if isOptionalBool(t) {
+		return family == familyBool || family == familyInteger
+	}
+	if isOptionalValue(t) {
+		return compatible(optionalValueType(t), family)
+	}
+	if isNullType(t) {
+		return compatible(nullValueType(t), family)
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return family == familyInteger || family == familyBool
+	case reflect.Float32, reflect.Float64:
+		return family == familyFloat || family == familyDecimal || family == familyInteger
+	case reflect.Bool:
+		return family == familyBool || family == familyInteger
+	case reflect.String:
+		// strings routinely hold times and decimals, but can't be written to numeric columns
+		return family != familyInteger && family != familyFloat && family != familyBool
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return family == familyBinary || family == familyText
+		}
+	}
+	return true
+}
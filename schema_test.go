@@ -0,0 +1,48 @@
+package pbsql
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+)
+
+func TestCheckSchema(t *testing.T) {
+	db, d := newFakeDB(t, "mysql")
+	d.columns = []string{"column_name", "data_type", "is_nullable"}
+	d.rows = [][]driver.Value{
+		{"id", "int", "NO"},
+		{"email", "varchar", "YES"},
+	}
+
+	type user struct {
+		ID    int32  `db:"id" primary_key:"y"`
+		Email int64  `db:"email"`
+		Name  string `db:"name" nullable:"y"`
+	}
+	drifts, err := CheckSchema(context.Background(), db, "user", &user{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []DriftKind{DriftTypeMismatch, DriftNullable, DriftMissingColumn}
+	if len(drifts) != len(expected) {
+		t.Fatal("unexpected drifts", drifts)
+	}
+	for i, kind := range expected {
+		if drifts[i].Kind != kind {
+			t.Log("Got:", drifts)
+			t.Fatal("Expected:", expected)
+		}
+	}
+	if d.args[0][0] != "user" {
+		t.Fatal("expected the table name to be bound", d.args[0])
+	}
+
+	d.rows = nil
+	drifts, err = CheckSchema(context.Background(), db, "user", &user{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 1 || drifts[0].Kind != DriftMissingTable {
+		t.Fatal("expected a missing table", drifts)
+	}
+}
@@ -0,0 +1,138 @@
+package pbsql
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// StmtCache is a size limited LRU cache of prepared statements keyed by message type, driver, and query shape.
+// Since pbsql only writes placeholders for the fields that are set, the generated SQL text identifies the shape of a
+// query and hot endpoints end up reusing a handful of statements.
+//
+// Statements evicted from the cache are closed once every caller holding them has released them.
+type StmtCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[stmtKey]*list.Element
+	stats   StmtCacheStats
+}
+
+// StmtCacheStats holds statement cache metrics
+type StmtCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+}
+
+type stmtKey struct {
+	typ reflect.Type
+	// db is the database the statement was prepared on, routed executors share a cache between several
+	db     *sqlx.DB
+	driver string
+	query  string
+}
+
+type stmtEntry struct {
+	key     stmtKey
+	stmt    *sqlx.Stmt
+	refs    int
+	evicted bool
+}
+
+// NewStmtCache returns a statement cache holding at most `size` statements, a size below 1 is treated as 1
+func NewStmtCache(size int) *StmtCache {
+	if size < 1 {
+		size = 1
+	}
+	return &StmtCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[stmtKey]*list.Element),
+	}
+}
+
+// Stats returns a snapshot of the cache metrics
+func (c *StmtCache) Stats() StmtCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Size = c.order.Len()
+	return stats
+}
+
+// Close evicts and closes every cached statement
+func (c *StmtCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var firstErr error
+	for c.order.Len() > 0 {
+		if err := c.evict(c.order.Back()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// get returns the statement cached under `key`, preparing it with `prepare` on a miss
+func (c *StmtCache) get(key stmtKey, prepare func() (*sqlx.Stmt, error)) (*sqlx.Stmt, func(), error) {
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.stats.Hits++
+		c.order.MoveToFront(el)
+		entry := el.Value.(*stmtEntry)
+		entry.refs++
+		c.mu.Unlock()
+		return entry.stmt, c.releaser(entry), nil
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	stmt, err := prepare()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		// another goroutine prepared the same statement while we were, keep theirs
+		stmt.Close()
+		entry := el.Value.(*stmtEntry)
+		entry.refs++
+		return entry.stmt, c.releaser(entry), nil
+	}
+	entry := &stmtEntry{key: key, stmt: stmt, refs: 1}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		c.evict(c.order.Back())
+	}
+	return stmt, c.releaser(entry), nil
+}
+
+func (c *StmtCache) releaser(entry *stmtEntry) func() {
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		entry.refs--
+		if entry.evicted && entry.refs == 0 {
+			entry.stmt.Close()
+		}
+	}
+}
+
+// evict must be called with c.mu held
+func (c *StmtCache) evict(el *list.Element) error {
+	entry := c.order.Remove(el).(*stmtEntry)
+	delete(c.entries, entry.key)
+	c.stats.Evictions++
+	entry.evicted = true
+	if entry.refs == 0 {
+		return entry.stmt.Close()
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package pbsql
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// WithStrict makes the builders return an error for mistakes they otherwise ignore, so that a typo in a tag surfaces
+// instead of silently dropping a predicate or an assignment:
+//
+//   - ErrUnmappedField for a field holding a value which isn't stored in a column or used by a predicate, e.g. a
+//     filter field whose `db` tag is missing, and for fields tagged `nullable` without a column
+//   - ErrUnknownField for entries of WithFieldMask which don't name any field
+//
+// Fields tagged `db:"-"`, relations, and the fields read by name such as OrderBy are never reported. Set
+// Config.Strict to build every query strictly, e.g. in tests.
+func WithStrict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// checkStrict returns the mistakes WithStrict reports for building a statement on `target` from `v`
+func (o *options) checkStrict(target string, v reflect.Value) error {
+	if !o.strict {
+		return nil
+	}
+	if _, err := normalizeMask(v.Type(), target, o.fieldMask); err != nil {
+		return err
+	}
+	var unmapped []string
+	for i := 0; i < v.NumField(); i++ {
+		field := parseReflection(v, i, target)
+		if !field.value.CanInterface() || field.isColumn {
+			continue
+		}
+		if field.self.Tag.Get("nullable") != "" {
+			unmapped = append(unmapped, field.self.Name+" is tagged nullable")
+		} else if field.name == "" && !field.value.IsZero() && !columnless(field.self) && !isRangeBound(v.Type(), field.self) {
+			unmapped = append(unmapped, field.self.Name+" is set")
+		}
+	}
+	if len(unmapped) > 0 {
+		return fmt.Errorf("%w: %s of %s", ErrUnmappedField, strings.Join(unmapped, ", "), target)
+	}
+	return nil
+}
+
+// columnless reports whether a field is meant to be read without a column: left out with `db:"-"`, a relation, a
+// location filter, a relative window, a select_func, or read by name by the builders
+func columnless(self reflect.StructField) bool {
+	return self.Tag.Get("db") == "-" || controlFields[self.Name] || strings.HasPrefix(self.Name, "XXX_") ||
+		self.Tag.Get("foreign_key") != "" || self.Tag.Get("foreign_table") != "" || self.Tag.Get("m2m") != "" ||
+		self.Tag.Get("select_func") != "" || self.Tag.Get("within") != "" || isGeoFilter(self)
+}
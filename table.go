@@ -0,0 +1,61 @@
+package pbsql
+
+import (
+	"reflect"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TableResolver returns the table a statement on `base` runs against for the message `msg`, e.g. the partition
+// `events_2024_05` of a table partitioned by month. It returns `base` for messages it doesn't route.
+type TableResolver func(base string, msg interface{}) string
+
+// WithTableResolver resolves the target table of every builder and Executor method with `r`, so that partition
+// routing isn't formatted at every call site:
+//
+//	exec.Create(ctx, "events", event, pbsql.WithTableResolver(pbsql.TimePartitions("OccurredAt", "_2006_01")))
+//
+// The resolved name also qualifies the columns of the statement, so clauses given with WithWhere must name it, or
+// leave their columns unqualified. Policies and read routing see the base name. Set Config.TableResolver to resolve
+// the tables of every query.
+func WithTableResolver(r TableResolver) Option {
+	return func(o *options) {
+		o.tableResolver = r
+	}
+}
+
+// TimePartitions returns a TableResolver appending the time held by the field `field` of messages to the base table,
+// formatted with the time.Format `layout`, e.g. "_2006_01" resolves "events" to "events_2024_05". The field may be a
+// time.Time or a *timestamppb.Timestamp, messages without the field or leaving it unset resolve to the base table.
+func TimePartitions(field string, layout string) TableResolver {
+	return func(base string, msg interface{}) string {
+		v := reflect.Indirect(reflect.ValueOf(msg))
+		if v.Kind() != reflect.Struct {
+			return base
+		}
+		f := v.FieldByName(field)
+		if !f.IsValid() || !f.CanInterface() {
+			return base
+		}
+		switch t := f.Interface().(type) {
+		case time.Time:
+			if !t.IsZero() {
+				return base + t.UTC().Format(layout)
+			}
+		case *timestamppb.Timestamp:
+			if t != nil {
+				return base + t.AsTime().Format(layout)
+			}
+		}
+		return base
+	}
+}
+
+// table returns the table a statement on `target` built from `source` runs against
+func (o *options) table(target string, source interface{}) string {
+	if o.tableResolver == nil {
+		return target
+	}
+	return o.tableResolver(target, source)
+}
@@ -0,0 +1,63 @@
+package pbsql
+
+import "time"
+
+// Columns of history tables bounding the period each version of a row was current, see WithHistoryAsOf
+const (
+	ValidFromColumn = "valid_from"
+	ValidToColumn   = "valid_to"
+)
+
+// asOfParam is the param the time of point-in-time reads is bound to
+const asOfParam = "pbsql_as_of"
+
+// WithAsOf reads the rows as they were at `at`, for audits and point-in-time reports. On MySQL, meaning MariaDB with
+// system-versioned tables, the read queries `<table> FOR SYSTEM_TIME AS OF ?`. Other dialects read the history table
+// of the target like WithHistoryAsOf.
+func WithAsOf(at time.Time) Option {
+	return func(o *options) {
+		o.asOf = at
+		o.asOfHistory = false
+	}
+}
+
+// WithHistoryAsOf reads the rows as they were at `at` from the history table of the target, see HistoryTable, which
+// holds every version of each row along with the period it was current: from ValidFromColumn, inclusive, to
+// ValidToColumn, exclusive, which is NULL for the current version. The read matches the version current at `at`:
+//
+//	SELECT ... FROM task_history WHERE true AND ... AND task_history.valid_from <= ? AND (task_history.valid_to IS NULL
+//	OR task_history.valid_to > ?)
+func WithHistoryAsOf(at time.Time) Option {
+	return func(o *options) {
+		o.asOf = at
+		o.asOfHistory = true
+	}
+}
+
+// asOfTable returns the relation a point-in-time read of `target` selects from: its history table unless the dialect
+// has system-versioned tables
+func (o *options) asOfTable(target string) string {
+	if o.asOf.IsZero() || (o.dialect == MySQL && !o.asOfHistory) {
+		return target
+	}
+	return HistoryTable(target)
+}
+
+// asOfClauses returns the period clause following the table of a point-in-time read of `target`, or the predicates
+// matching the versions of its history table current at the time of the read, and binds that time in the params of `o`
+func (o *options) asOfClauses(target string) (period string, predicates []string) {
+	if o.asOf.IsZero() {
+		return "", nil
+	}
+	if o.params == nil {
+		o.params = make(map[string]interface{})
+	}
+	o.params[asOfParam] = o.asOf
+	if o.dialect == MySQL && !o.asOfHistory {
+		return " FOR SYSTEM_TIME AS OF :" + asOfParam, nil
+	}
+	return "", []string{
+		target + "." + ValidFromColumn + " <= :" + asOfParam,
+		"(" + target + "." + ValidToColumn + " IS NULL OR " + target + "." + ValidToColumn + " > :" + asOfParam + ")",
+	}
+}
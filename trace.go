@@ -0,0 +1,49 @@
+package pbsql
+
+import (
+	"context"
+	"time"
+)
+
+// Operation identifies the kind of statement an Executor runs
+type Operation string
+
+// Operations reported to a Tracer
+const (
+	OpCreate Operation = "create"
+	OpUpsert Operation = "upsert"
+	OpRead   Operation = "read"
+	OpCount  Operation = "count"
+	OpUpdate Operation = "update"
+	OpDelete Operation = "delete"
+	// OpRefresh refreshes a materialized view, see WithMaterializedViews
+	OpRefresh Operation = "refresh"
+)
+
+// QueryInfo describes a single query built and run by an Executor
+type QueryInfo struct {
+	Table     string
+	Operation Operation
+	// Query is the generated SQL statement, before placeholder rebinding
+	Query string
+	// Rows holds the number of rows affected by a write or returned by a read
+	Rows int64
+	// BuildDuration is the time spent generating the statement
+	BuildDuration time.Duration
+	// Duration is the time spent executing the statement
+	Duration time.Duration
+	// Cached is set for reads served from the executor's ResultCache, see WithResultCache
+	Cached bool
+}
+
+// Tracer can be plugged into an Executor with WithTracer to instrument every query it runs, e.g. with OpenTelemetry
+// spans or latency metrics. See the pbsqlotel package for an OpenTelemetry implementation.
+type Tracer interface {
+	// StartQuery is called before a query is built, the returned context is used to execute it
+	StartQuery(ctx context.Context, table string, op Operation) (context.Context, QuerySpan)
+}
+
+// QuerySpan is returned by Tracer.StartQuery and ended once the query has run or failed
+type QuerySpan interface {
+	End(info QueryInfo, err error)
+}
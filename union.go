@@ -0,0 +1,132 @@
+package pbsql
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// unionSource is the synthetic column of union reads holding the table each row was read from
+const unionSource = "source_table"
+
+// BuildUnionQuery builds the read BuildReadQueryWithOptions would for each of `targets`, tables of identical shape
+// such as current and archived rows, and combines them with UNION ALL:
+//
+//	qry, args, err := pbsql.BuildUnionQuery([]string{"order", "order_archive"}, &pb.Order{CustomerId: 7}, pbsql.WithLimit(20, 0))
+//	// SELECT * FROM (SELECT order.id, ..., 'order' AS source_table FROM order WHERE true AND order.customer_id = ?
+//	// UNION ALL SELECT order_archive.id, ..., 'order_archive' AS source_table FROM order_archive WHERE true AND
+//	// order_archive.customer_id = ?) AS pbsql_union order by id asc, source_table asc LIMIT 20
+//
+// Each row holds the table it was read from, as named in `targets`, in the source_table column, which messages scanned
+// from the union map with a field tagged `db:"source_table"`. That field is never selected from the tables themselves.
+// The union is ordered by the OrderBy field of `source`, or by the primary keys, then by table, so that the limit and
+// offset of the read page through the union consistently. Union reads can't take row locks.
+func BuildUnionQuery(targets []string, source interface{}, opts ...Option) (string, []interface{}, error) {
+	o := newOptions(opts)
+	qry, err := unionQuery(targets, source, o)
+	if err != nil {
+		return "", nil, err
+	}
+	return o.bind(qry, source)
+}
+
+// unionQuery returns the named statement bound by BuildUnionQuery, and adds the params of each read to those of `o`
+func unionQuery(targets []string, source interface{}, o *options) (string, error) {
+	if len(targets) == 0 {
+		return "", fmt.Errorf("pbsql: no tables to read the union of")
+	}
+	if o.lock != 0 {
+		return "", fmt.Errorf("pbsql: union read of %s can't take a row lock", strings.Join(targets, ", "))
+	}
+	v := reflect.ValueOf(source).Elem()
+	limit := 0
+	params := make(map[string]interface{}, len(o.params))
+	for name, value := range o.params {
+		params[name] = value
+	}
+	reads := make([]string, len(targets))
+	orderBy := orderByOf(&v)
+	for i, name := range targets {
+		target := o.readRelation(o.table(name, source), source)
+		targetLimit, err := o.limits.limit(target, o.limit)
+		if err != nil {
+			return "", err
+		}
+		if targetLimit > 0 && (limit == 0 || targetLimit < limit) {
+			limit = targetLimit
+		}
+		// the order and page apply to the union, not to each read
+		read := *o
+		read.limit, read.offset, read.limits = 0, 0, Limits{}
+		read.params = make(map[string]interface{}, len(params))
+		for name, value := range params {
+			read.params[name] = value
+		}
+		q, err := selectQuery(target, source, &read)
+		if err != nil {
+			return "", err
+		}
+		var columns []string
+		for j, column := range rankedColumns("", q.Columns) {
+			if column != "."+unionSource {
+				columns = append(columns, q.Columns[j])
+			}
+		}
+		q.Columns = append(columns, "'"+strings.ReplaceAll(name, "'", "''")+"' AS "+unionSource)
+		q.OrderBy = ""
+		reads[i] = q.Named()
+		for name, value := range q.Params {
+			params[name] = value
+		}
+		orderBy = strings.ReplaceAll(orderBy, target+".", "")
+	}
+	if orderBy == "" {
+		var keys []string
+		for _, key := range primaryKeys(v, "") {
+			keys = append(keys, key.name+" asc")
+		}
+		orderBy = strings.Join(keys, ", ")
+	}
+	if orderBy != "" {
+		orderBy += ", "
+	}
+	o.params = params
+	qry := "SELECT * FROM (" + strings.Join(reads, " UNION ALL ") + ") AS pbsql_union order by " + orderBy + unionSource + " asc"
+	if limit > 0 {
+		qry += " LIMIT " + strconv.Itoa(limit)
+	}
+	if o.offset > 0 {
+		qry += " OFFSET " + strconv.Itoa(o.offset)
+	}
+	return qry, nil
+}
+
+// ReadUnion reads the rows of each of `targets` matching `source` into `dest` with BuildUnionQuery, in the order of
+// the union
+func (e *Executor) ReadUnion(ctx context.Context, targets []string, source interface{}, dest interface{}, opts ...Option) (err error) {
+	if len(targets) == 0 {
+		return fmt.Errorf("pbsql: no tables to read the union of")
+	}
+	e = e.route(targets[0], source, OpRead, opts...)
+	for _, target := range targets {
+		if opts, err = e.secure(ctx, target, OpRead, source, opts); err != nil {
+			return err
+		}
+	}
+	o := e.options(opts)
+	ctx, run := e.start(ctx, strings.Join(targets, ", "), OpRead)
+	defer func() { run.finish(err) }()
+
+	if err = run.build(func() (string, interface{}, error) {
+		qry, err := unionQuery(targets, source, o)
+		return qry, o.bindSource(source), err
+	}); err != nil {
+		return err
+	}
+	if err = e.selectRows(ctx, source, dest, run.info.Query, run.args); err == nil {
+		run.info.Rows = int64(reflect.Indirect(reflect.ValueOf(dest)).Len())
+	}
+	return err
+}
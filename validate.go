@@ -0,0 +1,135 @@
+package pbsql
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// Validator is implemented by messages which validate themselves, such as those generated by protoc-gen-validate.
+// Messages which also implement ValidateAll() error are validated with it, reporting every violation at once.
+type Validator interface {
+	Validate() error
+}
+
+// ValidateFunc validates a message about to be written to `table` by `op`, see WithValidation. The errors it returns
+// are reported as violations of a ValidationError.
+type ValidateFunc func(ctx context.Context, table string, op Operation, msg interface{}) error
+
+// WithValidation validates messages before the Executor creates, upserts, or updates them, so invalid messages never
+// reach the database: messages implementing Validator validate themselves, then each of `validators` is called. The
+// violations are aggregated into a ValidationError. Violations of a field naming its column or proto path, as
+// protoc-gen-validate errors do, are only reported by updates assigning the field, so that masked updates of messages
+// with required fields are not rejected for the fields they leave out.
+func WithValidation(validators ...ValidateFunc) ExecutorOption {
+	return func(e *Executor) {
+		e.validation = true
+		e.validators = append(e.validators, validators...)
+	}
+}
+
+// FieldViolation is a rule of a field broken by a message, see ValidationError
+type FieldViolation struct {
+	// Field is the name of the field reported by the validator, empty for violations of the whole message
+	Field  string
+	Reason string
+}
+
+// ValidationError lists the violations of a message rejected by the validation of an Executor, see WithValidation. It
+// wraps ErrInvalidMessage.
+type ValidationError struct {
+	Table      string
+	Violations []FieldViolation
+}
+
+func (e *ValidationError) Error() string {
+	reasons := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		reasons[i] = violation.Reason
+		if violation.Field != "" {
+			reasons[i] = violation.Field + ": " + violation.Reason
+		}
+	}
+	return "pbsql: invalid " + e.Table + ": " + strings.Join(reasons, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidMessage
+}
+
+// validate runs the validation of the Executor on `source`, about to be written to `target` by `op`. Updates only
+// report the violations of the fields they assign with `fieldMask`.
+func (e *Executor) validate(ctx context.Context, target string, op Operation, source interface{}, fieldMask []string) error {
+	if !e.validation {
+		return nil
+	}
+	var errs []error
+	switch v := source.(type) {
+	case interface{ ValidateAll() error }:
+		errs = append(errs, v.ValidateAll())
+	case Validator:
+		errs = append(errs, v.Validate())
+	}
+	for _, validator := range e.validators {
+		errs = append(errs, validator(ctx, target, op, source))
+	}
+	var violations []FieldViolation
+	for _, err := range errs {
+		for _, violation := range violationsOf(err) {
+			if op != OpUpdate || assignsViolation(target, source, fieldMask, violation) {
+				violations = append(violations, violation)
+			}
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return &ValidationError{Table: target, Violations: violations}
+}
+
+// violationsOf returns the violations reported by the validation error `err`: the errors of the multi errors of
+// protoc-gen-validate, which report their field and reason, or of errors joined with errors.Join
+func violationsOf(err error) []FieldViolation {
+	if err == nil {
+		return nil
+	}
+	var causes []error
+	switch multi := err.(type) {
+	case interface{ AllErrors() []error }:
+		causes = multi.AllErrors()
+	case interface{ Unwrap() []error }:
+		causes = multi.Unwrap()
+	}
+	if causes != nil {
+		var violations []FieldViolation
+		for _, cause := range causes {
+			violations = append(violations, violationsOf(cause)...)
+		}
+		return violations
+	}
+	var field interface {
+		Field() string
+		Reason() string
+	}
+	if errors.As(err, &field) {
+		return []FieldViolation{{Field: field.Field(), Reason: field.Reason()}}
+	}
+	return []FieldViolation{{Reason: err.Error()}}
+}
+
+// assignsViolation reports whether an update of `source` with `fieldMask` assigns the field of `violation`, or
+// whether the violation doesn't name a field of `source`
+func assignsViolation(target string, source interface{}, fieldMask []string, violation FieldViolation) bool {
+	v := reflect.ValueOf(source).Elem()
+	names, err := normalizeMask(v.Type(), target, []string{violation.Field})
+	if violation.Field == "" || err != nil {
+		return true
+	}
+	fieldMask, err = normalizeMask(v.Type(), target, fieldMask)
+	if err != nil {
+		return true
+	}
+	self, _ := v.Type().FieldByName(names[0])
+	return parseReflection(v, self.Index[0], target).assignedBy(fieldMask)
+}
@@ -0,0 +1,57 @@
+package pbsql
+
+import (
+	"reflect"
+	"sync"
+)
+
+var (
+	viewsMu sync.RWMutex
+	views   = make(map[reflect.Type]string)
+)
+
+// RegisterReadView makes reads of messages of the type of `msg` select from `view` instead of the table they are
+// written to, e.g. a view joining the details shown to clients onto the base table:
+//
+//	pbsql.RegisterReadView(&pb.Task{}, "task_detail_view")
+//
+// Reads, counts, searches, and Get on "task" then select from task_detail_view, while creates, updates, and deletes
+// still write to task, so callers keep passing a single table name. Columns only the view provides must be tagged
+// `readonly` so writes leave them out. Results cached by an Executor are invalidated by writes to the base table. An
+// empty view removes the registration.
+func RegisterReadView(msg interface{}, view string) {
+	t := reflect.TypeOf(msg)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	viewsMu.Lock()
+	defer viewsMu.Unlock()
+	if view == "" {
+		delete(views, t)
+		return
+	}
+	views[t] = view
+}
+
+// readView returns the view registered for the type of `source`, empty if there is none
+func readView(source interface{}) string {
+	t := reflect.TypeOf(source)
+	if t == nil {
+		return ""
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	viewsMu.RLock()
+	defer viewsMu.RUnlock()
+	return views[t]
+}
+
+// readRelation returns the relation a read of `table`, the resolved table of `source`, selects from: the view
+// registered for `source` resolved with the table resolver, or `table` itself
+func (o *options) readRelation(table string, source interface{}) string {
+	if view := readView(source); view != "" {
+		return o.table(view, source)
+	}
+	return table
+}
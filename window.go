@@ -0,0 +1,75 @@
+package pbsql
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// rankedAlias is the column numbering the rows of each partition of a ranked read, see WithFirstPerPartition
+const rankedAlias = "pbsql_rn"
+
+// WithFirstPerPartition reads only the first row of each partition of the rows matched, e.g. the latest order of
+// each customer:
+//
+//	CustomerId int64                  `db:"customer_id" partition_by:"y"`
+//	CreatedAt  *timestamppb.Timestamp `db:"created_at" rank_order:"desc"`
+//	// SELECT order.id, order.customer_id, order.created_at FROM (SELECT order.id, order.customer_id,
+//	// order.created_at, ROW_NUMBER() OVER (PARTITION BY order.customer_id ORDER BY order.created_at desc) AS
+//	// pbsql_rn FROM order WHERE true) AS order WHERE pbsql_rn = 1
+//
+// Rows are partitioned by the columns of the fields tagged `partition_by` and ranked by those tagged `rank_order`,
+// asc or desc, in the order of the fields, or by their primary keys in descending order if none is. The predicates
+// select the rows ranked, while the order, limit, and offset of the read apply to the first rows. Ranked reads can't
+// take row locks.
+func WithFirstPerPartition() Option {
+	return func(o *options) {
+		o.firstPerPartition = true
+	}
+}
+
+// partitionWindow returns the partition and order columns of the fields of `v`, see WithFirstPerPartition
+func partitionWindow(v reflect.Value, target string) ([]string, string, error) {
+	var partition, order []string
+	for i := 0; i < v.NumField(); i++ {
+		field := parseReflection(v, i, target)
+		if field.name == "" || !field.isColumn {
+			continue
+		}
+		if field.self.Tag.Get("partition_by") == "y" {
+			partition = append(partition, field.column())
+		}
+		if dir := field.self.Tag.Get("rank_order"); dir != "" {
+			if dir != "asc" && dir != "desc" {
+				return nil, "", fmt.Errorf("pbsql: unknown rank_order %q on %s", dir, field.self.Name)
+			}
+			order = append(order, field.column()+" "+dir)
+		}
+	}
+	if len(partition) == 0 {
+		return nil, "", fmt.Errorf("pbsql: %s has no field tagged partition_by", target)
+	}
+	if len(order) == 0 {
+		for _, key := range primaryKeys(v, target) {
+			order = append(order, key.column()+" desc")
+		}
+	}
+	if len(order) == 0 {
+		return nil, "", fmt.Errorf("%w: no rank_order to rank the rows of %s", ErrMissingPrimaryKey, target)
+	}
+	return partition, strings.Join(order, ", "), nil
+}
+
+// rankedColumns returns the select list of the query wrapping a ranked read of `table`, the names of the columns of
+// its subquery qualified by the subquery
+func rankedColumns(table string, columns []string) []string {
+	outer := make([]string, len(columns))
+	for i, column := range columns {
+		name := column[strings.LastIndex(column, ".")+1:]
+		if as := strings.LastIndex(column, " as "); as >= 0 {
+			name = column[as+len(" as "):]
+		}
+		outer[i] = table + "." + name
+	}
+	return outer
+}